@@ -0,0 +1,159 @@
+// Package access decides which clients may use a server, by IP address, network, or MAC address.
+package access
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/anacrolix/log"
+)
+
+// Rule allows or denies the clients in a network, or with a MAC address.
+type Rule struct {
+	Deny bool
+	// The network the rule matches, if MAC is nil.
+	Net *net.IPNet
+	// The MAC address the rule matches, resolved from the neighbour table.
+	MAC net.HardwareAddr
+}
+
+func (r Rule) String() string {
+	var s string
+	if r.MAC != nil {
+		s = r.MAC.String()
+	} else {
+		s = r.Net.String()
+	}
+	if r.Deny {
+		return "!" + s
+	}
+	return s
+}
+
+// ParseRules parses comma separated rules. Each is an IP address, a network in CIDR notation or
+// a MAC address, with a ! prefix for those to deny.
+func ParseRules(s string) (rules []Rule, err error) {
+	for _, el := range strings.Split(s, ",") {
+		el = strings.TrimSpace(el)
+		if el == "" {
+			continue
+		}
+		var r Rule
+		if strings.HasPrefix(el, "!") {
+			r.Deny = true
+			el = el[1:]
+		}
+		if ip := net.ParseIP(el); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			r.Net = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else if _, ipNet, err := net.ParseCIDR(el); err == nil {
+			r.Net = ipNet
+		} else if mac, err := net.ParseMAC(el); err == nil {
+			r.MAC = mac
+		} else {
+			return nil, fmt.Errorf("bad access rule %q", el)
+		}
+		rules = append(rules, r)
+	}
+	return
+}
+
+// Policy applies rules to clients. The first rule matching a client decides. Clients that match
+// none are allowed only if no rules allow, so a list of allowed clients excludes the rest, and a
+// list of denied clients admits the rest. A nil Policy allows everyone.
+type Policy struct {
+	Rules []Rule
+	// Returns the MAC address of a neighbour. The system's neighbour table is used if nil, with
+	// lookups reused for a few seconds.
+	LookupMAC func(net.IP) (net.HardwareAddr, error)
+	// Where denied clients are logged.
+	Logger log.Logger
+}
+
+func (p *Policy) lookupMAC(ip net.IP) (net.HardwareAddr, error) {
+	if p.LookupMAC != nil {
+		return p.LookupMAC(ip)
+	}
+	return neighbours.lookup(ip, lookupNeighbour)
+}
+
+// Allowed returns whether the client with the IP is allowed.
+func (p *Policy) Allowed(ip net.IP) bool {
+	if p == nil {
+		return true
+	}
+	var (
+		mac      net.HardwareAddr
+		macDone  bool
+		anyAllow bool
+	)
+	for _, r := range p.Rules {
+		anyAllow = anyAllow || !r.Deny
+		if r.MAC == nil {
+			if r.Net.Contains(ip) {
+				return !r.Deny
+			}
+			continue
+		}
+		if !macDone {
+			var err error
+			mac, err = p.lookupMAC(ip)
+			if err != nil {
+				p.Logger.Levelf(log.Debug, "looking up MAC address of %v: %v", ip, err)
+			}
+			macDone = true
+		}
+		if mac != nil && mac.String() == r.MAC.String() {
+			return !r.Deny
+		}
+	}
+	return !anyAllow
+}
+
+// Check returns whether the client is allowed, and logs it if not. what describes the attempt.
+func (p *Policy) Check(ip net.IP, what string) bool {
+	return p.CheckLevel(ip, what, log.Warning)
+}
+
+// CheckLevel is Check, logging denials at the level. Denials of traffic that clients repeat, such
+// as SSDP searches, are better logged at Debug.
+func (p *Policy) CheckLevel(ip net.IP, what string, level log.Level) bool {
+	if p.Allowed(ip) {
+		return true
+	}
+	p.Logger.Levelf(level, "denied %s from %v", what, ip)
+	return false
+}
+
+// RemoteIP returns the IP of the client making the request.
+func RemoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if i := strings.IndexByte(host, '%'); i != -1 {
+		// IPv6 addresses may have the form address%zone (e.g. ::1%eth0)
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// Handler responds with 403 Forbidden to requests from clients that aren't allowed, and passes
+// the rest to h.
+func (p *Policy) Handler(h http.Handler) http.Handler {
+	if p == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.Check(RemoteIP(r), r.Method+" "+r.URL.Path) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
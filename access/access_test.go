@@ -0,0 +1,116 @@
+package access
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anacrolix/log"
+)
+
+func mustParseRules(t *testing.T, s string) []Rule {
+	rules, err := ParseRules(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestParseRules(t *testing.T) {
+	rules := mustParseRules(t, "192.168.1.0/24, !192.168.1.7,fe80::1,!aa:bb:cc:dd:ee:ff")
+	var got []string
+	for _, r := range rules {
+		got = append(got, r.String())
+	}
+	want := []string{"192.168.1.0/24", "!192.168.1.7/32", "fe80::1/128", "!aa:bb:cc:dd:ee:ff"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	if _, err := ParseRules("192.168.1.300"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	macs := map[string]string{"192.168.1.5": "aa:bb:cc:dd:ee:ff"}
+	lookup := func(ip net.IP) (net.HardwareAddr, error) {
+		if s, ok := macs[ip.String()]; ok {
+			return net.ParseMAC(s)
+		}
+		return nil, errors.New("unknown")
+	}
+	for _, tc := range []struct {
+		rules string
+		ip    string
+		want  bool
+	}{
+		{"", "10.0.0.1", true},
+		{"192.168.1.0/24", "192.168.1.9", true},
+		{"192.168.1.0/24", "10.0.0.1", false},
+		// The first matching rule decides.
+		{"!192.168.1.9,192.168.1.0/24", "192.168.1.9", false},
+		{"!192.168.1.9", "10.0.0.1", true},
+		{"!aa:bb:cc:dd:ee:ff", "192.168.1.5", false},
+		{"!aa:bb:cc:dd:ee:ff", "192.168.1.6", true},
+		{"aa:bb:cc:dd:ee:ff", "192.168.1.5", true},
+		{"aa:bb:cc:dd:ee:ff", "192.168.1.6", false},
+	} {
+		p := &Policy{Rules: mustParseRules(t, tc.rules), LookupMAC: lookup, Logger: log.Default}
+		if got := p.Allowed(net.ParseIP(tc.ip)); got != tc.want {
+			t.Errorf("%q: %s: got %v, want %v", tc.rules, tc.ip, got, tc.want)
+		}
+	}
+	var p *Policy
+	if !p.Allowed(net.ParseIP("10.0.0.1")) {
+		t.Fatal("nil policy denied")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p := &Policy{Rules: mustParseRules(t, "fe80::/10"), Logger: log.Default}
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for addr, code := range map[string]int{
+		"[fe80::1%eth0]:1234": http.StatusOK,
+		"192.168.1.5:1234":    http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "/res", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("%s: got %d, want %d", addr, w.Code, code)
+		}
+	}
+}
+
+func TestNeighbourCache(t *testing.T) {
+	var (
+		c       neighbourCache
+		lookups int
+	)
+	lookup := func(ip net.IP) (net.HardwareAddr, error) {
+		lookups++
+		if ip.Equal(net.ParseIP("192.0.2.1")) {
+			return net.ParseMAC("00:11:22:33:44:55")
+		}
+		return nil, errors.New("not a neighbour")
+	}
+	for i := 0; i < 3; i++ {
+		if mac, err := c.lookup(net.ParseIP("192.0.2.1"), lookup); err != nil || mac.String() != "00:11:22:33:44:55" {
+			t.Fatalf("got %v, %v", mac, err)
+		}
+		if _, err := c.lookup(net.ParseIP("192.0.2.2"), lookup); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if lookups != 2 {
+		t.Fatalf("looked up %d times", lookups)
+	}
+}
@@ -0,0 +1,50 @@
+package access
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// How long the MAC address looked up for an IP is reused for, so that the neighbour table isn't
+// read for every request. Failures are reused too, as clients that aren't neighbours are common.
+const neighbourCacheTTL = 10 * time.Second
+
+type neighbourEntry struct {
+	mac    net.HardwareAddr
+	err    error
+	expiry time.Time
+}
+
+// Recent lookups in the neighbour table, by IP.
+type neighbourCache struct {
+	mu sync.Mutex
+	m  map[string]neighbourEntry
+}
+
+var neighbours neighbourCache
+
+// Returns the MAC address of ip from lookup, or from a recent call.
+func (me *neighbourCache) lookup(ip net.IP, lookup func(net.IP) (net.HardwareAddr, error)) (net.HardwareAddr, error) {
+	key := ip.String()
+	now := time.Now()
+	me.mu.Lock()
+	e, ok := me.m[key]
+	me.mu.Unlock()
+	if ok && now.Before(e.expiry) {
+		return e.mac, e.err
+	}
+	mac, err := lookup(ip)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for k, e := range me.m {
+		if !now.Before(e.expiry) {
+			delete(me.m, k)
+		}
+	}
+	if me.m == nil {
+		me.m = make(map[string]neighbourEntry)
+	}
+	me.m[key] = neighbourEntry{mac, err, now.Add(neighbourCacheTTL)}
+	return mac, err
+}
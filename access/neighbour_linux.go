@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package access
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Netlink neighbour attributes, from linux/neighbour.h.
+const (
+	ndaDst    = 1
+	ndaLLAddr = 2
+)
+
+// Returns the link layer address of ip from the kernel's neighbour table.
+func lookupNeighbour(ip net.IP) (net.HardwareAddr, error) {
+	family := unix.AF_INET6
+	if ip.To4() != nil {
+		family = unix.AF_INET
+	}
+	b, err := syscall.NetlinkRIB(unix.RTM_GETNEIGH, family)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWNEIGH || len(m.Data) < unix.SizeofNdMsg {
+			continue
+		}
+		var dst net.IP
+		var lladdr net.HardwareAddr
+		attrs := m.Data[unix.SizeofNdMsg:]
+		for len(attrs) >= unix.SizeofRtAttr {
+			l := int(binary.LittleEndian.Uint16(attrs[0:2]))
+			if l < unix.SizeofRtAttr || l > len(attrs) {
+				break
+			}
+			data := attrs[unix.SizeofRtAttr:l]
+			switch binary.LittleEndian.Uint16(attrs[2:4]) {
+			case ndaDst:
+				dst = net.IP(data)
+			case ndaLLAddr:
+				lladdr = net.HardwareAddr(data)
+			}
+			l = (l + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+			if l > len(attrs) {
+				break
+			}
+			attrs = attrs[l:]
+		}
+		if dst.Equal(ip) && len(lladdr) != 0 {
+			return lladdr, nil
+		}
+	}
+	return nil, errors.New("not in neighbour table")
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package access
+
+import (
+	"errors"
+	"net"
+)
+
+func lookupNeighbour(net.IP) (net.HardwareAddr, error) {
+	return nil, errors.New("neighbour table not supported on this platform")
+}
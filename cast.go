@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/anacrolix/dms/dlna/dms"
+)
+
+// Implements "dms cast", which has a running server play items on a renderer, through its HTTP
+// API.
+func castMain(args []string) error {
+	fs := flag.NewFlagSet("cast", flag.ExitOnError)
+	serverURL := fs.String("server", "http://localhost:1338", "URL of the dms to cast from")
+	list := fs.Bool("list", false, "list the renderers the server can find, and the casts going")
+	start := fs.String("start", "", "position to start the first item at, such as 0:01:30")
+	stop := fs.Bool("stop", false, "stop the cast to the renderer")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s cast [flags] <renderer> <path>...\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "The renderer is a UDN, friendly name or description URL. Paths are relative to the served root.")
+		fmt.Fprintln(fs.Output(), "The server must be run with -castAPI.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	api := strings.TrimSuffix(*serverURL, "/") + "/cast"
+	switch {
+	case *list:
+		if fs.NArg() != 0 {
+			fs.Usage()
+			return fmt.Errorf("unexpected positional arguments: %s", fs.Args())
+		}
+		var renderers []dms.Renderer
+		if err := castRequest(ctx, http.MethodGet, api+"/renderers", nil, &renderers); err != nil {
+			return err
+		}
+		for _, r := range renderers {
+			fmt.Printf("%s\t%s\t%s\n", r.Name, r.UDN, r.Location)
+		}
+		var casts []dms.CastStatus
+		if err := castRequest(ctx, http.MethodGet, api, nil, &casts); err != nil {
+			return err
+		}
+		for _, c := range casts {
+			fmt.Printf("casting to %s: %s (%d of %d)\n", c.Renderer.Name, c.Paths[c.Current], c.Current+1, len(c.Paths))
+		}
+		return nil
+	case *stop:
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("expected a renderer")
+		}
+		return castRequest(ctx, http.MethodDelete, api+"?"+url.Values{"renderer": {fs.Arg(0)}}.Encode(), nil, nil)
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a renderer and paths")
+	}
+	var status dms.CastStatus
+	err := castRequest(ctx, http.MethodPost, api, dms.CastRequest{
+		Renderer: fs.Arg(0),
+		Paths:    fs.Args()[1:],
+		Start:    *start,
+	}, &status)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("casting %d items to %s\n", len(status.Paths), status.Renderer.Name)
+	return nil
+}
+
+// Makes a request to the cast API, with in and out as JSON if they're not nil.
+func castRequest(ctx context.Context, method, target string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
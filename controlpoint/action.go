@@ -0,0 +1,112 @@
+package controlpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/anacrolix/dms/soap"
+	"github.com/anacrolix/dms/upnp"
+)
+
+// The most of a response that's read.
+const maxResponseSize = 16 << 20
+
+// Call invokes the action with the in arguments, and returns the out arguments. Errors
+// returned by the device in SOAP faults are *upnp.Error.
+func (s *Service) Call(ctx context.Context, action string, args [][2]string) ([][2]string, error) {
+	if s.ControlURL == nil {
+		return nil, fmt.Errorf("service %q has no control URL", s.ID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.ControlURL.String(), bytes.NewReader(actionRequestBody(s.Type, action, args)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPACTION", fmt.Sprintf(`"%s#%s"`, s.Type, action))
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseActionResponse(resp, body)
+}
+
+// Invoke calls the action with the fields of in as arguments, and sets those of the returned Out
+// from the response. Fields map to arguments as for upnp.Actions.
+func Invoke[In, Out any](ctx context.Context, s *Service, action string, in In) (out Out, err error) {
+	args, err := upnp.MarshalArgs(in)
+	if err != nil {
+		return
+	}
+	outArgs, err := s.Call(ctx, action, args)
+	if err != nil {
+		return
+	}
+	err = upnp.UnmarshalArgs(outArgs, &out)
+	return
+}
+
+func actionRequestBody(serviceType, action string, args [][2]string) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	fmt.Fprintf(&b, `<s:Envelope xmlns:s=%q s:encodingStyle=%q><s:Body>`, soap.EnvelopeNS, soap.EncodingStyle)
+	fmt.Fprintf(&b, `<u:%s xmlns:u="`, action)
+	xml.EscapeText(&b, []byte(serviceType))
+	b.WriteString(`">`)
+	for _, arg := range args {
+		fmt.Fprintf(&b, "<%s>", arg[0])
+		xml.EscapeText(&b, []byte(arg[1]))
+		fmt.Fprintf(&b, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&b, `</u:%s></s:Body></s:Envelope>`, action)
+	return b.Bytes()
+}
+
+func parseActionResponse(resp *http.Response, body []byte) ([][2]string, error) {
+	var env struct {
+		XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+		Body    struct {
+			Fault  *fault `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault"`
+			Action []byte `xml:",innerxml"`
+		} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+	}
+	if err := xml.Unmarshal(body, &env); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	if env.Body.Fault != nil {
+		return nil, env.Body.Fault.error()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return upnp.ParseActionArgs(env.Body.Action)
+}
+
+type fault struct {
+	Code   string `xml:"faultcode"`
+	String string `xml:"faultstring"`
+	// Some devices leave out the UPnP control namespace, so any is accepted.
+	Error *struct {
+		Code uint   `xml:"errorCode"`
+		Desc string `xml:"errorDescription"`
+	} `xml:"detail>UPnPError"`
+}
+
+// Returns the UPnP error in the fault, or one made from the SOAP fault if there isn't one.
+func (f *fault) error() error {
+	if f.Error == nil {
+		return fmt.Errorf("SOAP fault %s: %s", f.Code, f.String)
+	}
+	return &upnp.Error{Code: f.Error.Code, Desc: f.Error.Desc}
+}
@@ -0,0 +1,178 @@
+package controlpoint
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+const switchPowerType = "urn:schemas-upnp-org:service:SwitchPower:1"
+
+var testDesc = upnp.DeviceDesc{
+	Device: upnp.Device{
+		UDN: "uuid:root",
+		DeviceList: &upnp.DeviceList{Devices: []upnp.Device{{
+			UDN: "uuid:light",
+			ServiceList: []upnp.Service{{
+				ServiceType: switchPowerType,
+				ServiceId:   "urn:upnp-org:serviceId:SwitchPower",
+				SCPDURL:     "scpd.xml",
+				ControlURL:  "/control",
+				EventSubURL: "/event",
+			}},
+		}}},
+	},
+}
+
+func TestNewDevice(t *testing.T) {
+	d, err := NewDevice(&testDesc, "http://192.0.2.1:8080/dev/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := d.Service("urn:schemas-upnp-org:service:SwitchPower:1")
+	if s == nil {
+		t.Fatal("service not found")
+	}
+	if s.SCPDURL.String() != "http://192.0.2.1:8080/dev/scpd.xml" || s.ControlURL.String() != "http://192.0.2.1:8080/control" {
+		t.Fatalf("unexpected URLs: %v %v", s.SCPDURL, s.ControlURL)
+	}
+	if d.Service("urn:schemas-upnp-org:service:SwitchPower:2") != nil {
+		t.Fatal("matched a later version than the device has")
+	}
+	desc := testDesc
+	desc.URLBase = "http://192.0.2.2/"
+	d, err = NewDevice(&desc, "http://192.0.2.1:8080/dev/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := d.Embedded[0].Services[0].SCPDURL.String(); u != "http://192.0.2.2/scpd.xml" {
+		t.Fatalf("URLBase ignored: %v", u)
+	}
+}
+
+// Serves the test description, and a SwitchPower service that doubles its Level argument, and
+// sends an event of the level to subscribers.
+func newTestDevice(t *testing.T) (d *Device) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		xml.NewEncoder(w).Encode(testDesc)
+	})
+	mux.HandleFunc("/control", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("SOAPACTION") != `"`+switchPowerType+`#SetLevel"` {
+			t.Errorf("unexpected SOAPACTION: %q", r.Header.Get("SOAPACTION"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Level int `xml:"Body>SetLevel>Level"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+		}
+		if req.Level < 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+				`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+				`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>600</errorCode>`+
+				`<errorDescription>negative</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+			return
+		}
+		fmt.Fprintf(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+			`<u:SetLevelResponse xmlns:u="%s"><Result>%d</Result><Note>a &amp; b</Note></u:SetLevelResponse>`+
+			`</s:Body></s:Envelope>`, switchPowerType, req.Level*2)
+	})
+	mux.HandleFunc("/event", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "SUBSCRIBE" && r.Header.Get("SID") == "":
+			callbacks := upnp.ParseCallbackURLs(r.Header.Get("CALLBACK"))
+			w.Header().Set("SID", "uuid:sub")
+			w.Header().Set("TIMEOUT", "Second-1800")
+			// The initial event is sent before the response, as some devices do.
+			req, _ := http.NewRequest("NOTIFY", callbacks[0].String(), strings.NewReader(
+				`<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0">`+
+					`<e:property><Level>7</Level></e:property><e:property><LastChange>&lt;Event/&gt;</LastChange></e:property>`+
+					`</e:propertyset>`))
+			req.Header.Set("NT", "upnp:event")
+			req.Header.Set("NTS", "upnp:propchange")
+			req.Header.Set("SID", "uuid:sub")
+			req.Header.Set("SEQ", "0")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("NOTIFY: %s", resp.Status)
+			}
+		case r.Method == "UNSUBSCRIBE" && r.Header.Get("SID") == "uuid:sub":
+		default:
+			http.Error(w, "bad request", http.StatusPreconditionFailed)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	d, err := FetchDevice(context.Background(), ts.URL+"/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestInvoke(t *testing.T) {
+	s := newTestDevice(t).Service(switchPowerType)
+	ctx := context.Background()
+	type resp struct {
+		Result int
+		Note   string
+	}
+	r, err := Invoke[struct{ Level int }, resp](ctx, s, "SetLevel", struct{ Level int }{21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Result != 42 || r.Note != "a & b" {
+		t.Fatalf("unexpected response: %+v", r)
+	}
+	_, err = s.Call(ctx, "SetLevel", [][2]string{{"Level", "-1"}})
+	var upnpErr *upnp.Error
+	if !errors.As(err, &upnpErr) || upnpErr.Code != upnp.ArgumentValueInvalidErrorCode || upnpErr.Desc != "negative" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newTestDevice(t).Service(switchPowerType)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := NewEventListener(l, log.Default)
+	defer el.Close()
+	events := make(chan Event, 1)
+	sub, err := el.Subscribe(context.Background(), s, time.Hour, func(e Event) { events <- e })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.SID() != "uuid:sub" || sub.Timeout() != 30*time.Minute {
+		t.Fatalf("unexpected subscription: %q %v", sub.SID(), sub.Timeout())
+	}
+	e := <-events
+	if e.SID != "uuid:sub" || e.Seq != 0 || len(e.Vars) != 2 ||
+		e.Vars[0] != [2]string{"Level", "7"} || e.Vars[1] != [2]string{"LastChange", "<Event/>"} {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	if err := sub.Unsubscribe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
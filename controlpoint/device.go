@@ -0,0 +1,179 @@
+// Package controlpoint is a UPnP control point: it calls the actions of devices' services, and
+// subscribes to their events.
+package controlpoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/anacrolix/dms/ssdp"
+	"github.com/anacrolix/dms/upnp"
+)
+
+// Device is a device from a description, with the URLs of its services resolved.
+type Device struct {
+	Desc upnp.Device
+	// Where the root device's description was fetched from.
+	Location string
+	// The URL relative URLs in the description are resolved against.
+	BaseURL  *url.URL
+	Services []*Service
+	// The devices embedded in this one.
+	Embedded []*Device
+}
+
+// Service is a service of a device, as found in its description.
+type Service struct {
+	Type string
+	ID   string
+	// Where to fetch the SCPD from, send actions to, and subscribe to events on.
+	SCPDURL, ControlURL, EventSubURL *url.URL
+	// The client requests are made with. http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+// FetchDevice fetches the description at location, as given in SSDP responses.
+func FetchDevice(ctx context.Context, location string) (*Device, error) {
+	desc, err := ssdp.FetchDeviceDesc(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	return NewDevice(desc, location)
+}
+
+// NewDevice returns the root device of the description fetched from location. Relative URLs are
+// resolved against the URLBase of the description if it has one, and location otherwise.
+func NewDevice(desc *upnp.DeviceDesc, location string) (*Device, error) {
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parsing location: %w", err)
+	}
+	if desc.URLBase != "" {
+		base, err = base.Parse(desc.URLBase)
+		if err != nil {
+			return nil, fmt.Errorf("parsing URLBase: %w", err)
+		}
+	}
+	return newDevice(desc.Device, location, base)
+}
+
+// Discover searches for devices as given by opts, and fetches their descriptions. Each root
+// device is returned once, however many of its devices and services responded. Those whose
+// descriptions can't be fetched are left out.
+func Discover(ctx context.Context, opts ssdp.SearchOptions) (ret []*Device, err error) {
+	resps, err := ssdp.Search(ctx, opts)
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, r := range resps {
+		if r.Location == "" || seen[r.Location] {
+			continue
+		}
+		seen[r.Location] = true
+		d, err := FetchDevice(ctx, r.Location)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+func newDevice(desc upnp.Device, location string, base *url.URL) (*Device, error) {
+	d := &Device{
+		Desc:     desc,
+		Location: location,
+		BaseURL:  base,
+	}
+	for _, s := range desc.ServiceList {
+		svc := &Service{
+			Type: s.ServiceType,
+			ID:   s.ServiceId,
+		}
+		for _, u := range []struct {
+			to  **url.URL
+			ref string
+		}{
+			{&svc.SCPDURL, s.SCPDURL},
+			{&svc.ControlURL, s.ControlURL},
+			{&svc.EventSubURL, s.EventSubURL},
+		} {
+			// Devices leave the event URL empty for services without events.
+			if u.ref == "" {
+				continue
+			}
+			var err error
+			*u.to, err = base.Parse(u.ref)
+			if err != nil {
+				return nil, fmt.Errorf("service %q: %w", s.ServiceId, err)
+			}
+		}
+		d.Services = append(d.Services, svc)
+	}
+	if desc.DeviceList != nil {
+		for _, e := range desc.DeviceList.Devices {
+			ed, err := newDevice(e, location, base)
+			if err != nil {
+				return nil, fmt.Errorf("device %q: %w", e.UDN, err)
+			}
+			d.Embedded = append(d.Embedded, ed)
+		}
+	}
+	return d, nil
+}
+
+// Walk calls f with the device, and then those embedded in it, depth first.
+func (d *Device) Walk(f func(*Device)) {
+	f(d)
+	for _, e := range d.Embedded {
+		e.Walk(f)
+	}
+}
+
+// Device returns the first device of the type, which may be this one, or one embedded in it.
+func (d *Device) Device(deviceType string) (ret *Device) {
+	d.Walk(func(d *Device) {
+		if ret == nil && d.Desc.DeviceType == deviceType {
+			ret = d
+		}
+	})
+	return
+}
+
+// Service returns the first service of the type on the device, or those embedded in it. Services
+// of later versions match too, as they're backward compatible.
+func (d *Device) Service(serviceType string) (ret *Service) {
+	d.Walk(func(d *Device) {
+		for _, s := range d.Services {
+			if ret == nil && serviceTypeMatches(s.Type, serviceType) {
+				ret = s
+			}
+		}
+	})
+	return
+}
+
+func serviceTypeMatches(have, want string) bool {
+	if have == want {
+		return true
+	}
+	h, err := upnp.ParseServiceType(have)
+	if err != nil {
+		return false
+	}
+	w, err := upnp.ParseServiceType(want)
+	if err != nil {
+		return false
+	}
+	return h.Auth == w.Auth && h.Type == w.Type && h.Version >= w.Version
+}
+
+func (s *Service) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
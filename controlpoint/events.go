@@ -0,0 +1,353 @@
+package controlpoint
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// Event is a NOTIFY of changes to the evented state variables of a service.
+type Event struct {
+	SID string
+	// The sequence number, 0 for the initial event of a subscription.
+	Seq uint32
+	// The variables in the event, in the order they were sent.
+	Vars [][2]string
+}
+
+// EventListener receives the events of subscriptions on a local HTTP server. Each subscription
+// gets its own callback path, so events sent before the subscription's SID is known are still
+// delivered.
+type EventListener struct {
+	// The address devices send events to. If the listener is on an unspecified address, the one
+	// used to reach each service is used instead.
+	addr   *net.TCPAddr
+	server http.Server
+	Logger log.Logger
+
+	mu     sync.Mutex
+	subs   map[string]*Subscription
+	nextID uint64
+	closed bool
+}
+
+// NewEventListener serves event callbacks on l until Close.
+func NewEventListener(l net.Listener, logger log.Logger) *EventListener {
+	el := &EventListener{
+		addr:   l.Addr().(*net.TCPAddr),
+		Logger: logger,
+		subs:   make(map[string]*Subscription),
+	}
+	el.server.Handler = http.HandlerFunc(el.handleNotify)
+	go func() {
+		err := el.server.Serve(l)
+		if !errors.Is(err, http.ErrServerClosed) {
+			el.Logger.Levelf(log.Warning, "serving event callbacks: %v", err)
+		}
+	}()
+	return el
+}
+
+// Close stops receiving events, and renewing subscriptions. The subscriptions are left to
+// expire.
+func (el *EventListener) Close() error {
+	el.mu.Lock()
+	el.closed = true
+	subs := el.subs
+	el.subs = nil
+	el.mu.Unlock()
+	for _, sub := range subs {
+		sub.stop()
+	}
+	return el.server.Close()
+}
+
+func (el *EventListener) handleNotify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "NOTIFY" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("NT") != "upnp:event" || r.Header.Get("NTS") != "upnp:propchange" {
+		http.Error(w, "bad NT or NTS", http.StatusBadRequest)
+		return
+	}
+	el.mu.Lock()
+	sub := el.subs[r.URL.Path]
+	el.mu.Unlock()
+	if sub == nil {
+		http.Error(w, "no such subscription", http.StatusPreconditionFailed)
+		return
+	}
+	seq, err := strconv.ParseUint(r.Header.Get("SEQ"), 10, 32)
+	if err != nil {
+		http.Error(w, "bad SEQ", http.StatusBadRequest)
+		return
+	}
+	vars, err := parsePropertySet(io.LimitReader(r.Body, maxResponseSize))
+	if err != nil {
+		el.Logger.Levelf(log.Debug, "parsing event from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "bad property set", http.StatusBadRequest)
+		return
+	}
+	sub.deliver(Event{
+		SID:  r.Header.Get("SID"),
+		Seq:  uint32(seq),
+		Vars: vars,
+	})
+}
+
+func parsePropertySet(r io.Reader) (ret [][2]string, err error) {
+	var set struct {
+		Properties []struct {
+			Vars []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"property"`
+	}
+	if err = xml.NewDecoder(r).Decode(&set); err != nil {
+		return
+	}
+	for _, p := range set.Properties {
+		for _, v := range p.Vars {
+			ret = append(ret, [2]string{v.XMLName.Local, v.Value})
+		}
+	}
+	return
+}
+
+// Subscription is a subscription to the events of a service. It's renewed before it expires,
+// until Unsubscribe or the listener's Close.
+type Subscription struct {
+	el      *EventListener
+	service *Service
+	path    string
+	f       func(Event)
+	// Serializes calls to f.
+	deliverMu sync.Mutex
+
+	mu      sync.Mutex
+	sid     string
+	timeout time.Duration
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// Subscribe subscribes to the events of the service, asking for the timeout, and calls f with
+// each of them. The service may choose another timeout. f is called from the listener's
+// goroutines, one event at a time.
+func (el *EventListener) Subscribe(ctx context.Context, s *Service, timeout time.Duration, f func(Event)) (*Subscription, error) {
+	if s.EventSubURL == nil {
+		return nil, fmt.Errorf("service %q has no event URL", s.ID)
+	}
+	el.mu.Lock()
+	if el.closed {
+		el.mu.Unlock()
+		return nil, errors.New("listener closed")
+	}
+	el.nextID++
+	sub := &Subscription{
+		el:      el,
+		service: s,
+		path:    fmt.Sprintf("/event/%d", el.nextID),
+		f:       f,
+		timeout: timeout,
+		stopped: make(chan struct{}),
+	}
+	el.subs[sub.path] = sub
+	el.mu.Unlock()
+	if err := sub.subscribe(ctx); err != nil {
+		el.remove(sub)
+		return nil, err
+	}
+	go sub.keepAlive()
+	return sub, nil
+}
+
+func (el *EventListener) remove(sub *Subscription) {
+	el.mu.Lock()
+	delete(el.subs, sub.path)
+	el.mu.Unlock()
+}
+
+// Returns the callback URL for the subscription, on an address the service can reach.
+func (el *EventListener) callbackURL(sub *Subscription) (string, error) {
+	ip := el.addr.IP
+	if ip == nil || ip.IsUnspecified() {
+		conn, err := net.Dial("udp", sub.service.EventSubURL.Host)
+		if err != nil {
+			return "", fmt.Errorf("finding the local address to %s: %w", sub.service.EventSubURL.Host, err)
+		}
+		ip = conn.LocalAddr().(*net.UDPAddr).IP
+		conn.Close()
+	}
+	u := url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(ip.String(), strconv.Itoa(el.addr.Port)),
+		Path:   sub.path,
+	}
+	return u.String(), nil
+}
+
+// SID returns the current subscription ID. It changes if the subscription has to be made again.
+func (sub *Subscription) SID() string {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.sid
+}
+
+// Timeout returns the duration the service gave the subscription. It's 0 if it doesn't expire.
+func (sub *Subscription) Timeout() time.Duration {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.timeout
+}
+
+func (sub *Subscription) deliver(e Event) {
+	sub.deliverMu.Lock()
+	defer sub.deliverMu.Unlock()
+	select {
+	case <-sub.stopped:
+		return
+	default:
+	}
+	sub.f(e)
+}
+
+// Makes a new subscription, replacing any SID held.
+func (sub *Subscription) subscribe(ctx context.Context) error {
+	callback, err := sub.el.callbackURL(sub)
+	if err != nil {
+		return err
+	}
+	resp, err := sub.request(ctx, "SUBSCRIBE", func(h http.Header) {
+		h.Set("CALLBACK", "<"+callback+">")
+		h.Set("NT", "upnp:event")
+		h.Set("TIMEOUT", formatTimeout(sub.Timeout()))
+	})
+	if err != nil {
+		return err
+	}
+	sid := resp.Header.Get("SID")
+	if sid == "" {
+		return errors.New("no SID in response")
+	}
+	sub.mu.Lock()
+	sub.sid = sid
+	sub.timeout = parseTimeout(resp.Header.Get("TIMEOUT"))
+	sub.mu.Unlock()
+	return nil
+}
+
+// Renew renews the subscription. It's done automatically before the subscription expires.
+func (sub *Subscription) Renew(ctx context.Context) error {
+	resp, err := sub.request(ctx, "SUBSCRIBE", func(h http.Header) {
+		h.Set("SID", sub.SID())
+		h.Set("TIMEOUT", formatTimeout(sub.Timeout()))
+	})
+	if err != nil {
+		return err
+	}
+	if t := resp.Header.Get("TIMEOUT"); t != "" {
+		sub.mu.Lock()
+		sub.timeout = parseTimeout(t)
+		sub.mu.Unlock()
+	}
+	return nil
+}
+
+// Unsubscribe cancels the subscription. No more events are delivered after it returns, even if
+// the service couldn't be told.
+func (sub *Subscription) Unsubscribe(ctx context.Context) error {
+	sub.stop()
+	sub.el.remove(sub)
+	_, err := sub.request(ctx, "UNSUBSCRIBE", func(h http.Header) {
+		h.Set("SID", sub.SID())
+	})
+	return err
+}
+
+func (sub *Subscription) stop() {
+	sub.once.Do(func() {
+		close(sub.stopped)
+	})
+	// Wait for any event being delivered.
+	sub.deliverMu.Lock()
+	sub.deliverMu.Unlock()
+}
+
+func (sub *Subscription) request(ctx context.Context, method string, header func(http.Header)) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, sub.service.EventSubURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	header(req.Header)
+	resp, err := sub.service.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status: %s", method, resp.Status)
+	}
+	return resp, nil
+}
+
+// Renews the subscription at half its timeout, subscribing again if that fails, until stopped.
+func (sub *Subscription) keepAlive() {
+	for {
+		timeout := sub.Timeout()
+		if timeout <= 0 {
+			return
+		}
+		select {
+		case <-sub.stopped:
+			return
+		case <-time.After(timeout / 2):
+		}
+		// Each attempt gets its own time, so a renewal that times out leaves time to subscribe.
+		ctx, cancel := context.WithTimeout(context.Background(), timeout/4)
+		err := sub.Renew(ctx)
+		cancel()
+		if err != nil {
+			sub.el.Logger.Levelf(log.Debug, "renewing subscription %s: %v", sub.SID(), err)
+			ctx, cancel = context.WithTimeout(context.Background(), timeout/4)
+			err = sub.subscribe(ctx)
+			cancel()
+		}
+		if err != nil {
+			sub.el.Logger.Levelf(log.Warning, "resubscribing to %s: %v", sub.service.EventSubURL, err)
+		}
+	}
+}
+
+func formatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "Second-infinite"
+	}
+	return fmt.Sprintf("Second-%d", int64((d+time.Second-1)/time.Second))
+}
+
+// Parses a TIMEOUT header, such as "Second-1800". Infinite and unparseable timeouts are 0.
+func parseTimeout(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "Second-") {
+		return 0
+	}
+	i, err := strconv.ParseUint(s[len("Second-"):], 10, 32)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(i) * time.Second
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/anacrolix/dms/ssdp"
+	"github.com/anacrolix/dms/upnp"
+)
+
+// Implements "dms discover", which lists the UPnP devices and services on the
+// LAN.
+func discoverMain(args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	st := fs.String("st", ssdp.SearchAll, "search target, such as upnp:rootdevice or urn:schemas-upnp-org:device:MediaRenderer:1")
+	mx := fs.Int("mx", ssdp.DefaultMX, "seconds devices may delay responding, and to wait for responses")
+	ifName := fs.String("ifname", "", "specific network interface to search on")
+	ipv6 := fs.Bool("ipv6", false, "search over IPv6 instead of IPv4")
+	listen := fs.Duration("listen", 0, "after searching, listen for alive and byebye notifications for this long")
+	noDesc := fs.Bool("noDesc", false, "don't fetch device descriptions")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected positional arguments: %s", fs.Args())
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var ifs []net.Interface
+	if *ifName != "" {
+		ifi, err := net.InterfaceByName(*ifName)
+		if err != nil {
+			return err
+		}
+		ifs = append(ifs, *ifi)
+	}
+	resps, err := ssdp.Search(ctx, ssdp.SearchOptions{
+		ST:         *st,
+		MX:         *mx,
+		Interfaces: ifs,
+		IPv6:       *ipv6,
+	})
+	if err != nil {
+		return err
+	}
+	printDiscovered(ctx, resps, !*noDesc)
+
+	if *listen <= 0 {
+		return nil
+	}
+	if ifs == nil {
+		ifs, err = ssdp.MulticastInterfaces()
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel = context.WithTimeout(ctx, *listen)
+	defer cancel()
+	done := make(chan struct{})
+	for _, ifi := range ifs {
+		ifi := ifi
+		go func() {
+			defer func() { done <- struct{}{} }()
+			err := ssdp.Listen(ctx, nil, ifi, *ipv6, func(r ssdp.Response) {
+				fmt.Printf("%s %s %s from %s: %s %s\n",
+					time.Now().Format("15:04:05"), ifi.Name, r.NTS, r.From, r.USN, r.Location)
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error listening on %s: %s\n", ifi.Name, err)
+			}
+		}()
+	}
+	for range ifs {
+		<-done
+	}
+	return nil
+}
+
+// Prints the responses grouped by location, along with the devices and
+// services in their descriptions.
+func printDiscovered(ctx context.Context, resps []ssdp.Response, fetchDesc bool) {
+	var locations []string
+	byLocation := make(map[string][]ssdp.Response)
+	for _, r := range resps {
+		if _, ok := byLocation[r.Location]; !ok {
+			locations = append(locations, r.Location)
+		}
+		byLocation[r.Location] = append(byLocation[r.Location], r)
+	}
+	for _, loc := range locations {
+		rs := byLocation[loc]
+		fmt.Printf("%s (from %s)\n", loc, rs[0].From)
+		fmt.Printf("  server: %s\n", rs[0].Server)
+		for _, r := range rs {
+			fmt.Printf("  %s: %s\n", r.Target, r.USN)
+		}
+		if !fetchDesc || loc == "" {
+			continue
+		}
+		desc, err := ssdp.FetchDeviceDesc(ctx, loc)
+		if err != nil {
+			fmt.Printf("  error fetching description: %s\n", err)
+			continue
+		}
+		printDevice(desc.Device)
+	}
+}
+
+func printDevice(d upnp.Device) {
+	fmt.Printf("  device: %q (%s)\n", d.FriendlyName, d.DeviceType)
+	fmt.Printf("    manufacturer: %s, model: %s\n", d.Manufacturer, d.ModelName)
+	fmt.Printf("    udn: %s\n", d.UDN)
+	for _, s := range d.ServiceList {
+		fmt.Printf("    service: %s (control: %s)\n", s.ServiceType, s.ControlURL)
+	}
+}
@@ -109,3 +109,75 @@ func (me NPTRange) String() (ret string) {
 	}
 	return
 }
+
+// ProtocolInfo is a protocolInfo, as given for res elements, and in ConnectionManager Source and
+// Sink lists: "protocol:network:contentFormat:additionalInfo".
+type ProtocolInfo struct {
+	Protocol       string
+	Network        string
+	ContentFormat  string
+	AdditionalInfo string
+}
+
+func ParseProtocolInfo(s string) (ret ProtocolInfo, err error) {
+	ss := strings.SplitN(strings.TrimSpace(s), ":", 4)
+	if len(ss) != 4 {
+		err = fmt.Errorf("invalid protocolInfo: %q", s)
+		return
+	}
+	ret = ProtocolInfo{ss[0], ss[1], ss[2], ss[3]}
+	return
+}
+
+// ParseProtocolInfoList parses a comma separated list of protocolInfo, as returned by
+// GetProtocolInfo. Invalid entries are skipped.
+func ParseProtocolInfoList(s string) (ret []ProtocolInfo) {
+	for _, f := range strings.Split(s, ",") {
+		pi, err := ParseProtocolInfo(f)
+		if err == nil {
+			ret = append(ret, pi)
+		}
+	}
+	return
+}
+
+func (me ProtocolInfo) String() string {
+	return strings.Join([]string{me.Protocol, me.Network, me.ContentFormat, me.AdditionalInfo}, ":")
+}
+
+// ProfileName returns the DLNA.ORG_PN of the additional info, or "" if there isn't one.
+func (me ProtocolInfo) ProfileName() string {
+	for _, param := range strings.Split(me.AdditionalInfo, ";") {
+		if k, v, ok := strings.Cut(param, "="); ok && k == "DLNA.ORG_PN" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Accepts returns whether a sink that declares me can play a resource with the protocolInfo res.
+// Wildcards match anything, a content format such as "video/*" matches all of that type, and
+// profiles only have to agree if both give one.
+func (me ProtocolInfo) Accepts(res ProtocolInfo) bool {
+	if me.Protocol != "*" && !strings.EqualFold(me.Protocol, res.Protocol) {
+		return false
+	}
+	if !contentFormatAccepts(me.ContentFormat, res.ContentFormat) {
+		return false
+	}
+	pn, resPN := me.ProfileName(), res.ProfileName()
+	return pn == "" || resPN == "" || strings.EqualFold(pn, resPN)
+}
+
+func contentFormatAccepts(sink, res string) bool {
+	// Parameters, such as the rate of audio/L16, don't affect whether it can be played at all.
+	sink, _, _ = strings.Cut(strings.ToLower(sink), ";")
+	res, _, _ = strings.Cut(strings.ToLower(res), ";")
+	if sink == "*" || sink == res {
+		return true
+	}
+	if strings.HasSuffix(sink, "/*") {
+		return strings.HasPrefix(res, strings.TrimSuffix(sink, "*"))
+	}
+	return false
+}
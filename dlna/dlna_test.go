@@ -14,3 +14,33 @@ func TestContentFeaturesString(t *testing.T) {
 		t.Fatal(a)
 	}
 }
+
+func TestProtocolInfoAccepts(t *testing.T) {
+	sink := ParseProtocolInfoList("http-get:*:video/mp4:DLNA.ORG_PN=AVC_MP4_BL_CIF15_AAC_520,http-get:*:audio/*:*,bogus")
+	if len(sink) != 2 {
+		t.Fatalf("parsed %d", len(sink))
+	}
+	for _, tc := range []struct {
+		res  string
+		want bool
+	}{
+		{"http-get:*:video/mp4:DLNA.ORG_OP=01", true},
+		{"http-get:*:video/mp4:DLNA.ORG_PN=AVC_MP4_BL_CIF15_AAC_520;DLNA.ORG_OP=01", true},
+		{"http-get:*:video/mp4:DLNA.ORG_PN=AVC_MP4_HP_HD_AAC", false},
+		{"http-get:*:video/x-matroska:*", false},
+		{"rtsp-rtp-udp:*:video/mp4:*", false},
+		{"http-get:*:audio/L16;rate=44100;channels=2:DLNA.ORG_PN=LPCM", true},
+	} {
+		res, err := ParseProtocolInfo(tc.res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := false
+		for _, pi := range sink {
+			got = got || pi.Accepts(res)
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %v", tc.res, got)
+		}
+	}
+}
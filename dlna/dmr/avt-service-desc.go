@@ -0,0 +1,161 @@
+package dmr
+
+import "github.com/anacrolix/dms/upnp"
+
+func instanceIDArg() upnp.ArgDef {
+	return upnp.ArgDef{Name: "InstanceID", StateVar: "A_ARG_TYPE_InstanceID"}
+}
+
+var avTransportServiceDef = upnp.ServiceDef{
+	Actions: []upnp.ActionDef{
+		{
+			Name: "SetAVTransportURI",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "CurrentURI", StateVar: "AVTransportURI"},
+				{Name: "CurrentURIMetaData", StateVar: "AVTransportURIMetaData"},
+			},
+		},
+		{
+			Name: "SetNextAVTransportURI",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "NextURI", StateVar: "NextAVTransportURI"},
+				{Name: "NextURIMetaData", StateVar: "NextAVTransportURIMetaData"},
+			},
+		},
+		{
+			Name: "GetMediaInfo",
+			In:   []upnp.ArgDef{instanceIDArg()},
+			Out: []upnp.ArgDef{
+				{Name: "NrTracks", StateVar: "NumberOfTracks"},
+				{Name: "MediaDuration", StateVar: "CurrentMediaDuration"},
+				{Name: "CurrentURI", StateVar: "AVTransportURI"},
+				{Name: "CurrentURIMetaData", StateVar: "AVTransportURIMetaData"},
+				{Name: "NextURI", StateVar: "NextAVTransportURI"},
+				{Name: "NextURIMetaData", StateVar: "NextAVTransportURIMetaData"},
+				{Name: "PlayMedium", StateVar: "PlaybackStorageMedium"},
+				{Name: "RecordMedium", StateVar: "RecordStorageMedium"},
+				{Name: "WriteStatus", StateVar: "RecordMediumWriteStatus"},
+			},
+		},
+		{
+			Name: "GetTransportInfo",
+			In:   []upnp.ArgDef{instanceIDArg()},
+			Out: []upnp.ArgDef{
+				{Name: "CurrentTransportState", StateVar: "TransportState"},
+				{Name: "CurrentTransportStatus", StateVar: "TransportStatus"},
+				{Name: "CurrentSpeed", StateVar: "TransportPlaySpeed"},
+			},
+		},
+		{
+			Name: "GetPositionInfo",
+			In:   []upnp.ArgDef{instanceIDArg()},
+			Out: []upnp.ArgDef{
+				{Name: "Track", StateVar: "CurrentTrack"},
+				{Name: "TrackDuration", StateVar: "CurrentTrackDuration"},
+				{Name: "TrackMetaData", StateVar: "CurrentTrackMetaData"},
+				{Name: "TrackURI", StateVar: "CurrentTrackURI"},
+				{Name: "RelTime", StateVar: "RelativeTimePosition"},
+				{Name: "AbsTime", StateVar: "AbsoluteTimePosition"},
+				{Name: "RelCount", StateVar: "RelativeCounterPosition"},
+				{Name: "AbsCount", StateVar: "AbsoluteCounterPosition"},
+			},
+		},
+		{
+			Name: "GetDeviceCapabilities",
+			In:   []upnp.ArgDef{instanceIDArg()},
+			Out: []upnp.ArgDef{
+				{Name: "PlayMedia", StateVar: "PossiblePlaybackStorageMedia"},
+				{Name: "RecMedia", StateVar: "PossibleRecordStorageMedia"},
+				{Name: "RecQualityModes", StateVar: "PossibleRecordQualityModes"},
+			},
+		},
+		{
+			Name: "GetTransportSettings",
+			In:   []upnp.ArgDef{instanceIDArg()},
+			Out: []upnp.ArgDef{
+				{Name: "PlayMode", StateVar: "CurrentPlayMode"},
+				{Name: "RecQualityMode", StateVar: "CurrentRecordQualityMode"},
+			},
+		},
+		{
+			Name: "GetCurrentTransportActions",
+			In:   []upnp.ArgDef{instanceIDArg()},
+			Out:  []upnp.ArgDef{{Name: "Actions", StateVar: "CurrentTransportActions"}},
+		},
+		{
+			Name: "Stop",
+			In:   []upnp.ArgDef{instanceIDArg()},
+		},
+		{
+			Name: "Play",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "Speed", StateVar: "TransportPlaySpeed"},
+			},
+		},
+		{
+			Name: "Pause",
+			In:   []upnp.ArgDef{instanceIDArg()},
+		},
+		{
+			Name: "Seek",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "Unit", StateVar: "A_ARG_TYPE_SeekMode"},
+				{Name: "Target", StateVar: "A_ARG_TYPE_SeekTarget"},
+			},
+		},
+		{
+			Name: "Next",
+			In:   []upnp.ArgDef{instanceIDArg()},
+		},
+		{
+			Name: "Previous",
+			In:   []upnp.ArgDef{instanceIDArg()},
+		},
+	},
+	StateVars: []upnp.StateVarDef{
+		{
+			Name:     "TransportState",
+			DataType: "string",
+			AllowedValues: []string{
+				string(Stopped),
+				string(Playing),
+				string(Transitioning),
+				string(PausedPlayback),
+				string(NoMediaPresent),
+			},
+		},
+		{Name: "TransportStatus", DataType: "string", AllowedValues: []string{"OK", "ERROR_OCCURRED"}},
+		{Name: "PlaybackStorageMedium", DataType: "string", AllowedValues: []string{"NONE", "NETWORK"}},
+		{Name: "RecordStorageMedium", DataType: "string", AllowedValues: []string{"NOT_IMPLEMENTED"}},
+		{Name: "PossiblePlaybackStorageMedia", DataType: "string"},
+		{Name: "PossibleRecordStorageMedia", DataType: "string"},
+		{Name: "CurrentPlayMode", DataType: "string", AllowedValues: []string{"NORMAL"}, DefaultValue: "NORMAL"},
+		{Name: "TransportPlaySpeed", DataType: "string", AllowedValues: []string{"1"}},
+		{Name: "RecordMediumWriteStatus", DataType: "string", AllowedValues: []string{"NOT_IMPLEMENTED"}},
+		{Name: "CurrentRecordQualityMode", DataType: "string", AllowedValues: []string{"NOT_IMPLEMENTED"}},
+		{Name: "PossibleRecordQualityModes", DataType: "string"},
+		{Name: "NumberOfTracks", DataType: "ui4"},
+		{Name: "CurrentTrack", DataType: "ui4"},
+		{Name: "CurrentTrackDuration", DataType: "string"},
+		{Name: "CurrentMediaDuration", DataType: "string"},
+		{Name: "CurrentTrackMetaData", DataType: "string"},
+		{Name: "CurrentTrackURI", DataType: "string"},
+		{Name: "AVTransportURI", DataType: "string"},
+		{Name: "AVTransportURIMetaData", DataType: "string"},
+		{Name: "NextAVTransportURI", DataType: "string"},
+		{Name: "NextAVTransportURIMetaData", DataType: "string"},
+		{Name: "RelativeTimePosition", DataType: "string"},
+		{Name: "AbsoluteTimePosition", DataType: "string"},
+		{Name: "RelativeCounterPosition", DataType: "i4"},
+		{Name: "AbsoluteCounterPosition", DataType: "i4"},
+		{Name: "CurrentTransportActions", DataType: "string"},
+		{Name: "LastChange", DataType: "string", SendEvents: true},
+		{Name: "A_ARG_TYPE_SeekMode", DataType: "string", AllowedValues: []string{"ABS_TIME", "REL_TIME", "TRACK_NR"}},
+		{Name: "A_ARG_TYPE_SeekTarget", DataType: "string"},
+		{Name: "A_ARG_TYPE_InstanceID", DataType: "ui4"},
+	},
+}
@@ -0,0 +1,216 @@
+package dmr
+
+import (
+	"net/http"
+
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+type avTransportService struct {
+	*Renderer
+	*upnp.Actions
+	events upnp.Eventing
+	lastChange
+}
+
+func (avt *avTransportService) Subscribe(w http.ResponseWriter, r *http.Request) error {
+	avt.events.ServeSubscription(w, r, func() []upnp.Variable {
+		return avt.lastChange.variables(avt.avTransportVars())
+	})
+	return nil
+}
+
+func (avt *avTransportService) Unsubscribe(w http.ResponseWriter, r *http.Request) error {
+	avt.events.ServeSubscription(w, r, nil)
+	return nil
+}
+
+// The renderer has the one instance.
+type instance struct {
+	InstanceID uint32
+}
+
+func checkInstance(in instance) error {
+	if in.InstanceID != 0 {
+		return upnp.Errorf(upnpav.InvalidInstanceIDErrorCode, "invalid instance ID %d", in.InstanceID)
+	}
+	return nil
+}
+
+type setAVTransportURI struct {
+	instance
+	CurrentURI         string
+	CurrentURIMetaData string
+}
+
+type setNextAVTransportURI struct {
+	instance
+	NextURI         string
+	NextURIMetaData string
+}
+
+type mediaInfo struct {
+	NrTracks           uint32
+	MediaDuration      string
+	CurrentURI         string
+	CurrentURIMetaData string
+	NextURI            string
+	NextURIMetaData    string
+	PlayMedium         string
+	RecordMedium       string
+	WriteStatus        string
+}
+
+type transportInfo struct {
+	CurrentTransportState  string
+	CurrentTransportStatus string
+	CurrentSpeed           string
+}
+
+type positionInfo struct {
+	Track         uint32
+	TrackDuration string
+	TrackMetaData string
+	TrackURI      string
+	RelTime       string
+	AbsTime       string
+	RelCount      int32
+	AbsCount      int32
+}
+
+// The counter positions are not implemented.
+const counterNotImplemented = 2147483647
+
+type seek struct {
+	instance
+	Unit   string
+	Target string
+}
+
+func (avt *avTransportService) bindActions() {
+	avt.Actions = upnp.NewActions(&avTransportServiceDef)
+	upnp.Bind(avt.Actions, "SetAVTransportURI", func(_ *http.Request, in setAVTransportURI) (struct{}, error) {
+		if err := checkInstance(in.instance); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.setURI(in.CurrentURI, in.CurrentURIMetaData)
+	})
+	upnp.Bind(avt.Actions, "SetNextAVTransportURI", func(_ *http.Request, in setNextAVTransportURI) (struct{}, error) {
+		if err := checkInstance(in.instance); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.setNextURI(in.NextURI, in.NextURIMetaData)
+	})
+	upnp.Bind(avt.Actions, "GetMediaInfo", func(_ *http.Request, in instance) (ret mediaInfo, err error) {
+		if err = checkInstance(in); err != nil {
+			return
+		}
+		avt.mu.Lock()
+		defer avt.mu.Unlock()
+		return mediaInfo{
+			NrTracks:           avt.numberOfTracks(),
+			MediaDuration:      formatDuration(avt.media.Duration),
+			CurrentURI:         avt.media.URI,
+			CurrentURIMetaData: avt.media.Metadata,
+			NextURI:            avt.next.URI,
+			NextURIMetaData:    avt.next.Metadata,
+			PlayMedium:         avt.playbackMedium(),
+			RecordMedium:       "NOT_IMPLEMENTED",
+			WriteStatus:        "NOT_IMPLEMENTED",
+		}, nil
+	})
+	upnp.Bind(avt.Actions, "GetTransportInfo", func(_ *http.Request, in instance) (ret transportInfo, err error) {
+		if err = checkInstance(in); err != nil {
+			return
+		}
+		avt.mu.Lock()
+		defer avt.mu.Unlock()
+		return transportInfo{
+			CurrentTransportState:  string(avt.state),
+			CurrentTransportStatus: avt.transportStatus(),
+			CurrentSpeed:           "1",
+		}, nil
+	})
+	upnp.Bind(avt.Actions, "GetPositionInfo", func(_ *http.Request, in instance) (ret positionInfo, err error) {
+		if err = checkInstance(in); err != nil {
+			return
+		}
+		avt.mu.Lock()
+		defer avt.mu.Unlock()
+		pos := formatDuration(avt.position())
+		return positionInfo{
+			Track:         avt.numberOfTracks(),
+			TrackDuration: formatDuration(avt.media.Duration),
+			TrackMetaData: avt.media.Metadata,
+			TrackURI:      avt.media.URI,
+			RelTime:       pos,
+			AbsTime:       pos,
+			RelCount:      counterNotImplemented,
+			AbsCount:      counterNotImplemented,
+		}, nil
+	})
+	upnp.Bind(avt.Actions, "GetDeviceCapabilities", func(_ *http.Request, in instance) (ret struct{ PlayMedia, RecMedia, RecQualityModes string }, err error) {
+		if err = checkInstance(in); err != nil {
+			return
+		}
+		ret.PlayMedia = "NETWORK"
+		ret.RecMedia = "NOT_IMPLEMENTED"
+		ret.RecQualityModes = "NOT_IMPLEMENTED"
+		return
+	})
+	upnp.Bind(avt.Actions, "GetTransportSettings", func(_ *http.Request, in instance) (ret struct{ PlayMode, RecQualityMode string }, err error) {
+		if err = checkInstance(in); err != nil {
+			return
+		}
+		ret.PlayMode = "NORMAL"
+		ret.RecQualityMode = "NOT_IMPLEMENTED"
+		return
+	})
+	upnp.Bind(avt.Actions, "GetCurrentTransportActions", func(_ *http.Request, in instance) (ret struct{ Actions string }, err error) {
+		if err = checkInstance(in); err != nil {
+			return
+		}
+		avt.mu.Lock()
+		defer avt.mu.Unlock()
+		ret.Actions = avt.transportActions()
+		return
+	})
+	upnp.Bind(avt.Actions, "Play", func(_ *http.Request, in instance) (struct{}, error) {
+		if err := checkInstance(in); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.play()
+	})
+	upnp.Bind(avt.Actions, "Pause", func(_ *http.Request, in instance) (struct{}, error) {
+		if err := checkInstance(in); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.pause()
+	})
+	upnp.Bind(avt.Actions, "Stop", func(_ *http.Request, in instance) (struct{}, error) {
+		if err := checkInstance(in); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.stop()
+	})
+	upnp.Bind(avt.Actions, "Seek", func(_ *http.Request, in seek) (struct{}, error) {
+		if err := checkInstance(in.instance); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.seek(in.Unit, in.Target)
+	})
+	// Media have a single track, so Next moves to the next media, if there is one.
+	upnp.Bind(avt.Actions, "Next", func(_ *http.Request, in instance) (struct{}, error) {
+		if err := checkInstance(in); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.skip()
+	})
+	upnp.Bind(avt.Actions, "Previous", func(_ *http.Request, in instance) (struct{}, error) {
+		if err := checkInstance(in); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, avt.seek("TRACK_NR", "1")
+	})
+}
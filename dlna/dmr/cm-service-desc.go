@@ -0,0 +1,54 @@
+package dmr
+
+import "github.com/anacrolix/dms/upnp"
+
+var connectionManagerServiceDef = upnp.ServiceDef{
+	Actions: []upnp.ActionDef{
+		{
+			Name: "GetProtocolInfo",
+			Out: []upnp.ArgDef{
+				{Name: "Source", StateVar: "SourceProtocolInfo"},
+				{Name: "Sink", StateVar: "SinkProtocolInfo"},
+			},
+		},
+		{
+			Name: "GetCurrentConnectionIDs",
+			Out:  []upnp.ArgDef{{Name: "ConnectionIDs", StateVar: "CurrentConnectionIDs"}},
+		},
+		{
+			Name: "GetCurrentConnectionInfo",
+			In:   []upnp.ArgDef{{Name: "ConnectionID", StateVar: "A_ARG_TYPE_ConnectionID"}},
+			Out: []upnp.ArgDef{
+				{Name: "RcsID", StateVar: "A_ARG_TYPE_RcsID"},
+				{Name: "AVTransportID", StateVar: "A_ARG_TYPE_AVTransportID"},
+				{Name: "ProtocolInfo", StateVar: "A_ARG_TYPE_ProtocolInfo"},
+				{Name: "PeerConnectionManager", StateVar: "A_ARG_TYPE_ConnectionManager"},
+				{Name: "PeerConnectionID", StateVar: "A_ARG_TYPE_ConnectionID"},
+				{Name: "Direction", StateVar: "A_ARG_TYPE_Direction"},
+				{Name: "Status", StateVar: "A_ARG_TYPE_ConnectionStatus"},
+			},
+		},
+	},
+	StateVars: []upnp.StateVarDef{
+		{Name: "SourceProtocolInfo", DataType: "string", SendEvents: true},
+		{Name: "SinkProtocolInfo", DataType: "string", SendEvents: true},
+		{Name: "CurrentConnectionIDs", DataType: "string", SendEvents: true},
+		{
+			Name:     "A_ARG_TYPE_ConnectionStatus",
+			DataType: "string",
+			AllowedValues: []string{
+				"OK",
+				"ContentFormatMismatch",
+				"InsufficientBandwidth",
+				"UnreliableChannel",
+				"Unknown",
+			},
+		},
+		{Name: "A_ARG_TYPE_ConnectionManager", DataType: "string"},
+		{Name: "A_ARG_TYPE_Direction", DataType: "string", AllowedValues: []string{"Input", "Output"}},
+		{Name: "A_ARG_TYPE_ProtocolInfo", DataType: "string"},
+		{Name: "A_ARG_TYPE_ConnectionID", DataType: "i4"},
+		{Name: "A_ARG_TYPE_AVTransportID", DataType: "i4"},
+		{Name: "A_ARG_TYPE_RcsID", DataType: "i4"},
+	},
+}
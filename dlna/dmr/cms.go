@@ -0,0 +1,75 @@
+package dmr
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// What a renderer accepts if it's not told.
+const defaultSinkProtocolInfo = "http-get:*:*:*"
+
+type connectionManagerService struct {
+	*Renderer
+	*upnp.Actions
+	events upnp.Eventing
+}
+
+func (cms *connectionManagerService) sinkProtocolInfo() string {
+	if cms.SinkProtocolInfo == nil {
+		return defaultSinkProtocolInfo
+	}
+	return strings.Join(cms.SinkProtocolInfo, ",")
+}
+
+func (cms *connectionManagerService) Subscribe(w http.ResponseWriter, r *http.Request) error {
+	cms.events.ServeSubscription(w, r, func() []upnp.Variable {
+		return []upnp.Variable{
+			{XMLName: xml.Name{Local: "SourceProtocolInfo"}},
+			{XMLName: xml.Name{Local: "SinkProtocolInfo"}, Value: cms.sinkProtocolInfo()},
+			{XMLName: xml.Name{Local: "CurrentConnectionIDs"}, Value: "0"},
+		}
+	})
+	return nil
+}
+
+func (cms *connectionManagerService) Unsubscribe(w http.ResponseWriter, r *http.Request) error {
+	cms.events.ServeSubscription(w, r, nil)
+	return nil
+}
+
+type connectionInfo struct {
+	RcsID                 int32
+	AVTransportID         int32
+	ProtocolInfo          string
+	PeerConnectionManager string
+	PeerConnectionID      int32
+	Direction             string
+	Status                string
+}
+
+func (cms *connectionManagerService) bindActions() {
+	cms.Actions = upnp.NewActions(&connectionManagerServiceDef)
+	// Without PrepareForConnection, there's only the one connection, 0.
+	upnp.Bind(cms.Actions, "GetCurrentConnectionInfo", func(_ *http.Request, in struct{ ConnectionID int32 }) (connectionInfo, error) {
+		if in.ConnectionID != 0 {
+			return connectionInfo{}, upnp.Errorf(upnpav.InvalidConnectionReferenceErrorCode, "invalid connection reference")
+		}
+		return connectionInfo{
+			PeerConnectionID: -1,
+			Direction:        "Input",
+			Status:           "OK",
+		}, nil
+	})
+	upnp.Bind(cms.Actions, "GetCurrentConnectionIDs", func(*http.Request, struct{}) (ret struct{ ConnectionIDs string }, err error) {
+		ret.ConnectionIDs = "0"
+		return
+	})
+	upnp.Bind(cms.Actions, "GetProtocolInfo", func(*http.Request, struct{}) (ret struct{ Source, Sink string }, err error) {
+		ret.Sink = cms.sinkProtocolInfo()
+		return
+	})
+}
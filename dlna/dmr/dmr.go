@@ -0,0 +1,565 @@
+// Package dmr is a DLNA MediaRenderer. It plays what control points give it through a Sink, and
+// is served with the server package.
+package dmr
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/server"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	DeviceType = upnpav.MediaRendererDeviceType
+
+	AVTransportServiceType       = upnpav.AVTransportServiceType
+	RenderingControlServiceType  = "urn:schemas-upnp-org:service:RenderingControl:1"
+	ConnectionManagerServiceType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// TransportState is the state of the AVTransport.
+type TransportState string
+
+const (
+	NoMediaPresent TransportState = "NO_MEDIA_PRESENT"
+	Stopped        TransportState = "STOPPED"
+	Playing        TransportState = "PLAYING"
+	PausedPlayback TransportState = "PAUSED_PLAYBACK"
+	Transitioning  TransportState = "TRANSITIONING"
+)
+
+// The volume of a new renderer, out of 100.
+const defaultVolume = 50
+
+// Renderer is a MediaRenderer with a single transport, playing to its Sink.
+type Renderer struct {
+	FriendlyName string
+	// A UUID derived from FriendlyName is used if empty.
+	UUID string
+	Sink Sink
+	// The protocols the renderer accepts, reported by GetProtocolInfo. Any over HTTP are if nil.
+	SinkProtocolInfo []string
+	Logger           log.Logger
+
+	mu    sync.Mutex
+	state TransportState
+	// Whether the last playback failed.
+	failed bool
+	media  Media
+	// What to play when the media ends, if anything.
+	next     Media
+	playback Playback
+	// The position when playback was last started, paused or stopped, and when that was.
+	offset  time.Duration
+	started time.Time
+	volume  uint16
+	mute    bool
+
+	avt *avTransportService
+	rcs *renderingControlService
+}
+
+// Volumer is implemented by sinks that can change the volume.
+type Volumer interface {
+	// SetVolume sets the volume, out of 100.
+	SetVolume(volume uint16, mute bool) error
+}
+
+// Device returns the device of the renderer, to be served by the server package. It's to be
+// called once.
+func (me *Renderer) Device() *server.UpnpDevice {
+	me.state = NoMediaPresent
+	me.volume = defaultVolume
+	if me.UUID == "" {
+		me.UUID = server.MakeDeviceUuid(me.FriendlyName + " renderer")
+	}
+	me.avt = &avTransportService{Renderer: me}
+	me.avt.lastChange = lastChange{ns: avTransportEventNS, events: &me.avt.events, logger: me.Logger}
+	me.avt.bindActions()
+	me.rcs = &renderingControlService{Renderer: me}
+	me.rcs.lastChange = lastChange{ns: renderingControlEventNS, events: &me.rcs.events, logger: me.Logger}
+	me.rcs.bindActions()
+	cms := &connectionManagerService{Renderer: me}
+	cms.bindActions()
+	return &server.UpnpDevice{
+		FriendlyName:        me.FriendlyName,
+		Manufacturer:        "Matt Joiner <anacrolix@gmail.com>",
+		RootDeviceType:      DeviceType,
+		RootDeviceModelName: "dms renderer",
+		RootDeviceUUID:      me.UUID,
+		ServiceList: []*server.ServiceWithSCPD{
+			{
+				Service: upnp.Service{
+					ServiceType: AVTransportServiceType,
+					ServiceId:   "urn:upnp-org:serviceId:AVTransport",
+				},
+				Def: &avTransportServiceDef,
+			},
+			{
+				Service: upnp.Service{
+					ServiceType: RenderingControlServiceType,
+					ServiceId:   "urn:upnp-org:serviceId:RenderingControl",
+				},
+				Def: &renderingControlServiceDef,
+			},
+			{
+				Service: upnp.Service{
+					ServiceType: ConnectionManagerServiceType,
+					ServiceId:   "urn:upnp-org:serviceId:ConnectionManager",
+				},
+				Def: &connectionManagerServiceDef,
+			},
+		},
+		UpnpServices: map[string]upnp.UPnPService{
+			"AVTransport":       me.avt,
+			"RenderingControl":  me.rcs,
+			"ConnectionManager": cms,
+		},
+	}
+}
+
+// State returns the transport state, and the media's URI.
+func (me *Renderer) State() (TransportState, string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.state, me.media.URI
+}
+
+// Checks a URI control points give to play. Only HTTP is accepted, as in the default
+// SinkProtocolInfo, so sinks aren't handed local files or arguments.
+func checkURI(uri string) error {
+	if uri == "" {
+		return nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return upnp.Errorf(upnpav.ResourceNotFoundErrorCode, "bad URI: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return upnp.Errorf(upnpav.IllegalMIMETypeErrorCode, "unsupported URI scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return upnp.Errorf(upnpav.ResourceNotFoundErrorCode, "no host in URI %q", uri)
+	}
+	return nil
+}
+
+func (me *Renderer) setURI(uri, metadata string) error {
+	if err := checkURI(uri); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	wasPlaying := me.state == Playing
+	me.stopPlayback()
+	me.media = Media{
+		URI:      uri,
+		Metadata: metadata,
+		Duration: metadataDuration(metadata),
+	}
+	me.offset = 0
+	me.failed = false
+	me.avt.changed(me.mediaVars()...)
+	if uri == "" {
+		me.setState(NoMediaPresent)
+		return nil
+	}
+	if wasPlaying {
+		return me.startPlayback(0)
+	}
+	me.setState(Stopped)
+	return nil
+}
+
+func (me *Renderer) setNextURI(uri, metadata string) error {
+	if err := checkURI(uri); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.state == NoMediaPresent {
+		return upnp.Errorf(upnpav.TransitionNotAvailableErrorCode, "no media")
+	}
+	me.next = Media{
+		URI:      uri,
+		Metadata: metadata,
+		Duration: metadataDuration(metadata),
+	}
+	me.avt.changed(me.nextVars()...)
+	me.avt.changed(newStateVar("CurrentTransportActions", me.transportActions()))
+	return nil
+}
+
+// Moves on to the next media, playing it if the transport is playing. The mutex must be held.
+func (me *Renderer) advance() error {
+	if me.next.URI == "" {
+		return upnp.Errorf(upnpav.IllegalSeekTargetErrorCode, "no next media")
+	}
+	wasPlaying := me.state == Playing
+	me.stopPlayback()
+	me.media = me.next
+	me.next = Media{}
+	me.offset = 0
+	me.avt.changed(me.mediaVars()...)
+	me.avt.changed(me.nextVars()...)
+	if wasPlaying {
+		return me.startPlayback(0)
+	}
+	me.setState(Stopped)
+	return nil
+}
+
+func (me *Renderer) skip() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.advance()
+}
+
+func (me *Renderer) play() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	switch me.state {
+	case NoMediaPresent:
+		return upnp.Errorf(upnpav.NoContentsErrorCode, "no media")
+	case Playing:
+		return nil
+	case PausedPlayback:
+		if me.playback == nil {
+			break
+		}
+		if err := me.playback.Resume(); err != nil {
+			me.Logger.Levelf(log.Debug, "resuming: %v", err)
+			me.stopPlayback()
+			break
+		}
+		me.started = time.Now()
+		me.setState(Playing)
+		return nil
+	}
+	return me.startPlayback(me.offset)
+}
+
+func (me *Renderer) pause() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.state != Playing {
+		return upnp.Errorf(upnpav.TransitionNotAvailableErrorCode, "not playing")
+	}
+	me.offset = me.position()
+	if err := me.playback.Pause(); err != nil {
+		// Playing resumes from the offset instead.
+		me.Logger.Levelf(log.Debug, "pausing: %v", err)
+		me.stopPlayback()
+	}
+	me.setState(PausedPlayback)
+	return nil
+}
+
+func (me *Renderer) stop() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.state == NoMediaPresent {
+		return upnp.Errorf(upnpav.TransitionNotAvailableErrorCode, "no media")
+	}
+	me.stopPlayback()
+	me.offset = 0
+	me.setState(Stopped)
+	return nil
+}
+
+func (me *Renderer) seek(unit, target string) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.state == NoMediaPresent {
+		return upnp.Errorf(upnpav.TransitionNotAvailableErrorCode, "no media")
+	}
+	var offset time.Duration
+	switch unit {
+	case "REL_TIME", "ABS_TIME":
+		var err error
+		offset, err = parseDuration(target)
+		if err != nil || (me.media.Duration != 0 && offset > me.media.Duration) {
+			return upnp.Errorf(upnpav.IllegalSeekTargetErrorCode, "illegal seek target %q", target)
+		}
+	case "TRACK_NR":
+		if strings.TrimSpace(target) != "1" {
+			return upnp.Errorf(upnpav.IllegalSeekTargetErrorCode, "no track %q", target)
+		}
+	default:
+		return upnp.Errorf(upnpav.SeekModeNotSupportedErrorCode, "seek mode %q not supported", unit)
+	}
+	if me.state == Playing {
+		return me.startPlayback(offset)
+	}
+	// Playing starts from the offset.
+	me.stopPlayback()
+	me.offset = offset
+	return nil
+}
+
+// Starts playing the media from the offset. The mutex must be held.
+func (me *Renderer) startPlayback(offset time.Duration) error {
+	me.stopPlayback()
+	pb, err := me.Sink.Play(me.media, offset)
+	if err != nil {
+		me.failed = true
+		me.offset = 0
+		me.setState(Stopped)
+		return upnp.Errorf(upnp.ActionFailedErrorCode, "playing: %s", err)
+	}
+	me.failed = false
+	me.playback = pb
+	me.offset = offset
+	me.started = time.Now()
+	me.setState(Playing)
+	go me.watchPlayback(pb)
+	return nil
+}
+
+// Stops any playback. The mutex must be held.
+func (me *Renderer) stopPlayback() {
+	if me.playback == nil {
+		return
+	}
+	pb := me.playback
+	me.playback = nil
+	if err := pb.Stop(); err != nil {
+		me.Logger.Levelf(log.Warning, "stopping playback: %v", err)
+	}
+}
+
+// Stops the transport when playback ends by itself.
+func (me *Renderer) watchPlayback(pb Playback) {
+	<-pb.Done()
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.playback != pb {
+		return
+	}
+	me.playback = nil
+	me.offset = 0
+	if err := pb.Err(); err != nil {
+		me.Logger.Levelf(log.Warning, "playing %q: %v", me.media.URI, err)
+		me.failed = true
+	} else if me.next.URI != "" {
+		// advance plays the next media, as the transport is still playing.
+		if err := me.advance(); err != nil {
+			me.Logger.Levelf(log.Warning, "playing next: %v", err)
+		}
+		return
+	}
+	me.setState(Stopped)
+}
+
+// Returns the position in the media. The mutex must be held.
+func (me *Renderer) position() time.Duration {
+	pos := me.offset
+	if me.state == Playing {
+		pos += time.Since(me.started)
+	}
+	if me.media.Duration != 0 && pos > me.media.Duration {
+		pos = me.media.Duration
+	}
+	return pos
+}
+
+// The mutex must be held.
+func (me *Renderer) setState(s TransportState) {
+	me.state = s
+	me.avt.changed(
+		newStateVar("TransportState", string(s)),
+		newStateVar("TransportStatus", me.transportStatus()),
+		newStateVar("CurrentTransportActions", me.transportActions()),
+	)
+}
+
+func (me *Renderer) transportStatus() string {
+	if me.failed {
+		return "ERROR_OCCURRED"
+	}
+	return "OK"
+}
+
+// Returns the actions that can be taken in the current state, for CurrentTransportActions.
+func (me *Renderer) transportActions() string {
+	var actions string
+	switch me.state {
+	case Playing:
+		actions = "Pause,Stop,Seek"
+	case PausedPlayback:
+		actions = "Play,Stop,Seek"
+	case Stopped:
+		actions = "Play,Seek"
+	default:
+		return ""
+	}
+	if me.next.URI != "" {
+		actions += ",Next"
+	}
+	return actions
+}
+
+func (me *Renderer) numberOfTracks() uint32 {
+	if me.media.URI == "" {
+		return 0
+	}
+	return 1
+}
+
+// The AVTransport variables describing the media. The mutex must be held.
+func (me *Renderer) mediaVars() []stateVar {
+	duration := formatDuration(me.media.Duration)
+	return []stateVar{
+		newStateVar("AVTransportURI", me.media.URI),
+		newStateVar("AVTransportURIMetaData", me.media.Metadata),
+		newStateVar("CurrentTrackURI", me.media.URI),
+		newStateVar("CurrentTrackMetaData", me.media.Metadata),
+		newStateVar("NumberOfTracks", strconv.FormatUint(uint64(me.numberOfTracks()), 10)),
+		newStateVar("CurrentTrack", strconv.FormatUint(uint64(me.numberOfTracks()), 10)),
+		newStateVar("CurrentTrackDuration", duration),
+		newStateVar("CurrentMediaDuration", duration),
+	}
+}
+
+// The AVTransport variables describing the next media. The mutex must be held.
+func (me *Renderer) nextVars() []stateVar {
+	return []stateVar{
+		newStateVar("NextAVTransportURI", me.next.URI),
+		newStateVar("NextAVTransportURIMetaData", me.next.Metadata),
+	}
+}
+
+// Returns every AVTransport variable in LastChange, for the initial event.
+func (me *Renderer) avTransportVars() []stateVar {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return append([]stateVar{
+		newStateVar("TransportState", string(me.state)),
+		newStateVar("TransportStatus", me.transportStatus()),
+		newStateVar("CurrentTransportActions", me.transportActions()),
+		newStateVar("TransportPlaySpeed", "1"),
+		newStateVar("PlaybackStorageMedium", me.playbackMedium()),
+		newStateVar("CurrentPlayMode", "NORMAL"),
+	}, append(me.mediaVars(), me.nextVars()...)...)
+}
+
+func (me *Renderer) playbackMedium() string {
+	if me.media.URI == "" {
+		return "NONE"
+	}
+	return "NETWORK"
+}
+
+func (me *Renderer) setVolume(volume uint16, mute bool) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if v, ok := me.Sink.(Volumer); ok {
+		if err := v.SetVolume(volume, mute); err != nil {
+			return upnp.Errorf(upnp.ActionFailedErrorCode, "setting volume: %s", err)
+		}
+	}
+	me.volume = volume
+	me.mute = mute
+	me.rcs.changed(me.renderingControlVars()...)
+	return nil
+}
+
+func (me *Renderer) getVolume() (uint16, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.volume, me.mute
+}
+
+// Returns every RenderingControl variable in LastChange. The mutex must be held.
+func (me *Renderer) renderingControlVars() []stateVar {
+	volume := newStateVar("Volume", strconv.FormatUint(uint64(me.volume), 10))
+	volume.Channel = "Master"
+	mute := newStateVar("Mute", boolVal(me.mute))
+	mute.Channel = "Master"
+	return []stateVar{newStateVar("PresetNameList", factoryDefaultsPreset), volume, mute}
+}
+
+func boolVal(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// Formats a duration as H+:MM:SS, as in AVTransport time positions.
+func formatDuration(d time.Duration) string {
+	s := int64(d / time.Second)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// Parses H+:MM:SS[.F+] or H+:MM:SS[.F0/F1], as in AVTransport time positions and DIDL-Lite
+// durations.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	var frac time.Duration
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		f := s[i+1:]
+		s = s[:i]
+		if n, d, ok := strings.Cut(f, "/"); ok {
+			ni, err1 := strconv.ParseUint(n, 10, 32)
+			di, err2 := strconv.ParseUint(d, 10, 32)
+			if err1 != nil || err2 != nil || di == 0 || ni >= di {
+				return 0, fmt.Errorf("bad fraction %q", f)
+			}
+			frac = time.Duration(ni) * time.Second / time.Duration(di)
+		} else {
+			fs, err := strconv.ParseFloat("0."+f, 64)
+			if err != nil {
+				return 0, fmt.Errorf("bad fraction %q", f)
+			}
+			frac = time.Duration(fs * float64(time.Second))
+		}
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	var fields [3]uint64
+	for i, p := range parts {
+		v, err := strconv.ParseUint(strings.TrimPrefix(p, "+"), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		fields[i] = v
+	}
+	if fields[1] > 59 || fields[2] > 59 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return time.Duration(fields[0]*3600+fields[1]*60+fields[2])*time.Second + frac, nil
+}
+
+// Returns the duration of the first resource in the DIDL-Lite metadata that has one.
+func metadataDuration(metadata string) time.Duration {
+	var didl struct {
+		Items []struct {
+			Res []struct {
+				Duration string `xml:"duration,attr"`
+			} `xml:"res"`
+		} `xml:"item"`
+	}
+	if xml.Unmarshal([]byte(metadata), &didl) != nil {
+		return 0
+	}
+	for _, item := range didl.Items {
+		for _, res := range item.Res {
+			if d, err := parseDuration(res.Duration); err == nil && res.Duration != "" {
+				return d
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,245 @@
+package dmr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/controlpoint"
+	"github.com/anacrolix/dms/server"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"0:00:00":     0,
+		"1:02:03":     time.Hour + 2*time.Minute + 3*time.Second,
+		"12:00:01.5":  12*time.Hour + 1500*time.Millisecond,
+		"0:00:01.1/4": 1250 * time.Millisecond,
+		" 100:59:59 ": 100*time.Hour + 59*time.Minute + 59*time.Second,
+	} {
+		got, err := parseDuration(s)
+		if err != nil || got != want {
+			t.Errorf("%q: got %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "1:02", "0:60:00", "0:00:01.4/4", "a:00:00"} {
+		if _, err := parseDuration(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+	if s := formatDuration(time.Hour + 2*time.Minute + 3500*time.Millisecond); s != "1:02:03" {
+		t.Errorf("formatted %q", s)
+	}
+}
+
+func TestMetadataDuration(t *testing.T) {
+	d := metadataDuration(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"><item id="1">` +
+		`<res protocolInfo="http-get:*:image/jpeg:*">x</res>` +
+		`<res protocolInfo="http-get:*:audio/mpeg:*" duration="0:03:30.000">y</res></item></DIDL-Lite>`)
+	if d != 210*time.Second {
+		t.Fatalf("got %v", d)
+	}
+}
+
+// A Writer safe for the renderer to write to while the test reads.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (me *syncBuffer) Write(p []byte) (int, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.b.Write(p)
+}
+
+func (me *syncBuffer) String() string {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.b.String()
+}
+
+// Serves a renderer over HTTP only, and returns it with the device a control point sees.
+func startTestRenderer(t *testing.T, sink Sink) (*Renderer, *controlpoint.Device) {
+	r := &Renderer{FriendlyName: "test", Sink: sink, Logger: log.Default}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := server.New(r.Device(), server.WithListener(l), server.WithInterfaces(), server.WithLogger(log.Default))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Run(context.Background())
+	t.Cleanup(func() { s.Close() })
+	d, err := controlpoint.FetchDevice(context.Background(), "http://"+l.Addr().String()+"/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, d
+}
+
+type instanceArgs struct{ InstanceID uint32 }
+
+func call(s *controlpoint.Service, action string, args ...string) ([][2]string, error) {
+	in := [][2]string{{"InstanceID", "0"}}
+	for i := 0; i < len(args); i += 2 {
+		in = append(in, [2]string{args[i], args[i+1]})
+	}
+	return s.Call(context.Background(), action, in)
+}
+
+func mustCall(t *testing.T, s *controlpoint.Service, action string, args ...string) {
+	t.Helper()
+	if _, err := call(s, action, args...); err != nil {
+		t.Fatalf("%s: %v", action, err)
+	}
+}
+
+func transportState(t *testing.T, avt *controlpoint.Service) string {
+	t.Helper()
+	ret, err := controlpoint.Invoke[instanceArgs, transportInfo](context.Background(), avt, "GetTransportInfo", instanceArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret.CurrentTransportState
+}
+
+func TestTransport(t *testing.T) {
+	var record syncBuffer
+	r, d := startTestRenderer(t, NullSink{Record: &record})
+	avt := d.Service(AVTransportServiceType)
+	if transportState(t, avt) != string(NoMediaPresent) {
+		t.Fatal("expected no media")
+	}
+	var upnpErr *upnp.Error
+	if _, err := call(avt, "Play", "Speed", "1"); !errors.As(err, &upnpErr) || upnpErr.Code != upnpav.NoContentsErrorCode {
+		t.Fatalf("playing without media: %v", err)
+	}
+	for _, uri := range []string{"file:///etc/passwd", "--script=x.lua", "http://"} {
+		if _, err := call(avt, "SetAVTransportURI", "CurrentURI", uri, "CurrentURIMetaData", ""); !errors.As(err, &upnpErr) ||
+			(upnpErr.Code != upnpav.IllegalMIMETypeErrorCode && upnpErr.Code != upnpav.ResourceNotFoundErrorCode) {
+			t.Fatalf("setting URI %q: %v", uri, err)
+		}
+	}
+	mustCall(t, avt, "SetAVTransportURI", "CurrentURI", "http://example.com/a.mp3", "CurrentURIMetaData",
+		`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"><item><res duration="0:10:00">x</res></item></DIDL-Lite>`)
+	if transportState(t, avt) != string(Stopped) {
+		t.Fatal("expected stopped")
+	}
+	if _, err := call(avt, "Pause"); !errors.As(err, &upnpErr) || upnpErr.Code != upnpav.TransitionNotAvailableErrorCode {
+		t.Fatalf("pausing while stopped: %v", err)
+	}
+	mustCall(t, avt, "Seek", "Unit", "REL_TIME", "Target", "0:01:00")
+	mustCall(t, avt, "Play", "Speed", "1")
+	mustCall(t, avt, "Pause")
+	if state, _ := r.State(); state != PausedPlayback {
+		t.Fatalf("state %s", state)
+	}
+	pos, err := controlpoint.Invoke[instanceArgs, positionInfo](context.Background(), avt, "GetPositionInfo", instanceArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.TrackDuration != "0:10:00" || pos.RelTime != "0:01:00" || pos.Track != 1 {
+		t.Fatalf("unexpected position: %+v", pos)
+	}
+	if _, err := call(avt, "Seek", "Unit", "REL_TIME", "Target", "0:11:00"); !errors.As(err, &upnpErr) || upnpErr.Code != upnpav.IllegalSeekTargetErrorCode {
+		t.Fatalf("seeking past the end: %v", err)
+	}
+	if _, err := call(avt, "Seek", "Unit", "X_DLNA_REL_BYTE", "Target", "0"); err == nil {
+		t.Fatal("seeking by an unsupported unit")
+	}
+	mustCall(t, avt, "Play", "Speed", "1")
+	mustCall(t, avt, "Stop")
+	if _, err := call(avt, "Stop"); err != nil {
+		t.Fatalf("stopping again: %v", err)
+	}
+	if _, err := avt.Call(context.Background(), "Stop", [][2]string{{"InstanceID", "1"}}); !errors.As(err, &upnpErr) || upnpErr.Code != upnpav.InvalidInstanceIDErrorCode {
+		t.Fatalf("invalid instance: %v", err)
+	}
+	want := "play http://example.com/a.mp3 1m0s\npause\nresume\nstop\n"
+	if got := record.String(); got != want {
+		t.Fatalf("sink got %q, want %q", got, want)
+	}
+}
+
+func TestLastChange(t *testing.T) {
+	_, d := startTestRenderer(t, NullSink{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := controlpoint.NewEventListener(l, log.Default)
+	defer el.Close()
+	events := make(chan string, 10)
+	avt := d.Service(AVTransportServiceType)
+	_, err = el.Subscribe(context.Background(), avt, time.Minute, func(e controlpoint.Event) {
+		for _, v := range e.Vars {
+			if v[0] == "LastChange" {
+				events <- v[1]
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := <-events
+	if !strings.Contains(initial, `<TransportState val="NO_MEDIA_PRESENT">`) || !strings.Contains(initial, `xmlns="urn:schemas-upnp-org:metadata-1-0/AVT/"`) {
+		t.Fatalf("unexpected initial event: %s", initial)
+	}
+	mustCall(t, avt, "SetAVTransportURI", "CurrentURI", "http://example.com/a.mp3", "CurrentURIMetaData", "")
+	mustCall(t, avt, "Play", "Speed", "1")
+	// The changes are moderated into a single event.
+	change := <-events
+	if !strings.Contains(change, `<TransportState val="PLAYING">`) || !strings.Contains(change, `<AVTransportURI val="http://example.com/a.mp3">`) {
+		t.Fatalf("unexpected change: %s", change)
+	}
+	if strings.Contains(change, "STOPPED") {
+		t.Fatalf("change has superseded state: %s", change)
+	}
+
+	rcs := d.Service(RenderingControlServiceType)
+	mustCall(t, rcs, "SetVolume", "Channel", "Master", "DesiredVolume", "30")
+	ret, err := call(rcs, "GetVolume", "Channel", "Master")
+	if err != nil || len(ret) != 1 || ret[0][1] != "30" {
+		t.Fatalf("getting volume: %q %v", ret, err)
+	}
+	if _, err := call(rcs, "SetVolume", "Channel", "Master", "DesiredVolume", "101"); err == nil {
+		t.Fatal("set volume out of range")
+	}
+}
+
+// The transport moves on to the next media, and then stops, as the commands playing end by
+// themselves.
+func TestExecSinkEnds(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip(err)
+	}
+	r, d := startTestRenderer(t, ExecSink{Command: []string{"sleep", "{offset}"}})
+	avt := d.Service(AVTransportServiceType)
+	mustCall(t, avt, "SetAVTransportURI", "CurrentURI", "http://example.com/a.mp3", "CurrentURIMetaData", "")
+	mustCall(t, avt, "SetNextAVTransportURI", "NextURI", "http://example.com/b.mp3", "NextURIMetaData", "")
+	mustCall(t, avt, "Seek", "Unit", "REL_TIME", "Target", "0:00:00.2")
+	mustCall(t, avt, "Play", "Speed", "1")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		state, uri := r.State()
+		if state == Stopped && uri == "http://example.com/b.mp3" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still %s", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
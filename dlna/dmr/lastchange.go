@@ -0,0 +1,109 @@
+package dmr
+
+import (
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// The most often LastChange is evented, as the AVTransport and RenderingControl specs require.
+const lastChangeInterval = 200 * time.Millisecond
+
+const (
+	avTransportEventNS      = "urn:schemas-upnp-org:metadata-1-0/AVT/"
+	renderingControlEventNS = "urn:schemas-upnp-org:metadata-1-0/RCS/"
+)
+
+// A state variable in a LastChange event.
+type stateVar struct {
+	XMLName xml.Name
+	// For RenderingControl variables that are per channel.
+	Channel string `xml:"channel,attr,omitempty"`
+	Val     string `xml:"val,attr"`
+}
+
+func newStateVar(name, val string) stateVar {
+	return stateVar{XMLName: xml.Name{Local: name}, Val: val}
+}
+
+// Returns the LastChange value of the changes to instance 0.
+func marshalLastChange(ns string, vars []stateVar) (string, error) {
+	b, err := xml.Marshal(struct {
+		XMLName  xml.Name
+		Instance struct {
+			Val  string `xml:"val,attr"`
+			Vars []stateVar
+		} `xml:"InstanceID"`
+	}{
+		XMLName: xml.Name{Space: ns, Local: "Event"},
+		Instance: struct {
+			Val  string `xml:"val,attr"`
+			Vars []stateVar
+		}{"0", vars},
+	})
+	return string(b), err
+}
+
+// Collects changes to a service's state variables, and events them in LastChange, no more often
+// than lastChangeInterval.
+type lastChange struct {
+	ns     string
+	events *upnp.Eventing
+	logger log.Logger
+
+	pendingMu sync.Mutex
+	pending   []stateVar
+	timer     *time.Timer
+}
+
+// Returns the LastChange variable of the changes, or none if they can't be marshalled.
+func (me *lastChange) variables(vars []stateVar) []upnp.Variable {
+	value, err := marshalLastChange(me.ns, vars)
+	if err != nil {
+		me.logger.Levelf(log.Warning, "marshalling LastChange: %v", err)
+		return nil
+	}
+	return []upnp.Variable{{
+		XMLName: xml.Name{Local: "LastChange"},
+		Value:   value,
+	}}
+}
+
+// Records the changes, replacing earlier changes to the same variables not yet evented.
+func (me *lastChange) changed(vars ...stateVar) {
+	me.pendingMu.Lock()
+	defer me.pendingMu.Unlock()
+	for _, v := range vars {
+		replaced := false
+		for i, p := range me.pending {
+			if p.XMLName == v.XMLName && p.Channel == v.Channel {
+				me.pending[i] = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			me.pending = append(me.pending, v)
+		}
+	}
+	if me.timer == nil {
+		me.timer = time.AfterFunc(lastChangeInterval, me.flush)
+	}
+}
+
+func (me *lastChange) flush() {
+	me.pendingMu.Lock()
+	pending := me.pending
+	me.pending = nil
+	me.timer = nil
+	me.pendingMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if vars := me.variables(pending); vars != nil {
+		me.events.Notify(vars...)
+	}
+}
@@ -0,0 +1,61 @@
+package dmr
+
+import "github.com/anacrolix/dms/upnp"
+
+var renderingControlServiceDef = upnp.ServiceDef{
+	Actions: []upnp.ActionDef{
+		{
+			Name: "ListPresets",
+			In:   []upnp.ArgDef{instanceIDArg()},
+			Out:  []upnp.ArgDef{{Name: "CurrentPresetNameList", StateVar: "PresetNameList"}},
+		},
+		{
+			Name: "SelectPreset",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "PresetName", StateVar: "A_ARG_TYPE_PresetName"},
+			},
+		},
+		{
+			Name: "GetMute",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "Channel", StateVar: "A_ARG_TYPE_Channel"},
+			},
+			Out: []upnp.ArgDef{{Name: "CurrentMute", StateVar: "Mute"}},
+		},
+		{
+			Name: "SetMute",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "Channel", StateVar: "A_ARG_TYPE_Channel"},
+				{Name: "DesiredMute", StateVar: "Mute"},
+			},
+		},
+		{
+			Name: "GetVolume",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "Channel", StateVar: "A_ARG_TYPE_Channel"},
+			},
+			Out: []upnp.ArgDef{{Name: "CurrentVolume", StateVar: "Volume"}},
+		},
+		{
+			Name: "SetVolume",
+			In: []upnp.ArgDef{
+				instanceIDArg(),
+				{Name: "Channel", StateVar: "A_ARG_TYPE_Channel"},
+				{Name: "DesiredVolume", StateVar: "Volume"},
+			},
+		},
+	},
+	StateVars: []upnp.StateVarDef{
+		{Name: "PresetNameList", DataType: "string"},
+		{Name: "Mute", DataType: "boolean"},
+		{Name: "Volume", DataType: "ui2"},
+		{Name: "LastChange", DataType: "string", SendEvents: true},
+		{Name: "A_ARG_TYPE_Channel", DataType: "string", AllowedValues: []string{"Master"}},
+		{Name: "A_ARG_TYPE_InstanceID", DataType: "ui4"},
+		{Name: "A_ARG_TYPE_PresetName", DataType: "string", AllowedValues: []string{factoryDefaultsPreset}},
+	},
+}
@@ -0,0 +1,94 @@
+package dmr
+
+import (
+	"net/http"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// The only preset, required by RenderingControl.
+const factoryDefaultsPreset = "FactoryDefaults"
+
+type renderingControlService struct {
+	*Renderer
+	*upnp.Actions
+	events upnp.Eventing
+	lastChange
+}
+
+func (rcs *renderingControlService) Subscribe(w http.ResponseWriter, r *http.Request) error {
+	rcs.events.ServeSubscription(w, r, func() []upnp.Variable {
+		rcs.mu.Lock()
+		defer rcs.mu.Unlock()
+		return rcs.lastChange.variables(rcs.renderingControlVars())
+	})
+	return nil
+}
+
+func (rcs *renderingControlService) Unsubscribe(w http.ResponseWriter, r *http.Request) error {
+	rcs.events.ServeSubscription(w, r, nil)
+	return nil
+}
+
+// Channel is always Master, as the definition allows no other.
+type channel struct {
+	instance
+	Channel string
+}
+
+func (rcs *renderingControlService) bindActions() {
+	rcs.Actions = upnp.NewActions(&renderingControlServiceDef)
+	upnp.Bind(rcs.Actions, "ListPresets", func(_ *http.Request, in instance) (ret struct{ CurrentPresetNameList string }, err error) {
+		if err = checkInstance(in); err != nil {
+			return
+		}
+		ret.CurrentPresetNameList = factoryDefaultsPreset
+		return
+	})
+	upnp.Bind(rcs.Actions, "SelectPreset", func(_ *http.Request, in struct {
+		instance
+		PresetName string
+	}) (struct{}, error) {
+		if err := checkInstance(in.instance); err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, rcs.setVolume(defaultVolume, false)
+	})
+	upnp.Bind(rcs.Actions, "GetVolume", func(_ *http.Request, in channel) (ret struct{ CurrentVolume uint16 }, err error) {
+		if err = checkInstance(in.instance); err != nil {
+			return
+		}
+		ret.CurrentVolume, _ = rcs.getVolume()
+		return
+	})
+	upnp.Bind(rcs.Actions, "SetVolume", func(_ *http.Request, in struct {
+		channel
+		DesiredVolume uint16
+	}) (struct{}, error) {
+		if err := checkInstance(in.instance); err != nil {
+			return struct{}{}, err
+		}
+		if in.DesiredVolume > 100 {
+			return struct{}{}, upnp.Errorf(upnp.ArgumentValueInvalidErrorCode, "volume %d out of range", in.DesiredVolume)
+		}
+		_, mute := rcs.getVolume()
+		return struct{}{}, rcs.setVolume(in.DesiredVolume, mute)
+	})
+	upnp.Bind(rcs.Actions, "GetMute", func(_ *http.Request, in channel) (ret struct{ CurrentMute bool }, err error) {
+		if err = checkInstance(in.instance); err != nil {
+			return
+		}
+		_, ret.CurrentMute = rcs.getVolume()
+		return
+	})
+	upnp.Bind(rcs.Actions, "SetMute", func(_ *http.Request, in struct {
+		channel
+		DesiredMute bool
+	}) (struct{}, error) {
+		if err := checkInstance(in.instance); err != nil {
+			return struct{}{}, err
+		}
+		volume, _ := rcs.getVolume()
+		return struct{}{}, rcs.setVolume(volume, in.DesiredMute)
+	})
+}
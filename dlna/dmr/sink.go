@@ -0,0 +1,175 @@
+package dmr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Media is what the renderer has been given to play.
+type Media struct {
+	URI string
+	// The DIDL-Lite metadata given with the URI, if any.
+	Metadata string
+	// The duration given in the metadata, or 0 if it's unknown.
+	Duration time.Duration
+}
+
+// Sink plays media for the renderer. The renderer keeps track of the position itself, so sinks
+// only need to start, pause and stop.
+type Sink interface {
+	// Play starts playing the media from the offset. The renderer stops any previous playback
+	// first.
+	Play(m Media, offset time.Duration) (Playback, error)
+}
+
+// Playback is media being played by a sink.
+type Playback interface {
+	Pause() error
+	Resume() error
+	// Stop ends playing. It doesn't wait for Done.
+	Stop() error
+	// Done is closed when playing ends, by itself or from Stop.
+	Done() <-chan struct{}
+	// Err returns why playing ended, once Done is closed. It's nil if it ended normally, or from
+	// Stop.
+	Err() error
+}
+
+// NullSink plays nothing, and never ends by itself, so the renderer can run headless and in
+// tests. What it's asked to do is written to Record, if it's not nil, a line at a time.
+type NullSink struct {
+	Record io.Writer
+}
+
+func (me NullSink) record(format string, a ...interface{}) {
+	if me.Record != nil {
+		fmt.Fprintf(me.Record, format+"\n", a...)
+	}
+}
+
+func (me NullSink) Play(m Media, offset time.Duration) (Playback, error) {
+	me.record("play %s %s", m.URI, offset)
+	return &nullPlayback{sink: me, done: make(chan struct{})}, nil
+}
+
+type nullPlayback struct {
+	sink NullSink
+	done chan struct{}
+	once sync.Once
+}
+
+func (me *nullPlayback) Pause() error {
+	me.sink.record("pause")
+	return nil
+}
+
+func (me *nullPlayback) Resume() error {
+	me.sink.record("resume")
+	return nil
+}
+
+func (me *nullPlayback) Stop() error {
+	me.once.Do(func() {
+		me.sink.record("stop")
+		close(me.done)
+	})
+	return nil
+}
+
+func (me *nullPlayback) Done() <-chan struct{} {
+	return me.done
+}
+
+func (me *nullPlayback) Err() error {
+	return nil
+}
+
+// ExecSink plays media by running a command, such as a media player. In the arguments, {uri} is
+// replaced by the URI of the media, and {offset} by the seconds to start playing at. Pausing
+// stops the process, where that's supported.
+type ExecSink struct {
+	Command []string
+	// Where the command's output goes. It's discarded if nil.
+	Stdout, Stderr io.Writer
+}
+
+func (me ExecSink) Play(m Media, offset time.Duration) (Playback, error) {
+	if len(me.Command) == 0 {
+		return nil, errors.New("no command")
+	}
+	r := strings.NewReplacer(
+		"{uri}", m.URI,
+		"{offset}", strconv.FormatFloat(offset.Seconds(), 'f', -1, 64),
+	)
+	args := make([]string, 0, len(me.Command))
+	for _, a := range me.Command {
+		args = append(args, r.Replace(a))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = me.Stdout
+	cmd.Stderr = me.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pb := &execPlayback{
+		cmd:  cmd,
+		done: make(chan struct{}),
+	}
+	go func() {
+		err := cmd.Wait()
+		pb.mu.Lock()
+		if !pb.stopped {
+			pb.err = err
+		}
+		pb.mu.Unlock()
+		close(pb.done)
+	}()
+	return pb, nil
+}
+
+type execPlayback struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+
+	mu      sync.Mutex
+	stopped bool
+	err     error
+}
+
+func (me *execPlayback) Pause() error {
+	return pauseProcess(me.cmd.Process, true)
+}
+
+func (me *execPlayback) Resume() error {
+	return pauseProcess(me.cmd.Process, false)
+}
+
+func (me *execPlayback) Stop() error {
+	me.mu.Lock()
+	me.stopped = true
+	me.mu.Unlock()
+	// A stopped process has to be continued to be killed on some systems.
+	pauseProcess(me.cmd.Process, false)
+	err := me.cmd.Process.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		err = nil
+	}
+	return err
+}
+
+func (me *execPlayback) Done() <-chan struct{} {
+	return me.done
+}
+
+func (me *execPlayback) Err() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.err
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package dmr
+
+import (
+	"errors"
+	"os"
+)
+
+func pauseProcess(p *os.Process, pause bool) error {
+	if !pause {
+		return nil
+	}
+	return errors.New("pausing processes isn't supported")
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package dmr
+
+import (
+	"os"
+	"syscall"
+)
+
+func pauseProcess(p *os.Process, pause bool) error {
+	if pause {
+		return p.Signal(syscall.SIGSTOP)
+	}
+	return p.Signal(syscall.SIGCONT)
+}
@@ -0,0 +1,182 @@
+package dms
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const lpcmTranscodeKey = "lpcm"
+
+// Audio transcodes, keyed like transcodes. LPCM isn't here as its MIME-type
+// depends on the source, see lpcmTranscodeSpec.
+var audioTranscodes = map[string]transcodeSpec{
+	"mp3": {
+		mimeType:        "audio/mpeg",
+		DLNAProfileName: "MP3",
+		Transcode:       transcode.MP3Transcode,
+	},
+	"aac": {
+		mimeType:        "audio/mp4",
+		DLNAProfileName: "AAC_ISO",
+		Transcode:       transcode.AACTranscode,
+	},
+}
+
+// The approximate bitrates of the audio transcodes in bytes per second, as
+// used by the res bitrate attribute.
+var audioTranscodeBitrates = map[string]uint{
+	"mp3": 320000 / 8,
+	"aac": 256000 / 8,
+}
+
+// Codecs that are worth the bandwidth of offering as LPCM.
+var losslessAudioCodecs = map[string]bool{
+	"flac":            true,
+	"alac":            true,
+	"ape":             true,
+	"wavpack":         true,
+	"tta":             true,
+	"tak":             true,
+	"mlp":             true,
+	"truehd":          true,
+	"dsd_lsbf":        true,
+	"dsd_msbf":        true,
+	"dsd_lsbf_planar": true,
+	"dsd_msbf_planar": true,
+	"pcm_s24le":       true,
+	"pcm_s24be":       true,
+	"pcm_s32le":       true,
+	"pcm_s32be":       true,
+	"pcm_f32le":       true,
+	"pcm_f64le":       true,
+}
+
+// Codecs that renderers generally play without help.
+var nativeAudioCodecs = map[string]bool{
+	"mp3":       true,
+	"aac":       true,
+	"pcm_s16le": true,
+	"pcm_s16be": true,
+}
+
+// Returns the first stream of the given codec_type, or nil.
+func firstStreamOfType(info *ffprobe.Info, codecType string) map[string]interface{} {
+	if info == nil {
+		return nil
+	}
+	for _, s := range info.Streams {
+		if s["codec_type"] == codecType {
+			return s
+		}
+	}
+	return nil
+}
+
+// Returns the sample rate and channel count to transcode the given audio
+// stream to for the DLNA LPCM profile, which allows 44.1 or 48kHz, and mono
+// or stereo.
+func lpcmFormat(stream map[string]interface{}) (rate, channels int) {
+	rate, channels = 48000, 2
+	if r, ok := transcode.StreamInt(stream, "sample_rate"); ok && r%11025 == 0 {
+		rate = 44100
+	}
+	if c, ok := transcode.StreamInt(stream, "channels"); ok && c == 1 {
+		channels = 1
+	}
+	return
+}
+
+func lpcmTranscodeSpec(rate, channels int) transcodeSpec {
+	return transcodeSpec{
+		mimeType:        fmt.Sprintf("audio/L16;rate=%d;channels=%d", rate, channels),
+		DLNAProfileName: "LPCM",
+		Transcode:       transcode.LPCMTranscoder(rate, channels),
+	}
+}
+
+// Returns the keys of the audio transcodes worth offering given the first
+// audio stream of a file. Videos get compressed audio only versions, for
+// music videos and concerts.
+func audioTranscodeKeys(stream map[string]interface{}, isVideo bool) []string {
+	if isVideo {
+		if stream == nil {
+			return nil
+		}
+		return []string{"mp3", "aac"}
+	}
+	codec, _ := stream["codec_name"].(string)
+	switch {
+	case codec == "":
+		// We don't know what it is, so offer something most renderers can play.
+		return []string{"mp3"}
+	case nativeAudioCodecs[codec]:
+		return nil
+	case losslessAudioCodecs[codec]:
+		return []string{lpcmTranscodeKey, "mp3", "aac"}
+	default:
+		return []string{"mp3", "aac"}
+	}
+}
+
+// Returns the spec for the transcode key k of the file at path.
+func (me *Server) transcodeSpec(k, path string) (spec transcodeSpec, ok bool) {
+	if spec, ok = transcodes[k]; ok {
+		return
+	}
+	if k == lpcmTranscodeKey {
+		var stream map[string]interface{}
+		if !me.NoProbe {
+			info, _ := me.ffmpegProbe(path)
+			stream = firstStreamOfType(info, "audio")
+		}
+		return lpcmTranscodeSpec(lpcmFormat(stream)), true
+	}
+	spec, ok = audioTranscodes[k]
+	return
+}
+
+func audioTranscodeResources(host, path, duration string, stream map[string]interface{}, isVideo bool) (ret []upnpav.Resource) {
+	for _, k := range audioTranscodeKeys(stream, isVideo) {
+		// The compressed transcodes are resampled to 44.1kHz stereo.
+		var (
+			spec     transcodeSpec
+			bitrate  = audioTranscodeBitrates[k]
+			rate     = 44100
+			channels = 2
+		)
+		if k == lpcmTranscodeKey {
+			rate, channels = lpcmFormat(stream)
+			spec = lpcmTranscodeSpec(rate, channels)
+			bitrate = uint(rate * channels * 2)
+		} else {
+			spec = audioTranscodes[k]
+		}
+		ret = append(ret, upnpav.Resource{
+			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", spec.mimeType, dlna.ContentFeatures{
+				SupportTimeSeek: true,
+				Transcoded:      true,
+				ProfileName:     spec.DLNAProfileName,
+			}.String()),
+			URL: (&url.URL{
+				Scheme: "http",
+				Host:   host,
+				Path:   resPath,
+				RawQuery: url.Values{
+					"path":      {path},
+					"transcode": {k},
+				}.Encode(),
+			}).String(),
+			Bitrate:         bitrate,
+			Duration:        duration,
+			SampleFrequency: uint(rate),
+			NrAudioChannels: uint(channels),
+		})
+	}
+	return
+}
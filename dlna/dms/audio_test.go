@@ -0,0 +1,47 @@
+package dms
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAudioTranscodeKeys(t *testing.T) {
+	for _, c := range []struct {
+		codec   string
+		isVideo bool
+		want    []string
+	}{
+		{"flac", false, []string{lpcmTranscodeKey, "mp3", "aac"}},
+		{"opus", false, []string{"mp3", "aac"}},
+		{"mp3", false, nil},
+		{"", false, []string{"mp3"}},
+		{"ac3", true, []string{"mp3", "aac"}},
+	} {
+		var stream map[string]interface{}
+		if c.codec != "" {
+			stream = map[string]interface{}{"codec_name": c.codec}
+		}
+		got := audioTranscodeKeys(stream, c.isVideo)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q (video=%v): got %q, want %q", c.codec, c.isVideo, got, c.want)
+		}
+	}
+}
+
+func TestLPCMFormat(t *testing.T) {
+	for _, c := range []struct {
+		stream         map[string]interface{}
+		rate, channels int
+	}{
+		{map[string]interface{}{"sample_rate": "44100", "channels": json.Number("2")}, 44100, 2},
+		{map[string]interface{}{"sample_rate": "88200", "channels": 6.0}, 44100, 2},
+		{map[string]interface{}{"sample_rate": "96000", "channels": 1.0}, 48000, 1},
+		{nil, 48000, 2},
+	} {
+		rate, channels := lpcmFormat(c.stream)
+		if rate != c.rate || channels != c.channels {
+			t.Errorf("%v: got %d/%d, want %d/%d", c.stream, rate, channels, c.rate, c.channels)
+		}
+	}
+}
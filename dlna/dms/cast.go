@@ -0,0 +1,620 @@
+package dms
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/access"
+	"github.com/anacrolix/dms/controlpoint"
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/ssdp"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	castPath          = "/cast"
+	castRenderersPath = "/cast/renderers"
+	// How often renderers are polled to follow what they're playing, by default.
+	defaultCastPollInterval = 2 * time.Second
+	// A cast is given up on after this many polls of its renderer fail in a row.
+	castMaxPollErrors = 5
+)
+
+var (
+	errRendererNotFound = errors.New("renderer not found")
+	errBadCastPath      = errors.New("bad path")
+)
+
+// Renderer is a MediaRenderer on the network that items can be cast to.
+type Renderer struct {
+	Name string
+	UDN  string
+	// The URL of the renderer's root device description.
+	Location string
+}
+
+// CastRequest asks for items to be played on a renderer, one after another.
+type CastRequest struct {
+	// The renderer's UDN, friendly name, or description URL.
+	Renderer string
+	// Paths of items relative to the root. Directories are replaced by the items directly in
+	// them.
+	Paths []string
+	// Where to start the first item, such as "0:01:30". It starts at the beginning if empty.
+	Start string
+}
+
+// CastStatus describes items being played on a renderer.
+type CastStatus struct {
+	Renderer Renderer
+	Paths    []string
+	// The index in Paths of the item the renderer is on.
+	Current int
+	Started time.Time
+}
+
+type castItem struct {
+	path     string
+	uri      string
+	metadata string
+}
+
+type castSession struct {
+	srv    *Server
+	logger log.Logger
+	avt    *controlpoint.Service
+	items  []castItem
+	cancel context.CancelFunc
+	done   chan struct{}
+	// Whether the renderer has been seen playing the current item.
+	sawPlaying bool
+	// Set if the renderer doesn't support SetNextAVTransportURI, so the next item has to be
+	// started when the current one ends.
+	noNext bool
+
+	mu     sync.Mutex
+	status CastStatus
+}
+
+type castSessions struct {
+	mu sync.Mutex
+	// By renderer UDN.
+	m map[string]*castSession
+}
+
+type castInstance struct {
+	InstanceID uint32
+}
+
+type castMediaInfo struct {
+	CurrentURI string
+	NextURI    string
+}
+
+type castTransportInfo struct {
+	CurrentTransportState string
+}
+
+func (me *Server) castPollInterval() time.Duration {
+	if me.CastPollInterval != 0 {
+		return me.CastPollInterval
+	}
+	return defaultCastPollInterval
+}
+
+func rendererOf(d *controlpoint.Device) Renderer {
+	return Renderer{
+		Name:     d.Desc.FriendlyName,
+		UDN:      d.Desc.UDN,
+		Location: d.Location,
+	}
+}
+
+// Returns the renderers found on the interfaces the server does SSDP on.
+func (me *Server) discoverRenderers(ctx context.Context) (ret []*controlpoint.Device, err error) {
+	ifs := me.ssdpInterfaces()
+	if len(ifs) == 0 {
+		return
+	}
+	devices, err := controlpoint.Discover(ctx, ssdp.SearchOptions{
+		ST:         upnpav.MediaRendererDeviceType,
+		Interfaces: ifs,
+	})
+	if err != nil {
+		return
+	}
+	for _, d := range devices {
+		if r := d.Device(upnpav.MediaRendererDeviceType); r != nil {
+			ret = append(ret, r)
+		}
+	}
+	return
+}
+
+// Renderers searches the network for renderers.
+func (me *Server) Renderers(ctx context.Context) (ret []Renderer, err error) {
+	devices, err := me.discoverRenderers(ctx)
+	for _, d := range devices {
+		ret = append(ret, rendererOf(d))
+	}
+	return
+}
+
+// Finds the renderer by description URL, or by UDN or friendly name among those discovered.
+func (me *Server) findRenderer(ctx context.Context, spec string) (*controlpoint.Device, error) {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		d, err := controlpoint.FetchDevice(ctx, spec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errRendererNotFound, err)
+		}
+		if r := d.Device(upnpav.MediaRendererDeviceType); r != nil {
+			return r, nil
+		}
+		return nil, fmt.Errorf("%w: %s is not a MediaRenderer", errRendererNotFound, spec)
+	}
+	devices, err := me.discoverRenderers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if d.Desc.UDN == spec || strings.EqualFold(d.Desc.FriendlyName, spec) {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", errRendererNotFound, spec)
+}
+
+// Returns the host renderer d can reach us on.
+func (me *Server) castHost(d *controlpoint.Device) (string, error) {
+	u, err := url.Parse(d.Location)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	// Nothing is sent, this only picks the local address routed to the renderer.
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	return net.JoinHostPort(ip.String(), strconv.Itoa(me.httpPort())), nil
+}
+
+// Returns the item to cast with only the resource the renderer is to play, which is the first as
+// they're ordered for the renderer.
+func castResource(item upnpav.Item) (upnpav.Item, error) {
+	if len(item.Res) == 0 {
+		return item, errors.New("no resources")
+	}
+	item.Res = item.Res[:1]
+	return item, nil
+}
+
+func (me *Server) castItem(cds *contentDirectoryService, item upnpav.Item) (ret castItem, err error) {
+	o, err := cds.objectFromID(item.ID)
+	if err != nil {
+		return
+	}
+	item, err = castResource(item)
+	if err != nil {
+		return
+	}
+	buf, err := xml.Marshal(item)
+	if err != nil {
+		return
+	}
+	ret = castItem{
+		path:     o.Path,
+		uri:      item.Res[0].URL,
+		metadata: didl_lite(string(buf)),
+	}
+	return
+}
+
+// Returns the items to cast for the paths, with URLs on host, and resources the sink accepts if
+// there are any.
+func (me *Server) castItems(paths []string, host string, sink []dlna.ProtocolInfo) (ret []castItem, err error) {
+	cds := me.services["ContentDirectory"].(*contentDirectoryService)
+	for _, p := range paths {
+		o := object{path.Clean("/" + p), me.RootObjectPath}
+		fi, err := os.Stat(o.FilePath())
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", errBadCastPath, p, err)
+		}
+		var objs []interface{}
+		if fi.IsDir() {
+			objs, err = cds.readContainer(o, host, "", sink)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %v", errBadCastPath, p, err)
+			}
+		} else {
+			obj, err := cds.cdsObjectToUpnpavObject(o, fi, host, "", sink)
+			if err != nil {
+				return nil, fmt.Errorf("%w %q: %v", errBadCastPath, p, err)
+			}
+			if _, ok := obj.(upnpav.Item); !ok {
+				return nil, fmt.Errorf("%w %q: not a media item", errBadCastPath, p)
+			}
+			objs = append(objs, obj)
+		}
+		for _, obj := range objs {
+			item, ok := obj.(upnpav.Item)
+			if !ok {
+				continue
+			}
+			ci, err := me.castItem(cds, item)
+			if err != nil {
+				me.Logger.Levelf(log.Warning, "not casting %q: %v", item.ID, err)
+				continue
+			}
+			ret = append(ret, ci)
+		}
+	}
+	if len(ret) == 0 {
+		err = fmt.Errorf("%w: nothing to play", errBadCastPath)
+	}
+	return
+}
+
+// Cast plays the requested items on a renderer, queueing each after the one before. Any cast
+// already going to the renderer is replaced.
+func (me *Server) Cast(ctx context.Context, req CastRequest) (status CastStatus, err error) {
+	d, err := me.findRenderer(ctx, req.Renderer)
+	if err != nil {
+		return
+	}
+	avt := d.Service(upnpav.AVTransportServiceType)
+	if avt == nil {
+		err = fmt.Errorf("%q has no AVTransport service", d.Desc.FriendlyName)
+		return
+	}
+	host, err := me.castHost(d)
+	if err != nil {
+		return
+	}
+	sink, err := me.fetchRendererSink(ctx, d)
+	if err != nil {
+		me.Logger.Levelf(log.Debug, "getting protocol info of %q: %v", d.Desc.FriendlyName, err)
+	}
+	items, err := me.castItems(req.Paths, host, sink)
+	if err != nil {
+		return
+	}
+	me.stopCastSession(d.Desc.UDN)
+	// Some renderers refuse a new URI while they're playing.
+	avt.Call(ctx, "Stop", [][2]string{{"InstanceID", "0"}})
+	if err = castPlay(ctx, avt, items[0]); err != nil {
+		return
+	}
+	if req.Start != "" {
+		_, err = avt.Call(ctx, "Seek", [][2]string{
+			{"InstanceID", "0"},
+			{"Unit", "REL_TIME"},
+			{"Target", req.Start},
+		})
+		if err != nil {
+			err = fmt.Errorf("seeking to %s: %w", req.Start, err)
+			return
+		}
+	}
+	sessCtx, cancel := context.WithCancel(context.Background())
+	s := &castSession{
+		srv:    me,
+		logger: me.Logger.WithNames("cast"),
+		avt:    avt,
+		items:  items,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		status: CastStatus{
+			Renderer: rendererOf(d),
+			Started:  time.Now(),
+		},
+	}
+	for _, item := range items {
+		s.status.Paths = append(s.status.Paths, item.path)
+	}
+	me.casts.mu.Lock()
+	if me.casts.m == nil {
+		me.casts.m = make(map[string]*castSession)
+	}
+	me.casts.m[d.Desc.UDN] = s
+	me.casts.mu.Unlock()
+	go s.run(sessCtx)
+	status = s.Status()
+	return
+}
+
+// Sets the renderer to the item and plays it.
+func castPlay(ctx context.Context, avt *controlpoint.Service, item castItem) error {
+	_, err := avt.Call(ctx, "SetAVTransportURI", [][2]string{
+		{"InstanceID", "0"},
+		{"CurrentURI", item.uri},
+		{"CurrentURIMetaData", item.metadata},
+	})
+	if err != nil {
+		return fmt.Errorf("setting URI: %w", err)
+	}
+	_, err = avt.Call(ctx, "Play", [][2]string{{"InstanceID", "0"}, {"Speed", "1"}})
+	if err != nil {
+		return fmt.Errorf("playing: %w", err)
+	}
+	return nil
+}
+
+// StopCast stops the cast to the renderer, given by UDN, friendly name or description URL. The
+// renderer is told to stop.
+func (me *Server) StopCast(ctx context.Context, renderer string) error {
+	var s *castSession
+	me.casts.mu.Lock()
+	for _, cs := range me.casts.m {
+		r := cs.Status().Renderer
+		if r.UDN == renderer || r.Location == renderer || strings.EqualFold(r.Name, renderer) {
+			s = cs
+			break
+		}
+	}
+	me.casts.mu.Unlock()
+	if s == nil {
+		return fmt.Errorf("%w: no cast to %q", errRendererNotFound, renderer)
+	}
+	me.stopCastSession(s.Status().Renderer.UDN)
+	_, err := s.avt.Call(ctx, "Stop", [][2]string{{"InstanceID", "0"}})
+	return err
+}
+
+// Stops following the cast to the renderer, if there is one.
+func (me *Server) stopCastSession(udn string) {
+	me.casts.mu.Lock()
+	s := me.casts.m[udn]
+	delete(me.casts.m, udn)
+	me.casts.mu.Unlock()
+	if s != nil {
+		s.cancel()
+		<-s.done
+	}
+}
+
+func (me *Server) closeCastSessions() {
+	me.casts.mu.Lock()
+	ss := me.casts.m
+	me.casts.m = nil
+	me.casts.mu.Unlock()
+	for _, s := range ss {
+		s.cancel()
+		<-s.done
+	}
+}
+
+// CastStatus returns the casts that are going, oldest first.
+func (me *Server) CastStatus() (ret []CastStatus) {
+	me.casts.mu.Lock()
+	for _, s := range me.casts.m {
+		ret = append(ret, s.Status())
+	}
+	me.casts.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Started.Before(ret[j].Started)
+	})
+	return
+}
+
+func (s *castSession) Status() (ret CastStatus) {
+	s.mu.Lock()
+	ret = s.status
+	s.mu.Unlock()
+	return
+}
+
+func (s *castSession) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.Current
+}
+
+func (s *castSession) setCurrent(i int) {
+	s.mu.Lock()
+	s.status.Current = i
+	s.mu.Unlock()
+	s.sawPlaying = false
+}
+
+// Follows the renderer through the items, queueing each next one, until it's done with them or
+// something else is played.
+func (s *castSession) run(ctx context.Context) {
+	defer close(s.done)
+	defer func() {
+		udn := s.Status().Renderer.UDN
+		s.srv.casts.mu.Lock()
+		if s.srv.casts.m[udn] == s {
+			delete(s.srv.casts.m, udn)
+		}
+		s.srv.casts.mu.Unlock()
+	}()
+	ticker := time.NewTicker(s.srv.castPollInterval())
+	defer ticker.Stop()
+	errs := 0
+	for {
+		done, err := s.poll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			errs++
+			s.logger.Levelf(log.Warning, "polling %q: %v", s.status.Renderer.Name, err)
+			if errs >= castMaxPollErrors {
+				return
+			}
+		} else {
+			errs = 0
+		}
+		if done {
+			s.logger.Levelf(log.Debug, "cast to %q done", s.status.Renderer.Name)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *castSession) poll(ctx context.Context) (done bool, err error) {
+	mi, err := controlpoint.Invoke[castInstance, castMediaInfo](ctx, s.avt, "GetMediaInfo", castInstance{})
+	if err != nil {
+		return
+	}
+	ti, err := controlpoint.Invoke[castInstance, castTransportInfo](ctx, s.avt, "GetTransportInfo", castInstance{})
+	if err != nil {
+		return
+	}
+	i := s.current()
+	for i < len(s.items) && s.items[i].uri != mi.CurrentURI {
+		i++
+	}
+	if i == len(s.items) {
+		// Something else is playing now.
+		return true, nil
+	}
+	if i != s.current() {
+		s.setCurrent(i)
+	}
+	last := i+1 == len(s.items)
+	switch ti.CurrentTransportState {
+	case "STOPPED", "NO_MEDIA_PRESENT":
+		if !s.sawPlaying {
+			break
+		}
+		if last || !s.noNext {
+			// Either it's all played, or the renderer was stopped rather than moving on to the
+			// item that was queued.
+			return true, nil
+		}
+		if err = castPlay(ctx, s.avt, s.items[i+1]); err != nil {
+			return
+		}
+		s.setCurrent(i + 1)
+		return
+	case "PLAYING", "TRANSITIONING":
+		s.sawPlaying = true
+	}
+	if last || s.noNext || mi.NextURI == s.items[i+1].uri {
+		return
+	}
+	next := s.items[i+1]
+	_, err = s.avt.Call(ctx, "SetNextAVTransportURI", [][2]string{
+		{"InstanceID", "0"},
+		{"NextURI", next.uri},
+		{"NextURIMetaData", next.metadata},
+	})
+	var upnpErr *upnp.Error
+	if errors.As(err, &upnpErr) && upnpErr.Code == upnp.InvalidActionErrorCode {
+		s.logger.Levelf(log.Debug, "%q can't queue items, starting each when the last ends", s.status.Renderer.Name)
+		s.noNext = true
+		err = nil
+	}
+	return
+}
+
+// Responds with an error if the client may not use the cast API, and returns whether it may.
+func (me *Server) checkCastAPI(w http.ResponseWriter, r *http.Request) bool {
+	if !me.CastAPI {
+		http.Error(w, "the cast API is disabled", http.StatusNotFound)
+		return false
+	}
+	if !me.CastAccess.Check(access.RemoteIP(r), r.Method+" "+r.URL.Path) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (me *Server) serveCast(w http.ResponseWriter, r *http.Request) {
+	if !me.checkCastAPI(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		me.serveJSON(w, me.CastStatus())
+	case http.MethodPost:
+		// Only JSON is accepted, as browsers won't send it cross-origin without asking, so web
+		// pages can't start casts.
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req CastRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status, err := me.Cast(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), castErrorStatus(err))
+			return
+		}
+		me.serveJSON(w, status)
+	case http.MethodDelete:
+		if err := me.StopCast(r.Context(), r.URL.Query().Get("renderer")); err != nil {
+			http.Error(w, err.Error(), castErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func castErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errRendererNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errBadCastPath):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func (me *Server) serveCastRenderers(w http.ResponseWriter, r *http.Request) {
+	if !me.checkCastAPI(w, r) {
+		return
+	}
+	rs, err := me.Renderers(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	me.serveJSON(w, rs)
+}
+
+func (me *Server) serveJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		me.Logger.Printf("error encoding response: %s", err)
+	}
+}
@@ -0,0 +1,242 @@
+package dms_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/access"
+	"github.com/anacrolix/dms/controlpoint"
+	"github.com/anacrolix/dms/dlna/dmr"
+	"github.com/anacrolix/dms/dlna/dms"
+	"github.com/anacrolix/dms/server"
+)
+
+// Plays until the test ends it.
+type testPlayback struct {
+	media dmr.Media
+	done  chan struct{}
+}
+
+func (me *testPlayback) Pause() error  { return nil }
+func (me *testPlayback) Resume() error { return nil }
+func (me *testPlayback) Err() error    { return nil }
+
+func (me *testPlayback) Stop() error {
+	select {
+	case <-me.done:
+	default:
+		close(me.done)
+	}
+	return nil
+}
+
+func (me *testPlayback) Done() <-chan struct{} { return me.done }
+
+type testSink chan *testPlayback
+
+func (me testSink) Play(m dmr.Media, offset time.Duration) (dmr.Playback, error) {
+	pb := &testPlayback{media: m, done: make(chan struct{})}
+	me <- pb
+	return pb, nil
+}
+
+func (me testSink) next(t *testing.T) *testPlayback {
+	t.Helper()
+	select {
+	case pb := <-me:
+		return pb
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing played")
+		return nil
+	}
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestCast(t *testing.T) {
+	ctx := context.Background()
+	sink := make(testSink, 1)
+	r := &dmr.Renderer{FriendlyName: "tv", Sink: sink, Logger: log.Default}
+	rl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := server.New(r.Device(), server.WithListener(rl), server.WithInterfaces(), server.WithLogger(log.Default))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go rs.Run(ctx)
+	t.Cleanup(func() { rs.Close() })
+	location := "http://" + rl.Addr().String() + "/rootDesc.xml"
+	rd, err := controlpoint.FetchDevice(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	avt := rd.Service("urn:schemas-upnp-org:service:AVTransport:1")
+
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "Music"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		if err := os.WriteFile(filepath.Join(root, "Music", name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dms.Server{
+		HTTPConn:         l,
+		Interfaces:       []net.Interface{},
+		FriendlyName:     "test",
+		RootObjectPath:   root,
+		NoProbe:          true,
+		NoTranscode:      true,
+		CastPollInterval: 10 * time.Millisecond,
+		CastAPI:          true,
+		Logger:           log.Default,
+	}
+	if err := srv.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	t.Cleanup(func() { srv.Close() })
+	api := "http://" + l.Addr().String() + "/cast"
+
+	body, _ := json.Marshal(dms.CastRequest{Renderer: location, Paths: []string{"Music"}})
+	resp, err := http.Post(api, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("casting: %s", resp.Status)
+	}
+
+	a := sink.next(t)
+	if !strings.Contains(a.media.URI, "a.mp3") {
+		t.Fatalf("first played %q", a.media.URI)
+	}
+	if !strings.Contains(a.media.Metadata, "<dc:title>a.mp3</dc:title>") {
+		t.Fatalf("metadata %q", a.media.Metadata)
+	}
+	waitFor(t, "b to be queued", func() bool {
+		ret, err := avt.Call(ctx, "GetMediaInfo", [][2]string{{"InstanceID", "0"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, arg := range ret {
+			if arg[0] == "NextURI" {
+				return strings.Contains(arg[1], "b.mp3")
+			}
+		}
+		return false
+	})
+	a.Stop()
+	b := sink.next(t)
+	if !strings.Contains(b.media.URI, "b.mp3") {
+		t.Fatalf("second played %q", b.media.URI)
+	}
+	status := func() (ret []dms.CastStatus) {
+		resp, err := http.Get(api)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	waitFor(t, "the cast to move on", func() bool {
+		s := status()
+		return len(s) == 1 && s[0].Current == 1
+	})
+	if s := status()[0]; s.Renderer.Name != "tv" || len(s.Paths) != 2 {
+		t.Fatalf("status %+v", s)
+	}
+	b.Stop()
+	waitFor(t, "the cast to end", func() bool {
+		return len(status()) == 0
+	})
+}
+
+func TestCastBadRequests(t *testing.T) {
+	newServer := func(castAPI bool, castAccess *access.Policy) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &dms.Server{
+			HTTPConn:       l,
+			Interfaces:     []net.Interface{},
+			RootObjectPath: t.TempDir(),
+			NoProbe:        true,
+			Logger:         log.Default,
+			CastAPI:        castAPI,
+			CastAccess:     castAccess,
+		}
+		if err := srv.Init(); err != nil {
+			t.Fatal(err)
+		}
+		go srv.Run()
+		t.Cleanup(func() { srv.Close() })
+		return "http://" + l.Addr().String() + "/cast"
+	}
+	expectStatus := func(what string, req *http.Request, code int) {
+		t.Helper()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%s: %s", what, resp.Status)
+		}
+	}
+	castRequest := func(api, contentType, body string) *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, api, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		return req
+	}
+	const unknown = `{"Renderer": "nowhere", "Paths": ["x"]}`
+
+	api := newServer(true, nil)
+	expectStatus("unknown renderer", castRequest(api, "application/json", unknown), http.StatusNotFound)
+	expectStatus("form", castRequest(api, "application/x-www-form-urlencoded", "renderer=nowhere&path=x"), http.StatusUnsupportedMediaType)
+	req, _ := http.NewRequest(http.MethodDelete, api+"?renderer=nowhere", nil)
+	expectStatus("stopping unknown cast", req, http.StatusNotFound)
+
+	api = newServer(false, nil)
+	expectStatus("disabled", castRequest(api, "application/json", unknown), http.StatusNotFound)
+	req, _ = http.NewRequest(http.MethodGet, api+"/renderers", nil)
+	expectStatus("disabled renderers", req, http.StatusNotFound)
+
+	rules, err := access.ParseRules("!127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api = newServer(true, &access.Policy{Rules: rules, Logger: log.Default})
+	expectStatus("denied", castRequest(api, "application/json", unknown), http.StatusForbidden)
+}
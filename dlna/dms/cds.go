@@ -13,12 +13,15 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/anacrolix/ffprobe"
 	"github.com/anacrolix/log"
 
 	"github.com/anacrolix/dms/dlna"
 	"github.com/anacrolix/dms/misc"
+	"github.com/anacrolix/dms/transcode"
 	"github.com/anacrolix/dms/upnp"
 	"github.com/anacrolix/dms/upnpav"
 )
@@ -28,10 +31,34 @@ const dmsMetadataSuffix = ".dms.json"
 type contentDirectoryService struct {
 	*Server
 	upnp.Eventing
+	*upnp.Actions
+
+	bookmarks      bookmarks
+	systemUpdateID atomic.Uint32
+	walks          walkCache
+
+	writeMu sync.Mutex
+	// Objects made with CreateObject that are waiting for their content, by path.
+	imports        map[string]*pendingImport
+	lastTransferID uint32
+	transfers      map[uint32]*transfer
 }
 
-func (cds *contentDirectoryService) updateIDString() string {
-	return fmt.Sprintf("%d", uint32(os.Getpid()))
+func (cds *contentDirectoryService) updateID() uint32 {
+	return cds.systemUpdateID.Load()
+}
+
+// Bumps the SystemUpdateID after the tree is changed, so that clients don't use what they cached.
+func (cds *contentDirectoryService) changed() {
+	id := cds.systemUpdateID.Add(1)
+	cds.Notify(upnp.Variable{XMLName: xml.Name{Local: "SystemUpdateID"}, Value: strconv.FormatUint(uint64(id), 10)})
+}
+
+func (cds *contentDirectoryService) eventVariables() []upnp.Variable {
+	return []upnp.Variable{
+		{XMLName: xml.Name{Local: "SystemUpdateID"}, Value: strconv.FormatUint(uint64(cds.updateID()), 10)},
+		{XMLName: xml.Name{Local: "TransferIDs"}, Value: cds.transferIDs()},
+	}
 }
 
 type dmsDynamicStreamResource struct {
@@ -73,7 +100,7 @@ func readDynamicStream(metadataPath string) (*dmsDynamicMediaItem, error) {
 	return &re, nil
 }
 
-func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObject object, fileInfo os.FileInfo, host, userAgent string) (ret interface{}, err error) {
+func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObject object, fileInfo os.FileInfo, host, userAgent string, sink []dlna.ProtocolInfo) (ret interface{}, err error) {
 	// at this point we know that entryFilePath points to a .dms.json file; slurp and parse
 	dmsMediaItem, err := readDynamicStream(cdsObject.FilePath())
 	if err != nil {
@@ -161,17 +188,24 @@ func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObjec
 		}).String(),
 		ProtocolInfo: "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN",
 	})
+	kind := "video"
+	if dmsMediaItem.Type == "audio" {
+		kind = "audio"
+	}
+	preferSinkResource(item.Res, kind, sink)
 
 	ret = item
 	return
 }
 
 // Turns the given entry and DMS host into a UPnP object. A nil object is
-// returned if the entry is not of interest.
+// returned if the entry is not of interest. Resources are ordered for the
+// renderer with the sink, if it's known.
 func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 	cdsObject object,
 	fileInfo os.FileInfo,
 	host, userAgent string,
+	sink []dlna.ProtocolInfo,
 ) (ret interface{}, err error) {
 	entryFilePath := cdsObject.FilePath()
 	ignored, err := me.IgnorePath(entryFilePath)
@@ -183,12 +217,12 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 	}
 	isDmsMetadata := strings.HasSuffix(entryFilePath, dmsMetadataSuffix)
 	if !fileInfo.IsDir() && me.AllowDynamicStreams && isDmsMetadata {
-		return me.cdsObjectDynamicStreamToUpnpavObject(cdsObject, fileInfo, host, userAgent)
+		return me.cdsObjectDynamicStreamToUpnpavObject(cdsObject, fileInfo, host, userAgent, sink)
 	}
 
 	obj := upnpav.Object{
 		ID:         cdsObject.ID(),
-		Restricted: 1,
+		Restricted: me.restricted(),
 		ParentID:   cdsObject.ParentID(),
 	}
 	if fileInfo.IsDir() {
@@ -237,7 +271,8 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 		resDuration   string
 	)
 	if !me.NoProbe {
-		ffInfo, probeErr := me.ffmpegProbe(entryFilePath)
+		var probeErr error
+		ffInfo, probeErr = me.ffmpegProbe(entryFilePath)
 		switch probeErr {
 		case nil:
 			if ffInfo != nil {
@@ -260,17 +295,17 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 				if strm["codec_type"] != "video" {
 					continue
 				}
-				width := strm["width"]
-				height := strm["height"]
-				return fmt.Sprintf("%.0fx%.0f", width, height)
+				width, _ := transcode.StreamInt(strm, "width")
+				height, _ := transcode.StreamInt(strm, "height")
+				return fmt.Sprintf("%dx%d", width, height)
 			}
 		}
 		return ""
 	}()
 	item := upnpav.Item{
 		Object: obj,
-		// Capacity: 1 for raw, 1 for icon, plus transcodes.
-		Res: make([]upnpav.Resource, 0, 2+len(transcodes)),
+		// Capacity: 1 for raw, 1 for icon, 1 for HLS, plus transcodes.
+		Res: make([]upnpav.Resource, 0, 3+len(transcodes)+len(audioTranscodes)),
 	}
 	item.Res = append(item.Res, upnpav.Resource{
 		URL: (&url.URL{
@@ -292,18 +327,20 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 	if mimeType.IsVideo() {
 		if !me.NoTranscode {
 			item.Res = append(item.Res, transcodeResources(host, cdsObject.Path, resolution, resDuration)...)
+			item.Res = append(item.Res, hlsResource(host, cdsObject.Path, resolution, resDuration))
+			item.Res = append(item.Res, trackResources(host, cdsObject.Path, resolution, resDuration, ffInfo)...)
 		}
-		item.Res = append(item.Res, upnpav.Resource{
-			URL: (&url.URL{
-				Scheme: "http",
-				Host:   host,
-				Path:   subtitlePath,
-				RawQuery: url.Values{
-					"path": {cdsObject.Path},
-				}.Encode(),
-			}).String(),
-			ProtocolInfo: "http-get:*:text/plain",
-		})
+		subs := me.subtitles(entryFilePath, ffInfo)
+		item.Res = append(item.Res, me.subtitleResources(host, cdsObject.Path, subs)...)
+		item.CaptionInfoEx = me.captionInfoEx(host, cdsObject.Path, subs)
+	}
+	item.DcmInfo = me.dcmInfo(cdsObject, fileInfo, mimeType.IsVideo())
+	// Audio only versions come after the video resources, so they aren't
+	// picked by renderers that play the first resource they can.
+	if (mimeType.IsVideo() || mimeType.IsAudio()) && !me.NoTranscode {
+		item.Res = append(item.Res, audioTranscodeResources(
+			host, cdsObject.Path, resDuration, firstStreamOfType(ffInfo, "audio"), mimeType.IsVideo(),
+		)...)
 	}
 	if mimeType.IsVideo() || mimeType.IsImage() {
 		item.Res = append(item.Res, upnpav.Resource{
@@ -319,6 +356,7 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 			ProtocolInfo: "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN",
 		})
 	}
+	preferSinkResource(item.Res, mimeType.Type(), sink)
 	ret = item
 	return
 }
@@ -327,6 +365,7 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 func (me *contentDirectoryService) readContainer(
 	o object,
 	host, userAgent string,
+	sink []dlna.ProtocolInfo,
 ) (ret []interface{}, err error) {
 	sfis := sortableFileInfoSlice{
 		// TODO(anacrolix): Dig up why this special cast was added.
@@ -339,7 +378,7 @@ func (me *contentDirectoryService) readContainer(
 	sort.Sort(sfis)
 	for _, fi := range sfis.fileInfoSlice {
 		child := object{path.Join(o.Path, fi.Name()), me.RootObjectPath}
-		obj, err := me.cdsObjectToUpnpavObject(child, fi, host, userAgent)
+		obj, err := me.cdsObjectToUpnpavObject(child, fi, host, userAgent, sink)
 		if err != nil {
 			me.Logger.Printf("error with %s: %s", child.FilePath(), err)
 			continue
@@ -355,8 +394,16 @@ type browse struct {
 	ObjectID       string
 	BrowseFlag     string
 	Filter         string
-	StartingIndex  int
-	RequestedCount int
+	StartingIndex  uint32
+	RequestedCount uint32
+	SortCriteria   string
+}
+
+type browseResponse struct {
+	Result         string
+	NumberReturned uint32
+	TotalMatches   uint32
+	UpdateID       uint32
 }
 
 // ContentDirectory object from ObjectID.
@@ -377,120 +424,133 @@ func (me *contentDirectoryService) objectFromID(id string) (o object, err error)
 	return
 }
 
-func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
+// Binds the service's actions, so that it can handle them.
+func (me *contentDirectoryService) bindActions() {
+	me.Actions = upnp.NewActions(&contentDirectoryServiceDef)
+	upnp.Bind(me.Actions, "GetSystemUpdateID", func(*http.Request, struct{}) (ret struct{ Id uint32 }, err error) {
+		ret.Id = me.updateID()
+		return
+	})
+	upnp.Bind(me.Actions, "GetSortCapabilities", func(*http.Request, struct{}) (ret struct{ SortCaps string }, err error) {
+		ret.SortCaps = "dc:title"
+		return
+	})
+	upnp.Bind(me.Actions, "GetSortExtensionCapabilities", func(*http.Request, struct{}) (ret struct{ SortExtensionCaps string }, err error) {
+		return
+	})
+	upnp.Bind(me.Actions, "GetFeatureList", func(*http.Request, struct{}) (ret struct{ FeatureList string }, err error) {
+		// No optional features, such as BASICVIEW, are supported.
+		ret.FeatureList = `<?xml version="1.0" encoding="UTF-8"?>
+<Features xmlns="urn:schemas-upnp-org:av:avs" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="urn:schemas-upnp-org:av:avs http://www.upnp.org/schemas/av/avs.xsd"></Features>`
+		return
+	})
+	upnp.Bind(me.Actions, "GetSearchCapabilities", func(*http.Request, struct{}) (ret struct{ SearchCaps string }, err error) {
+		ret.SearchCaps = searchCapabilities
+		return
+	})
+	upnp.Bind(me.Actions, "Browse", me.browse)
+	upnp.Bind(me.Actions, "Search", me.search)
+	upnp.Bind(me.Actions, "CreateObject", me.createObject)
+	upnp.Bind(me.Actions, "DestroyObject", me.destroyObject)
+	upnp.Bind(me.Actions, "UpdateObject", me.updateObject)
+	upnp.Bind(me.Actions, "ImportResource", me.importResource)
+	upnp.Bind(me.Actions, "StopTransferResource", me.stopTransferResource)
+	upnp.Bind(me.Actions, "GetTransferProgress", me.getTransferProgress)
+	// Samsung Extensions
+	upnp.Bind(me.Actions, "X_GetFeatureList", func(*http.Request, struct{}) (ret struct{ FeatureList string }, err error) {
+		ret.FeatureList = samsungFeatureList
+		return
+	})
+	upnp.Bind(me.Actions, "X_SetBookmark", func(_ *http.Request, in setBookmark) (struct{}, error) {
+		obj, err := me.objectFromID(in.ObjectID)
+		if err != nil {
+			return struct{}{}, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+		}
+		me.bookmarks.set(obj.Path, in.PosSecond)
+		return struct{}{}, nil
+	})
+}
+
+func (me *contentDirectoryService) browse(r *http.Request, browse browse) (ret browseResponse, err error) {
 	host := r.Host
 	userAgent := r.UserAgent()
-	switch action {
-	case "GetSystemUpdateID":
-		return [][2]string{
-			{"Id", me.updateIDString()},
-		}, nil
-	case "GetSortCapabilities":
-		return [][2]string{
-			{"SortCaps", "dc:title"},
-		}, nil
-	case "Browse":
-		var browse browse
-		if err := xml.Unmarshal([]byte(argsXML), &browse); err != nil {
-			return nil, err
+	sink := me.rendererSink(r)
+	if _, ok := msViews[browse.ObjectID]; ok {
+		return me.browseView(r, browse)
+	}
+	obj, err := me.objectFromID(browse.ObjectID)
+	if err != nil {
+		err = upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+		return
+	}
+	ret.UpdateID = me.updateID()
+	switch browse.BrowseFlag {
+	case "BrowseDirectChildren":
+		var objs []interface{}
+		if me.OnBrowseDirectChildren == nil {
+			objs, err = me.readContainer(obj, host, userAgent, sink)
+		} else {
+			objs, err = me.OnBrowseDirectChildren(obj.Path, obj.RootObjectPath, host, userAgent)
 		}
-		obj, err := me.objectFromID(browse.ObjectID)
 		if err != nil {
-			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+			err = upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+			return
 		}
-		switch browse.BrowseFlag {
-		case "BrowseDirectChildren":
-			var objs []interface{}
-			if me.OnBrowseDirectChildren == nil {
-				objs, err = me.readContainer(obj, host, userAgent)
-			} else {
-				objs, err = me.OnBrowseDirectChildren(obj.Path, obj.RootObjectPath, host, userAgent)
-			}
-			if err != nil {
-				return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
-			}
-			totalMatches := len(objs)
-			objs = objs[func() (low int) {
-				low = browse.StartingIndex
-				if low > len(objs) {
-					low = len(objs)
-				}
-				return
-			}():]
-			if browse.RequestedCount != 0 && int(browse.RequestedCount) < len(objs) {
-				objs = objs[:browse.RequestedCount]
-			}
-			result, err := xml.Marshal(objs)
+		ret.TotalMatches = uint32(len(objs))
+		if int64(browse.StartingIndex) < int64(len(objs)) {
+			objs = objs[browse.StartingIndex:]
+		} else {
+			objs = nil
+		}
+		if browse.RequestedCount != 0 && int64(browse.RequestedCount) < int64(len(objs)) {
+			objs = objs[:browse.RequestedCount]
+		}
+		var result []byte
+		result, err = xml.Marshal(objs)
+		if err != nil {
+			return
+		}
+		ret.Result = didl_lite(string(result))
+		ret.NumberReturned = uint32(len(objs))
+		return
+	case "BrowseMetadata":
+		var obj_ interface{}
+		if me.OnBrowseMetadata == nil {
+			var fileInfo os.FileInfo
+			fileInfo, err = os.Stat(obj.FilePath())
 			if err != nil {
-				return nil, err
-			}
-			return [][2]string{
-				{"Result", didl_lite(string(result))},
-				{"NumberReturned", fmt.Sprint(len(objs))},
-				{"TotalMatches", fmt.Sprint(totalMatches)},
-				{"UpdateID", me.updateIDString()},
-			}, nil
-		case "BrowseMetadata":
-			var ret interface{}
-			var err error
-			if me.OnBrowseMetadata == nil {
-				var fileInfo os.FileInfo
-				fileInfo, err = os.Stat(obj.FilePath())
-				if err != nil {
-					if os.IsNotExist(err) {
-						return nil, &upnp.Error{
-							Code: upnpav.NoSuchObjectErrorCode,
-							Desc: err.Error(),
-						}
+				if os.IsNotExist(err) {
+					err = &upnp.Error{
+						Code: upnpav.NoSuchObjectErrorCode,
+						Desc: err.Error(),
 					}
-					return nil, err
 				}
-				ret, err = me.cdsObjectToUpnpavObject(obj, fileInfo, host, userAgent)
-			} else {
-				ret, err = me.OnBrowseMetadata(obj.Path, obj.RootObjectPath, host, userAgent)
-			}
-			if err != nil {
-				return nil, err
-			}
-			buf, err := xml.Marshal(ret)
-			if err != nil {
-				return nil, err
+				return
 			}
-			return [][2]string{
-				{"Result", didl_lite(func() string { return string(buf) }())},
-				{"NumberReturned", "1"},
-				{"TotalMatches", "1"},
-				{"UpdateID", me.updateIDString()},
-			}, nil
-		default:
-			return nil, upnp.Errorf(
-				upnp.ArgumentValueInvalidErrorCode,
-				"unhandled browse flag: %v",
-				browse.BrowseFlag,
-			)
+			obj_, err = me.cdsObjectToUpnpavObject(obj, fileInfo, host, userAgent, sink)
+		} else {
+			obj_, err = me.OnBrowseMetadata(obj.Path, obj.RootObjectPath, host, userAgent)
 		}
-	case "GetSearchCapabilities":
-		return [][2]string{
-			{"SearchCaps", ""},
-		}, nil
-	// Samsung Extensions
-	case "X_GetFeatureList":
-		// TODO: make it dependable on model
-		// https://github.com/1100101/minidlna/blob/ca6dbba18390ad6f8b8d7b7dbcf797dbfd95e2db/upnpsoap.c#L2153-L2199
-		return [][2]string{
-			{"FeatureList", `<Features xmlns="urn:schemas-upnp-org:av:avs" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="urn:schemas-upnp-org:av:avs http://www.upnp.org/schemas/av/avs.xsd">
-	<Feature name="samsung.com_BASICVIEW" version="1">
-		<container id="0" type="object.item.audioItem"/> // "A"
-		<container id="0" type="object.item.videoItem"/> // "V"
-		<container id="0" type="object.item.imageItem"/> // "I"
-	</Feature>
-</Features>`},
-		}, nil
-	case "X_SetBookmark":
-		// just ignore
-		return [][2]string{}, nil
+		if err != nil {
+			return
+		}
+		var buf []byte
+		buf, err = xml.Marshal(obj_)
+		if err != nil {
+			return
+		}
+		ret.Result = didl_lite(string(buf))
+		ret.NumberReturned = 1
+		ret.TotalMatches = 1
+		return
 	default:
-		return nil, upnp.InvalidActionError
+		// Such as when it's missing.
+		err = upnp.Errorf(
+			upnp.ArgumentValueInvalidErrorCode,
+			"unhandled browse flag: %v",
+			browse.BrowseFlag,
+		)
+		return
 	}
 }
 
@@ -502,7 +562,7 @@ type object struct {
 
 // Returns the number of children this object has, such as for a container.
 func (cds *contentDirectoryService) objectChildCount(me object) int {
-	objs, err := cds.readContainer(me, "", "")
+	objs, err := cds.readContainer(me, "", "", nil)
 	if err != nil {
 		cds.Logger.Printf("error reading container: %s", err)
 	}
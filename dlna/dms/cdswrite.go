@@ -0,0 +1,758 @@
+package dms
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/access"
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Where the content of objects made with CreateObject is uploaded to, with POST or PUT.
+const importPath = "/import"
+
+var errNoSuchImport = errors.New("no such import")
+
+// How long objects made with CreateObject wait for their content before their names are freed.
+const importExpiry = time.Hour
+
+// Fetches the content of ImportResource. Only public addresses are dialed, including for
+// redirects, so that clients can't have the server fetch from itself or its network.
+var importClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return fmt.Errorf("%s isn't a public address", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	},
+}
+
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+// Extensions given to created items of these types, when the title doesn't have one. Others get
+// the first the mime package knows.
+var importExtensions = map[string]string{
+	"audio/mpeg":      ".mp3",
+	"image/jpeg":      ".jpg",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+}
+
+// An object made with CreateObject that's waiting for its content.
+type pendingImport struct {
+	// Whether content is being written to it.
+	busy bool
+	// When it was made, or its content last failed to be written.
+	since time.Time
+}
+
+// A transfer started by ImportResource.
+type transfer struct {
+	// One of the A_ARG_TYPE_TransferStatus values.
+	status string
+	// Bytes written so far.
+	length int64
+	// The size of the content, or -1 if it's not known.
+	total  int64
+	cancel context.CancelFunc
+}
+
+// Returns the Restricted attribute of objects in the tree.
+func (me *Server) restricted() int {
+	if me.Writable {
+		return 0
+	}
+	return 1
+}
+
+// Returns an error if the client making the request may not change the tree.
+func (me *Server) checkWrite(r *http.Request) error {
+	if !me.Writable {
+		return upnp.Errorf(upnpav.RestrictedObjectErrorCode, "the server isn't writable")
+	}
+	if !me.WriteAccess.Check(access.RemoteIP(r), "writing") {
+		return upnp.Errorf(upnpav.RestrictedObjectErrorCode, "not allowed to write")
+	}
+	return nil
+}
+
+// Returns the object for the ID, if it exists and isn't ignored.
+func (me *contentDirectoryService) existingObject(id string) (o object, fi os.FileInfo, err error) {
+	o, err = me.objectFromID(id)
+	if err == nil {
+		fi, err = os.Stat(o.FilePath())
+	}
+	if err == nil {
+		var ignored bool
+		ignored, err = me.IgnorePath(o.FilePath())
+		if ignored {
+			err = fmt.Errorf("%s is ignored", o.Path)
+		}
+	}
+	if err != nil {
+		err = upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+	}
+	return
+}
+
+// Returns the title as a file name, or false if it can't be one. Hidden names aren't allowed, so
+// that objects can't be created that wouldn't be listed.
+func titleFileName(title string) (string, bool) {
+	name := strings.TrimSpace(title)
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	return name, true
+}
+
+// Returns the ith alternative to the name, such as "photo (2).jpg" for 2.
+func numberedName(name string, i int) string {
+	if i == 1 {
+		return name
+	}
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+}
+
+// An object in the DIDL-Lite Elements of CreateObject.
+type didlLiteObject struct {
+	XMLName xml.Name
+	Title   string `xml:"title"`
+	Class   string `xml:"class"`
+	Res     []struct {
+		ProtocolInfo string `xml:"protocolInfo,attr"`
+	} `xml:"res"`
+}
+
+type createObject struct {
+	ContainerID string
+	Elements    string
+}
+
+type createdObject struct {
+	ObjectID string
+	Result   string
+}
+
+// Creates a folder, or an item whose content is then uploaded to the importUri of its resource.
+func (me *contentDirectoryService) createObject(r *http.Request, in createObject) (ret createdObject, err error) {
+	if err = me.checkWrite(r); err != nil {
+		return
+	}
+	parent, fi, err := me.existingObject(in.ContainerID)
+	if err != nil || !fi.IsDir() {
+		err = upnp.Errorf(upnpav.NoSuchContainerErrorCode, "no such container: %s", in.ContainerID)
+		return
+	}
+	var elements struct {
+		Objects []didlLiteObject `xml:",any"`
+	}
+	if err = xml.Unmarshal([]byte(in.Elements), &elements); err != nil || len(elements.Objects) != 1 {
+		err = upnp.Errorf(upnpav.BadMetadataErrorCode, "expected one object in DIDL-Lite")
+		return
+	}
+	e := elements.Objects[0]
+	name, ok := titleFileName(e.Title)
+	if !ok {
+		err = upnp.Errorf(upnpav.BadMetadataErrorCode, "bad title %q", e.Title)
+		return
+	}
+	obj := upnpav.Object{
+		ParentID:   parent.ID(),
+		Restricted: me.restricted(),
+	}
+	var created interface{}
+	switch {
+	case e.XMLName.Local == "container" && strings.HasPrefix(e.Class, "object.container"):
+		var child object
+		child, err = me.createFolder(parent, name)
+		if err != nil {
+			return
+		}
+		me.changed()
+		obj.ID = child.ID()
+		obj.Title = path.Base(child.Path)
+		obj.Class = "object.container.storageFolder"
+		created = upnpav.Container{Object: obj}
+	case e.XMLName.Local == "item" && strings.HasPrefix(e.Class, "object.item"):
+		mt := mimeTypeByBaseName(name)
+		if !mt.IsMedia() {
+			// Add an extension for the type of the resource, so that it's served.
+			for _, res := range e.Res {
+				pi, err := dlna.ParseProtocolInfo(res.ProtocolInfo)
+				if err != nil {
+					continue
+				}
+				ext, ok := importExtensions[pi.ContentFormat]
+				if !ok {
+					exts, _ := mime.ExtensionsByType(pi.ContentFormat)
+					if len(exts) == 0 {
+						continue
+					}
+					sort.Strings(exts)
+					ext = exts[0]
+				}
+				name += ext
+				mt = mimeTypeByBaseName(name)
+				break
+			}
+		}
+		if !mt.IsMedia() {
+			err = upnp.Errorf(upnpav.BadMetadataErrorCode, "%q isn't a media file name", name)
+			return
+		}
+		child := me.addImport(parent, name)
+		obj.ID = child.ID()
+		obj.Title = path.Base(child.Path)
+		obj.Class = "object.item." + mt.Type() + "Item"
+		created = upnpav.Item{
+			Object: obj,
+			Res: []upnpav.Resource{{
+				ProtocolInfo: fmt.Sprintf("http-get:*:%s:*", mt),
+				ImportURI: (&url.URL{
+					Scheme:   "http",
+					Host:     r.Host,
+					Path:     importPath,
+					RawQuery: url.Values{"path": {child.Path}}.Encode(),
+				}).String(),
+			}},
+		}
+	default:
+		err = upnp.Errorf(upnpav.BadMetadataErrorCode, "can't create %s of class %q", e.XMLName.Local, e.Class)
+		return
+	}
+	buf, err := xml.Marshal(created)
+	if err != nil {
+		return
+	}
+	ret.ObjectID = obj.ID
+	ret.Result = didl_lite(string(buf))
+	return
+}
+
+// Makes a folder in the parent, numbering the name if it's taken.
+func (me *contentDirectoryService) createFolder(parent object, name string) (child object, err error) {
+	for i := 1; ; i++ {
+		child = object{path.Join(parent.Path, numberedName(name, i)), me.RootObjectPath}
+		err = os.Mkdir(child.FilePath(), 0o755)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		err = upnp.Errorf(upnpav.CannotProcessRequestErrorCode, err.Error())
+	}
+	return
+}
+
+// Reserves a name in the parent for content to be imported to, numbering the name if it's taken.
+func (me *contentDirectoryService) addImport(parent object, name string) (child object) {
+	me.writeMu.Lock()
+	defer me.writeMu.Unlock()
+	if me.imports == nil {
+		me.imports = make(map[string]*pendingImport)
+	}
+	me.expireImports()
+	for i := 1; ; i++ {
+		child = object{path.Join(parent.Path, numberedName(name, i)), me.RootObjectPath}
+		if _, err := os.Lstat(child.FilePath()); err == nil {
+			continue
+		}
+		if _, ok := me.imports[child.Path]; !ok {
+			break
+		}
+	}
+	me.imports[child.Path] = &pendingImport{since: time.Now()}
+	return
+}
+
+// Forgets imports that have waited too long for their content. writeMu must be held.
+func (me *contentDirectoryService) expireImports() {
+	for p, pi := range me.imports {
+		if !pi.busy && time.Since(pi.since) > importExpiry {
+			delete(me.imports, p)
+		}
+	}
+}
+
+// Marks the import as busy, so that content isn't written to it twice at once.
+func (me *contentDirectoryService) claimImport(p string) error {
+	me.writeMu.Lock()
+	defer me.writeMu.Unlock()
+	me.expireImports()
+	pi, ok := me.imports[p]
+	if !ok || pi.busy {
+		return errNoSuchImport
+	}
+	pi.busy = true
+	return nil
+}
+
+// Writes the content of an object waiting for it. The object appears once all of the content is
+// written. progress, if not nil, is called with the number of bytes written so far.
+func (me *contentDirectoryService) importContent(p string, content io.Reader, progress func(int64)) error {
+	if err := me.claimImport(p); err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		me.writeMu.Lock()
+		if done {
+			delete(me.imports, p)
+		} else if pi, ok := me.imports[p]; ok {
+			pi.busy = false
+			pi.since = time.Now()
+		}
+		me.writeMu.Unlock()
+	}()
+	filePath := (&object{p, me.RootObjectPath}).FilePath()
+	// Hidden, so that it isn't listed while it's being written.
+	f, err := os.CreateTemp(filepath.Dir(filePath), ".dms-import-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := io.Writer(f)
+	if progress != nil {
+		w = progressWriter{f, progress, new(int64)}
+	}
+	_, err = io.Copy(w, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filePath)
+	}
+	done = err == nil
+	if done {
+		me.changed()
+	}
+	return err
+}
+
+type progressWriter struct {
+	w        io.Writer
+	progress func(int64)
+	n        *int64
+}
+
+func (me progressWriter) Write(b []byte) (n int, err error) {
+	n, err = me.w.Write(b)
+	*me.n += int64(n)
+	me.progress(*me.n)
+	return
+}
+
+func (me *Server) serveImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := me.checkWrite(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	cds := me.services["ContentDirectory"].(*contentDirectoryService)
+	p := path.Clean("/" + r.URL.Query().Get("path"))
+	err := cds.importContent(p, r.Body, nil)
+	if errors.Is(err, errNoSuchImport) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		me.Logger.Levelf(log.Warning, "importing %q: %v", p, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	me.Logger.Printf("imported %q from %s", p, r.RemoteAddr)
+}
+
+// Deletes the object, or moves it to TrashPath if that's set.
+func (me *contentDirectoryService) destroyObject(r *http.Request, in struct{ ObjectID string }) (struct{}, error) {
+	if err := me.checkWrite(r); err != nil {
+		return struct{}{}, err
+	}
+	o, err := me.objectFromID(in.ObjectID)
+	if err == nil && o.IsRoot() {
+		return struct{}{}, upnp.Errorf(upnpav.RestrictedObjectErrorCode, "the root can't be destroyed")
+	}
+	if err == nil {
+		me.writeMu.Lock()
+		pi, ok := me.imports[o.Path]
+		if ok && !pi.busy {
+			delete(me.imports, o.Path)
+		}
+		me.writeMu.Unlock()
+		if ok {
+			return struct{}{}, nil
+		}
+	}
+	o, _, err = me.existingObject(in.ObjectID)
+	if err != nil {
+		return struct{}{}, err
+	}
+	if me.TrashPath == "" {
+		err = os.RemoveAll(o.FilePath())
+	} else {
+		err = me.trash(o.FilePath())
+	}
+	if err != nil {
+		return struct{}{}, upnp.Errorf(upnpav.CannotProcessRequestErrorCode, err.Error())
+	}
+	me.Logger.Printf("destroyed %q for %s", o.Path, r.RemoteAddr)
+	me.changed()
+	return struct{}{}, nil
+}
+
+// Moves the file into TrashPath, numbering its name if it's taken there.
+func (me *contentDirectoryService) trash(filePath string) error {
+	if err := os.MkdirAll(me.TrashPath, 0o750); err != nil {
+		return err
+	}
+	for i := 1; ; i++ {
+		dest := filepath.Join(me.TrashPath, numberedName(filepath.Base(filePath), i))
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+		err := os.Rename(filePath, dest)
+		if errors.Is(err, syscall.EXDEV) {
+			// The trash is on another file system.
+			err = copyTree(filePath, dest)
+			if err == nil {
+				err = os.RemoveAll(filePath)
+			} else {
+				os.RemoveAll(dest)
+			}
+		}
+		return err
+	}
+}
+
+// Copies the file or directory at src to dest, which mustn't exist.
+func copyTree(src, dest string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, 0o750)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err == nil {
+				err = os.Symlink(link, target)
+			}
+			return err
+		case d.Type().IsRegular():
+			return copyFile(p, target)
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dest string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type updateObject struct {
+	ObjectID        string
+	CurrentTagValue string
+	NewTagValue     string
+}
+
+// Splits a tag value list of UpdateObject, in which commas in the fragments are escaped.
+func splitTagValues(s string) (ret []string) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case s[i] == ',':
+			ret = append(ret, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return append(ret, b.String())
+}
+
+// A tag of UpdateObject, such as <dc:title>x</dc:title>. Empty fragments have a zero name.
+type tagValue struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+func parseTagValue(s string) (ret tagValue, err error) {
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	err = xml.Unmarshal([]byte(s), &ret)
+	return
+}
+
+// Changes the object's metadata. Only titles can be changed, which renames the object.
+func (me *contentDirectoryService) updateObject(r *http.Request, in updateObject) (struct{}, error) {
+	if err := me.checkWrite(r); err != nil {
+		return struct{}{}, err
+	}
+	o, fi, err := me.existingObject(in.ObjectID)
+	if err != nil {
+		return struct{}{}, err
+	}
+	if o.IsRoot() {
+		return struct{}{}, upnp.Errorf(upnpav.RestrictedObjectErrorCode, "the root can't be changed")
+	}
+	current := splitTagValues(in.CurrentTagValue)
+	newValues := splitTagValues(in.NewTagValue)
+	if len(current) != len(newValues) {
+		return struct{}{}, upnp.Errorf(upnpav.ParameterMismatchErrorCode, "%d current tags, and %d new", len(current), len(newValues))
+	}
+	name := fi.Name()
+	for i := range current {
+		c, err := parseTagValue(current[i])
+		if err != nil {
+			return struct{}{}, upnp.Errorf(upnpav.InvalidCurrentTagValueErrorCode, err.Error())
+		}
+		n, err := parseTagValue(newValues[i])
+		if err != nil {
+			return struct{}{}, upnp.Errorf(upnpav.InvalidNewTagValueErrorCode, err.Error())
+		}
+		if c.XMLName.Local != "title" || n.XMLName.Local != "title" {
+			return struct{}{}, upnp.Errorf(upnpav.ReadOnlyTagErrorCode, "only titles can be changed")
+		}
+		if c.Value != name {
+			return struct{}{}, upnp.Errorf(upnpav.InvalidCurrentTagValueErrorCode, "the title is %q", name)
+		}
+		var ok bool
+		if name, ok = titleFileName(n.Value); !ok {
+			return struct{}{}, upnp.Errorf(upnpav.InvalidNewTagValueErrorCode, "bad title %q", n.Value)
+		}
+		// Renaming to dynamic stream metadata would have the server run commands from uploads, and
+		// other types wouldn't be served.
+		if strings.HasSuffix(name, dmsMetadataSuffix) || (!fi.IsDir() && !mimeTypeByBaseName(name).IsMedia()) {
+			return struct{}{}, upnp.Errorf(upnpav.InvalidNewTagValueErrorCode, "%q isn't a media file name", name)
+		}
+	}
+	if name == fi.Name() {
+		return struct{}{}, nil
+	}
+	dest := filepath.Join(filepath.Dir(o.FilePath()), name)
+	if _, err := os.Lstat(dest); err == nil {
+		return struct{}{}, upnp.Errorf(upnpav.InvalidNewTagValueErrorCode, "%q already exists", name)
+	}
+	if err := os.Rename(o.FilePath(), dest); err != nil {
+		return struct{}{}, upnp.Errorf(upnpav.CannotProcessRequestErrorCode, err.Error())
+	}
+	me.Logger.Printf("renamed %q to %q for %s", o.Path, name, r.RemoteAddr)
+	me.changed()
+	return struct{}{}, nil
+}
+
+type importResource struct {
+	SourceURI      string
+	DestinationURI string
+}
+
+// Has the server fetch the content of an object waiting for it from SourceURI.
+func (me *contentDirectoryService) importResource(r *http.Request, in importResource) (ret struct{ TransferID uint32 }, err error) {
+	if err = me.checkWrite(r); err != nil {
+		return
+	}
+	dest, err := url.Parse(in.DestinationURI)
+	if err != nil || dest.Path != importPath {
+		err = upnp.Errorf(upnpav.NoSuchDestinationResourceErrorCode, "%q isn't an importUri", in.DestinationURI)
+		return
+	}
+	p := path.Clean("/" + dest.Query().Get("path"))
+	src, err := url.Parse(in.SourceURI)
+	if err != nil || (src.Scheme != "http" && src.Scheme != "https") {
+		err = upnp.Errorf(upnpav.NoSuchSourceResourceErrorCode, "can't fetch %q", in.SourceURI)
+		return
+	}
+	me.writeMu.Lock()
+	_, ok := me.imports[p]
+	me.writeMu.Unlock()
+	if !ok {
+		err = upnp.Errorf(upnpav.NoSuchDestinationResourceErrorCode, "nothing is waiting for content at %q", in.DestinationURI)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &transfer{status: "IN_PROGRESS", total: -1, cancel: cancel}
+	me.writeMu.Lock()
+	if me.transfers == nil {
+		me.transfers = make(map[uint32]*transfer)
+	}
+	me.lastTransferID++
+	ret.TransferID = me.lastTransferID
+	me.transfers[ret.TransferID] = t
+	me.writeMu.Unlock()
+	me.notifyTransferIDs()
+	go func() {
+		defer cancel()
+		err := me.fetchImport(ctx, src.String(), p, t)
+		me.writeMu.Lock()
+		switch {
+		case ctx.Err() != nil:
+			t.status = "STOPPED"
+		case err != nil:
+			t.status = "ERROR"
+		default:
+			t.status = "COMPLETED"
+		}
+		me.writeMu.Unlock()
+		if err != nil {
+			me.Logger.Levelf(log.Warning, "importing %q from %q: %v", p, src, err)
+		}
+		me.notifyTransferIDs()
+	}()
+	return
+}
+
+func (me *contentDirectoryService) fetchImport(ctx context.Context, src, p string, t *transfer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := importClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	me.writeMu.Lock()
+	t.total = resp.ContentLength
+	me.writeMu.Unlock()
+	return me.importContent(p, resp.Body, func(n int64) {
+		me.writeMu.Lock()
+		t.length = n
+		me.writeMu.Unlock()
+	})
+}
+
+func (me *contentDirectoryService) getTransfer(id uint32) (*transfer, error) {
+	t, ok := me.transfers[id]
+	if !ok {
+		return nil, upnp.Errorf(upnpav.NoSuchFileTransferErrorCode, "no such transfer %d", id)
+	}
+	return t, nil
+}
+
+func (me *contentDirectoryService) stopTransferResource(r *http.Request, in struct{ TransferID uint32 }) (struct{}, error) {
+	if err := me.checkWrite(r); err != nil {
+		return struct{}{}, err
+	}
+	me.writeMu.Lock()
+	defer me.writeMu.Unlock()
+	t, err := me.getTransfer(in.TransferID)
+	if err != nil {
+		return struct{}{}, err
+	}
+	t.cancel()
+	return struct{}{}, nil
+}
+
+type transferProgress struct {
+	TransferStatus string
+	TransferLength string
+	TransferTotal  string
+}
+
+func (me *contentDirectoryService) getTransferProgress(_ *http.Request, in struct{ TransferID uint32 }) (ret transferProgress, err error) {
+	me.writeMu.Lock()
+	defer me.writeMu.Unlock()
+	t, err := me.getTransfer(in.TransferID)
+	if err != nil {
+		return
+	}
+	ret.TransferStatus = t.status
+	ret.TransferLength = strconv.FormatInt(t.length, 10)
+	if t.total >= 0 {
+		ret.TransferTotal = strconv.FormatInt(t.total, 10)
+	}
+	return
+}
+
+// Returns the IDs of the transfers in progress, as a comma separated list.
+func (me *contentDirectoryService) transferIDs() string {
+	me.writeMu.Lock()
+	var ids []int
+	for id, t := range me.transfers {
+		if t.status == "IN_PROGRESS" {
+			ids = append(ids, int(id))
+		}
+	}
+	me.writeMu.Unlock()
+	sort.Ints(ids)
+	ss := make([]string, 0, len(ids))
+	for _, id := range ids {
+		ss = append(ss, strconv.Itoa(id))
+	}
+	return strings.Join(ss, ",")
+}
+
+func (me *contentDirectoryService) notifyTransferIDs() {
+	me.Notify(upnp.Variable{XMLName: xml.Name{Local: "TransferIDs"}, Value: me.transferIDs()})
+}
@@ -0,0 +1,234 @@
+package dms
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/access"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func newWritableTestService(t *testing.T) *contentDirectoryService {
+	srv := &Server{
+		RootObjectPath: t.TempDir(),
+		NoProbe:        true,
+		Logger:         log.Default,
+		Writable:       true,
+		TrashPath:      t.TempDir(),
+	}
+	if err := srv.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	return srv.services["ContentDirectory"].(*contentDirectoryService)
+}
+
+func requireUPnPErrorCode(t *testing.T, err error, code uint) {
+	t.Helper()
+	var upnpErr *upnp.Error
+	if !errors.As(err, &upnpErr) || upnpErr.Code != code {
+		t.Fatalf("expected error %d, got %v", code, err)
+	}
+}
+
+func TestCreateImportUpdateDestroy(t *testing.T) {
+	cds := newWritableTestService(t)
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	updateID := cds.updateID()
+	created, err := cds.createObject(r, createObject{
+		ContainerID: "0",
+		Elements:    `<DIDL-Lite xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"><container id="" parentID="0" restricted="0"><dc:title>Phone</dc:title><upnp:class>object.container.storageFolder</upnp:class></container></DIDL-Lite>`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ObjectID != "%2FPhone" || !strings.Contains(created.Result, `restricted="0"`) {
+		t.Fatalf("%+v", created)
+	}
+	if fi, err := os.Stat(filepath.Join(cds.RootObjectPath, "Phone")); err != nil || !fi.IsDir() {
+		t.Fatalf("folder wasn't made: %v", err)
+	}
+	if cds.updateID() == updateID {
+		t.Fatal("SystemUpdateID didn't change")
+	}
+
+	created, err = cds.createObject(r, createObject{
+		ContainerID: created.ObjectID,
+		Elements:    `<DIDL-Lite xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"><item id="" parentID="%2FPhone" restricted="0"><dc:title>IMG_0001</dc:title><upnp:class>object.item.imageItem.photo</upnp:class><res protocolInfo="http-get:*:image/jpeg:*"></res></item></DIDL-Lite>`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const importURI = "http://example.com/import?path=%2FPhone%2FIMG_0001.jpg"
+	if created.ObjectID != "%2FPhone%2FIMG_0001.jpg" || !strings.Contains(created.Result, `importUri="`+strings.ReplaceAll(importURI, "&", "&amp;")+`"`) {
+		t.Fatalf("%+v", created)
+	}
+	upload := func() int {
+		w := httptest.NewRecorder()
+		cds.serveImport(w, httptest.NewRequest("PUT", importURI, strings.NewReader("jpeg")))
+		return w.Code
+	}
+	if code := upload(); code != http.StatusOK {
+		t.Fatalf("upload: %d", code)
+	}
+	if code := upload(); code != http.StatusNotFound {
+		t.Fatalf("second upload: %d", code)
+	}
+	if b, err := os.ReadFile(filepath.Join(cds.RootObjectPath, "Phone", "IMG_0001.jpg")); err != nil || string(b) != "jpeg" {
+		t.Fatalf("uploaded %q: %v", b, err)
+	}
+
+	_, err = cds.updateObject(r, updateObject{
+		ObjectID:        created.ObjectID,
+		CurrentTagValue: "<dc:title>IMG_0002.jpg</dc:title>",
+		NewTagValue:     "<dc:title>Beach.jpg</dc:title>",
+	})
+	requireUPnPErrorCode(t, err, upnpav.InvalidCurrentTagValueErrorCode)
+	_, err = cds.updateObject(r, updateObject{
+		ObjectID:        created.ObjectID,
+		CurrentTagValue: "<upnp:genre>x</upnp:genre>",
+		NewTagValue:     "<upnp:genre>y</upnp:genre>",
+	})
+	requireUPnPErrorCode(t, err, upnpav.ReadOnlyTagErrorCode)
+	for _, title := range []string{"run.dms.json", "notes.txt"} {
+		_, err = cds.updateObject(r, updateObject{
+			ObjectID:        created.ObjectID,
+			CurrentTagValue: "<dc:title>IMG_0001.jpg</dc:title>",
+			NewTagValue:     "<dc:title>" + title + "</dc:title>",
+		})
+		requireUPnPErrorCode(t, err, upnpav.InvalidNewTagValueErrorCode)
+	}
+	_, err = cds.updateObject(r, updateObject{
+		ObjectID:        created.ObjectID,
+		CurrentTagValue: "<dc:title>IMG_0001.jpg</dc:title>",
+		NewTagValue:     `<dc:title>Beach\, day.jpg</dc:title>`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cds.destroyObject(r, struct{ ObjectID string }{"%2FPhone%2FBeach%2C+day.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cds.TrashPath, "Beach, day.jpg")); err != nil {
+		t.Fatalf("not in the trash: %v", err)
+	}
+	_, err = cds.destroyObject(r, struct{ ObjectID string }{"0"})
+	requireUPnPErrorCode(t, err, upnpav.RestrictedObjectErrorCode)
+}
+
+func TestImportResource(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("id3"))
+	}))
+	defer source.Close()
+	cds := newWritableTestService(t)
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	_, err := cds.createObject(r, createObject{
+		ContainerID: "0",
+		Elements:    `<DIDL-Lite xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"><item><dc:title>song.mp3</dc:title><upnp:class>object.item.audioItem.musicTrack</upnp:class></item></DIDL-Lite>`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := (&url.URL{
+		Scheme:   "http",
+		Host:     r.Host,
+		Path:     importPath,
+		RawQuery: url.Values{"path": {"/song.mp3"}}.Encode(),
+	}).String()
+	_, err = cds.importResource(r, importResource{SourceURI: source.URL, DestinationURI: "http://example.com/res?path=%2Fsong.mp3"})
+	requireUPnPErrorCode(t, err, upnpav.NoSuchDestinationResourceErrorCode)
+	// The source is on loopback, which the server refuses to fetch from.
+	if progress := waitForTransfer(t, cds, r, importResource{SourceURI: source.URL, DestinationURI: dest}); progress.TransferStatus != "ERROR" {
+		t.Fatalf("%+v", progress)
+	}
+	defer func(c *http.Client) { importClient = c }(importClient)
+	importClient = source.Client()
+	progress := waitForTransfer(t, cds, r, importResource{SourceURI: source.URL, DestinationURI: dest})
+	if progress.TransferStatus != "COMPLETED" || progress.TransferLength != "3" || progress.TransferTotal != "3" {
+		t.Fatalf("%+v", progress)
+	}
+	if b, err := os.ReadFile(filepath.Join(cds.RootObjectPath, "song.mp3")); err != nil || string(b) != "id3" {
+		t.Fatalf("imported %q: %v", b, err)
+	}
+	if ids := cds.transferIDs(); ids != "" {
+		t.Fatalf("transfers in progress: %q", ids)
+	}
+}
+
+// Starts the import, and returns its progress once it's no longer in progress.
+func waitForTransfer(t *testing.T, cds *contentDirectoryService, r *http.Request, in importResource) transferProgress {
+	t.Helper()
+	started, err := cds.importResource(r, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		progress, err := cds.getTransferProgress(r, started)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if progress.TransferStatus != "IN_PROGRESS" {
+			return progress
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%+v", progress)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestImportExpiry(t *testing.T) {
+	cds := newWritableTestService(t)
+	child := cds.addImport(object{"/", cds.RootObjectPath}, "song.mp3")
+	cds.imports[child.Path].since = time.Now().Add(-importExpiry - time.Minute)
+	if err := cds.importContent(child.Path, strings.NewReader("id3"), nil); err != errNoSuchImport {
+		t.Fatalf("imported to expired object: %v", err)
+	}
+	if again := cds.addImport(object{"/", cds.RootObjectPath}, "song.mp3"); again.Path != child.Path {
+		t.Fatalf("expired name wasn't reused: %q", again.Path)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "Album")
+	if err := os.MkdirAll(filepath.Join(src, "Disc 1"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "Disc 1", "song.mp3"), []byte("id3"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "Album")
+	if err := copyTree(src, dest); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dest, "Disc 1", "song.mp3")); err != nil || string(b) != "id3" {
+		t.Fatalf("copied %q: %v", b, err)
+	}
+}
+
+func TestWriteAccess(t *testing.T) {
+	cds := newWritableTestService(t)
+	_, deny, _ := net.ParseCIDR("192.0.2.0/24")
+	cds.WriteAccess = &access.Policy{Rules: []access.Rule{{Net: deny, Deny: true}}, Logger: log.Default}
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	_, err := cds.createObject(r, createObject{ContainerID: "0"})
+	requireUPnPErrorCode(t, err, upnpav.RestrictedObjectErrorCode)
+	cds.Writable = false
+	cds.WriteAccess = nil
+	_, err = cds.destroyObject(r, struct{ ObjectID string }{"0"})
+	requireUPnPErrorCode(t, err, upnpav.RestrictedObjectErrorCode)
+}
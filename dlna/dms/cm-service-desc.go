@@ -1,185 +1,72 @@
 package dms
 
-const connectionManagerServiceDescription = `<?xml version="1.0"?>
-<scpd xmlns="urn:schemas-upnp-org:service-1-0">
-	<specVersion>
-		<major>1</major>
-		<minor>0</minor>
-	</specVersion>
-	<actionList>
-		<action>
-			<name>GetProtocolInfo</name>
-			<argumentList>
-				<argument>
-					<name>Source</name>
-					<direction>out</direction>
-					<relatedStateVariable>SourceProtocolInfo</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>Sink</name>
-					<direction>out</direction>
-					<relatedStateVariable>SinkProtocolInfo</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-		<action>
-			<name>PrepareForConnection</name>
-			<argumentList>
-				<argument>
-					<name>RemoteProtocolInfo</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>PeerConnectionManager</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>PeerConnectionID</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>Direction</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>ConnectionID</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>AVTransportID</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>RcsID</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-		<action>
-			<name>ConnectionComplete</name>
-			<argumentList>
-				<argument>
-					<name>ConnectionID</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-		<action>
-			<name>GetCurrentConnectionIDs</name>
-			<argumentList>
-				<argument>
-					<name>ConnectionIDs</name>
-					<direction>out</direction>
-					<relatedStateVariable>CurrentConnectionIDs</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-		<action>
-			<name>GetCurrentConnectionInfo</name>
-			<argumentList>
-				<argument>
-					<name>ConnectionID</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>RcsID</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_RcsID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>AVTransportID</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_AVTransportID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>ProtocolInfo</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_ProtocolInfo</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>PeerConnectionManager</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionManager</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>PeerConnectionID</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>Direction</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_Direction</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>Status</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_ConnectionStatus</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-	</actionList>
-	<serviceStateTable>
-		<stateVariable sendEvents="yes">
-			<name>SourceProtocolInfo</name>
-			<dataType>string</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="yes">
-			<name>SinkProtocolInfo</name>
-			<dataType>string</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="yes">
-			<name>CurrentConnectionIDs</name>
-			<dataType>string</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_ConnectionStatus</name>
-			<dataType>string</dataType>
-			<allowedValueList>
-				<allowedValue>OK</allowedValue>
-				<allowedValue>ContentFormatMismatch</allowedValue>
-				<allowedValue>InsufficientBandwidth</allowedValue>
-				<allowedValue>UnreliableChannel</allowedValue>
-				<allowedValue>Unknown</allowedValue>
-			</allowedValueList>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_ConnectionManager</name>
-			<dataType>string</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_Direction</name>
-			<dataType>string</dataType>
-			<allowedValueList>
-				<allowedValue>Input</allowedValue>
-				<allowedValue>Output</allowedValue>
-			</allowedValueList>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_ProtocolInfo</name>
-			<dataType>string</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_ConnectionID</name>
-			<dataType>i4</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_AVTransportID</name>
-			<dataType>i4</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_RcsID</name>
-			<dataType>i4</dataType>
-		</stateVariable>
-	</serviceStateTable>
-</scpd>
-`
+import "github.com/anacrolix/dms/upnp"
+
+var connectionManagerServiceDef = upnp.ServiceDef{
+	Actions: []upnp.ActionDef{
+		{
+			Name: "GetProtocolInfo",
+			Out: []upnp.ArgDef{
+				{Name: "Source", StateVar: "SourceProtocolInfo"},
+				{Name: "Sink", StateVar: "SinkProtocolInfo"},
+			},
+		},
+		{
+			Name: "PrepareForConnection",
+			In: []upnp.ArgDef{
+				{Name: "RemoteProtocolInfo", StateVar: "A_ARG_TYPE_ProtocolInfo"},
+				{Name: "PeerConnectionManager", StateVar: "A_ARG_TYPE_ConnectionManager"},
+				{Name: "PeerConnectionID", StateVar: "A_ARG_TYPE_ConnectionID"},
+				{Name: "Direction", StateVar: "A_ARG_TYPE_Direction"},
+			},
+			Out: []upnp.ArgDef{
+				{Name: "ConnectionID", StateVar: "A_ARG_TYPE_ConnectionID"},
+				{Name: "AVTransportID", StateVar: "A_ARG_TYPE_AVTransportID"},
+				{Name: "RcsID", StateVar: "A_ARG_TYPE_RcsID"},
+			},
+		},
+		{
+			Name: "ConnectionComplete",
+			In:   []upnp.ArgDef{{Name: "ConnectionID", StateVar: "A_ARG_TYPE_ConnectionID"}},
+		},
+		{
+			Name: "GetCurrentConnectionIDs",
+			Out:  []upnp.ArgDef{{Name: "ConnectionIDs", StateVar: "CurrentConnectionIDs"}},
+		},
+		{
+			Name: "GetCurrentConnectionInfo",
+			In:   []upnp.ArgDef{{Name: "ConnectionID", StateVar: "A_ARG_TYPE_ConnectionID"}},
+			Out: []upnp.ArgDef{
+				{Name: "RcsID", StateVar: "A_ARG_TYPE_RcsID"},
+				{Name: "AVTransportID", StateVar: "A_ARG_TYPE_AVTransportID"},
+				{Name: "ProtocolInfo", StateVar: "A_ARG_TYPE_ProtocolInfo"},
+				{Name: "PeerConnectionManager", StateVar: "A_ARG_TYPE_ConnectionManager"},
+				{Name: "PeerConnectionID", StateVar: "A_ARG_TYPE_ConnectionID"},
+				{Name: "Direction", StateVar: "A_ARG_TYPE_Direction"},
+				{Name: "Status", StateVar: "A_ARG_TYPE_ConnectionStatus"},
+			},
+		},
+	},
+	StateVars: []upnp.StateVarDef{
+		{Name: "SourceProtocolInfo", DataType: "string", SendEvents: true},
+		{Name: "SinkProtocolInfo", DataType: "string", SendEvents: true},
+		{Name: "CurrentConnectionIDs", DataType: "string", SendEvents: true},
+		{
+			Name:     "A_ARG_TYPE_ConnectionStatus",
+			DataType: "string",
+			AllowedValues: []string{
+				"OK",
+				"ContentFormatMismatch",
+				"InsufficientBandwidth",
+				"UnreliableChannel",
+				"Unknown",
+			},
+		},
+		{Name: "A_ARG_TYPE_ConnectionManager", DataType: "string"},
+		{Name: "A_ARG_TYPE_Direction", DataType: "string", AllowedValues: []string{"Input", "Output"}},
+		{Name: "A_ARG_TYPE_ProtocolInfo", DataType: "string"},
+		{Name: "A_ARG_TYPE_ConnectionID", DataType: "i4"},
+		{Name: "A_ARG_TYPE_AVTransportID", DataType: "i4"},
+		{Name: "A_ARG_TYPE_RcsID", DataType: "i4"},
+	},
+}
@@ -1,42 +1,295 @@
 package dms
 
 import (
+	"encoding/xml"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/anacrolix/dms/dlna"
 	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
 )
 
-// const defaultProtocolInfo = "http-get:*:video/mpeg:*,http-get:*:video/mp4:*,http-get:*:video/vnd.dlna.mpeg-tts:*,http-get:*:video/avi:*,http-get:*:video/x-matroska:*,http-get:*:video/x-ms-wmv:*,http-get:*:video/wtv:*,http-get:*:audio/mpeg:*,http-get:*:audio/mp3:*,http-get:*:audio/mp4:*,http-get:*:audio/x-ms-wma*,http-get:*:audio/wav:*,http-get:*:audio/L16:*,http-get:*image/jpeg:*,http-get:*image/png:*,http-get:*image/gif:*,http-get:*image/tiff:*"
-const defaultProtocolInfo = "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_SM,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_MED,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_LRG,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_RES_H_V,http-get:*:image/png:DLNA.ORG_PN=PNG_TN,http-get:*:image/png:DLNA.ORG_PN=PNG_LRG,http-get:*:image/gif:DLNA.ORG_PN=GIF_LRG,http-get:*:audio/mpeg:DLNA.ORG_PN=MP3,http-get:*:audio/L16:DLNA.ORG_PN=LPCM,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_24_AC3_ISO;SONY.COM_PN=AVC_TS_HD_24_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_24_AC3;SONY.COM_PN=AVC_TS_HD_24_AC3,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_24_AC3_T;SONY.COM_PN=AVC_TS_HD_24_AC3_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_PS_PAL,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_PS_NTSC,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_50_L2_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_60_L2_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_SD_EU_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_EU,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_EU_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_50_AC3_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_50_L2_ISO;SONY.COM_PN=HD2_50_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_60_AC3_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_60_L2_ISO;SONY.COM_PN=HD2_60_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_HD_50_L2_T;SONY.COM_PN=HD2_50_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_HD_60_L2_T;SONY.COM_PN=HD2_60_T,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_50_AC3_ISO;SONY.COM_PN=AVC_TS_HD_50_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_50_AC3;SONY.COM_PN=AVC_TS_HD_50_AC3,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_60_AC3_ISO;SONY.COM_PN=AVC_TS_HD_60_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_60_AC3;SONY.COM_PN=AVC_TS_HD_60_AC3,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_50_AC3_T;SONY.COM_PN=AVC_TS_HD_50_AC3_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_60_AC3_T;SONY.COM_PN=AVC_TS_HD_60_AC3_T,http-get:*:video/x-mp2t-mphl-188:*,http-get:*:video/*:*,http-get:*:audio/*:*,http-get:*:image/*:*,http-get:*:text/srt:*,http-get:*:text/smi:*,http-get:*:text/ssa:*,http-get:*:*:*"
+const (
+	// Prepared connections that have had no streams for this long are ended, as clients don't
+	// always complete them.
+	preparedConnectionIdleTimeout = 10 * time.Minute
+	// The most connections a client can have prepared at once.
+	maxPreparedConnections = 16
+)
 
 type connectionManagerService struct {
 	*Server
 	upnp.Eventing
+	*upnp.Actions
+
+	connMu sync.Mutex
+	// The last connection ID given out.
+	lastConnectionID int32
+	connections      map[int32]*connection
+}
+
+type connectionInfo struct {
+	RcsID                 int32
+	AVTransportID         int32
+	ProtocolInfo          string
+	PeerConnectionManager string
+	PeerConnectionID      int32
+	Direction             string
+	Status                string
+}
+
+// A connection to a client, either prepared by it, or made implicitly when it streams without
+// preparing one.
+type connection struct {
+	info connectionInfo
+	// The client's IP, so streams are counted against connections it prepared.
+	client   string
+	implicit bool
+	// The number of streams open on the connection. Implicit connections end with their last
+	// stream.
+	streams int
+	// When the connection last had no streams.
+	idleSince time.Time
+}
+
+type prepareForConnection struct {
+	RemoteProtocolInfo    string
+	PeerConnectionManager string
+	PeerConnectionID      int32
+	Direction             string
+}
+
+type preparedConnection struct {
+	ConnectionID  int32
+	AVTransportID int32
+	RcsID         int32
+}
+
+func (cms *connectionManagerService) bindActions() {
+	cms.Actions = upnp.NewActions(&connectionManagerServiceDef)
+	upnp.Bind(cms.Actions, "PrepareForConnection", func(r *http.Request, in prepareForConnection) (ret preparedConnection, err error) {
+		ret.ConnectionID, err = cms.prepare(r, in)
+		ret.AVTransportID = -1
+		ret.RcsID = -1
+		return
+	})
+	upnp.Bind(cms.Actions, "ConnectionComplete", func(_ *http.Request, in struct{ ConnectionID int32 }) (struct{}, error) {
+		return struct{}{}, cms.complete(in.ConnectionID)
+	})
+	upnp.Bind(cms.Actions, "GetCurrentConnectionInfo", func(_ *http.Request, in struct{ ConnectionID int32 }) (connectionInfo, error) {
+		cms.connMu.Lock()
+		defer cms.connMu.Unlock()
+		c, ok := cms.connections[in.ConnectionID]
+		if !ok {
+			return connectionInfo{}, upnp.Errorf(upnpav.InvalidConnectionReferenceErrorCode, "invalid connection reference")
+		}
+		return c.info, nil
+	})
+	upnp.Bind(cms.Actions, "GetCurrentConnectionIDs", func(*http.Request, struct{}) (ret struct{ ConnectionIDs string }, err error) {
+		ret.ConnectionIDs = cms.connectionIDs()
+		return
+	})
+	upnp.Bind(cms.Actions, "GetProtocolInfo", func(*http.Request, struct{}) (ret struct{ Source, Sink string }, err error) {
+		ret.Source = cms.sourceProtocolInfo()
+		return
+	})
 }
 
-func (cms *connectionManagerService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
-	switch action {
-	case ".GetCurrentConnectionInfo":
-		return [][2]string{
-			{"ConnectionID", "0"},
-			{"RcsID", "-1"},
-			{"AVTransportID", "-1"},
-			{"ProtocolInfo", ""},
-			{"PeerConnectionManager", ""},
-			{"PeerConnectionID", "-1"},
-			{"Direction", "Output"},
-			{"Status", "OK"},
-		}, nil
-	case "GetCurrentConnectionIDs":
-		return [][2]string{
-			{"ConnectionIDs", ""},
-		}, nil
-	case "GetProtocolInfo":
-		return [][2]string{
-			{"Source", defaultProtocolInfo},
-			{"Sink", ""},
-		}, nil
-	default:
-		return nil, upnp.InvalidActionError
+func (cms *connectionManagerService) prepare(r *http.Request, in prepareForConnection) (id int32, err error) {
+	if in.Direction != "Output" {
+		err = upnp.Errorf(upnpav.IncompatibleDirectionsErrorCode, "only output connections are possible")
+		return
 	}
+	remote, err := dlna.ParseProtocolInfo(in.RemoteProtocolInfo)
+	if err != nil {
+		err = upnp.Errorf(upnpav.IncompatibleProtocolInfoErrorCode, "%s", err)
+		return
+	}
+	accepted := false
+	for _, pi := range dlna.ParseProtocolInfoList(cms.sourceProtocolInfo()) {
+		accepted = accepted || pi.Accepts(remote)
+	}
+	if !accepted {
+		err = upnp.Errorf(upnpav.IncompatibleProtocolInfoErrorCode, "%s isn't served", in.RemoteProtocolInfo)
+		return
+	}
+	client := hostIP(r.RemoteAddr)
+	if cms.expireConnections(client) >= maxPreparedConnections {
+		err = upnp.Errorf(upnpav.LocalRestrictionsErrorCode, "too many connections prepared")
+		return
+	}
+	id = cms.addConnection(&connection{
+		info: connectionInfo{
+			RcsID:                 -1,
+			AVTransportID:         -1,
+			ProtocolInfo:          in.RemoteProtocolInfo,
+			PeerConnectionManager: in.PeerConnectionManager,
+			PeerConnectionID:      in.PeerConnectionID,
+			Direction:             "Output",
+			Status:                "OK",
+		},
+		client:    client,
+		idleSince: time.Now(),
+	})
+	return
+}
+
+// Ends prepared connections that have been idle too long, and returns how many the client still
+// has prepared.
+func (cms *connectionManagerService) expireConnections(client string) (prepared int) {
+	cms.connMu.Lock()
+	expired := false
+	for id, c := range cms.connections {
+		if c.implicit {
+			continue
+		}
+		if c.streams == 0 && time.Since(c.idleSince) > preparedConnectionIdleTimeout {
+			delete(cms.connections, id)
+			expired = true
+			continue
+		}
+		if c.client == client {
+			prepared++
+		}
+	}
+	cms.connMu.Unlock()
+	if expired {
+		cms.notifyConnectionIDs()
+	}
+	return
+}
+
+func (cms *connectionManagerService) complete(id int32) error {
+	cms.connMu.Lock()
+	_, ok := cms.connections[id]
+	delete(cms.connections, id)
+	cms.connMu.Unlock()
+	if !ok {
+		return upnp.Errorf(upnpav.InvalidConnectionReferenceErrorCode, "invalid connection reference")
+	}
+	cms.notifyConnectionIDs()
+	return nil
+}
+
+func (cms *connectionManagerService) addConnection(c *connection) int32 {
+	cms.connMu.Lock()
+	if cms.connections == nil {
+		cms.connections = make(map[int32]*connection)
+	}
+	cms.lastConnectionID++
+	id := cms.lastConnectionID
+	cms.connections[id] = c
+	cms.connMu.Unlock()
+	cms.notifyConnectionIDs()
+	return id
+}
+
+// Counts a stream to the client against a connection, making an implicit one if the client hasn't
+// prepared one. The returned func is called when the stream ends.
+func (cms *connectionManagerService) openStream(r *http.Request, protocolInfo string) (done func()) {
+	client := hostIP(r.RemoteAddr)
+	cms.connMu.Lock()
+	var (
+		id       int32
+		c        *connection
+		implicit *connection
+	)
+	for cid, cc := range cms.connections {
+		if cc.client != client {
+			continue
+		}
+		if !cc.implicit {
+			id, c = cid, cc
+			break
+		}
+		if cc.info.ProtocolInfo == protocolInfo {
+			id, implicit = cid, cc
+		}
+	}
+	if c == nil {
+		c = implicit
+	}
+	if c != nil {
+		c.streams++
+		cms.connMu.Unlock()
+	} else {
+		cms.connMu.Unlock()
+		c = &connection{
+			info: connectionInfo{
+				RcsID:            -1,
+				AVTransportID:    -1,
+				ProtocolInfo:     protocolInfo,
+				PeerConnectionID: -1,
+				Direction:        "Output",
+				Status:           "OK",
+			},
+			client:   client,
+			implicit: true,
+			streams:  1,
+		}
+		id = cms.addConnection(c)
+	}
+	return func() {
+		cms.connMu.Lock()
+		c.streams--
+		if c.streams == 0 {
+			c.idleSince = time.Now()
+		}
+		end := c.implicit && c.streams == 0 && cms.connections[id] == c
+		if end {
+			delete(cms.connections, id)
+		}
+		cms.connMu.Unlock()
+		if end {
+			cms.notifyConnectionIDs()
+		}
+	}
+}
+
+// Returns the current connection IDs as a comma separated list.
+func (cms *connectionManagerService) connectionIDs() string {
+	cms.connMu.Lock()
+	ids := make([]int, 0, len(cms.connections))
+	for id := range cms.connections {
+		ids = append(ids, int(id))
+	}
+	cms.connMu.Unlock()
+	sort.Ints(ids)
+	ss := make([]string, 0, len(ids))
+	for _, id := range ids {
+		ss = append(ss, strconv.Itoa(id))
+	}
+	return strings.Join(ss, ",")
+}
+
+func (cms *connectionManagerService) notifyConnectionIDs() {
+	cms.Notify(upnp.Variable{XMLName: xml.Name{Local: "CurrentConnectionIDs"}, Value: cms.connectionIDs()})
+}
+
+func (cms *connectionManagerService) eventVariables() []upnp.Variable {
+	return []upnp.Variable{
+		{XMLName: xml.Name{Local: "SourceProtocolInfo"}, Value: cms.sourceProtocolInfo()},
+		{XMLName: xml.Name{Local: "SinkProtocolInfo"}},
+		{XMLName: xml.Name{Local: "CurrentConnectionIDs"}, Value: cms.connectionIDs()},
+	}
+}
+
+func (me *Server) connectionManager() *connectionManagerService {
+	return me.services["ConnectionManager"].(*connectionManagerService)
+}
+
+// Counts the stream being served for the request as a connection, until the returned func is
+// called.
+func (me *Server) openStream(r *http.Request, protocolInfo string) (done func()) {
+	return me.connectionManager().openStream(r, protocolInfo)
+}
+
+func (me *Server) connectionManagerEventSubHandler(w http.ResponseWriter, r *http.Request) {
+	cms := me.connectionManager()
+	cms.ServeSubscription(w, r, cms.eventVariables)
 }
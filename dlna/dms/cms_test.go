@@ -0,0 +1,142 @@
+package dms
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/controlpoint"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestConnectionManager(t *testing.T) {
+	ctx := context.Background()
+	srv, location := newTestServer(t)
+	d, err := controlpoint.FetchDevice(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := d.Service("urn:schemas-upnp-org:service:ConnectionManager:1")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := controlpoint.NewEventListener(l, log.Default)
+	defer el.Close()
+	events := make(chan controlpoint.Event, 10)
+	if _, err := el.Subscribe(ctx, cm, time.Minute, func(e controlpoint.Event) { events <- e }); err != nil {
+		t.Fatal(err)
+	}
+	connectionIDs := func() string {
+		t.Helper()
+		select {
+		case e := <-events:
+			for _, v := range e.Vars {
+				if v[0] == "CurrentConnectionIDs" {
+					return v[1]
+				}
+			}
+			t.Fatalf("no CurrentConnectionIDs in %+v", e)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return ""
+	}
+	if ids := connectionIDs(); ids != "" {
+		t.Fatalf("initial connections %q", ids)
+	}
+
+	type prepareResult struct {
+		ConnectionID, AVTransportID, RcsID int32
+	}
+	_, err = controlpoint.Invoke[prepareForConnection, prepareResult](ctx, cm, "PrepareForConnection", prepareForConnection{
+		RemoteProtocolInfo: "http-get:*:application/pdf:*",
+		PeerConnectionID:   -1,
+		Direction:          "Output",
+	})
+	var upnpErr *upnp.Error
+	if !errors.As(err, &upnpErr) || upnpErr.Code != upnpav.IncompatibleProtocolInfoErrorCode {
+		t.Fatalf("preparing for a PDF: %v", err)
+	}
+	prepared, err := controlpoint.Invoke[prepareForConnection, prepareResult](ctx, cm, "PrepareForConnection", prepareForConnection{
+		RemoteProtocolInfo:    "http-get:*:audio/mpeg:*",
+		PeerConnectionManager: "uuid:peer/urn:upnp-org:serviceId:ConnectionManager",
+		PeerConnectionID:      3,
+		Direction:             "Output",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := connectionIDs(); ids != "1" {
+		t.Fatalf("connections %q after preparing", ids)
+	}
+	info, err := controlpoint.Invoke[struct{ ConnectionID int32 }, connectionInfo](ctx, cm, "GetCurrentConnectionInfo", struct{ ConnectionID int32 }{prepared.ConnectionID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProtocolInfo != "http-get:*:audio/mpeg:*" || info.PeerConnectionID != 3 || info.Direction != "Output" || info.Status != "OK" || info.RcsID != -1 {
+		t.Fatalf("connection info %+v", info)
+	}
+
+	// A stream from another client gets a connection of its own, that ends with it.
+	r := httptest.NewRequest("GET", resPath, nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	done := srv.openStream(r, "http-get:*:video/mp4:*")
+	if ids := connectionIDs(); ids != "1,2" {
+		t.Fatalf("connections %q while streaming", ids)
+	}
+	done()
+	if ids := connectionIDs(); ids != "1" {
+		t.Fatalf("connections %q after streaming", ids)
+	}
+
+	if _, err := cm.Call(ctx, "ConnectionComplete", [][2]string{{"ConnectionID", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := connectionIDs(); ids != "" {
+		t.Fatalf("connections %q after completing", ids)
+	}
+}
+
+func TestPreparedConnectionLimits(t *testing.T) {
+	srv, _ := newTestServer(t)
+	cms := srv.connectionManager()
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	in := prepareForConnection{
+		RemoteProtocolInfo: "http-get:*:audio/mpeg:*",
+		PeerConnectionID:   -1,
+		Direction:          "Output",
+	}
+	for i := 0; i < maxPreparedConnections; i++ {
+		if _, err := cms.prepare(r, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var upnpErr *upnp.Error
+	if _, err := cms.prepare(r, in); !errors.As(err, &upnpErr) || upnpErr.Code != upnpav.LocalRestrictionsErrorCode {
+		t.Fatalf("preparing too many: %v", err)
+	}
+	// Another client isn't affected.
+	other := httptest.NewRequest("POST", serviceControlURL, nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+	if _, err := cms.prepare(other, in); err != nil {
+		t.Fatal(err)
+	}
+	cms.connMu.Lock()
+	for _, c := range cms.connections {
+		c.idleSince = time.Now().Add(-preparedConnectionIdleTimeout - time.Second)
+	}
+	cms.connMu.Unlock()
+	if _, err := cms.prepare(r, in); err != nil {
+		t.Fatalf("preparing after the others expired: %v", err)
+	}
+	if ids := cms.connectionIDs(); ids != "18" {
+		t.Fatalf("connections %q", ids)
+	}
+}
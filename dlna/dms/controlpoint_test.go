@@ -0,0 +1,169 @@
+package dms
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/controlpoint"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Starts a server over HTTP only, and returns the description URL.
+func startTestServer(t *testing.T) string {
+	_, location := newTestServer(t)
+	return location
+}
+
+// Starts a server over HTTP only, and returns it with its description URL.
+func newTestServer(t *testing.T) (*Server, string) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "Music"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "Music", "song.mp3"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		HTTPConn:       l,
+		FriendlyName:   "test",
+		RootObjectPath: root,
+		NoProbe:        true,
+		Logger:         log.Default,
+	}
+	if err := srv.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.serveHTTP()
+	t.Cleanup(func() {
+		close(srv.closed)
+		l.Close()
+	})
+	return srv, "http://" + l.Addr().String() + rootDescPath
+}
+
+// The descriptions must be of the UPnP version the SSDP messages are.
+func TestDescriptionsAreUPnP11(t *testing.T) {
+	resp, err := http.Get(startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var desc upnp.DeviceDesc
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		t.Fatal(err)
+	}
+	if desc.SpecVersion != (upnp.SpecVersion{Major: 1, Minor: 1}) || desc.ConfigID == 0 {
+		t.Fatalf("root description is version %+v, with configId %d", desc.SpecVersion, desc.ConfigID)
+	}
+	for _, s := range services {
+		if !strings.Contains(s.SCPD, "<minor>1</minor>") {
+			t.Errorf("%s SCPD isn't UPnP 1.1", s.ServiceType)
+		}
+	}
+}
+
+func TestControlPointBrowse(t *testing.T) {
+	ctx := context.Background()
+	d, err := controlpoint.FetchDevice(ctx, startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cds := d.Service("urn:schemas-upnp-org:service:ContentDirectory:1")
+	if cds == nil {
+		t.Fatal("no ContentDirectory")
+	}
+	type browseArgs struct {
+		ObjectID       string
+		BrowseFlag     string
+		Filter         string
+		StartingIndex  uint32
+		RequestedCount uint32
+		SortCriteria   string
+	}
+	type browseResult struct {
+		Result         string
+		NumberReturned uint32
+		TotalMatches   uint32
+	}
+	ret, err := controlpoint.Invoke[browseArgs, browseResult](ctx, cds, "Browse", browseArgs{
+		ObjectID:   "0",
+		BrowseFlag: "BrowseDirectChildren",
+		Filter:     "*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.NumberReturned != 1 || ret.TotalMatches != 1 || !strings.Contains(ret.Result, "<dc:title>Music</dc:title>") {
+		t.Fatalf("unexpected result: %+v", ret)
+	}
+	_, err = controlpoint.Invoke[browseArgs, browseResult](ctx, cds, "Browse", browseArgs{
+		ObjectID:   "%2Fmissing",
+		BrowseFlag: "BrowseMetadata",
+	})
+	var upnpErr *upnp.Error
+	if !errors.As(err, &upnpErr) || upnpErr.Code != upnpav.NoSuchObjectErrorCode {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestControlPointSubscribe(t *testing.T) {
+	ctx := context.Background()
+	srv, location := newTestServer(t)
+	d, err := controlpoint.FetchDevice(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := controlpoint.NewEventListener(l, log.Default)
+	defer el.Close()
+	events := make(chan controlpoint.Event, 1)
+	sub, err := el.Subscribe(ctx, d.Service("urn:schemas-upnp-org:service:ContentDirectory:1"), time.Minute, func(e controlpoint.Event) {
+		events <- e
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cds := srv.services["ContentDirectory"].(*contentDirectoryService)
+	updateID := func() string {
+		return strconv.FormatUint(uint64(cds.updateID()), 10)
+	}
+	select {
+	case e := <-events:
+		if e.SID != sub.SID() || e.Seq != 0 || len(e.Vars) != 2 || e.Vars[0] != [2]string{"SystemUpdateID", updateID()} || e.Vars[1] != [2]string{"TransferIDs", ""} {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no initial event")
+	}
+	cds.changed()
+	select {
+	case e := <-events:
+		if e.Seq != 1 || len(e.Vars) != 1 || e.Vars[0] != [2]string{"SystemUpdateID", updateID()} {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the change")
+	}
+	if err := sub.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
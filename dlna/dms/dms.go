@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
@@ -20,12 +19,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/anacrolix/ffprobe"
 	"github.com/anacrolix/log"
 
+	"github.com/anacrolix/dms/access"
 	"github.com/anacrolix/dms/dlna"
 	"github.com/anacrolix/dms/soap"
 	"github.com/anacrolix/dms/ssdp"
@@ -40,29 +39,32 @@ import (
 const serverVersion = "1"
 
 var (
-	serverField = fmt.Sprintf(`Linux/3.4 DLNADOC/1.50 UPnP/1.0 %s/%s`,
+	serverField = fmt.Sprintf(`Linux/3.4 DLNADOC/1.50 UPnP/1.1 %s/%s`,
 		userAgentProduct,
 		serverVersion)
 	rootDeviceModelName = fmt.Sprintf("%s %s", userAgentProduct, serverVersion)
 )
 
+const projectURL = "https://github.com/anacrolix/dms"
+
 const (
-	userAgentProduct            = "dms"
-	rootDeviceType              = "urn:schemas-upnp-org:device:MediaServer:1"
-	resPath                     = "/res"
-	iconPath                    = "/icon"
-	subtitlePath                = "/subtitle"
-	rootDescPath                = "/rootDesc.xml"
-	contentDirectoryEventSubURL = "/evt/ContentDirectory"
-	serviceControlURL           = "/ctl"
-	deviceIconPath              = "/deviceIcon"
+	userAgentProduct             = "dms"
+	rootDeviceType               = "urn:schemas-upnp-org:device:MediaServer:1"
+	resPath                      = "/res"
+	iconPath                     = "/icon"
+	subtitlePath                 = "/subtitle"
+	rootDescPath                 = "/rootDesc.xml"
+	contentDirectoryEventSubURL  = "/evt/ContentDirectory"
+	connectionManagerEventSubURL = "/evt/ConnectionManager"
+	serviceControlURL            = "/ctl"
+	deviceIconPath               = "/deviceIcon"
 )
 
 type transcodeSpec struct {
 	mimeType        string
 	DLNAProfileName string
 	DLNAFlags       string
-	Transcode       func(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error)
+	Transcode       func(path string, start, length time.Duration, stderr io.Writer, opts transcode.Options) (r io.ReadCloser, err error)
 }
 
 var transcodes = map[string]transcodeSpec{
@@ -85,9 +87,10 @@ func makeDeviceUuid(unique string) string {
 	return upnp.FormatUUID(buf)
 }
 
-// Groups the service definition with its XML description.
+// Groups the service with its definition, and the XML description built from that.
 type service struct {
 	upnp.Service
+	Def  *upnp.ServiceDef
 	SCPD string
 }
 
@@ -99,21 +102,23 @@ var services = []*service{
 			ServiceId:   "urn:upnp-org:serviceId:ContentDirectory",
 			EventSubURL: contentDirectoryEventSubURL,
 		},
-		SCPD: contentDirectoryServiceDescription,
+		Def: &contentDirectoryServiceDef,
 	},
 	{
 		Service: upnp.Service{
 			ServiceType: "urn:schemas-upnp-org:service:ConnectionManager:1",
 			ServiceId:   "urn:upnp-org:serviceId:ConnectionManager",
+			EventSubURL: connectionManagerEventSubURL,
 		},
-		SCPD: connectionManagerServiceDescription,
+		Def: &connectionManagerServiceDef,
 	},
 	{
 		Service: upnp.Service{
 			ServiceType: "urn:microsoft.com:service:X_MS_MediaReceiverRegistrar:1",
 			ServiceId:   "urn:microsoft.com:serviceId:X_MS_MediaReceiverRegistrar",
+			EventSubURL: mediaReceiverRegistrarEventSubURL,
 		},
-		SCPD: mediaReceiverRegistrarDescription,
+		Def: &mediaReceiverRegistrarServiceDef,
 	},
 }
 
@@ -121,6 +126,17 @@ var services = []*service{
 func init() {
 	for _, s := range services {
 		s.ControlURL = serviceControlURL
+		if err := s.Def.Check(); err != nil {
+			log.Panicf("bad definition of %s: %s", s.ServiceType, err)
+		}
+		scpd := s.Def.SCPD()
+		// The descriptions are UPnP 1.1, as the SSDP messages are.
+		scpd.SpecVersion.Minor = 1
+		b, err := xml.MarshalIndent(scpd, "", "\t")
+		if err != nil {
+			log.Panicf("marshalling SCPD of %s: %s", s.ServiceType, err)
+		}
+		s.SCPD = xml.Header + string(b)
 	}
 }
 
@@ -143,7 +159,7 @@ func (me *Server) httpPort() int {
 
 func (me *Server) serveHTTP() error {
 	srv := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handler: me.Access.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if me.LogHeaders {
 				fmt.Fprintf(os.Stderr, "%s %s\r\n", r.Method, r.RequestURI)
 				r.Header.Write(os.Stderr)
@@ -155,7 +171,7 @@ func (me *Server) serveHTTP() error {
 				ResponseWriter: w,
 				logHeader:      me.LogHeaders,
 			}, r)
-		}),
+		})),
 	}
 	err := srv.Serve(me.HTTPConn)
 	select {
@@ -169,24 +185,163 @@ func (me *Server) serveHTTP() error {
 // An interface with these flags should be valid for SSDP.
 const ssdpInterfaceFlags = net.FlagUp | net.FlagMulticast
 
+// Identifies an SSDP server run by ssdpInterface.
+type ssdpKey struct {
+	index int
+	name  string
+	ipv6  bool
+}
+
+// A running ssdpInterface.
+type ssdpRunner struct {
+	stop    chan struct{}
+	refresh chan struct{}
+	done    chan struct{}
+	started time.Time
+	// Why it failed, set before done is closed.
+	err error
+}
+
+// When to restart an SSDP server that failed.
+type ssdpRestart struct {
+	delay time.Duration
+	at    time.Time
+}
+
+// Runs SSDP on the interfaces returned by ssdpInterfaces until the server is
+// closed, starting and stopping per-interface servers as interfaces come and
+// go, and having them check their addresses when anything changes. Servers
+// that fail are restarted with backoff.
 func (me *Server) doSSDP() {
-	var wg sync.WaitGroup
-	for _, if_ := range me.Interfaces {
-		if_ := if_
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			me.ssdpInterface(if_)
-		}()
-	}
-	wg.Wait()
+	me.serveSearchPorts()
+	running := make(map[ssdpKey]*ssdpRunner)
+	restarts := make(map[ssdpKey]*ssdpRestart)
+	defer func() {
+		for _, r := range running {
+			<-r.done
+		}
+	}()
+	changes := me.watchInterfaces()
+	exited := make(chan struct{}, 1)
+	for {
+		var restart <-chan time.Time
+		if at := me.updateSSDP(running, restarts, exited); !at.IsZero() {
+			restart = time.After(time.Until(at))
+		}
+		select {
+		case <-me.closed:
+			return
+		case <-changes:
+			select {
+			case <-me.closed:
+				return
+			case <-time.After(interfaceSettleDelay):
+			}
+		case <-exited:
+		case <-restart:
+		}
+	}
+}
+
+// Brings the running SSDP servers in line with the current interfaces. exited
+// is signalled when a server returns. It returns when the next failed server
+// is due to be restarted, or the zero time if none are.
+func (me *Server) updateSSDP(running map[ssdpKey]*ssdpRunner, restarts map[ssdpKey]*ssdpRestart, exited chan<- struct{}) (nextRestart time.Time) {
+	want := make(map[ssdpKey]net.Interface)
+	for _, if_ := range me.ssdpInterfaces() {
+		want[ssdpKey{if_.Index, if_.Name, false}] = if_
+		// Unlike IPv4, IPv6 multicast isn't routed over interfaces without the multicast flag,
+		// such as loopback on Linux.
+		if if_.Flags&net.FlagMulticast != 0 && (me.SSDPIPv6 == nil || me.SSDPIPv6(if_)) {
+			want[ssdpKey{if_.Index, if_.Name, true}] = if_
+		}
+	}
+	now := time.Now()
+	for k, r := range running {
+		select {
+		case <-r.done:
+			// It failed or stopped by itself, and can be tried again.
+			delete(running, k)
+			me.ssdpStatus.stopped(k, r.err)
+			if r.err == nil {
+				continue
+			}
+			rs := restarts[k]
+			if rs == nil || now.Sub(r.started) >= maxSSDPRestartDelay {
+				// It's been fine for a while, so start backing off again.
+				rs = &ssdpRestart{}
+				restarts[k] = rs
+			}
+			rs.delay = ssdp.NextRetryDelay(rs.delay, minSSDPRestartDelay, maxSSDPRestartDelay)
+			rs.at = now.Add(rs.delay)
+			me.Logger.Levelf(log.Warning, "restarting SSDP on %q in %v: %v", k.name, rs.delay, r.err)
+			continue
+		default:
+		}
+		if _, ok := want[k]; !ok {
+			close(r.stop)
+			<-r.done
+			delete(running, k)
+			continue
+		}
+		select {
+		case r.refresh <- struct{}{}:
+		default:
+		}
+	}
+	for k := range restarts {
+		if _, ok := want[k]; !ok {
+			delete(restarts, k)
+		}
+	}
+	me.ssdpStatus.retain(want)
+	for k, if_ := range want {
+		if _, ok := running[k]; ok {
+			continue
+		}
+		if rs := restarts[k]; rs != nil && now.Before(rs.at) {
+			if nextRestart.IsZero() || rs.at.Before(nextRestart) {
+				nextRestart = rs.at
+			}
+			continue
+		}
+		r := &ssdpRunner{
+			stop:    make(chan struct{}),
+			refresh: make(chan struct{}, 1),
+			done:    make(chan struct{}),
+			started: now,
+		}
+		running[k] = r
+		me.ssdpStatus.started(k, restarts[k] != nil)
+		go func(if_ net.Interface, ipv6 bool) {
+			defer func() {
+				select {
+				case exited <- struct{}{}:
+				default:
+				}
+			}()
+			defer close(r.done)
+			r.err = me.ssdpInterface(if_, ipv6, r.stop, r.refresh)
+		}(if_, k.ipv6)
+	}
+	return
 }
 
-// Run SSDP server on an interface.
-func (me *Server) ssdpInterface(if_ net.Interface) {
-	logger := me.Logger.WithNames("ssdp", if_.Name)
+// Run SSDP server on an interface, for one address family.
+// It returns when stop or the server is closed. Receiving on refresh has it
+// check the interface's addresses. An error is returned if the SSDP server
+// failed and should be restarted.
+func (me *Server) ssdpInterface(if_ net.Interface, ipv6 bool, stop, refresh <-chan struct{}) error {
+	family := "ipv4"
+	if ipv6 {
+		family = "ipv6"
+	}
+	key := ssdpKey{if_.Index, if_.Name, ipv6}
+	logger := me.Logger.WithNames("ssdp", if_.Name, family)
 	s := ssdp.Server{
 		Interface: if_,
+		IPv6:      ipv6,
+		SiteLocal: me.SSDPSiteLocal,
 		Devices:   devices(),
 		Services:  serviceTypes(),
 		Location: func(ip net.IP) string {
@@ -195,35 +350,59 @@ func (me *Server) ssdpInterface(if_ net.Interface) {
 		Server:         serverField,
 		UUID:           me.rootDeviceUUID,
 		NotifyInterval: me.NotifyInterval,
-		Logger:         logger,
+		BootID:         me.bootID,
+		ConfigID:       me.configID,
+		SearchPort:     me.searchPorts[ipv6],
+		AllowSender: func(ip net.IP) bool {
+			return me.Access.CheckLevel(ip, "M-SEARCH", log.Debug)
+		},
+		OnError: func(err error) {
+			logger.Levelf(log.Warning, "%v", err)
+			me.ssdpStatus.error(key, err)
+		},
+		Logger: logger,
 	}
 	if err := s.Init(); err != nil {
 		if if_.Flags&ssdpInterfaceFlags != ssdpInterfaceFlags {
 			// Didn't expect it to work anyway.
-			return
+			return nil
 		}
 		if strings.Contains(err.Error(), "listen") {
 			// OSX has a lot of dud interfaces. Failure to create a socket on
 			// the interface are what we're expecting if the interface is no
 			// good.
-			return
+			return nil
 		}
-		logger.Printf("error creating ssdp server on %s: %s", if_.Name, err)
-		return
+		return fmt.Errorf("creating %s ssdp server on %s: %w", family, if_.Name, err)
 	}
 	defer s.Close()
-	logger.Levelf(log.Info, "started SSDP on %q", if_.Name)
-	stopped := make(chan struct{})
+	me.ssdpServers.add(&s)
+	defer me.ssdpServers.remove(&s)
+	logger.Levelf(log.Info, "started %s SSDP on %q", family, if_.Name)
+	refreshMDNS := func() {}
+	if me.MDNS {
+		var stopMDNS func()
+		refreshMDNS, stopMDNS = me.startMDNS(if_, ipv6, logger)
+		defer stopMDNS()
+	}
+	served := make(chan error, 1)
 	go func() {
-		defer close(stopped)
-		if err := s.Serve(); err != nil {
-			logger.Printf("%q: %q\n", if_.Name, err)
-		}
+		served <- s.Serve()
 	}()
-	select {
-	case <-me.closed:
-		// Returning will close the server.
-	case <-stopped:
+	for {
+		select {
+		case <-me.closed:
+			// Returning will close the server.
+			return nil
+		case <-stop:
+			logger.Levelf(log.Info, "stopping %s SSDP on %q", family, if_.Name)
+			return nil
+		case err := <-served:
+			return err
+		case <-refresh:
+			s.Refresh()
+			refreshMDNS()
+		}
 	}
 }
 
@@ -236,9 +415,14 @@ type Icon struct {
 }
 
 type Server struct {
-	HTTPConn               net.Listener
-	FriendlyName           string
-	Interfaces             []net.Interface
+	HTTPConn     net.Listener
+	FriendlyName string
+	// The interfaces to do SSDP on. If nil, all interfaces are used as they come and go. Either
+	// way, interfaces that are down are skipped until they're up.
+	Interfaces []net.Interface
+	// If not nil, SSDP is only done on the interfaces it returns true for. It's applied each time
+	// the interfaces change.
+	InterfaceFilter        func(net.Interface) bool
 	httpServeMux           *http.ServeMux
 	RootObjectPath         string
 	OnBrowseDirectChildren func(path string, rootObjectPath string, host, userAgent string) (ret []interface{}, err error)
@@ -263,23 +447,73 @@ type Server struct {
 	StallEventSubscribe bool
 	// Time interval between SSPD announces
 	NotifyInterval time.Duration
+	// Whether to also do SSDP over IPv6 on an interface. IPv6 is used on all interfaces if this
+	// is nil.
+	SSDPIPv6 func(net.Interface) bool
+	// Also use the IPv6 site-local SSDP group ff05::c, in addition to link-local ff02::c.
+	SSDPSiteLocal bool
+	// The port to answer unicast searches on, advertised as SEARCHPORT.UPNP.ORG. The first free
+	// port from 49152 is used if this is 0.
+	SSDPSearchPort int
+	// Also advertise the HTTP endpoint with mDNS/DNS-SD, as _http._tcp with the description path
+	// and UDN in TXT records, on the interfaces SSDP is done on.
+	MDNS bool
+	// The file the UPnP 1.1 boot ID is persisted in, so that it increases across restarts. The
+	// start time is used as the boot ID if this is empty.
+	BootIDPath string
 	// Ignore hidden files and directories
 	IgnoreHidden bool
 	// Ignore unreadable files and directories
 	IgnoreUnreadable bool
 	// Ignore comma separated list of directories
 	IgnorePaths []string
-	// White list of clients
-	AllowedIpNets []*net.IPNet
+	// Which clients may use the server, over HTTP and SSDP. Everyone may if nil.
+	Access *access.Policy
+	// Where to keep the media receivers, such as Xboxes, that have registered with the
+	// X_MS_MediaReceiverRegistrar. They aren't kept across restarts if empty.
+	MediaReceiversPath string
+	// If not nil, only media receivers with these device IDs or IPs are authorized by the
+	// X_MS_MediaReceiverRegistrar. Others are recorded, so that they can be approved. This only
+	// changes what IsAuthorized and IsValidated answer: use Access to keep clients out.
+	ApprovedMediaReceivers []string
+	// Allow clients to create, upload, rename and destroy objects, through the ContentDirectory.
+	Writable bool
+	// Which clients may change the tree when it's Writable. Everyone may if nil.
+	WriteAccess *access.Policy
+	// Where destroyed objects are moved to. They're deleted if this is empty. It shouldn't be
+	// under the root, or they'll still be served.
+	TrashPath string
 	// Activate support for dynamic streams configured via .dms.json metadata files
 	// This feature is not enabled by default, since having write access to a shared media
 	// folder allows executing arbitrary commands in the context of the DLNA server.
 	AllowDynamicStreams bool
+	// Languages in order of preference, as ISO 639-2 codes such as "eng". These choose the
+	// default audio and subtitle tracks of transcodes.
+	PreferredLanguages []string
+	// Render subtitles into the video of transcodes by default, for renderers that can't display
+	// them.
+	BurnSubtitles bool
+	// How often renderers that are being cast to are polled. Defaults to 2s.
+	CastPollInterval time.Duration
+	// Serve the cast API, which plays items on renderers, over HTTP.
+	CastAPI bool
+	// Which clients may use the cast API. Everyone allowed by Access may if nil.
+	CastAccess *access.Policy
 	// pattern where to write transcode logs to. The [tsname] placeholder is replaced with the name
 	// of the item currently being played. The default is $HOME/.dms/log/[tsname]
 	TranscodeLogPattern string
 	Logger              log.Logger
 	eventingLogger      log.Logger
+	hls                 hlsSessions
+	bootID              *ssdp.BootID
+	configID            uint32
+	// Unicast search ports by whether they're IPv6.
+	searchPorts       map[bool]int
+	ssdpServers       ssdpServers
+	ssdpStatus        ssdpStatuses
+	transcodeSessions transcodeSessions
+	casts             castSessions
+	sinks             rendererSinks
 }
 
 // UPnP SOAP service.
@@ -410,7 +644,7 @@ func writeResponseCode(w http.ResponseWriter, partialResponse bool) {
 	}())
 }
 
-func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, path_ string, ts transcodeSpec, tsname string, dynamicMode bool) {
+func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, path_ string, ts transcodeSpec, tsname string, dynamicMode bool, opts transcode.Options) {
 	w.Header().Set(dlna.TransferModeDomain, "Streaming")
 	w.Header().Set("content-type", ts.mimeType)
 	w.Header().Set(dlna.ContentFeaturesDomain, (dlna.ContentFeatures{
@@ -449,25 +683,24 @@ func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, pat
 	} else {
 		logTsName = tsname
 	}
-	stderrPath := strings.Replace(me.TranscodeLogPattern, "[tsname]", logTsName, -1)
 	var logFile io.Writer
-	if stderrPath != "" {
-		os.MkdirAll(filepath.Dir(stderrPath), 0o750)
-		aLogFile, err := os.Create(stderrPath)
-		if err != nil {
-			log.Printf("couldn't create transcode log file: %s", err)
-		} else {
-			defer aLogFile.Close()
-			log.Printf("logging transcode to %q", stderrPath)
-		}
-		logFile = aLogFile
-	}
-	p, err := ts.Transcode(path_, range_.Start, range_.End-range_.Start, logFile)
+	if f := me.openTranscodeLog(logTsName); f != nil {
+		defer f.Close()
+		logFile = f
+	}
+	defer me.openStream(r, fmt.Sprintf("http-get:*:%s:%s", ts.mimeType, dlna.ContentFeatures{
+		ProfileName: ts.DLNAProfileName,
+	}.String()))()
+	session := me.startTranscodeSession(path_, tsname, r.RemoteAddr, true)
+	defer me.endTranscodeSession(session)
+	opts.Progress = session.update
+	p, err := ts.Transcode(path_, range_.Start, range_.End-range_.Start, logFile, opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer p.Close()
+	p = timedReader{p, session}
 	// I recently switched this to returning 200 if no range is specified for
 	// pure UPnP clients. It's possible that DLNA clients will *always* expect
 	// 206. It appears the HTTP standard requires that 206 only be used if a
@@ -476,6 +709,23 @@ func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, pat
 	io.Copy(w, p)
 }
 
+// Creates the transcode log file for tsname as per TranscodeLogPattern. Returns nil if there's
+// nowhere to log to.
+func (me *Server) openTranscodeLog(tsname string) *os.File {
+	stderrPath := strings.Replace(me.TranscodeLogPattern, "[tsname]", tsname, -1)
+	if stderrPath == "" {
+		return nil
+	}
+	os.MkdirAll(filepath.Dir(stderrPath), 0o750)
+	f, err := os.Create(stderrPath)
+	if err != nil {
+		log.Printf("couldn't create transcode log file: %s", err)
+		return nil
+	}
+	log.Printf("logging transcode to %q", stderrPath)
+	return f
+}
+
 func init() {
 	startTime = time.Now()
 }
@@ -552,14 +802,14 @@ func init() {
 }
 
 // Install handlers to serve SCPD for each UPnP service.
-func handleSCPDs(mux *http.ServeMux) {
+func (me *Server) handleSCPDs(mux *http.ServeMux) {
 	for _, s := range services {
 		mux.HandleFunc(s.SCPDURL, func(serviceDesc string) http.HandlerFunc {
 			return func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("content-type", `text/xml; charset="utf-8"`)
 				http.ServeContent(w, r, "", startTime, bytes.NewReader([]byte(serviceDesc)))
 			}
-		}(s.SCPD))
+		}(scpdWithConfigID(s.SCPD, me.configID)))
 	}
 }
 
@@ -588,22 +838,6 @@ func (me *Server) soapActionResponse(sa upnp.SoapAction, actionRequestXML []byte
 
 // Handle a service control HTTP request.
 func (me *Server) serviceControlHandler(w http.ResponseWriter, r *http.Request) {
-	found := false
-	clientIp, _, _ := net.SplitHostPort(r.RemoteAddr)
-	if zoneDelimiterIdx := strings.Index(clientIp, "%"); zoneDelimiterIdx != -1 {
-		// IPv6 addresses may have the form address%zone (e.g. ::1%eth0)
-		clientIp = clientIp[:zoneDelimiterIdx]
-	}
-	for _, ipnet := range me.AllowedIpNets {
-		if ipnet.Contains(net.ParseIP(clientIp)) {
-			found = true
-		}
-	}
-	if !found {
-		log.Printf("not allowed client %s, %+v", clientIp, me.AllowedIpNets)
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return
-	}
 	soapActionString := r.Header.Get("SOAPACTION")
 	soapAction, err := upnp.ParseActionHTTPHeader(soapActionString)
 	if err != nil {
@@ -676,71 +910,6 @@ func (me *Server) serveIcon(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(body))
 }
 
-func (me *Server) serveSubtitle(w http.ResponseWriter, r *http.Request) {
-	filePath := me.filePath(r.URL.Query().Get("path"))
-	subtitleFilePath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".srt"
-	http.ServeFile(w, r, subtitleFilePath)
-}
-
-func (server *Server) contentDirectoryInitialEvent(urls []*url.URL, sid string) {
-	body := xmlMarshalOrPanic(upnp.PropertySet{
-		Properties: []upnp.Property{
-			{
-				Variable: upnp.Variable{
-					XMLName: xml.Name{
-						Local: "SystemUpdateID",
-					},
-					Value: "0",
-				},
-			},
-			// upnp.Property{
-			// 	Variable: upnp.Variable{
-			// 		XMLName: xml.Name{
-			// 			Local: "ContainerUpdateIDs",
-			// 		},
-			// 	},
-			// },
-			// upnp.Property{
-			// 	Variable: upnp.Variable{
-			// 		XMLName: xml.Name{
-			// 			Local: "TransferIDs",
-			// 		},
-			// 	},
-			// },
-		},
-		Space: "urn:schemas-upnp-org:event-1-0",
-	})
-	body = append([]byte(`<?xml version="1.0"?>`+"\n"), body...)
-	server.eventingLogger.Print(string(body))
-	for _, _url := range urls {
-		bodyReader := bytes.NewReader(body)
-		req, err := http.NewRequest("NOTIFY", _url.String(), bodyReader)
-		if err != nil {
-			log.Printf("Could not create a request to notify %s: %s", _url.String(), err)
-			continue
-		}
-		req.Header["CONTENT-TYPE"] = []string{`text/xml; charset="utf-8"`}
-		req.Header["NT"] = []string{"upnp:event"}
-		req.Header["NTS"] = []string{"upnp:propchange"}
-		req.Header["SID"] = []string{sid}
-		req.Header["SEQ"] = []string{"0"}
-		// req.Header["TRANSFER-ENCODING"] = []string{"chunked"}
-		// req.ContentLength = int64(bodyReader.Len())
-		server.eventingLogger.Print(req.Header)
-		server.eventingLogger.Print("starting notify")
-		resp, err := http.DefaultClient.Do(req)
-		server.eventingLogger.Print("finished notify")
-		if err != nil {
-			log.Printf("Could not notify %s: %s", _url.String(), err)
-			continue
-		}
-		server.eventingLogger.Print(resp)
-		b, _ := ioutil.ReadAll(resp.Body)
-		server.eventingLogger.Println(string(b))
-		resp.Body.Close()
-	}
-}
-
 func (server *Server) contentDirectoryEventSubHandler(w http.ResponseWriter, r *http.Request) {
 	if server.StallEventSubscribe {
 		// I have an LG TV that doesn't like my eventing implementation.
@@ -762,32 +931,8 @@ func (server *Server) contentDirectoryEventSubHandler(w http.ResponseWriter, r *
 		server.eventingLogger.Printf("stalled subscribe connection went away after %s", time.Since(t))
 		return
 	}
-	// The following code is a work in progress. It partially implements
-	// the spec on eventing but hasn't been completed as I have nothing to
-	// test it with.
-	server.eventingLogger.Print(r.Header)
-	service := server.services["ContentDirectory"]
-	server.eventingLogger.Println(r.RemoteAddr, r.Method, r.Header.Get("SID"))
-	if r.Method == "SUBSCRIBE" && r.Header.Get("SID") == "" {
-		urls := upnp.ParseCallbackURLs(r.Header.Get("CALLBACK"))
-		server.eventingLogger.Println(urls)
-		var timeout int
-		fmt.Sscanf(r.Header.Get("TIMEOUT"), "Second-%d", &timeout)
-		server.eventingLogger.Println(timeout, r.Header.Get("TIMEOUT"))
-		sid, timeout, _ := service.Subscribe(urls, timeout)
-		w.Header()["SID"] = []string{sid}
-		w.Header()["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", timeout)}
-		// TODO: Shouldn't have to do this to get headers logged.
-		w.WriteHeader(http.StatusOK)
-		go func() {
-			time.Sleep(100 * time.Millisecond)
-			server.contentDirectoryInitialEvent(urls, sid)
-		}()
-	} else if r.Method == "SUBSCRIBE" {
-		http.Error(w, "meh", http.StatusPreconditionFailed)
-	} else {
-		server.eventingLogger.Printf("unhandled event method: %s", r.Method)
-	}
+	cds := server.services["ContentDirectory"].(*contentDirectoryService)
+	cds.ServeSubscription(w, r, cds.eventVariables)
 }
 
 func (server *Server) serveDynamicStream(w http.ResponseWriter, r *http.Request, metadataPath string) error {
@@ -815,7 +960,7 @@ func (server *Server) serveDynamicStream(w http.ResponseWriter, r *http.Request,
 		mimeType:        dmsStream.MimeType,
 		Transcode:       transcode.Exec,
 	}
-	server.serveDLNATranscode(w, r, dmsStream.Command, dmsTsSpec, filepath.Base(metadataPath), true)
+	server.serveDLNATranscode(w, r, dmsStream.Command, dmsTsSpec, filepath.Base(metadataPath), true, transcode.Options{})
 	return nil
 }
 
@@ -835,8 +980,15 @@ func (server *Server) initMux(mux *http.ServeMux) {
 		}
 	})
 	mux.HandleFunc(contentDirectoryEventSubURL, server.contentDirectoryEventSubHandler)
+	mux.HandleFunc(connectionManagerEventSubURL, server.connectionManagerEventSubHandler)
+	mux.HandleFunc(mediaReceiverRegistrarEventSubURL, server.mediaReceiverRegistrarEventSubHandler)
 	mux.HandleFunc(iconPath, server.serveIcon)
 	mux.HandleFunc(subtitlePath, server.serveSubtitle)
+	mux.HandleFunc(hlsPath, server.serveHLS)
+	mux.HandleFunc(statusPath, server.serveStatus)
+	mux.HandleFunc(castPath, server.serveCast)
+	mux.HandleFunc(castRenderersPath, server.serveCastRenderers)
+	mux.HandleFunc(importPath, server.serveImport)
 	mux.HandleFunc(resPath, func(w http.ResponseWriter, r *http.Request) {
 		filePath := server.filePath(r.URL.Query().Get("path"))
 		if ignored, err := server.IgnorePath(filePath); err != nil {
@@ -858,6 +1010,8 @@ func (server *Server) initMux(mux *http.ServeMux) {
 				return
 			}
 		}
+		server.setCaptionInfoHeader(w, r, filePath)
+		server.setMediaInfoHeader(w, r, filePath)
 		var k string
 		if server.ForceTranscodeTo != "" {
 			k = server.ForceTranscodeTo
@@ -872,6 +1026,11 @@ func (server *Server) initMux(mux *http.ServeMux) {
 			}
 			w.Header().Set("Content-Type", string(mimeType))
 			w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(path.Base(filePath)))
+			if r.Method != "HEAD" {
+				defer server.openStream(r, fmt.Sprintf("http-get:*:%s:%s", mimeType, dlna.ContentFeatures{
+					SupportRange: true,
+				}.String()))()
+			}
 			http.ServeFile(w, r, filePath)
 			return
 		}
@@ -879,12 +1038,17 @@ func (server *Server) initMux(mux *http.ServeMux) {
 			http.Error(w, "transcodes disabled", http.StatusNotFound)
 			return
 		}
-		spec, ok := transcodes[k]
+		spec, ok := server.transcodeSpec(k, filePath)
 		if !ok {
 			http.Error(w, fmt.Sprintf("bad transcode spec key: %s", k), http.StatusBadRequest)
 			return
 		}
-		server.serveDLNATranscode(w, r, filePath, spec, k, false)
+		tracks, err := server.requestTracks(filePath, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		server.serveDLNATranscode(w, r, filePath, spec, k, false, transcode.Options{Tracks: tracks})
 	})
 	mux.HandleFunc(rootDescPath, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("content-type", `text/xml; charset="utf-8"`)
@@ -892,7 +1056,7 @@ func (server *Server) initMux(mux *http.ServeMux) {
 		w.Header().Set("server", serverField)
 		w.Write(server.rootDescXML)
 	})
-	handleSCPDs(mux)
+	server.handleSCPDs(mux)
 	mux.HandleFunc(serviceControlURL, server.serviceControlHandler)
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	// DeviceIcons
@@ -924,16 +1088,25 @@ func (s *Server) initServices() (err error) {
 	if err != nil {
 		return
 	}
+	cds := &contentDirectoryService{
+		Server: s,
+	}
+	// Starting from the pid means clients don't keep what they cached from previous runs.
+	cds.systemUpdateID.Store(uint32(os.Getpid()))
+	cds.bindActions()
+	cms := &connectionManagerService{
+		Server: s,
+	}
+	cms.bindActions()
+	mrrs := &mediaReceiverRegistrarService{
+		Server: s,
+	}
+	mrrs.bindActions()
+	mrrs.loadReceivers()
 	s.services = map[string]UPnPService{
-		urn.Type: &contentDirectoryService{
-			Server: s,
-		},
-		urn1.Type: &connectionManagerService{
-			Server: s,
-		},
-		urn2.Type: &mediaReceiverRegistrarService{
-			Server: s,
-		},
+		urn.Type:  cds,
+		urn1.Type: cms,
+		urn2.Type: mrrs,
 	}
 	return
 }
@@ -954,64 +1127,64 @@ func (srv *Server) Init() (err error) {
 			return
 		}
 	}
-	if srv.Interfaces == nil {
-		ifs, err := net.Interfaces()
-		if err != nil {
-			log.Print(err)
-		}
-		var tmp []net.Interface
-		for _, if_ := range ifs {
-			if if_.Flags&net.FlagUp == 0 || if_.MTU <= 0 {
-				continue
-			}
-			tmp = append(tmp, if_)
-		}
-		srv.Interfaces = tmp
-	}
 	if srv.FFProbeCache == nil {
 		srv.FFProbeCache = dummyFFProbeCache{}
 	}
 	srv.httpServeMux = http.NewServeMux()
 	srv.rootDeviceUUID = makeDeviceUuid(srv.FriendlyName)
-	srv.rootDescXML, err = xml.MarshalIndent(
-		upnp.DeviceDesc{
-			// NSDLNA:      "urn:schemas-dlna-org:device-1-0",
-			// NSSEC:       "http://www.sec.co.kr/dlna",
-			SpecVersion: upnp.SpecVersion{Major: 1, Minor: 0},
-			Device: upnp.Device{
-				DeviceType:   rootDeviceType,
-				FriendlyName: srv.FriendlyName,
-				Manufacturer: "Matt Joiner <anacrolix@gmail.com>",
-				ModelName:    rootDeviceModelName,
-				UDN:          srv.rootDeviceUUID,
-				VendorXML: `
+	srv.bootID = srv.loadBootID()
+	rootDesc := upnp.DeviceDesc{
+		// NSDLNA:      "urn:schemas-dlna-org:device-1-0",
+		// NSSEC:       "http://www.sec.co.kr/dlna",
+		SpecVersion: upnp.SpecVersion{Major: 1, Minor: 1},
+		Device: upnp.Device{
+			DeviceType:       rootDeviceType,
+			FriendlyName:     srv.FriendlyName,
+			Manufacturer:     "Matt Joiner <anacrolix@gmail.com>",
+			ManufacturerURL:  projectURL,
+			ModelDescription: rootDeviceModelName,
+			// Xbox 360s only list servers with this model name.
+			ModelName:    fmt.Sprintf("Windows Media Connect compatible (%s)", userAgentProduct),
+			ModelNumber:  serverVersion,
+			ModelURL:     projectURL,
+			SerialNumber: strings.TrimPrefix(srv.rootDeviceUUID, "uuid:"),
+			UDN:          srv.rootDeviceUUID,
+			VendorXML: `
+     <microsoft:magicPacketWakeSupported xmlns:microsoft="urn:schemas-microsoft-com:WMPNSS-1-0">0</microsoft:magicPacketWakeSupported>
      <dlna:X_DLNACAP/>
      <dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
      <dlna:X_DLNADOC>M-DMS-1.50</dlna:X_DLNADOC>
      <sec:ProductCap>smi,DCM10,getMediaInfo.sec,getCaptionInfo.sec</sec:ProductCap>
      <sec:X_ProductCap>smi,DCM10,getMediaInfo.sec,getCaptionInfo.sec</sec:X_ProductCap>`,
-				ServiceList: func() (ss []upnp.Service) {
-					for _, s := range services {
-						ss = append(ss, s.Service)
-					}
-					return
-				}(),
-				IconList: func() (ret []upnp.Icon) {
-					for i, di := range srv.Icons {
-						ret = append(ret, upnp.Icon{
-							Height:   di.Height,
-							Width:    di.Width,
-							Depth:    di.Depth,
-							Mimetype: di.Mimetype,
-							URL:      fmt.Sprintf("%s/%d", deviceIconPath, i),
-						})
-					}
-					return
-				}(),
-				PresentationURL: "/",
-			},
+			ServiceList: func() (ss []upnp.Service) {
+				for _, s := range services {
+					ss = append(ss, s.Service)
+				}
+				return
+			}(),
+			IconList: func() (ret []upnp.Icon) {
+				for i, di := range srv.Icons {
+					ret = append(ret, upnp.Icon{
+						Height:   di.Height,
+						Width:    di.Width,
+						Depth:    di.Depth,
+						Mimetype: di.Mimetype,
+						URL:      fmt.Sprintf("%s/%d", deviceIconPath, i),
+					})
+				}
+				return
+			}(),
+			PresentationURL: "/",
 		},
-		" ", "  ")
+	}
+	// The configuration ID covers the description without itself.
+	srv.rootDescXML, err = xml.MarshalIndent(rootDesc, " ", "  ")
+	if err != nil {
+		return
+	}
+	srv.configID = configID(srv.rootDescXML)
+	rootDesc.ConfigID = srv.configID
+	srv.rootDescXML, err = xml.MarshalIndent(rootDesc, " ", "  ")
 	if err != nil {
 		return
 	}
@@ -1037,6 +1210,8 @@ func (srv *Server) Run() (err error) {
 		srv.doSSDP()
 		close(srv.ssdpStopped)
 	}()
+	go srv.reapHLSSessions()
+	go srv.watchRendererSinks()
 	return srv.serveHTTP()
 }
 
@@ -1044,6 +1219,8 @@ func (srv *Server) Close() (err error) {
 	close(srv.closed)
 	err = srv.HTTPConn.Close()
 	<-srv.ssdpStopped
+	srv.closeHLSSessions()
+	srv.closeCastSessions()
 	return
 }
 
@@ -1052,11 +1229,14 @@ func didl_lite(chardata string) string {
 		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
 		` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"` +
 		` xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
-		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">` +
+		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/"` +
+		` xmlns:sec="http://www.sec.co.kr/">` +
 		chardata +
 		`</DIDL-Lite>`
 }
 
+// Returns the root description URL on ip. IPv6 addresses are bracketed. Zones
+// are left out, as they're only meaningful to us and not the receiver.
 func (me *Server) location(ip net.IP) string {
 	url := url.URL{
 		Scheme: "http",
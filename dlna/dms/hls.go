@@ -0,0 +1,455 @@
+package dms
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	hlsPath            = "/hls/"
+	hlsPlaylistName    = "index.m3u8"
+	hlsSegmentDuration = 6 * time.Second
+	// Requests for segments further than this beyond the last segment ffmpeg
+	// has produced restart ffmpeg at the requested segment instead of waiting,
+	// unless others are waiting for the running ffmpeg.
+	hlsMaxLookahead = 3
+	// A running ffmpeg that hasn't produced a segment for this long can be
+	// restarted for requests it isn't going to produce, even if others are
+	// waiting for it.
+	hlsStallTimeout = 30 * time.Second
+	// How many segments before the last requested are kept, for clients that
+	// skip back a little. Those before are removed.
+	hlsKeepBehind = 10
+	// Sessions that haven't been accessed for this long have their ffmpeg
+	// killed and segments removed.
+	hlsIdleTimeout = 2 * time.Minute
+	// How long to wait for ffmpeg to produce a requested segment.
+	hlsSegmentTimeout = time.Minute
+)
+
+// An HLS transcode of a single file in a single format. The session itself
+// is kept for the life of the server so that clients can resume after the
+// segments have been cleaned up.
+type hlsSession struct {
+	path   string
+	format transcode.HLSFormat
+	opts   transcode.Options
+
+	mu         sync.Mutex
+	dir        string
+	cmd        *exec.Cmd
+	done       chan struct{}
+	start      int
+	lastAccess time.Time
+	// The address of the last client to request a segment.
+	client string
+	// Requests waiting for the running ffmpeg to produce their segments.
+	waiters int
+	// The highest segment ffmpeg was seen to have produced since it was last
+	// started, and when. Segments before it may have been removed since.
+	produced   int
+	producedAt time.Time
+}
+
+type hlsSessions struct {
+	mu sync.Mutex
+	m  map[string]*hlsSession
+}
+
+// Returns a stable identifier for the transcode of path to format.
+func hlsSessionID(path string, format transcode.HLSFormat, opts transcode.Options) string {
+	h := md5.Sum([]byte(fmt.Sprintf("%s\x00%+v\x00%s", format, opts, path)))
+	return hex.EncodeToString(h[:8])
+}
+
+func (me *hlsSessions) get(id string) *hlsSession {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.m[id]
+}
+
+func (me *hlsSessions) getOrCreate(path string, format transcode.HLSFormat, opts transcode.Options) (id string, s *hlsSession) {
+	id = hlsSessionID(path, format, opts)
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[string]*hlsSession)
+	}
+	s = me.m[id]
+	if s == nil {
+		s = &hlsSession{path: path, format: format, opts: opts}
+		me.m[id] = s
+	}
+	return
+}
+
+func (me *hlsSessions) all() (ret []*hlsSession) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, s := range me.m {
+		ret = append(ret, s)
+	}
+	return
+}
+
+// Kills ffmpeg if it's running. The session lock must be held.
+func (s *hlsSession) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.cmd.Process.Kill()
+	<-s.done
+	s.cmd = nil
+	s.done = nil
+}
+
+// Kills ffmpeg and removes all segments. The session lock must be held.
+func (s *hlsSession) cleanup() {
+	s.stop()
+	if s.dir != "" {
+		if err := os.RemoveAll(s.dir); err != nil {
+			log.Printf("error removing hls segments: %s", err)
+		}
+		s.dir = ""
+	}
+}
+
+// Returns the highest numbered segment that ffmpeg has completed since it was
+// last started. The scan continues from the last one seen, as those behind it
+// may have been removed. The session lock must be held.
+func (s *hlsSession) latest() int {
+	n := s.produced
+	for fileExists(filepath.Join(s.dir, s.format.SegmentName(n+1))) {
+		n++
+	}
+	if n != s.produced {
+		s.produced = n
+		s.producedAt = time.Now()
+	}
+	return n
+}
+
+// Returns whether ffmpeg is running. The session lock must be held.
+func (s *hlsSession) running() bool {
+	if s.cmd == nil {
+		return false
+	}
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Returns whether ffmpeg has produced a segment recently. The session lock
+// must be held.
+func (s *hlsSession) progressing() bool {
+	s.latest()
+	return time.Since(s.producedAt) < hlsStallTimeout
+}
+
+// Removes the segments more than hlsKeepBehind before n. The session lock
+// must be held.
+func (s *hlsSession) removeBehind(n int) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		m, err := s.format.ParseSegmentName(e.Name())
+		if err != nil || m >= n-hlsKeepBehind {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, e.Name())); err != nil {
+			log.Levelf(log.Debug, "removing hls segment: %s", err)
+		}
+	}
+}
+
+// Starts ffmpeg producing segments from n onwards, replacing any running
+// instance. The session lock must be held.
+func (me *Server) startHLS(s *hlsSession, n int) (err error) {
+	s.stop()
+	if s.dir == "" {
+		s.dir, err = os.MkdirTemp("", "dms-hls-")
+		if err != nil {
+			return
+		}
+	}
+	var stderr io.Writer
+	logFile := me.openTranscodeLog(filepath.Join("hls", filepath.Base(s.path)))
+	if logFile != nil {
+		stderr = logFile
+	}
+	session := me.startTranscodeSession(s.path, "hls", s.client, false)
+	opts := s.opts
+	opts.Progress = session.update
+	cmd, err := transcode.HLSTranscode(s.path, s.dir, n, hlsSegmentDuration, s.format, stderr, opts)
+	if err != nil {
+		me.endTranscodeSession(session)
+		if logFile != nil {
+			logFile.Close()
+		}
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer me.endTranscodeSession(session)
+		if err := cmd.Wait(); err != nil {
+			me.Logger.Levelf(log.Debug, "hls transcode of %q from segment %d ended: %s", s.path, n, err)
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+	}()
+	s.cmd = cmd
+	s.done = done
+	s.start = n
+	s.waiters = 0
+	s.produced = n - 1
+	s.producedAt = time.Now()
+	return
+}
+
+// Returns the local path of the named file in the session, once it exists.
+// Segment n is the segment the file belongs to. ffmpeg is restarted at n if
+// it isn't going to produce it soon, which is how seeking is handled, but not
+// while it's producing segments others are waiting for.
+func (me *Server) hlsFile(ctx context.Context, s *hlsSession, name string, n int, client string) (string, error) {
+	timeout := time.NewTimer(hlsSegmentTimeout)
+	defer timeout.Stop()
+	// The ffmpeg this request is counted as waiting for, if any.
+	var waitingOn chan struct{}
+	defer func() {
+		s.mu.Lock()
+		if waitingOn != nil && s.done == waitingOn {
+			s.waiters--
+		}
+		s.mu.Unlock()
+	}()
+	for {
+		s.mu.Lock()
+		s.lastAccess = time.Now()
+		s.client = client
+		if s.dir != "" {
+			if fp := filepath.Join(s.dir, name); fileExists(fp) {
+				s.removeBehind(n)
+				s.mu.Unlock()
+				return fp, nil
+			}
+		}
+		if waitingOn != nil && s.done != waitingOn {
+			// ffmpeg was restarted elsewhere, so wait for the new one if it's
+			// going to produce n too.
+			waitingOn = nil
+		}
+		if waitingOn == nil {
+			latest := s.latest()
+			coming := s.running() && n >= s.start && n > latest && n <= latest+hlsMaxLookahead
+			if !coming && (!s.running() || s.waiters == 0 || !s.progressing()) {
+				if err := me.startHLS(s, n); err != nil {
+					s.mu.Unlock()
+					return "", err
+				}
+				coming = true
+			}
+			if coming {
+				waitingOn = s.done
+				s.waiters++
+			}
+		}
+		done := s.done
+		s.mu.Unlock()
+		select {
+		case <-done:
+			s.mu.Lock()
+			restarted := s.done != done
+			fp := filepath.Join(s.dir, name)
+			s.mu.Unlock()
+			if waitingOn == done && !restarted {
+				if fileExists(fp) {
+					return fp, nil
+				}
+				return "", fmt.Errorf("transcode ended before producing %s", name)
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout.C:
+			return "", fmt.Errorf("timed out waiting for %s", name)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (me *Server) serveHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	filePath := me.filePath(r.URL.Query().Get("path"))
+	if ignored, err := me.IgnorePath(filePath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if ignored {
+		http.Error(w, "no such object", http.StatusNotFound)
+		return
+	}
+	format := transcode.HLSFormat(r.URL.Query().Get("format"))
+	switch format {
+	case "":
+		format = transcode.HLSFormatTS
+	case transcode.HLSFormatTS, transcode.HLSFormatFMP4:
+	default:
+		http.Error(w, fmt.Sprintf("bad hls format: %s", format), http.StatusBadRequest)
+		return
+	}
+	ffInfo, err := me.ffmpegProbe(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ffInfo == nil {
+		http.Error(w, "unable to probe file", http.StatusInternalServerError)
+		return
+	}
+	duration, err := ffInfo.Duration()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unknown duration: %s", err), http.StatusInternalServerError)
+		return
+	}
+	tracks, err := me.requestTracks(filePath, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, _ := me.hls.getOrCreate(filePath, format, transcode.Options{Tracks: tracks})
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.WriteString(w, transcode.HLSPlaylist(duration, hlsSegmentDuration, format, id+"/"))
+}
+
+// Handles everything under hlsPath: the playlist, and the segments it refers
+// to.
+func (me *Server) serveHLS(w http.ResponseWriter, r *http.Request) {
+	if me.NoTranscode {
+		http.Error(w, "transcodes disabled", http.StatusNotFound)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, hlsPath)
+	if rest == hlsPlaylistName {
+		me.serveHLSPlaylist(w, r)
+		return
+	}
+	id, name, ok := strings.Cut(rest, "/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s := me.hls.get(id)
+	if s == nil {
+		http.Error(w, "no such hls session", http.StatusNotFound)
+		return
+	}
+	var (
+		n        int
+		mimeType = s.format.SegmentMimeType()
+	)
+	if name == transcode.HLSInitName && s.format == transcode.HLSFormatFMP4 {
+		// The initialization segment is written when ffmpeg starts, so any
+		// running instance will do.
+		s.mu.Lock()
+		if s.cmd != nil {
+			n = s.start
+		}
+		s.mu.Unlock()
+	} else {
+		var err error
+		n, err = s.format.ParseSegmentName(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	if r.Method == "HEAD" {
+		w.Header().Set("Content-Type", mimeType)
+		return
+	}
+	fp, err := me.hlsFile(r.Context(), s, name, n, r.RemoteAddr)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			me.Logger.Printf("error serving hls %s for %q: %s", name, s.path, err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mimeType)
+	http.ServeFile(w, r, fp)
+}
+
+// Stops the transcodes of sessions that haven't been accessed recently, until
+// the server is closed.
+func (me *Server) reapHLSSessions() {
+	t := time.NewTicker(hlsIdleTimeout / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-me.closed:
+			return
+		case <-t.C:
+		}
+		for _, s := range me.hls.all() {
+			s.mu.Lock()
+			if s.dir != "" && time.Since(s.lastAccess) > hlsIdleTimeout {
+				me.Logger.Levelf(log.Debug, "cleaning up idle hls session for %q", s.path)
+				s.cleanup()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (me *Server) closeHLSSessions() {
+	for _, s := range me.hls.all() {
+		s.mu.Lock()
+		s.cleanup()
+		s.mu.Unlock()
+	}
+}
+
+// Returns the resource for the HLS playlist of the given path, for clients
+// that browse with DLNA but play with HLS.
+func hlsResource(host, path, resolution, duration string) upnpav.Resource {
+	return upnpav.Resource{
+		URL: (&url.URL{
+			Scheme: "http",
+			Host:   host,
+			Path:   hlsPath + hlsPlaylistName,
+			RawQuery: url.Values{
+				"path": {path},
+			}.Encode(),
+		}).String(),
+		ProtocolInfo: "http-get:*:application/vnd.apple.mpegurl:*",
+		Resolution:   resolution,
+		Duration:     duration,
+	}
+}
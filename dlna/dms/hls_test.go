@@ -0,0 +1,47 @@
+package dms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+func TestHLSRemoveBehind(t *testing.T) {
+	s := &hlsSession{format: transcode.HLSFormatTS, dir: t.TempDir()}
+	for n := 0; n < 20; n++ {
+		if err := os.WriteFile(filepath.Join(s.dir, s.format.SegmentName(n)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "ffmpeg.m3u8"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.removeBehind(15)
+	for n := 0; n < 20; n++ {
+		if exists, want := fileExists(filepath.Join(s.dir, s.format.SegmentName(n))), n >= 15-hlsKeepBehind; exists != want {
+			t.Errorf("segment %d exists: %v", n, exists)
+		}
+	}
+	if !fileExists(filepath.Join(s.dir, "ffmpeg.m3u8")) {
+		t.Error("playlist was removed")
+	}
+	s.start = 5
+	s.produced = 4
+	if latest := s.latest(); latest != 19 {
+		t.Errorf("latest segment %d", latest)
+	}
+	// Playback has passed start+hlsKeepBehind, so segments from start on have
+	// been removed, but the latest is still known.
+	s.removeBehind(18)
+	if fileExists(filepath.Join(s.dir, s.format.SegmentName(s.start))) {
+		t.Fatal("segment at start wasn't removed")
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, s.format.SegmentName(20)), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if latest := s.latest(); latest != 20 {
+		t.Errorf("latest segment %d after removing start", latest)
+	}
+}
@@ -0,0 +1,111 @@
+package dms
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+const (
+	// How often interfaces are checked for changes where there's no way to be
+	// notified of them.
+	interfacePollInterval = 10 * time.Second
+	// Interface changes come in bursts, such as a link coming up and then
+	// getting addresses, so they're acted on once things have settled.
+	interfaceSettleDelay = 500 * time.Millisecond
+)
+
+// Returns a description of the interfaces and their addresses that changes
+// whenever anything relevant to SSDP does.
+func interfacesSnapshot() string {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	var lines []string
+	for _, if_ := range ifs {
+		addrs, _ := if_.Addrs()
+		lines = append(lines, fmt.Sprintf("%d %s %s %d %v", if_.Index, if_.Name, if_.Flags, if_.MTU, addrs))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// Polls the interfaces for changes, calling changed when they differ from
+// the last poll, until closed is closed.
+func pollInterfaceChanges(closed <-chan struct{}, changed func()) {
+	last := interfacesSnapshot()
+	t := time.NewTicker(interfacePollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-t.C:
+		}
+		if s := interfacesSnapshot(); s != last {
+			last = s
+			changed()
+		}
+	}
+}
+
+// Returns a channel that receives when interfaces or their addresses may have
+// changed. The platform's notifications are used if possible, and polling
+// otherwise.
+func (me *Server) watchInterfaces() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		err := watchInterfaceChanges(me.closed, changed)
+		select {
+		case <-me.closed:
+			return
+		default:
+		}
+		me.Logger.Levelf(log.Debug, "polling for interface changes: %v", err)
+		pollInterfaceChanges(me.closed, changed)
+	}()
+	return ch
+}
+
+// Returns the interfaces SSDP should currently run on. Configured interfaces
+// are looked up again by name, as their index and flags can change.
+func (me *Server) ssdpInterfaces() (ret []net.Interface) {
+	var ifs []net.Interface
+	if me.Interfaces != nil {
+		for _, if_ := range me.Interfaces {
+			cur, err := net.InterfaceByName(if_.Name)
+			if err != nil {
+				continue
+			}
+			ifs = append(ifs, *cur)
+		}
+	} else {
+		var err error
+		ifs, err = net.Interfaces()
+		if err != nil {
+			me.Logger.Printf("error getting interfaces: %v", err)
+			return
+		}
+	}
+	for _, if_ := range ifs {
+		if if_.Flags&net.FlagUp == 0 || if_.MTU <= 0 {
+			continue
+		}
+		if me.InterfaceFilter != nil && !me.InterfaceFilter(if_) {
+			continue
+		}
+		ret = append(ret, if_)
+	}
+	return
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package dms
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Calls changed whenever the kernel reports a link or address change, until
+// closed is closed. The messages aren't parsed, as any of them is reason to
+// look at the interfaces again.
+func watchInterfaceChanges(closed <-chan struct{}, changed func()) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	err = unix.Bind(fd, &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+	})
+	if err != nil {
+		unix.Close(fd)
+		return os.NewSyscallError("bind", err)
+	}
+	// Going through os.File puts the socket in the runtime poller, so closing
+	// it unblocks the read.
+	f := os.NewFile(uintptr(fd), "netlink")
+	go func() {
+		<-closed
+		f.Close()
+	}()
+	b := make([]byte, os.Getpagesize())
+	for {
+		if _, err := f.Read(b); err != nil {
+			return err
+		}
+		changed()
+	}
+}
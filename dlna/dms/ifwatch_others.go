@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package dms
+
+import "errors"
+
+func watchInterfaceChanges(closed <-chan struct{}, changed func()) error {
+	return errors.New("interface change notifications not supported")
+}
@@ -0,0 +1,59 @@
+package dms
+
+import (
+	"net"
+	"os"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/mdns"
+)
+
+// The DNS-SD service type the HTTP endpoint is advertised as.
+const mdnsService = "_http._tcp"
+
+// Returns the mDNS host name to advertise, distinct from the host's own so
+// that it doesn't conflict with another responder on it.
+func mdnsHost() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = ""
+	}
+	return mdns.HostLabel(hostname + "-dms")
+}
+
+// Starts advertising over mDNS on an interface, for one address family, as
+// an SSDP server does. It returns a function that refreshes the server's
+// addresses, and one that stops it. Failing to start is logged, and isn't
+// fatal to SSDP.
+func (me *Server) startMDNS(if_ net.Interface, ipv6 bool, logger log.Logger) (refresh, stop func()) {
+	logger = logger.WithNames("mdns")
+	s := mdns.Server{
+		Interface: if_,
+		IPv6:      ipv6,
+		Instance:  me.FriendlyName,
+		Service:   mdnsService,
+		Host:      mdnsHost(),
+		Port:      me.httpPort(),
+		TXT: []string{
+			"path=" + rootDescPath,
+			"udn=" + me.rootDeviceUUID,
+		},
+		Logger: logger,
+	}
+	if err := s.Init(); err != nil {
+		logger.Levelf(log.Warning, "error creating mdns server on %s: %v", if_.Name, err)
+		return func() {}, func() {}
+	}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		if err := s.Serve(); err != nil {
+			logger.Levelf(log.Warning, "mdns server on %s: %v", if_.Name, err)
+		}
+	}()
+	return s.Refresh, func() {
+		s.Close()
+		<-served
+	}
+}
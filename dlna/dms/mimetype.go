@@ -20,6 +20,25 @@ func init() {
 	if err := mime.AddExtensionType(".ogg", "audio/ogg"); err != nil {
 		log.Printf("Could not register audio/ogg MIME type: %s", err)
 	}
+	// Audio formats that are commonly missing from the system MIME-type
+	// tables, and that we can transcode.
+	for ext, mt := range map[string]string{
+		".flac": "audio/flac",
+		".ape":  "audio/x-ape",
+		".wv":   "audio/x-wavpack",
+		".dsf":  "audio/x-dsf",
+		".dff":  "audio/x-dff",
+		".opus": "audio/ogg",
+		".wma":  "audio/x-ms-wma",
+		".m4a":  "audio/mp4",
+	} {
+		if mime.TypeByExtension(ext) != "" {
+			continue
+		}
+		if err := mime.AddExtensionType(ext, mt); err != nil {
+			log.Printf("Could not register %s MIME type: %s", mt, err)
+		}
+	}
 }
 
 // Example: "video/mpeg"
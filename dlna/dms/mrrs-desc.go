@@ -1,90 +1,33 @@
 package dms
 
-const mediaReceiverRegistrarDescription = `<?xml version="1.0"?>
-<scpd xmlns="urn:schemas-upnp-org:service-1-0">
-	<specVersion>
-		<major>1</major>
-		<minor>0</minor>
-	</specVersion>
-	<actionList>
-		<action>
-			<name>IsAuthorized</name>
-			<argumentList>
-				<argument>
-					<name>DeviceID</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_DeviceID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>Result</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-		<action>
-			<name>RegisterDevice</name>
-			<argumentList>
-				<argument>
-					<name>RegistrationReqMsg</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_RegistrationReqMsg</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>RegistrationRespMsg</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_RegistrationRespMsg</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-		<action>
-			<name>IsValidated</name>
-			<argumentList>
-				<argument>
-					<name>DeviceID</name>
-					<direction>in</direction>
-					<relatedStateVariable>A_ARG_TYPE_DeviceID</relatedStateVariable>
-				</argument>
-				<argument>
-					<name>Result</name>
-					<direction>out</direction>
-					<relatedStateVariable>A_ARG_TYPE_Result</relatedStateVariable>
-				</argument>
-			</argumentList>
-		</action>
-	</actionList>
-	<serviceStateTable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_DeviceID</name>
-			<dataType>string</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_Result</name>
-			<dataType>int</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_RegistrationReqMsg</name>
-			<dataType>bin.base64</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="no">
-			<name>A_ARG_TYPE_RegistrationRespMsg</name>
-			<dataType>bin.base64</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="yes">
-			<name>AuthorizationGrantedUpdateID</name>
-			<dataType>ui4</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="yes">
-			<name>AuthorizationDeniedUpdateID</name>
-			<dataType>ui4</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="yes">
-			<name>ValidationSucceededUpdateID</name>
-			<dataType>ui4</dataType>
-		</stateVariable>
-		<stateVariable sendEvents="yes">
-			<name>ValidationRevokedUpdateID</name>
-			<dataType>ui4</dataType>
-		</stateVariable>
-	</serviceStateTable>
-</scpd>`
+import "github.com/anacrolix/dms/upnp"
+
+var mediaReceiverRegistrarServiceDef = upnp.ServiceDef{
+	Actions: []upnp.ActionDef{
+		{
+			Name: "IsAuthorized",
+			In:   []upnp.ArgDef{{Name: "DeviceID", StateVar: "A_ARG_TYPE_DeviceID"}},
+			Out:  []upnp.ArgDef{{Name: "Result", StateVar: "A_ARG_TYPE_Result"}},
+		},
+		{
+			Name: "RegisterDevice",
+			In:   []upnp.ArgDef{{Name: "RegistrationReqMsg", StateVar: "A_ARG_TYPE_RegistrationReqMsg"}},
+			Out:  []upnp.ArgDef{{Name: "RegistrationRespMsg", StateVar: "A_ARG_TYPE_RegistrationRespMsg"}},
+		},
+		{
+			Name: "IsValidated",
+			In:   []upnp.ArgDef{{Name: "DeviceID", StateVar: "A_ARG_TYPE_DeviceID"}},
+			Out:  []upnp.ArgDef{{Name: "Result", StateVar: "A_ARG_TYPE_Result"}},
+		},
+	},
+	StateVars: []upnp.StateVarDef{
+		{Name: "A_ARG_TYPE_DeviceID", DataType: "string"},
+		{Name: "A_ARG_TYPE_Result", DataType: "int"},
+		{Name: "A_ARG_TYPE_RegistrationReqMsg", DataType: "bin.base64"},
+		{Name: "A_ARG_TYPE_RegistrationRespMsg", DataType: "bin.base64"},
+		{Name: "AuthorizationGrantedUpdateID", DataType: "ui4", SendEvents: true},
+		{Name: "AuthorizationDeniedUpdateID", DataType: "ui4", SendEvents: true},
+		{Name: "ValidationSucceededUpdateID", DataType: "ui4", SendEvents: true},
+		{Name: "ValidationRevokedUpdateID", DataType: "ui4", SendEvents: true},
+	},
+}
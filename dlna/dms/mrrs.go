@@ -1,28 +1,229 @@
 package dms
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/fs"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
 
 	"github.com/anacrolix/dms/upnp"
 )
 
+const mediaReceiverRegistrarEventSubURL = "/evt/X_MS_MediaReceiverRegistrar"
+
+// How many receivers are remembered. The least recently seen are forgotten beyond this, those
+// that haven't registered first, so that clients making up device IDs can't grow the list.
+const maxMediaReceivers = 100
+
+// MediaReceiver is a client that has used the X_MS_MediaReceiverRegistrar, such as an Xbox or
+// Windows Media Player.
+type MediaReceiver struct {
+	// The DeviceID it gave, if any. Xbox 360s don't give one.
+	DeviceID string `json:",omitempty"`
+	IP       string
+	// The User-Agent it last gave.
+	UserAgent string
+	// Whether it has sent RegisterDevice.
+	Registered bool
+	FirstSeen  time.Time
+	LastSeen   time.Time
+}
+
 type mediaReceiverRegistrarService struct {
 	*Server
 	upnp.Eventing
+	*upnp.Actions
+
+	receiversMu sync.Mutex
+	// By DeviceID, or IP if there isn't one.
+	receivers map[string]*MediaReceiver
+	// The evented update IDs, which change as receivers are authorized or denied.
+	grantedUpdateID, deniedUpdateID uint32
+}
+
+func (mrrs *mediaReceiverRegistrarService) bindActions() {
+	mrrs.Actions = upnp.NewActions(&mediaReceiverRegistrarServiceDef)
+	authorized := func(r *http.Request, in struct{ DeviceID string }) (ret struct{ Result int32 }, err error) {
+		if mrrs.authorize(r, in.DeviceID, false) {
+			ret.Result = 1
+		}
+		return
+	}
+	upnp.Bind(mrrs.Actions, "IsAuthorized", authorized)
+	upnp.Bind(mrrs.Actions, "IsValidated", authorized)
+	upnp.Bind(mrrs.Actions, "RegisterDevice", func(r *http.Request, _ struct{ RegistrationReqMsg string }) (ret struct{ RegistrationRespMsg string }, err error) {
+		mrrs.authorize(r, "", true)
+		ret.RegistrationRespMsg = mrrs.rootDeviceUUID
+		return
+	})
+}
+
+// Records the receiver making the request, and returns whether it's approved. Only registrations
+// change the update IDs and are saved.
+func (mrrs *mediaReceiverRegistrarService) authorize(r *http.Request, deviceID string, register bool) bool {
+	ip := hostIP(r.RemoteAddr)
+	approved := mrrs.ApprovedMediaReceivers == nil
+	for _, a := range mrrs.ApprovedMediaReceivers {
+		if a == ip || (deviceID != "" && a == deviceID) {
+			approved = true
+		}
+	}
+	key := deviceID
+	if key == "" {
+		key = ip
+	}
+	now := time.Now()
+	mrrs.receiversMu.Lock()
+	if mrrs.receivers == nil {
+		mrrs.receivers = make(map[string]*MediaReceiver)
+	}
+	mr, ok := mrrs.receivers[key]
+	changed := register && (!ok || !mr.Registered)
+	if !ok {
+		mrrs.forgetReceivers(maxMediaReceivers - 1)
+		mr = &MediaReceiver{DeviceID: deviceID, FirstSeen: now}
+		mrrs.receivers[key] = mr
+	}
+	mr.IP = ip
+	mr.UserAgent = r.UserAgent()
+	mr.LastSeen = now
+	mr.Registered = mr.Registered || register
+	if changed {
+		if approved {
+			mrrs.grantedUpdateID++
+		} else {
+			mrrs.deniedUpdateID++
+		}
+	}
+	mrrs.receiversMu.Unlock()
+	if !ok && !changed {
+		mrrs.Logger.Levelf(log.Debug, "media receiver %q at %s seen", deviceID, ip)
+	}
+	if changed {
+		if approved {
+			mrrs.Logger.Printf("media receiver %q at %s authorized", deviceID, ip)
+		} else {
+			mrrs.Logger.Printf("media receiver %q at %s isn't approved", deviceID, ip)
+		}
+		mrrs.saveReceivers()
+		mrrs.Notify(mrrs.eventVariables()...)
+	}
+	return approved
+}
+
+// Forgets the least recently seen receivers until there are at most n, preferring those that
+// haven't registered. receiversMu must be held.
+func (mrrs *mediaReceiverRegistrarService) forgetReceivers(n int) {
+	for len(mrrs.receivers) > n {
+		var oldest string
+		for key, mr := range mrrs.receivers {
+			o := mrrs.receivers[oldest]
+			if o == nil || (o.Registered && !mr.Registered) ||
+				(o.Registered == mr.Registered && mr.LastSeen.Before(o.LastSeen)) {
+				oldest = key
+			}
+		}
+		delete(mrrs.receivers, oldest)
+	}
+}
+
+func (mrrs *mediaReceiverRegistrarService) eventVariables() []upnp.Variable {
+	mrrs.receiversMu.Lock()
+	defer mrrs.receiversMu.Unlock()
+	granted := strconv.FormatUint(uint64(mrrs.grantedUpdateID), 10)
+	denied := strconv.FormatUint(uint64(mrrs.deniedUpdateID), 10)
+	return []upnp.Variable{
+		{XMLName: xml.Name{Local: "AuthorizationGrantedUpdateID"}, Value: granted},
+		{XMLName: xml.Name{Local: "AuthorizationDeniedUpdateID"}, Value: denied},
+		{XMLName: xml.Name{Local: "ValidationSucceededUpdateID"}, Value: granted},
+		{XMLName: xml.Name{Local: "ValidationRevokedUpdateID"}, Value: denied},
+	}
+}
+
+func (mrrs *mediaReceiverRegistrarService) mediaReceivers() (ret []MediaReceiver) {
+	mrrs.receiversMu.Lock()
+	for _, mr := range mrrs.receivers {
+		ret = append(ret, *mr)
+	}
+	mrrs.receiversMu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].FirstSeen.Before(ret[j].FirstSeen)
+	})
+	return
+}
+
+// Loads the registered receivers from MediaReceiversPath, if it's set.
+func (mrrs *mediaReceiverRegistrarService) loadReceivers() {
+	if mrrs.MediaReceiversPath == "" {
+		return
+	}
+	b, err := os.ReadFile(mrrs.MediaReceiversPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var mrs []MediaReceiver
+	if err == nil {
+		err = json.Unmarshal(b, &mrs)
+	}
+	if err != nil {
+		mrrs.Logger.Printf("error loading media receivers from %q: %v", mrrs.MediaReceiversPath, err)
+		return
+	}
+	mrrs.receiversMu.Lock()
+	mrrs.receivers = make(map[string]*MediaReceiver, len(mrs))
+	for i := range mrs {
+		key := mrs[i].DeviceID
+		if key == "" {
+			key = mrs[i].IP
+		}
+		mrrs.receivers[key] = &mrs[i]
+	}
+	mrrs.receiversMu.Unlock()
 }
 
-func (mrrs *mediaReceiverRegistrarService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
-	switch action {
-	case "IsAuthorized", "IsValidated":
-		return [][2]string{
-			{"Result", "1"},
-		}, nil
-	case "RegisterDevice":
-		return [][2]string{
-			{"RegistrationRespMsg", mrrs.rootDeviceUUID},
-		}, nil
-		//		return nil, nil
-	default:
-		return nil, upnp.InvalidActionError
+// Saves the registered receivers to MediaReceiversPath, if it's set.
+func (mrrs *mediaReceiverRegistrarService) saveReceivers() {
+	if mrrs.MediaReceiversPath == "" {
+		return
+	}
+	var registered []MediaReceiver
+	for _, mr := range mrrs.mediaReceivers() {
+		if mr.Registered {
+			registered = append(registered, mr)
+		}
+	}
+	b, err := json.MarshalIndent(registered, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(mrrs.MediaReceiversPath), 0o750)
 	}
+	if err == nil {
+		err = os.WriteFile(mrrs.MediaReceiversPath, append(b, '\n'), 0o640)
+	}
+	if err != nil {
+		mrrs.Logger.Printf("error saving media receivers: %v", err)
+	}
+}
+
+func (me *Server) mediaReceiverRegistrar() *mediaReceiverRegistrarService {
+	return me.services["X_MS_MediaReceiverRegistrar"].(*mediaReceiverRegistrarService)
+}
+
+// MediaReceivers returns the clients that have used the X_MS_MediaReceiverRegistrar, in the order
+// they were first seen.
+func (me *Server) MediaReceivers() []MediaReceiver {
+	return me.mediaReceiverRegistrar().mediaReceivers()
+}
+
+func (me *Server) mediaReceiverRegistrarEventSubHandler(w http.ResponseWriter, r *http.Request) {
+	mrrs := me.mediaReceiverRegistrar()
+	mrrs.ServeSubscription(w, r, mrrs.eventVariables)
 }
@@ -0,0 +1,76 @@
+package dms
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/log"
+)
+
+func TestMediaReceiverRegistrar(t *testing.T) {
+	newServer := func(path string) *mediaReceiverRegistrarService {
+		srv := &Server{
+			RootObjectPath:         t.TempDir(),
+			NoProbe:                true,
+			Logger:                 log.Default,
+			MediaReceiversPath:     path,
+			ApprovedMediaReceivers: []string{"192.0.2.1", "approved-id"},
+		}
+		if err := srv.initServices(); err != nil {
+			t.Fatal(err)
+		}
+		return srv.mediaReceiverRegistrar()
+	}
+	request := func(ip string) *http.Request {
+		r := httptest.NewRequest("POST", serviceControlURL, nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("User-Agent", "Xbox/2.0.17559.0 UPnP/1.0 Xbox/2.0.17559.0")
+		return r
+	}
+	path := filepath.Join(t.TempDir(), "mediareceivers.json")
+	mrrs := newServer(path)
+	if !mrrs.authorize(request("192.0.2.1"), "", true) {
+		t.Fatal("approved IP wasn't authorized")
+	}
+	if !mrrs.authorize(request("192.0.2.2"), "approved-id", false) {
+		t.Fatal("approved device ID wasn't authorized")
+	}
+	if mrrs.authorize(request("192.0.2.3"), "", false) {
+		t.Fatal("unapproved receiver was authorized")
+	}
+	mrrs.authorize(request("192.0.2.4"), "", true)
+	if mrrs.grantedUpdateID != 1 || mrrs.deniedUpdateID != 1 {
+		t.Fatalf("update IDs: granted %d, denied %d", mrrs.grantedUpdateID, mrrs.deniedUpdateID)
+	}
+	if mrs := mrrs.mediaReceivers(); len(mrs) != 4 || mrs[1].DeviceID != "approved-id" || mrs[2].Registered {
+		t.Fatalf("recorded %+v", mrs)
+	}
+
+	// Only the registered receivers are saved.
+	mrs := newServer(path).mediaReceivers()
+	if len(mrs) != 2 || mrs[0].IP != "192.0.2.1" || !mrs[0].Registered || mrs[1].IP != "192.0.2.4" {
+		t.Fatalf("loaded %+v", mrs)
+	}
+}
+
+func TestMediaReceiverRegistrarLimit(t *testing.T) {
+	srv := &Server{RootObjectPath: t.TempDir(), NoProbe: true, Logger: log.Default}
+	if err := srv.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	mrrs := srv.mediaReceiverRegistrar()
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	mrrs.authorize(r, "registered", true)
+	for i := 0; i < 2*maxMediaReceivers; i++ {
+		mrrs.authorize(r, fmt.Sprintf("made-up-%d", i), false)
+	}
+	if n := len(mrrs.mediaReceivers()); n != maxMediaReceivers {
+		t.Fatalf("%d receivers", n)
+	}
+	if mr := mrrs.receivers["registered"]; mr == nil || !mr.Registered {
+		t.Fatal("registered receiver was forgotten")
+	}
+}
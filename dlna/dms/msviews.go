@@ -0,0 +1,301 @@
+package dms
+
+import (
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// A flat list of the media of a kind anywhere under the root.
+type msView struct {
+	title string
+	// The media type listed, such as "audio", or empty for views that are always empty.
+	kind string
+}
+
+// Microsoft's well-known container IDs, which Windows Media Player and Xbox consoles browse and
+// search without finding them from the root. Tags aren't indexed, so the genre, artist and album
+// views list all the music, and there are no playlists. None of these are valid paths, so they
+// can't collide with real ObjectIDs.
+var msViews = map[string]msView{
+	"1":  {"Music", "audio"},
+	"4":  {"All Music", "audio"},
+	"5":  {"Genre", "audio"},
+	"6":  {"Artist", "audio"},
+	"7":  {"Album", "audio"},
+	"F":  {"Playlists", ""},
+	"2":  {"Video", "video"},
+	"8":  {"All Video", "video"},
+	"15": {"Video", "video"},
+	"3":  {"Pictures", "image"},
+	"B":  {"All Pictures", "image"},
+	"16": {"Pictures", "image"},
+}
+
+// An object found walking the tree, with the properties it can be searched by.
+type searchEntry struct {
+	obj   object
+	fi    os.FileInfo
+	props map[string]string
+}
+
+// How long a walk is reused for, so that paging through a view or search results doesn't walk the
+// tree for every page. Walks aren't reused after the SystemUpdateID changes.
+const walkCacheTTL = 30 * time.Second
+
+type walkKey struct {
+	path string
+	kind string
+}
+
+type cachedWalk struct {
+	updateID uint32
+	expiry   time.Time
+	entries  []searchEntry
+}
+
+// Recent walks of the tree. They're shared, so their entries mustn't be modified.
+type walkCache struct {
+	mu sync.Mutex
+	m  map[walkKey]cachedWalk
+}
+
+// Returns the objects under o, depth first in name order. Media is only included if it's of the
+// kind, and containers only if the kind is empty. Dynamic streams aren't included. Recent walks are
+// reused.
+func (me *contentDirectoryService) walkEntries(o object, kind string) []searchEntry {
+	key := walkKey{o.Path, kind}
+	updateID := me.updateID()
+	now := time.Now()
+	// Held while walking, so that concurrent requests for the same pages don't walk too.
+	me.walks.mu.Lock()
+	defer me.walks.mu.Unlock()
+	if w, ok := me.walks.m[key]; ok && w.updateID == updateID && now.Before(w.expiry) {
+		return w.entries
+	}
+	var seen []os.FileInfo
+	if fi, err := os.Stat(o.FilePath()); err == nil {
+		seen = append(seen, fi)
+	}
+	entries := me.walkDir(o, kind, &seen)
+	for k, w := range me.walks.m {
+		if !now.Before(w.expiry) {
+			delete(me.walks.m, k)
+		}
+	}
+	if me.walks.m == nil {
+		me.walks.m = make(map[walkKey]cachedWalk)
+	}
+	me.walks.m[key] = cachedWalk{updateID, now.Add(walkCacheTTL), entries}
+	return entries
+}
+
+// Walks the directory for walkEntries. Directories already seen, through symlinks, are skipped,
+// so that loops end and aren't walked repeatedly.
+func (me *contentDirectoryService) walkDir(o object, kind string, seen *[]os.FileInfo) (ret []searchEntry) {
+	fis, err := o.readDir()
+	if err != nil {
+		me.Logger.Printf("error reading container: %s", err)
+		return
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return strings.ToLower(fis[i].Name()) < strings.ToLower(fis[j].Name())
+	})
+	for _, fi := range fis {
+		child := object{path.Join(o.Path, fi.Name()), me.RootObjectPath}
+		if ignored, err := me.IgnorePath(child.FilePath()); err != nil || ignored {
+			continue
+		}
+		props := map[string]string{
+			"@id":       child.ID(),
+			"@parentID": child.ParentID(),
+			"dc:title":  fi.Name(),
+		}
+		if fi.IsDir() {
+			if seenDir(*seen, fi) {
+				continue
+			}
+			*seen = append(*seen, fi)
+			if kind == "" {
+				props["upnp:class"] = "object.container.storageFolder"
+				ret = append(ret, searchEntry{child, fi, props})
+			}
+			ret = append(ret, me.walkDir(child, kind, seen)...)
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		mimeType, err := MimeTypeByPath(child.FilePath())
+		if err != nil || !mimeType.IsMedia() || (kind != "" && mimeType.Type() != kind) {
+			continue
+		}
+		props["upnp:class"] = "object.item." + mimeType.Type() + "Item"
+		ret = append(ret, searchEntry{child, fi, props})
+	}
+	return
+}
+
+func seenDir(seen []os.FileInfo, fi os.FileInfo) bool {
+	for _, s := range seen {
+		if os.SameFile(s, fi) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the view's entries. Magic IDs that aren't views return nil.
+func (me *contentDirectoryService) viewEntries(id string) []searchEntry {
+	view, ok := msViews[id]
+	if !ok || view.kind == "" {
+		return nil
+	}
+	return me.walkEntries(object{"/", me.RootObjectPath}, view.kind)
+}
+
+// Converts the page of entries to upnpav objects, in DIDL-Lite. Objects are made children of
+// parentID if it's not empty, as they are in views.
+func (me *contentDirectoryService) entriesResult(
+	entries []searchEntry,
+	startingIndex, requestedCount uint32,
+	parentID string,
+	host, userAgent string,
+	sink []dlna.ProtocolInfo,
+) (result string, numberReturned uint32, err error) {
+	if int64(startingIndex) < int64(len(entries)) {
+		entries = entries[startingIndex:]
+	} else {
+		entries = nil
+	}
+	if requestedCount != 0 && int64(requestedCount) < int64(len(entries)) {
+		entries = entries[:requestedCount]
+	}
+	var objs []interface{}
+	for _, e := range entries {
+		obj, err := me.cdsObjectToUpnpavObject(e.obj, e.fi, host, userAgent, sink)
+		if err != nil {
+			me.Logger.Printf("error with %s: %s", e.obj.FilePath(), err)
+			continue
+		}
+		if parentID != "" {
+			switch o := obj.(type) {
+			case upnpav.Item:
+				o.ParentID = parentID
+				obj = o
+			case upnpav.Container:
+				o.ParentID = parentID
+				obj = o
+			}
+		}
+		if obj != nil {
+			objs = append(objs, obj)
+		}
+	}
+	buf, err := xml.Marshal(objs)
+	if err != nil {
+		return
+	}
+	return didl_lite(string(buf)), uint32(len(objs)), nil
+}
+
+// Browses one of Microsoft's well-known containers.
+func (me *contentDirectoryService) browseView(r *http.Request, browse browse) (ret browseResponse, err error) {
+	ret.UpdateID = me.updateID()
+	entries := me.viewEntries(browse.ObjectID)
+	switch browse.BrowseFlag {
+	case "BrowseDirectChildren":
+		ret.TotalMatches = uint32(len(entries))
+		ret.Result, ret.NumberReturned, err = me.entriesResult(
+			entries, browse.StartingIndex, browse.RequestedCount, browse.ObjectID,
+			r.Host, r.UserAgent(), me.rendererSink(r))
+		return
+	case "BrowseMetadata":
+		var buf []byte
+		buf, err = xml.Marshal(upnpav.Container{
+			Object: upnpav.Object{
+				ID:         browse.ObjectID,
+				ParentID:   "0",
+				Restricted: 1,
+				Class:      "object.container.storageFolder",
+				Title:      msViews[browse.ObjectID].title,
+			},
+			ChildCount: len(entries),
+		})
+		if err != nil {
+			return
+		}
+		ret.Result = didl_lite(string(buf))
+		ret.NumberReturned = 1
+		ret.TotalMatches = 1
+		return
+	default:
+		err = upnp.Errorf(
+			upnp.ArgumentValueInvalidErrorCode,
+			"unhandled browse flag: %v",
+			browse.BrowseFlag,
+		)
+		return
+	}
+}
+
+type search struct {
+	ContainerID    string
+	SearchCriteria string
+	Filter         string
+	StartingIndex  uint32
+	RequestedCount uint32
+	SortCriteria   string
+}
+
+// Searches the container, which can be one of Microsoft's well-known containers, recursively.
+func (me *contentDirectoryService) search(r *http.Request, search search) (ret browseResponse, err error) {
+	criteria, err := parseSearchCriteria(search.SearchCriteria)
+	if err != nil {
+		err = upnp.Errorf(upnpav.InvalidSearchCriteriaErrorCode, "%s", err)
+		return
+	}
+	var (
+		entries  []searchEntry
+		parentID string
+	)
+	if _, ok := msViews[search.ContainerID]; ok {
+		entries = me.viewEntries(search.ContainerID)
+		parentID = search.ContainerID
+	} else {
+		var obj object
+		obj, err = me.objectFromID(search.ContainerID)
+		if err != nil {
+			err = upnp.Errorf(upnpav.NoSuchContainerErrorCode, err.Error())
+			return
+		}
+		var fi os.FileInfo
+		fi, err = os.Stat(obj.FilePath())
+		if err != nil || !fi.IsDir() {
+			err = upnp.Errorf(upnpav.NoSuchContainerErrorCode, "no such container: %s", search.ContainerID)
+			return
+		}
+		entries = me.walkEntries(obj, "")
+	}
+	var matches []searchEntry
+	for _, e := range entries {
+		if criteria(e.props) {
+			matches = append(matches, e)
+		}
+	}
+	ret.UpdateID = me.updateID()
+	ret.TotalMatches = uint32(len(matches))
+	ret.Result, ret.NumberReturned, err = me.entriesResult(
+		matches, search.StartingIndex, search.RequestedCount, parentID,
+		r.Host, r.UserAgent(), me.rendererSink(r))
+	return
+}
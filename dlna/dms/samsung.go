@@ -0,0 +1,88 @@
+package dms
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Samsung's BASICVIEW feature, which points their TVs at flat lists of each kind of media, instead
+// of having them browse the folders. These are Microsoft's well-known views.
+const samsungFeatureList = `<?xml version="1.0" encoding="UTF-8"?>
+<Features xmlns="urn:schemas-upnp-org:av:avs" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="urn:schemas-upnp-org:av:avs http://www.upnp.org/schemas/av/avs.xsd">
+<Feature name="samsung.com_BASICVIEW" version="1">
+<container id="1" type="object.item.audioItem"/>
+<container id="2" type="object.item.videoItem"/>
+<container id="3" type="object.item.imageItem"/>
+</Feature>
+</Features>`
+
+// Where Samsung renderers were last told to resume items from, through X_SetBookmark. They aren't
+// kept across restarts.
+type bookmarks struct {
+	mu sync.Mutex
+	// Seconds into the item, by object path.
+	m map[string]uint32
+}
+
+func (me *bookmarks) set(path string, pos uint32) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[string]uint32)
+	}
+	if pos == 0 {
+		delete(me.m, path)
+		return
+	}
+	me.m[path] = pos
+}
+
+func (me *bookmarks) get(path string) uint32 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.m[path]
+}
+
+type setBookmark struct {
+	CategoryType uint32
+	RID          uint32
+	ObjectID     string
+	PosSecond    uint32
+}
+
+// Returns the sec:dcmInfo of an item, which Samsung renderers group by, and resume videos from.
+func (me *contentDirectoryService) dcmInfo(o object, fi os.FileInfo, video bool) string {
+	modTime := fi.ModTime()
+	ret := fmt.Sprintf("CREATIONDATE=%d,YEAR=%d", modTime.Unix(), modTime.Year())
+	if video {
+		if bm := me.bookmarks.get(o.Path); bm != 0 {
+			ret += ",BM=" + strconv.FormatUint(uint64(bm), 10)
+		}
+	}
+	return ret
+}
+
+// Answers Samsung's getMediaInfo.sec request header on media requests with the duration, which
+// their renderers use to seek.
+func (me *Server) setMediaInfoHeader(w http.ResponseWriter, r *http.Request, filePath string) {
+	if r.Header.Get("getMediaInfo.sec") != "1" || me.NoProbe {
+		return
+	}
+	if mimeType, err := MimeTypeByPath(filePath); err != nil || !(mimeType.IsVideo() || mimeType.IsAudio()) {
+		return
+	}
+	info, err := me.ffmpegProbe(filePath)
+	if err != nil || info == nil {
+		return
+	}
+	d, err := info.Duration()
+	if err != nil {
+		return
+	}
+	// Set directly, as Samsung renderers don't recognize the canonicalized form.
+	w.Header()["MediaInfo.sec"] = []string{fmt.Sprintf("SEC_Duration=%d;", d/time.Millisecond)}
+}
@@ -0,0 +1,105 @@
+package dms
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/controlpoint"
+)
+
+func TestSamsungFeatureList(t *testing.T) {
+	var features struct {
+		Feature struct {
+			Name      string `xml:"name,attr"`
+			Container []struct {
+				ID   string `xml:"id,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"container"`
+		}
+	}
+	if err := xml.Unmarshal([]byte(samsungFeatureList), &features); err != nil {
+		t.Fatal(err)
+	}
+	if features.Feature.Name != "samsung.com_BASICVIEW" || len(features.Feature.Container) != 3 {
+		t.Fatalf("%+v", features)
+	}
+	for _, c := range features.Feature.Container {
+		if view, ok := msViews[c.ID]; !ok || c.Type != "object.item."+view.kind+"Item" {
+			t.Errorf("container %q isn't a view of %s", c.ID, c.Type)
+		}
+	}
+}
+
+type mapCache map[interface{}]interface{}
+
+func (me mapCache) Set(key, value interface{}) { me[key] = value }
+
+func (me mapCache) Get(key interface{}) (value interface{}, ok bool) {
+	value, ok = me[key]
+	return
+}
+
+func TestSamsungMediaInfoHeader(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(filePath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := mapCache{
+		ffmpegInfoCacheKey{filePath, fi.ModTime().UnixNano()}: &ffprobe.Info{
+			Format: map[string]interface{}{"duration": "90.5"},
+		},
+	}
+	srv := &Server{FFProbeCache: cache}
+	r := httptest.NewRequest("GET", resPath, nil)
+	w := httptest.NewRecorder()
+	srv.setMediaInfoHeader(w, r, filePath)
+	if h := w.Header()["MediaInfo.sec"]; h != nil {
+		t.Fatalf("answered without being asked: %q", h)
+	}
+	r.Header.Set("getMediaInfo.sec", "1")
+	srv.setMediaInfoHeader(w, r, filePath)
+	if h := w.Header()["MediaInfo.sec"]; len(h) != 1 || h[0] != "SEC_Duration=90500;" {
+		t.Fatalf("MediaInfo.sec %q", h)
+	}
+}
+
+func TestSamsungBookmark(t *testing.T) {
+	ctx := context.Background()
+	srv, location := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(srv.RootObjectPath, "Music", "clip.mp4"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := controlpoint.FetchDevice(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cds := d.Service("urn:schemas-upnp-org:service:ContentDirectory:1")
+	const id = "%2FMusic%2Fclip.mp4"
+	if _, err := controlpoint.Invoke[setBookmark, struct{}](ctx, cds, "X_SetBookmark", setBookmark{
+		ObjectID:  id,
+		PosSecond: 90,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := controlpoint.Invoke[browse, browseResponse](ctx, cds, "Browse", browse{
+		ObjectID:   id,
+		BrowseFlag: "BrowseMetadata",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ret.Result, "<sec:dcmInfo>CREATIONDATE=") || !strings.Contains(ret.Result, ",BM=90</sec:dcmInfo>") {
+		t.Fatalf("no bookmark in %s", ret.Result)
+	}
+}
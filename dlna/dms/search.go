@@ -0,0 +1,204 @@
+package dms
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// The properties objects can be searched by.
+const searchCapabilities = "@id,@parentID,upnp:class,dc:title"
+
+// Decides whether an object with the properties matches a search. Properties missing from the map
+// don't exist.
+type searchCriteria func(props map[string]string) bool
+
+// Parses the SearchCriteria of a ContentDirectory Search, as given in ContentDirectory:1 2.5.5.
+func parseSearchCriteria(s string) (searchCriteria, error) {
+	if strings.TrimSpace(s) == "*" || strings.TrimSpace(s) == "" {
+		return func(map[string]string) bool { return true }, nil
+	}
+	toks, err := searchTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	p := searchParser{toks: toks}
+	ret, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	return ret, nil
+}
+
+type searchToken struct {
+	text string
+	// Whether the token was a quoted string.
+	quoted bool
+}
+
+func searchTokens(s string) (ret []searchToken, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(' || c == ')':
+			ret = append(ret, searchToken{text: string(c)})
+			i++
+		case c == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			i++
+			ret = append(ret, searchToken{text: b.String(), quoted: true})
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && s[j] != '(' && s[j] != ')' && s[j] != '"' {
+				j++
+			}
+			ret = append(ret, searchToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return
+}
+
+type searchParser struct {
+	toks []searchToken
+	pos  int
+}
+
+func (p *searchParser) next() (tok searchToken, ok bool) {
+	if p.pos == len(p.toks) {
+		return
+	}
+	tok = p.toks[p.pos]
+	p.pos++
+	return tok, true
+}
+
+// Whether the next token is the unquoted keyword, which is consumed if so.
+func (p *searchParser) accept(keyword string) bool {
+	if p.pos < len(p.toks) && !p.toks[p.pos].quoted && strings.EqualFold(p.toks[p.pos].text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *searchParser) or() (searchCriteria, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = func(l, r searchCriteria) searchCriteria {
+			return func(props map[string]string) bool { return l(props) || r(props) }
+		}(l, r)
+	}
+	return l, nil
+}
+
+func (p *searchParser) and() (searchCriteria, error) {
+	l, err := p.rel()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		r, err := p.rel()
+		if err != nil {
+			return nil, err
+		}
+		l = func(l, r searchCriteria) searchCriteria {
+			return func(props map[string]string) bool { return l(props) && r(props) }
+		}(l, r)
+	}
+	return l, nil
+}
+
+func (p *searchParser) rel() (searchCriteria, error) {
+	if p.accept("(") {
+		ret, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return ret, nil
+	}
+	prop, ok := p.next()
+	if !ok || prop.quoted {
+		return nil, fmt.Errorf("expected a property")
+	}
+	op, ok := p.next()
+	if !ok || op.quoted {
+		return nil, fmt.Errorf("expected an operator after %s", prop.text)
+	}
+	val, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("expected a value after %s %s", prop.text, op.text)
+	}
+	if strings.EqualFold(op.text, "exists") {
+		if val.quoted || (val.text != "true" && val.text != "false") {
+			return nil, fmt.Errorf("exists takes true or false")
+		}
+		want := val.text == "true"
+		return func(props map[string]string) bool {
+			_, ok := props[prop.text]
+			return ok == want
+		}, nil
+	}
+	if !val.quoted {
+		return nil, fmt.Errorf("expected a quoted value after %s %s", prop.text, op.text)
+	}
+	cmp, err := searchOp(op.text, strings.ToLower(val.text))
+	if err != nil {
+		return nil, err
+	}
+	return func(props map[string]string) bool {
+		have, ok := props[prop.text]
+		return ok && cmp(strings.ToLower(have))
+	}, nil
+}
+
+// Returns the comparison of a property's value with val by the operator. Strings are compared
+// without regard to case.
+func searchOp(op, val string) (func(have string) bool, error) {
+	switch strings.ToLower(op) {
+	case "=":
+		return func(have string) bool { return have == val }, nil
+	case "!=":
+		return func(have string) bool { return have != val }, nil
+	case "<":
+		return func(have string) bool { return have < val }, nil
+	case "<=":
+		return func(have string) bool { return have <= val }, nil
+	case ">":
+		return func(have string) bool { return have > val }, nil
+	case ">=":
+		return func(have string) bool { return have >= val }, nil
+	case "contains":
+		return func(have string) bool { return strings.Contains(have, val) }, nil
+	case "doesnotcontain":
+		return func(have string) bool { return !strings.Contains(have, val) }, nil
+	case "derivedfrom":
+		return func(have string) bool { return have == val || strings.HasPrefix(have, val+".") }, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
@@ -0,0 +1,114 @@
+package dms
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchCriteria(t *testing.T) {
+	song := map[string]string{
+		"@id":        "%2FMusic%2Fsong.mp3",
+		"@parentID":  "%2FMusic",
+		"upnp:class": "object.item.audioItem",
+		"dc:title":   "song.mp3",
+	}
+	for criteria, want := range map[string]bool{
+		"*":                                    true,
+		`upnp:class derivedfrom "object.item"`: true,
+		`upnp:class derivedfrom "object.item.videoItem"`:                    false,
+		`upnp:class = "object.item.audioItem" and dc:title contains "SONG"`: true,
+		`dc:title doesNotContain "song" or @parentID = "%2FMusic"`:          true,
+		`(dc:title = "a" or dc:title = "b") and @id exists true`:            false,
+		`upnp:artist exists false`:                                          true,
+		`upnp:artist = "x"`:                                                 false,
+		`dc:title < "t"`:                                                    true,
+		`dc:title = "say \"hi\""`:                                           false,
+	} {
+		c, err := parseSearchCriteria(criteria)
+		if err != nil {
+			t.Errorf("%s: %v", criteria, err)
+			continue
+		}
+		if got := c(song); got != want {
+			t.Errorf("%s matched %v", criteria, got)
+		}
+	}
+	for _, criteria := range []string{
+		`dc:title`,
+		`dc:title = song`,
+		`dc:title like "song"`,
+		`(dc:title = "song"`,
+		`dc:title = "song`,
+		`@id exists "true"`,
+		`dc:title = "a" and`,
+	} {
+		if _, err := parseSearchCriteria(criteria); err == nil {
+			t.Errorf("%s parsed", criteria)
+		}
+	}
+}
+
+func TestMicrosoftViews(t *testing.T) {
+	srv, _ := newTestServer(t)
+	cds := srv.services["ContentDirectory"].(*contentDirectoryService)
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	ret, err := cds.browse(r, browse{ObjectID: "4", BrowseFlag: "BrowseDirectChildren"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.TotalMatches != 1 || !strings.Contains(ret.Result, `parentID="4"`) || !strings.Contains(ret.Result, "<dc:title>song.mp3</dc:title>") {
+		t.Fatalf("all music: %+v", ret)
+	}
+	ret, err = cds.browse(r, browse{ObjectID: "8", BrowseFlag: "BrowseMetadata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ret.Result, `childCount="0"`) || !strings.Contains(ret.Result, "<dc:title>All Video</dc:title>") {
+		t.Fatalf("all video metadata: %+v", ret)
+	}
+	ret, err = cds.search(r, search{ContainerID: "7", SearchCriteria: `upnp:class derivedfrom "object.item.audioItem"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.TotalMatches != 1 || ret.NumberReturned != 1 {
+		t.Fatalf("searching albums: %+v", ret)
+	}
+	ret, err = cds.search(r, search{ContainerID: "0", SearchCriteria: `dc:title contains "music"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.TotalMatches != 1 || !strings.Contains(ret.Result, "object.container.storageFolder") {
+		t.Fatalf("searching the root: %+v", ret)
+	}
+	if _, err := cds.search(r, search{ContainerID: "0", SearchCriteria: "dc:title ="}); err == nil {
+		t.Fatal("bad criteria accepted")
+	}
+}
+
+func TestWalkEntries(t *testing.T) {
+	srv, _ := newTestServer(t)
+	cds := srv.services["ContentDirectory"].(*contentDirectoryService)
+	music := filepath.Join(srv.RootObjectPath, "Music")
+	for _, name := range []string{"root", "again"} {
+		if err := os.Symlink(srv.RootObjectPath, filepath.Join(music, name)); err != nil {
+			t.Skip(err)
+		}
+	}
+	root := object{"/", srv.RootObjectPath}
+	if entries := cds.walkEntries(root, "audio"); len(entries) != 1 {
+		t.Fatalf("walking with symlink loops: %v", entries)
+	}
+	if err := os.WriteFile(filepath.Join(music, "other.mp3"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if entries := cds.walkEntries(root, "audio"); len(entries) != 1 {
+		t.Fatal("walk wasn't reused")
+	}
+	cds.changed()
+	if entries := cds.walkEntries(root, "audio"); len(entries) != 2 {
+		t.Fatalf("walk was reused after the tree changed: %v", entries)
+	}
+}
@@ -0,0 +1,100 @@
+package dms
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// Returns a valid value for an argument of the state variable.
+func sampleArgValue(sv *upnp.StateVarDef) string {
+	if len(sv.AllowedValues) != 0 {
+		return sv.AllowedValues[0]
+	}
+	return "0"
+}
+
+// Every advertised action must be handled, and return only its advertised
+// out arguments. Errors particular to the service, such as for the unknown
+// connection ID 0, mean it was handled.
+func TestServicesHandleDefinedActions(t *testing.T) {
+	srv := &Server{
+		RootObjectPath: t.TempDir(),
+		NoProbe:        true,
+		Logger:         log.Default,
+	}
+	if err := srv.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range services {
+		urn, err := upnp.ParseServiceType(s.ServiceType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range s.Def.Actions {
+			var args strings.Builder
+			for _, arg := range a.In {
+				sv, _ := s.Def.StateVar(arg.StateVar)
+				fmt.Fprintf(&args, "<%s>%s</%s>", arg.Name, sampleArgValue(sv), arg.Name)
+			}
+			argsXML := fmt.Sprintf(`<u:%s xmlns:u="%s">%s</u:%s>`, a.Name, s.ServiceType, args.String(), a.Name)
+			if err := s.Def.CheckAction(a.Name, []byte(argsXML)); err != nil {
+				t.Errorf("%s: %s: %v", urn.Type, a.Name, err)
+				continue
+			}
+			r := httptest.NewRequest("POST", serviceControlURL, nil)
+			out, err := srv.services[urn.Type].Handle(a.Name, []byte(argsXML), r)
+			if upnpErr, ok := err.(*upnp.Error); ok && upnpErr.Code >= 700 {
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: %s: %v", urn.Type, a.Name, err)
+				continue
+			}
+		outArgs:
+			for _, arg := range out {
+				for _, def := range a.Out {
+					if def.Name == arg[0] {
+						continue outArgs
+					}
+				}
+				t.Errorf("%s: %s returned undefined argument %q", urn.Type, a.Name, arg[0])
+			}
+		}
+	}
+}
+
+func TestCheckActionRejectsUndefined(t *testing.T) {
+	err := contentDirectoryServiceDef.CheckAction("ExportResource", nil)
+	if upnp.ConvertError(err).Code != upnp.InvalidActionErrorCode {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBrowseInvalidArgs(t *testing.T) {
+	srv := &Server{RootObjectPath: t.TempDir(), NoProbe: true, Logger: log.Default}
+	if err := srv.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	for args, code := range map[string]uint{
+		`<ObjectID>0</ObjectID><RequestedCount>lots</RequestedCount>`:     upnp.ArgumentValueInvalidErrorCode,
+		`<ObjectID>0</ObjectID><BrowseFlag>BrowseEverything</BrowseFlag>`: upnp.ArgumentValueInvalidErrorCode,
+	} {
+		argsXML := `<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">` + args + `</u:Browse>`
+		_, err := srv.services["ContentDirectory"].Handle("Browse", []byte(argsXML), r)
+		if err == nil || upnp.ConvertError(err).Code != code {
+			t.Errorf("%s: got %v, want code %d", args, err, code)
+		}
+	}
+	// Arguments Browse doesn't define are ignored.
+	argsXML := `<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"><ObjectID>0</ObjectID><BrowseFlag>BrowseDirectChildren</BrowseFlag><Recursive>1</Recursive></u:Browse>`
+	if _, err := srv.services["ContentDirectory"].Handle("Browse", []byte(argsXML), r); err != nil {
+		t.Errorf("unexpected argument wasn't ignored: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package dms
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/controlpoint"
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	connectionManagerServiceType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	// How often the renderers on the network are asked what they can play.
+	rendererSinksInterval = 15 * time.Minute
+	// How long searching for renderers and asking them can take.
+	rendererSinksTimeout = 30 * time.Second
+)
+
+// What renderers on the network can play, from their ConnectionManager Sink.
+type rendererSinks struct {
+	mu sync.Mutex
+	// By renderer IP.
+	m map[string][]dlna.ProtocolInfo
+	// The IPs of clients not known to be renderers that have been looked up since the last
+	// refresh.
+	lookedUp map[string]bool
+}
+
+func (me *rendererSinks) get(ip string) (sink []dlna.ProtocolInfo, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	sink, ok = me.m[ip]
+	return
+}
+
+func (me *rendererSinks) set(ip string, sink []dlna.ProtocolInfo) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[string][]dlna.ProtocolInfo)
+	}
+	me.m[ip] = sink
+}
+
+// Replaces all the sinks with those found by a refresh, so renderers that have gone, or changed
+// IP, are forgotten.
+func (me *rendererSinks) replace(m map[string][]dlna.ProtocolInfo) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.m = m
+	me.lookedUp = nil
+}
+
+// Returns whether the client should be looked up, which is once between refreshes.
+func (me *rendererSinks) lookUp(ip string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.m[ip]; ok || me.lookedUp[ip] {
+		return false
+	}
+	if me.lookedUp == nil {
+		me.lookedUp = make(map[string]bool)
+	}
+	me.lookedUp[ip] = true
+	return true
+}
+
+// Returns the IP in the host, without any zone, so that addresses compare equal.
+func hostIP(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host, _, _ = strings.Cut(host, "%")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// Returns what the renderer at the request's address said it can play, or nil if it's not known.
+// Clients that aren't known are looked up in the background, for their next requests.
+func (me *Server) rendererSink(r *http.Request) []dlna.ProtocolInfo {
+	ip := hostIP(r.RemoteAddr)
+	sink, ok := me.sinks.get(ip)
+	if !ok && me.sinks.lookUp(ip) {
+		go me.lookUpRendererSink(ip)
+	}
+	return sink
+}
+
+// Returns the IP of the device, from its description URL.
+func deviceIP(d *controlpoint.Device) (string, error) {
+	u, err := url.Parse(d.Location)
+	if err != nil {
+		return "", err
+	}
+	return hostIP(u.Host), nil
+}
+
+// Asks the renderer what it can play.
+func getRendererSink(ctx context.Context, d *controlpoint.Device) ([]dlna.ProtocolInfo, error) {
+	cm := d.Service(connectionManagerServiceType)
+	if cm == nil {
+		return nil, nil
+	}
+	ret, err := controlpoint.Invoke[struct{}, struct{ Sink string }](ctx, cm, "GetProtocolInfo", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return dlna.ParseProtocolInfoList(ret.Sink), nil
+}
+
+// Asks the renderer what it can play, and remembers it for when it browses.
+func (me *Server) fetchRendererSink(ctx context.Context, d *controlpoint.Device) ([]dlna.ProtocolInfo, error) {
+	sink, err := getRendererSink(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := deviceIP(d); err == nil {
+		me.sinks.set(ip, sink)
+	}
+	return sink, nil
+}
+
+// Returns a context for searching for renderers and asking them, that ends if the server is
+// closed.
+func (me *Server) rendererSinksContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), rendererSinksTimeout)
+	go func() {
+		select {
+		case <-me.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Searches for renderers at the IP, and asks them what they can play.
+func (me *Server) lookUpRendererSink(ip string) {
+	ctx, cancel := me.rendererSinksContext()
+	defer cancel()
+	renderers, err := me.discoverRenderers(ctx)
+	if err != nil {
+		me.Logger.Levelf(log.Debug, "searching for renderer at %s: %v", ip, err)
+		return
+	}
+	for _, d := range renderers {
+		if dip, err := deviceIP(d); err != nil || dip != ip {
+			continue
+		}
+		if _, err := me.fetchRendererSink(ctx, d); err != nil {
+			me.Logger.Levelf(log.Debug, "getting protocol info of %q: %v", d.Desc.FriendlyName, err)
+		}
+	}
+}
+
+func (me *Server) refreshRendererSinks() {
+	ctx, cancel := me.rendererSinksContext()
+	defer cancel()
+	renderers, err := me.discoverRenderers(ctx)
+	if err != nil {
+		me.Logger.Levelf(log.Warning, "searching for renderers: %v", err)
+		return
+	}
+	m := make(map[string][]dlna.ProtocolInfo)
+	for _, d := range renderers {
+		ip, err := deviceIP(d)
+		if err != nil {
+			continue
+		}
+		sink, err := getRendererSink(ctx, d)
+		if err != nil {
+			me.Logger.Levelf(log.Debug, "getting protocol info of %q: %v", d.Desc.FriendlyName, err)
+			// Keep what it said before, rather than forget a renderer that's slow to answer.
+			var ok bool
+			if sink, ok = me.sinks.get(ip); !ok {
+				continue
+			}
+		}
+		m[ip] = sink
+	}
+	me.sinks.replace(m)
+}
+
+// Keeps what the renderers on the network can play up to date, until the server is closed.
+func (me *Server) watchRendererSinks() {
+	ticker := time.NewTicker(rendererSinksInterval)
+	defer ticker.Stop()
+	for {
+		me.refreshRendererSinks()
+		select {
+		case <-me.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Moves the first resource of the kind, such as "video", that the sink accepts to the front, as
+// renderers tend to play the first resource they're given. Nothing changes if the sink is unknown
+// or accepts none of them.
+func preferSinkResource(res []upnpav.Resource, kind string, sink []dlna.ProtocolInfo) {
+	for i, r := range res {
+		pi, err := dlna.ParseProtocolInfo(r.ProtocolInfo)
+		if err != nil || !strings.HasPrefix(pi.ContentFormat, kind+"/") {
+			continue
+		}
+		for _, s := range sink {
+			if s.Accepts(pi) {
+				copy(res[1:i+1], res[:i])
+				res[0] = r
+				return
+			}
+		}
+	}
+}
+
+// Returns the Source list of the ConnectionManager, from the resources the server gives items.
+func (me *Server) sourceProtocolInfo() string {
+	var ret []string
+	seen := make(map[string]bool)
+	add := func(mimeType, profile string) {
+		info := "*"
+		if profile != "" {
+			info = "DLNA.ORG_PN=" + profile
+		}
+		pi := dlna.ProtocolInfo{
+			Protocol:       "http-get",
+			Network:        "*",
+			ContentFormat:  mimeType,
+			AdditionalInfo: info,
+		}.String()
+		if !seen[pi] {
+			seen[pi] = true
+			ret = append(ret, pi)
+		}
+	}
+	if !me.NoTranscode {
+		var specs []transcodeSpec
+		for _, m := range []map[string]transcodeSpec{transcodes, audioTranscodes} {
+			for _, spec := range m {
+				specs = append(specs, spec)
+			}
+		}
+		sort.Slice(specs, func(i, j int) bool {
+			if specs[i].mimeType != specs[j].mimeType {
+				return specs[i].mimeType < specs[j].mimeType
+			}
+			return specs[i].DLNAProfileName < specs[j].DLNAProfileName
+		})
+		for _, spec := range specs {
+			add(spec.mimeType, spec.DLNAProfileName)
+		}
+		add("audio/L16", lpcmTranscodeSpec(44100, 2).DLNAProfileName)
+		add("application/vnd.apple.mpegurl", "")
+	}
+	add("image/jpeg", "JPEG_TN")
+	var subs []string
+	for _, mt := range subtitleMimeTypes {
+		subs = append(subs, mt)
+	}
+	sort.Strings(subs)
+	for _, mt := range subs {
+		add(mt, "")
+	}
+	// Files are served as they are, whatever their type.
+	for _, t := range []string{"video", "audio", "image"} {
+		add(t+"/*", "")
+	}
+	return strings.Join(ret, ",")
+}
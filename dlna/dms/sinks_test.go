@@ -0,0 +1,94 @@
+package dms
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestPreferSinkResource(t *testing.T) {
+	res := func() []upnpav.Resource {
+		return []upnpav.Resource{
+			{URL: "raw", ProtocolInfo: "http-get:*:video/x-matroska:DLNA.ORG_OP=01"},
+			{URL: "t", ProtocolInfo: "http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_PAL;DLNA.ORG_OP=10"},
+			{URL: "web", ProtocolInfo: "http-get:*:video/mp4:DLNA.ORG_OP=10"},
+			{URL: "mp3", ProtocolInfo: "http-get:*:audio/mpeg:DLNA.ORG_PN=MP3"},
+			{URL: "thumb", ProtocolInfo: "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN"},
+		}
+	}
+	order := func(rs []upnpav.Resource) (ret []string) {
+		for _, r := range rs {
+			ret = append(ret, r.URL)
+		}
+		return
+	}
+	for _, tc := range []struct {
+		sink string
+		want string
+	}{
+		{"", "raw t web mp3 thumb"},
+		{"http-get:*:video/mp4:*,http-get:*:image/jpeg:*", "web raw t mp3 thumb"},
+		{"http-get:*:video/*:*", "raw t web mp3 thumb"},
+		// Audio only and image resources aren't preferred for videos.
+		{"http-get:*:audio/mpeg:*,http-get:*:image/jpeg:*", "raw t web mp3 thumb"},
+	} {
+		rs := res()
+		preferSinkResource(rs, "video", dlna.ParseProtocolInfoList(tc.sink))
+		if got := strings.Join(order(rs), " "); got != tc.want {
+			t.Errorf("sink %q: got %s, want %s", tc.sink, got, tc.want)
+		}
+	}
+}
+
+func TestSourceProtocolInfo(t *testing.T) {
+	source := dlna.ParseProtocolInfoList((&Server{}).sourceProtocolInfo())
+	accepted := func(pi string) bool {
+		res, err := dlna.ParseProtocolInfo(pi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range source {
+			if s.Accepts(res) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, pi := range []string{
+		"http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_PAL",
+		"http-get:*:audio/mpeg:DLNA.ORG_PN=MP3",
+		"http-get:*:text/srt:*",
+		"http-get:*:video/x-matroska:*",
+	} {
+		if !accepted(pi) {
+			t.Errorf("%s not in source", pi)
+		}
+	}
+	if accepted("http-get:*:application/pdf:*") {
+		t.Error("non-media in source")
+	}
+}
+
+func TestRendererSinks(t *testing.T) {
+	var s rendererSinks
+	sink := dlna.ParseProtocolInfoList("http-get:*:video/mp4:*")
+	s.set("192.0.2.1", sink)
+	if s.lookUp("192.0.2.1") {
+		t.Error("looking up a known renderer")
+	}
+	if !s.lookUp("192.0.2.2") || s.lookUp("192.0.2.2") {
+		t.Error("unknown clients should be looked up once")
+	}
+	s.replace(map[string][]dlna.ProtocolInfo{"192.0.2.3": sink})
+	if _, ok := s.get("192.0.2.1"); ok {
+		t.Error("renderer kept after a refresh that didn't find it")
+	}
+	if got, ok := s.get("192.0.2.3"); !ok || len(got) != 1 {
+		t.Errorf("refreshed sink %v", got)
+	}
+	if !s.lookUp("192.0.2.2") {
+		t.Error("clients should be looked up again after a refresh")
+	}
+}
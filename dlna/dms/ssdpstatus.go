@@ -0,0 +1,108 @@
+package dms
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Failed SSDP servers are restarted after delays doubling from the first up
+// to the second.
+const (
+	minSSDPRestartDelay = time.Second
+	maxSSDPRestartDelay = time.Minute
+)
+
+// SSDPStatus describes the SSDP server for an interface and address family.
+type SSDPStatus struct {
+	Interface string
+	IPv6      bool
+	Running   bool
+	Started   time.Time
+	// The number of times it's been restarted after failing.
+	Restarts int
+	// The number of errors, including those it recovered from.
+	Errors        int
+	LastError     string     `json:",omitempty"`
+	LastErrorTime *time.Time `json:",omitempty"`
+}
+
+type ssdpStatuses struct {
+	mu sync.Mutex
+	m  map[ssdpKey]*SSDPStatus
+}
+
+// Returns the status for k, creating it if necessary. The lock must be held.
+func (me *ssdpStatuses) get(k ssdpKey) *SSDPStatus {
+	if me.m == nil {
+		me.m = make(map[ssdpKey]*SSDPStatus)
+	}
+	s, ok := me.m[k]
+	if !ok {
+		s = &SSDPStatus{Interface: k.name, IPv6: k.ipv6}
+		me.m[k] = s
+	}
+	return s
+}
+
+func (me *ssdpStatuses) started(k ssdpKey, restart bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.get(k)
+	s.Running = true
+	s.Started = time.Now()
+	if restart {
+		s.Restarts++
+	}
+}
+
+func (me *ssdpStatuses) stopped(k ssdpKey, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s := me.get(k)
+	s.Running = false
+	if err != nil {
+		me.recordError(s, err)
+	}
+}
+
+func (me *ssdpStatuses) error(k ssdpKey, err error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.recordError(me.get(k), err)
+}
+
+func (me *ssdpStatuses) recordError(s *SSDPStatus, err error) {
+	s.Errors++
+	s.LastError = err.Error()
+	now := time.Now()
+	s.LastErrorTime = &now
+}
+
+// Forgets the servers of interfaces that are no longer used.
+func (me *ssdpStatuses) retain(keys map[ssdpKey]net.Interface) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for k := range me.m {
+		if _, ok := keys[k]; !ok {
+			delete(me.m, k)
+		}
+	}
+}
+
+// SSDPStatus returns the state of the SSDP servers, by interface.
+func (me *Server) SSDPStatus() (ret []SSDPStatus) {
+	me.ssdpStatus.mu.Lock()
+	for _, s := range me.ssdpStatus.m {
+		ret = append(ret, *s)
+	}
+	me.ssdpStatus.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Interface != ret[j].Interface {
+			return ret[i].Interface < ret[j].Interface
+		}
+		return !ret[i].IPv6 && ret[j].IPv6
+	})
+	return
+}
@@ -0,0 +1,29 @@
+package dms
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSSDPStatus(t *testing.T) {
+	var srv Server
+	eth0 := ssdpKey{2, "eth0", false}
+	eth0v6 := ssdpKey{2, "eth0", true}
+	srv.ssdpStatus.started(eth0v6, false)
+	srv.ssdpStatus.started(eth0, false)
+	srv.ssdpStatus.error(eth0, errors.New("read failed"))
+	srv.ssdpStatus.stopped(eth0, errors.New("socket closed"))
+	srv.ssdpStatus.started(eth0, true)
+	st := srv.SSDPStatus()
+	if len(st) != 2 || st[0].IPv6 || !st[1].IPv6 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if !st[0].Running || st[0].Restarts != 1 || st[0].Errors != 2 || st[0].LastError != "socket closed" {
+		t.Fatalf("unexpected status: %+v", st[0])
+	}
+	srv.ssdpStatus.retain(map[ssdpKey]net.Interface{eth0v6: {}})
+	if st := srv.SSDPStatus(); len(st) != 1 || !st[0].IPv6 {
+		t.Fatalf("unexpected status after retain: %+v", st)
+	}
+}
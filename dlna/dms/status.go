@@ -0,0 +1,182 @@
+package dms
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+const (
+	statusPath = "/status"
+	// How long a transcode has to run slower than real time before it's
+	// warned about.
+	slowTranscodeWarnAfter = 10 * time.Second
+)
+
+// TranscodeStatus describes a running transcode.
+type TranscodeStatus struct {
+	ID   int64
+	Path string
+	// The transcode key, such as "t" or "hls".
+	Transcode string
+	Client    string
+	Started   time.Time
+	transcode.Progress
+}
+
+// Status is the server state exposed on statusPath.
+type Status struct {
+	Transcodes     []TranscodeStatus
+	SSDP           []SSDPStatus
+	MediaReceivers []MediaReceiver
+}
+
+type transcodeSession struct {
+	logger log.Logger
+
+	mu     sync.Mutex
+	status TranscodeStatus
+	// Whether the output is piped to the client. Piped transcodes are slowed
+	// by clients that read at playback rate, so they're only slow if we're
+	// waiting on them.
+	piped        bool
+	readWait     time.Duration
+	lastReadWait time.Duration
+	lastUpdate   time.Time
+	slowSince    time.Time
+	warned       bool
+}
+
+type transcodeSessions struct {
+	mu     sync.Mutex
+	nextID int64
+	m      map[int64]*transcodeSession
+}
+
+func (me *Server) startTranscodeSession(path, key, client string, piped bool) *transcodeSession {
+	me.transcodeSessions.mu.Lock()
+	defer me.transcodeSessions.mu.Unlock()
+	if me.transcodeSessions.m == nil {
+		me.transcodeSessions.m = make(map[int64]*transcodeSession)
+	}
+	me.transcodeSessions.nextID++
+	id := me.transcodeSessions.nextID
+	s := &transcodeSession{
+		logger: me.Logger.WithNames("transcode"),
+		status: TranscodeStatus{
+			ID:        id,
+			Path:      path,
+			Transcode: key,
+			Client:    client,
+			Started:   time.Now(),
+		},
+		piped:      piped,
+		lastUpdate: time.Now(),
+	}
+	me.transcodeSessions.m[id] = s
+	return s
+}
+
+func (me *Server) endTranscodeSession(s *transcodeSession) {
+	me.transcodeSessions.mu.Lock()
+	delete(me.transcodeSessions.m, s.status.ID)
+	me.transcodeSessions.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	s.logger.Levelf(log.Info,
+		"transcode %d (%s) of %q for %s ended at %s after %s: speed %.2fx, %d dropped frames",
+		st.ID, st.Transcode, st.Path, st.Client, st.Position, time.Since(st.Started).Round(time.Second),
+		st.Speed, st.DroppedFrames)
+}
+
+// Records a progress report from ffmpeg, warning if the transcode isn't
+// keeping up with real time.
+func (s *transcodeSession) update(p transcode.Progress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	waited := s.readWait - s.lastReadWait
+	elapsed := now.Sub(s.lastUpdate)
+	s.lastReadWait = s.readWait
+	s.lastUpdate = now
+	s.status.Progress = p
+	st := s.status
+	s.logger.Levelf(log.Debug, "transcode %d at %s: speed %.2fx, %.1f fps, %.1f kbit/s, %d dropped frames",
+		st.ID, p.Position, p.Speed, p.FPS, p.Bitrate, p.DroppedFrames)
+	slow := p.Speed > 0 && p.Speed < 1 && (!s.piped || waited > elapsed/2)
+	if !slow {
+		if s.warned {
+			s.logger.Levelf(log.Info, "transcode %d of %q has caught up: speed %.2fx", st.ID, st.Path, p.Speed)
+		}
+		s.slowSince = time.Time{}
+		s.warned = false
+		return
+	}
+	if s.slowSince.IsZero() {
+		s.slowSince = now
+	}
+	if !s.warned && now.Sub(s.slowSince) >= slowTranscodeWarnAfter {
+		s.warned = true
+		s.logger.Levelf(log.Warning, "transcode %d of %q for %s is slower than real time: speed %.2fx, %.1f fps, %d dropped frames",
+			st.ID, st.Path, st.Client, p.Speed, p.FPS, p.DroppedFrames)
+	}
+}
+
+func (s *transcodeSession) addReadWait(d time.Duration) {
+	s.mu.Lock()
+	s.readWait += d
+	s.mu.Unlock()
+}
+
+// Wraps transcode output to measure how long the client waits on it.
+type timedReader struct {
+	io.ReadCloser
+	s *transcodeSession
+}
+
+func (me timedReader) Read(b []byte) (int, error) {
+	t := time.Now()
+	n, err := me.ReadCloser.Read(b)
+	me.s.addReadWait(time.Since(t))
+	return n, err
+}
+
+// TranscodeStatus returns the state of all running transcodes, oldest first.
+func (me *Server) TranscodeStatus() (ret []TranscodeStatus) {
+	me.transcodeSessions.mu.Lock()
+	sessions := make([]*transcodeSession, 0, len(me.transcodeSessions.m))
+	for _, s := range me.transcodeSessions.m {
+		sessions = append(sessions, s)
+	}
+	me.transcodeSessions.mu.Unlock()
+	for _, s := range sessions {
+		s.mu.Lock()
+		ret = append(ret, s.status)
+		s.mu.Unlock()
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return
+}
+
+func (me *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(Status{
+		Transcodes:     me.TranscodeStatus(),
+		SSDP:           me.SSDPStatus(),
+		MediaReceivers: me.MediaReceivers(),
+	}); err != nil {
+		me.Logger.Printf("error encoding status: %s", err)
+	}
+}
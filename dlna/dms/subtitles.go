@@ -0,0 +1,400 @@
+package dms
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// A subtitle of a video, either in a separate file or embedded in it.
+type subtitle struct {
+	// The path of the subtitle file relative to the video's directory, with
+	// forward slashes. Empty for embedded subtitles.
+	File string
+	// The relative index among the video's subtitle streams, for embedded
+	// subtitles.
+	Stream int
+	// The source format. This is one of the values of subtitleFileFormats for
+	// files, and the codec name for embedded subtitles.
+	Format string
+	// The ISO 639-2 language code, if known.
+	Language string
+}
+
+// Subtitle file extensions and the formats they contain. ".sub" is either
+// MicroDVD text, or VobSub images if there's a matching ".idx". VobSub isn't
+// offered, as renderers can't be given the ".idx" it needs too.
+var subtitleFileFormats = map[string]string{
+	".srt":  "srt",
+	".ass":  "ass",
+	".ssa":  "ssa",
+	".vtt":  "vtt",
+	".smi":  "smi",
+	".sami": "smi",
+	".sub":  "microdvd",
+}
+
+// MIME-types of subtitle formats that are served as they are.
+var subtitleMimeTypes = map[string]string{
+	"srt": "text/srt",
+	"ass": "text/ssa",
+	"ssa": "text/ssa",
+	"vtt": "text/vtt",
+	"smi": "text/smi",
+}
+
+// Lowercased names of directories next to videos that hold their subtitles.
+var subtitleDirNames = map[string]bool{
+	"subs":      true,
+	"subtitles": true,
+}
+
+// Whether ffmpeg can convert the subtitle to text formats.
+func (s subtitle) convertible() bool {
+	if s.File != "" {
+		return true
+	}
+	return transcode.TextSubtitleCodecs[s.Format]
+}
+
+// Returns the subtitle described by the file name name, found at rel
+// relative to the video's directory dir. If prefix isn't empty, only files
+// named after the video with that prefix are considered.
+func subtitleFile(dir, rel, prefix string) (ret subtitle, ok bool) {
+	name := path.Base(rel)
+	ext := strings.ToLower(path.Ext(name))
+	format, ok := subtitleFileFormats[ext]
+	if !ok {
+		return
+	}
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	if prefix != "" {
+		if stem != prefix && !strings.HasPrefix(stem, prefix+".") {
+			return ret, false
+		}
+		stem = strings.TrimPrefix(stem, prefix)
+	}
+	if ext == ".sub" && fileExists(filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(rel, path.Ext(rel))+".idx"))) {
+		return ret, false
+	}
+	ret = subtitle{
+		File:     rel,
+		Format:   format,
+		Language: filenameLanguage(stem),
+	}
+	return
+}
+
+// Returns the subtitles in the subtitle directory subDir of dir for the video
+// with the extensionless name base. These are the files named after the video,
+// and anything in a directory named after the video.
+func subtitleDirFiles(dir, subDir, base string) (ret []subtitle) {
+	entries, err := os.ReadDir(filepath.Join(dir, subDir))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		rel := path.Join(subDir, e.Name())
+		if !e.IsDir() {
+			if s, ok := subtitleFile(dir, rel, base); ok {
+				ret = append(ret, s)
+			}
+			continue
+		}
+		if !strings.EqualFold(e.Name(), base) {
+			continue
+		}
+		files, _ := os.ReadDir(filepath.Join(dir, filepath.FromSlash(rel)))
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			if s, ok := subtitleFile(dir, path.Join(rel, f.Name()), ""); ok {
+				ret = append(ret, s)
+			}
+		}
+	}
+	return
+}
+
+// Returns the subtitles of the video at videoPath: files alongside it, then
+// those in subtitle directories, then the streams in info.
+func findSubtitles(videoPath string, info *ffprobe.Info) (ret []subtitle) {
+	dir, name := filepath.Split(videoPath)
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	entries, _ := os.ReadDir(dir)
+	var inDirs []subtitle
+	for _, e := range entries {
+		if e.IsDir() {
+			if subtitleDirNames[strings.ToLower(e.Name())] {
+				inDirs = append(inDirs, subtitleDirFiles(dir, e.Name(), base)...)
+			}
+			continue
+		}
+		if s, ok := subtitleFile(dir, e.Name(), base); ok {
+			ret = append(ret, s)
+		}
+	}
+	ret = append(ret, inDirs...)
+	for i, s := range transcode.StreamsOfType(info, "subtitle") {
+		codec, _ := s["codec_name"].(string)
+		ret = append(ret, subtitle{
+			Stream:   i,
+			Format:   codec,
+			Language: normalizeLanguage(transcode.StreamLanguage(s)),
+		})
+	}
+	return
+}
+
+// Returns the subtitles of the video at videoPath, in the preferred languages
+// first. info is probed if it's needed and not given.
+func (me *Server) subtitles(videoPath string, info *ffprobe.Info) []subtitle {
+	if info == nil && !me.NoProbe {
+		info, _ = me.ffmpegProbe(videoPath)
+	}
+	subs := findSubtitles(videoPath, info)
+	rank := func(s subtitle) int {
+		for i, lang := range me.PreferredLanguages {
+			if s.Language != "" && normalizeLanguage(lang) == s.Language {
+				return i
+			}
+		}
+		return len(me.PreferredLanguages)
+	}
+	sort.SliceStable(subs, func(i, j int) bool {
+		return rank(subs[i]) < rank(subs[j])
+	})
+	return subs
+}
+
+// Returns the URL of the subtitle of the video at the object path in format.
+func subtitleURL(host, path string, s subtitle, format string) string {
+	q := url.Values{
+		"path":   {path},
+		"format": {format},
+	}
+	if s.File != "" {
+		q.Set("file", s.File)
+	} else {
+		q.Set("stream", strconv.Itoa(s.Stream))
+	}
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     subtitlePath,
+		RawQuery: q.Encode(),
+	}).String()
+}
+
+// Returns the URL of s as SRT, if it's available as SRT.
+func (me *Server) subtitleSRTURL(host, path string, s subtitle) (string, bool) {
+	if (s.File != "" && s.Format == "srt") || (!me.NoTranscode && s.convertible()) {
+		return subtitleURL(host, path, s, string(transcode.SubtitleFormatSRT)), true
+	}
+	return "", false
+}
+
+// Returns a resource for each subtitle file as it is, and as SRT for those
+// that need converting.
+func (me *Server) subtitleResources(host, path string, subs []subtitle) (ret []upnpav.Resource) {
+	for _, s := range subs {
+		if mimeType, ok := subtitleMimeTypes[s.Format]; ok && s.File != "" {
+			ret = append(ret, upnpav.Resource{
+				URL:          subtitleURL(host, path, s, s.Format),
+				ProtocolInfo: "http-get:*:" + mimeType + ":*",
+			})
+			if s.Format == "srt" {
+				continue
+			}
+		}
+		if !me.NoTranscode && s.convertible() {
+			ret = append(ret, upnpav.Resource{
+				URL:          subtitleURL(host, path, s, string(transcode.SubtitleFormatSRT)),
+				ProtocolInfo: "http-get:*:" + transcode.SubtitleFormatSRT.MimeType() + ":*",
+			})
+		}
+	}
+	return
+}
+
+// Returns the Samsung caption element for the most preferred subtitle
+// available as SRT. Samsung renderers only use the first, so there's no point
+// listing the others.
+func (me *Server) captionInfoEx(host, path string, subs []subtitle) []upnpav.CaptionInfoEx {
+	for _, s := range subs {
+		if u, ok := me.subtitleSRTURL(host, path, s); ok {
+			return []upnpav.CaptionInfoEx{{Type: "srt", URL: u}}
+		}
+	}
+	return nil
+}
+
+// Answers Samsung's getCaptionInfo.sec request header on media requests with
+// the URL of the preferred subtitle.
+func (me *Server) setCaptionInfoHeader(w http.ResponseWriter, r *http.Request, filePath string) {
+	if r.Header.Get("getCaptionInfo.sec") != "1" {
+		return
+	}
+	if mimeType, err := MimeTypeByPath(filePath); err != nil || !mimeType.IsVideo() {
+		return
+	}
+	for _, s := range me.subtitles(filePath, nil) {
+		if u, ok := me.subtitleSRTURL(r.Host, r.URL.Query().Get("path"), s); ok {
+			// Set directly, as Samsung renderers don't recognize the
+			// canonicalized form.
+			w.Header()["CaptionInfo.sec"] = []string{u}
+			return
+		}
+	}
+}
+
+// Returns the subtitle selected by the file or stream query parameters,
+// defaulting to the first.
+func selectSubtitle(subs []subtitle, q url.Values) (subtitle, bool) {
+	for _, s := range subs {
+		switch {
+		case q.Has("file"):
+			if s.File != "" && s.File == q.Get("file") {
+				return s, true
+			}
+		case q.Has("stream"):
+			if s.File == "" && strconv.Itoa(s.Stream) == q.Get("stream") {
+				return s, true
+			}
+		default:
+			return s, true
+		}
+	}
+	return subtitle{}, false
+}
+
+// Serves a subtitle of the video in the path parameter in the requested
+// format, converting it if necessary. The format defaults to SRT.
+func (me *Server) serveSubtitle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filePath := me.filePath(q.Get("path"))
+	if ignored, err := me.IgnorePath(filePath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if ignored {
+		http.Error(w, "no such object", http.StatusNotFound)
+		return
+	}
+	s, ok := selectSubtitle(me.subtitles(filePath, nil), q)
+	if !ok {
+		http.Error(w, "no such subtitle", http.StatusNotFound)
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = string(transcode.SubtitleFormatSRT)
+	}
+	if s.File != "" && format == s.Format {
+		if mimeType, ok := subtitleMimeTypes[s.Format]; ok {
+			w.Header().Set("Content-Type", mimeType)
+			http.ServeFile(w, r, filepath.Join(filepath.Dir(filePath), filepath.FromSlash(s.File)))
+			return
+		}
+	}
+	if me.NoTranscode {
+		http.Error(w, "transcodes disabled", http.StatusNotFound)
+		return
+	}
+	target, err := transcode.ParseSubtitleFormat(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.convertible() {
+		http.Error(w, "subtitle can't be converted to "+format, http.StatusNotFound)
+		return
+	}
+	input, stream := filePath, s.Stream
+	if s.File != "" {
+		input, stream = filepath.Join(filepath.Dir(filePath), filepath.FromSlash(s.File)), 0
+	}
+	b, err := transcode.ConvertSubtitle(input, stream, target)
+	if err != nil {
+		me.Logger.Printf("error converting subtitle of %q: %s", filePath, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", target.MimeType())
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
+
+// Language names and ISO 639-1 and 639-2/B codes, mapped to the ISO 639-2/T
+// codes that ffmpeg tags streams with.
+var languageCodes = func() map[string]string {
+	ret := make(map[string]string)
+	for _, l := range [][]string{
+		{"ara", "ar", "arabic"},
+		{"ces", "cs", "cze", "czech"},
+		{"dan", "da", "danish"},
+		{"deu", "de", "ger", "german"},
+		{"ell", "el", "gre", "greek"},
+		{"eng", "en", "english"},
+		{"fin", "fi", "finnish"},
+		{"fra", "fr", "fre", "french"},
+		{"heb", "he", "hebrew"},
+		{"hin", "hi", "hindi"},
+		{"hun", "hu", "hungarian"},
+		{"ita", "it", "italian"},
+		{"jpn", "ja", "japanese"},
+		{"kor", "ko", "korean"},
+		{"nld", "nl", "dut", "dutch"},
+		{"nor", "no", "norwegian"},
+		{"pol", "pl", "polish"},
+		{"por", "pt", "portuguese"},
+		{"ron", "ro", "rum", "romanian"},
+		{"rus", "ru", "russian"},
+		{"spa", "es", "spanish"},
+		{"swe", "sv", "swedish"},
+		{"tha", "th", "thai"},
+		{"tur", "tr", "turkish"},
+		{"ukr", "uk", "ukrainian"},
+		{"vie", "vi", "vietnamese"},
+		{"zho", "zh", "chi", "chinese"},
+	} {
+		for _, s := range l {
+			ret[s] = l[0]
+		}
+	}
+	return ret
+}()
+
+// Returns the ISO 639-2/T code for a language code or English name, or the
+// lowercased input if it's unknown.
+func normalizeLanguage(s string) string {
+	s = strings.ToLower(s)
+	if code, ok := languageCodes[s]; ok {
+		return code
+	}
+	return s
+}
+
+// Returns the language in a subtitle file name, such as "en" in ".en.forced"
+// or "English" in "2_English", or "" if none is found.
+func filenameLanguage(s string) string {
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == ' '
+	}) {
+		if code, ok := languageCodes[strings.ToLower(part)]; ok {
+			return code
+		}
+	}
+	return ""
+}
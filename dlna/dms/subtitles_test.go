@@ -0,0 +1,97 @@
+package dms
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestFindSubtitles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"movie.mkv",
+		"movie.srt",
+		"movie.en.forced.srt",
+		"movie.fr.ass",
+		"movie.idx",
+		"movie.sub",
+		"movie.it.sub",
+		"movie2.srt",
+		"other.vtt",
+		"Subs/movie.de.vtt",
+		"Subs/movie/2_Spanish.srt",
+		"Subs/movie/notes.txt",
+	} {
+		fp := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := findSubtitles(filepath.Join(dir, "movie.mkv"), nil)
+	want := []subtitle{
+		{File: "movie.en.forced.srt", Format: "srt", Language: "eng"},
+		{File: "movie.fr.ass", Format: "ass", Language: "fra"},
+		{File: "movie.it.sub", Format: "microdvd", Language: "ita"},
+		{File: "movie.srt", Format: "srt"},
+		{File: "Subs/movie/2_Spanish.srt", Format: "srt", Language: "spa"},
+		{File: "Subs/movie.de.vtt", Format: "vtt", Language: "deu"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSubtitlesPreferredLanguage(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.avi", "a.en.srt", "a.fr.srt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := Server{NoProbe: true, PreferredLanguages: []string{"fre"}}
+	subs := srv.subtitles(filepath.Join(dir, "a.avi"), nil)
+	if len(subs) != 2 || subs[0].Language != "fra" {
+		t.Fatalf("unexpected order: %+v", subs)
+	}
+}
+
+func TestCaptionInfoExMarshal(t *testing.T) {
+	b, err := xml.Marshal(upnpav.CaptionInfoEx{Type: "srt", URL: "http://host/subtitle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `<sec:CaptionInfoEx sec:type="srt">http://host/subtitle</sec:CaptionInfoEx>`
+	if string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+}
+
+func TestSubtitleResources(t *testing.T) {
+	srv := Server{}
+	res := srv.subtitleResources("host", "/movie.mkv", []subtitle{
+		{File: "movie.srt", Format: "srt"},
+		{File: "movie.ass", Format: "ass"},
+		{Stream: 1, Format: "subrip"},
+		{Stream: 2, Format: "hdmv_pgs_subtitle"},
+	})
+	var got []string
+	for _, r := range res {
+		got = append(got, strings.Split(r.ProtocolInfo, ":")[2])
+	}
+	want := []string{"text/srt", "text/ssa", "text/srt", "text/srt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	srv.NoTranscode = true
+	if res := srv.subtitleResources("host", "/movie.mkv", []subtitle{{Stream: 0, Format: "subrip"}}); len(res) != 0 {
+		t.Fatalf("got conversions with transcodes disabled: %v", res)
+	}
+}
@@ -0,0 +1,89 @@
+package dms
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// The transcode that gets a resource per audio track, when there's more than
+// one.
+const trackTranscodeKey = "t"
+
+// Returns the tracks to transcode for the file at path. The defaults come from
+// the server's preferred languages, and can be overridden with the query
+// parameters "audio" and "sub", given as a relative index or language code,
+// or "none" for no subtitles, and "burn", a boolean.
+func (me *Server) requestTracks(path string, q url.Values) (tracks transcode.Tracks, err error) {
+	var info *ffprobe.Info
+	if !me.NoProbe {
+		info, _ = me.ffmpegProbe(path)
+	}
+	tracks = transcode.SelectTracks(info, me.PreferredLanguages)
+	tracks.BurnSubtitle = me.BurnSubtitles
+	if a := q.Get("audio"); a != "" {
+		tracks.Audio, err = transcode.ParseTrack(a, transcode.StreamsOfType(info, "audio"))
+		if err != nil {
+			err = fmt.Errorf("bad audio track: %w", err)
+			return
+		}
+	}
+	switch sub := q.Get("sub"); sub {
+	case "":
+	case "none":
+		tracks.HasSubtitle = false
+	default:
+		tracks.Subtitle, err = transcode.ParseTrack(sub, transcode.StreamsOfType(info, "subtitle"))
+		if err != nil {
+			err = fmt.Errorf("bad subtitle track: %w", err)
+			return
+		}
+		tracks.HasSubtitle = true
+	}
+	if b := q.Get("burn"); b != "" {
+		tracks.BurnSubtitle, err = strconv.ParseBool(b)
+		if err != nil {
+			err = fmt.Errorf("bad burn value: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// Returns a resource for each audio track of a file with several of them, so
+// that renderers that list resources can offer a choice of language.
+func trackResources(host, path, resolution, duration string, info *ffprobe.Info) (ret []upnpav.Resource) {
+	audio := transcode.StreamsOfType(info, "audio")
+	if len(audio) < 2 {
+		return
+	}
+	spec := transcodes[trackTranscodeKey]
+	for i := range audio {
+		ret = append(ret, upnpav.Resource{
+			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", spec.mimeType, dlna.ContentFeatures{
+				SupportTimeSeek: true,
+				Transcoded:      true,
+				ProfileName:     spec.DLNAProfileName,
+			}.String()),
+			URL: (&url.URL{
+				Scheme: "http",
+				Host:   host,
+				Path:   resPath,
+				RawQuery: url.Values{
+					"path":      {path},
+					"transcode": {trackTranscodeKey},
+					"audio":     {strconv.Itoa(i)},
+				}.Encode(),
+			}).String(),
+			Resolution: resolution,
+			Duration:   duration,
+		})
+	}
+	return
+}
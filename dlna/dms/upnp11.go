@@ -0,0 +1,157 @@
+package dms
+
+import (
+	"errors"
+	"hash/fnv"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/ssdp"
+)
+
+// The first port tried for unicast searches. UPnP 1.1 requires
+// SEARCHPORT.UPNP.ORG to be in 49152-65535.
+const firstSearchPort = 49152
+
+// Returns the boot ID for this run, incremented from the one persisted at
+// BootIDPath. Without a path, or if it can't be used, the boot ID is the
+// start time, which also increases across restarts.
+func (me *Server) loadBootID() *ssdp.BootID {
+	fallback := func() *ssdp.BootID {
+		return ssdp.NewBootID(uint32(time.Now().Unix()), nil)
+	}
+	if me.BootIDPath == "" {
+		return fallback()
+	}
+	save := func(v uint32) {
+		if err := os.MkdirAll(filepath.Dir(me.BootIDPath), 0o750); err != nil {
+			me.Logger.Printf("error saving boot id: %v", err)
+			return
+		}
+		if err := os.WriteFile(me.BootIDPath, []byte(strconv.FormatUint(uint64(v), 10)+"\n"), 0o640); err != nil {
+			me.Logger.Printf("error saving boot id: %v", err)
+		}
+	}
+	var last uint64
+	b, err := os.ReadFile(me.BootIDPath)
+	if err == nil {
+		last, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		me.Logger.Printf("error loading boot id from %q: %v", me.BootIDPath, err)
+		return fallback()
+	}
+	ret := ssdp.NewBootID(uint32(last), save)
+	ret.Advance(uint32(last))
+	return ret
+}
+
+// Returns the CONFIGID.UPNP.ORG for the root description, which must be
+// marshalled without its configId, and the service descriptions.
+func configID(rootDescXML []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(rootDescXML)
+	for _, s := range services {
+		h.Write([]byte(s.SCPD))
+	}
+	return ssdp.ConfigID(h.Sum32())
+}
+
+// Returns the SCPD with the configId attribute added to the root element.
+func scpdWithConfigID(scpd string, configID uint32) string {
+	return strings.Replace(scpd, "<scpd ", `<scpd configId="`+strconv.FormatUint(uint64(configID), 10)+`" `, 1)
+}
+
+// The running SSDP servers, so that searches received on the search ports can
+// be passed to them.
+type ssdpServers struct {
+	mu sync.Mutex
+	m  map[*ssdp.Server]struct{}
+}
+
+func (me *ssdpServers) add(s *ssdp.Server) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[*ssdp.Server]struct{})
+	}
+	me.m[s] = struct{}{}
+}
+
+func (me *ssdpServers) remove(s *ssdp.Server) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.m, s)
+}
+
+func (me *ssdpServers) all() (ret []*ssdp.Server) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for s := range me.m {
+		ret = append(ret, s)
+	}
+	return
+}
+
+// Opens a socket for unicast searches of an address family, on SSDPSearchPort
+// or the first free port from firstSearchPort.
+func (me *Server) listenSearchPort(network string) (*net.UDPConn, error) {
+	if me.SSDPSearchPort != 0 {
+		return net.ListenUDP(network, &net.UDPAddr{Port: me.SSDPSearchPort})
+	}
+	var err error
+	for port := firstSearchPort; port < firstSearchPort+100; port++ {
+		var conn *net.UDPConn
+		conn, err = net.ListenUDP(network, &net.UDPAddr{Port: port})
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Opens the unicast search sockets, and passes searches received on them to
+// the SSDP servers of the same address family until the server is closed.
+func (me *Server) serveSearchPorts() {
+	for _, ipv6 := range []bool{false, true} {
+		network := "udp4"
+		if ipv6 {
+			network = "udp6"
+		}
+		conn, err := me.listenSearchPort(network)
+		if err != nil {
+			me.Logger.Levelf(log.Warning, "unicast searches over %s disabled: %v", network, err)
+			continue
+		}
+		if me.searchPorts == nil {
+			me.searchPorts = make(map[bool]int)
+		}
+		me.searchPorts[ipv6] = conn.LocalAddr().(*net.UDPAddr).Port
+		go func() {
+			<-me.closed
+			conn.Close()
+		}()
+		go func(ipv6 bool) {
+			b := make([]byte, 65536)
+			for {
+				n, from, err := conn.ReadFromUDP(b)
+				if err != nil {
+					return
+				}
+				for _, s := range me.ssdpServers.all() {
+					if s.IPv6 == ipv6 {
+						s.HandleSearch(append([]byte(nil), b[:n]...), from)
+					}
+				}
+			}
+		}(ipv6)
+	}
+}
@@ -3,9 +3,11 @@ package main
 import (
 	_ "embed"
 	"fmt"
+	"log"
 
 	"github.com/anacrolix/dms/dlna/dms"
 	"github.com/anacrolix/dms/server"
+	"github.com/anacrolix/dms/server/cli"
 	"github.com/anacrolix/dms/upnp"
 )
 
@@ -17,10 +19,8 @@ var (
 			Service: upnp.Service{
 				ServiceType: "urn:schemas-upnp-org:service:SwitchPower:1",
 				ServiceId:   "urn:upnp-org:serviceId:SwitchPower:1",
-				ControlURL:  "/ctl",
-				EventSubURL: "/evt/SwitchPower",
 			},
-			SCPD: switchPowerServiceDescription,
+			Def: &switchPowerServiceDef,
 		},
 	}
 
@@ -44,5 +44,7 @@ var (
 )
 
 func main() {
-	server.Start(lightDevice)
+	if err := cli.Main(lightDevice); err != nil {
+		log.Fatal(err)
+	}
 }
\ No newline at end of file
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/xml"
 	"net/http"
 
 	"github.com/anacrolix/dms/upnp" //TODO
@@ -20,10 +19,11 @@ var (
 
 type switchPowerService struct {
 	upnp.Eventing
+	*upnp.Actions
 }
 
 type setStatusReq struct {
-	NewTargetValue bool `xml:"newTargetValue"`
+	NewTargetValue bool `upnp:"newTargetValue"`
 }
 
 func boolNum(b bool) string {
@@ -67,36 +67,24 @@ func(s *switchPowerService) NotifyIfNeed(oldStatus bool) {
 	// }
 }
 
-func (s *switchPowerService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
-	log.Printf("Received action!!! %s", action)
-	// target := s.DeviceModel.GetTarget()
-	// status := s.DeviceModel.GetStatus()
-	// TODO: using %d to print boolean status
-	switch action {
-	case "GetStatus":
+func newSwitchPowerService() *switchPowerService {
+	s := &switchPowerService{Actions: upnp.NewActions(&switchPowerServiceDef)}
+	upnp.Bind(s.Actions, "GetStatus", func(*http.Request, struct{}) (ret struct{ ResultStatus bool }, err error) {
 		log.Printf("GetStatus returning %v", boolNum(status))
-		return [][2]string{
-			{"ResultStatus", boolNum(status)},
-		}, nil
-	case "GetTarget":
+		ret.ResultStatus = status
+		return
+	})
+	upnp.Bind(s.Actions, "GetTarget", func(*http.Request, struct{}) (ret struct{ RetTargetValue bool }, err error) {
 		log.Printf("GetTarget returning %v", boolNum(target))
-		return [][2]string{
-			{"RetTargetValue", boolNum(target)},
-		}, nil
-	case "SetTarget":
+		ret.RetTargetValue = target
+		return
+	})
+	upnp.Bind(s.Actions, "SetTarget", func(_ *http.Request, req setStatusReq) (struct{}, error) {
 		defer s.NotifyIfNeed(status)
-
-		var setStatusReq setStatusReq
-		if err := xml.Unmarshal([]byte(argsXML), &setStatusReq); err != nil {
-			log.Warnf("Unmarshall failed in SetTarget: %v", err)
-			return nil, err
-		}
-		log.Infof("Receive %v in Set target", setStatusReq)
-		// s.DeviceModel.SetTarget(setStatusReq.NewTargetValue)
-		target = setStatusReq.NewTargetValue
-
-		return [][2]string{}, nil
-	default:
-		return nil, upnp.InvalidActionError
-	}
-}
\ No newline at end of file
+		log.Infof("Receive %v in Set target", req)
+		// s.DeviceModel.SetTarget(req.NewTargetValue)
+		target = req.NewTargetValue
+		return struct{}{}, nil
+	})
+	return s
+}
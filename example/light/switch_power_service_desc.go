@@ -1,55 +1,24 @@
 package main
 
-const switchPowerServiceDescription = `<?xml version="1.0" encoding="utf-8"?>
-<scpd xmlns="urn:schemas-upnp-org:service-1-0">
-  <specVersion>
-    <major>1</major>
-    <minor>0</minor>
-  </specVersion>
-  <actionList>
-    <action>
-      <name>SetTarget</name>
-      <argumentList>
-        <argument>
-          <name>newTargetValue</name>
-          <relatedStateVariable>Target</relatedStateVariable>
-          <direction>in</direction>
-        </argument>
-      </argumentList>
-    </action>
-    <action>
-      <name>GetTarget</name>
-      <argumentList>
-        <argument>
-          <name>RetTargetValue</name>
-          <relatedStateVariable>Target</relatedStateVariable>
-          <direction>out</direction>
-        </argument>
-      </argumentList>
-    </action>
-    <action>
-      <name>GetStatus</name>
-      <argumentList>
-        <argument>
-          <name>ResultStatus</name>
-          <relatedStateVariable>Status</relatedStateVariable>
-          <direction>out</direction>
-        </argument>
-      </argumentList>
-    </action>
-  </actionList>
-  <serviceStateTable>
-    <stateVariable sendEvents="no">
-      <name>Target</name>
-      <dataType>boolean</dataType>
-      <defaultValue>0</defaultValue>
-    </stateVariable>
-    <stateVariable sendEvents="yes">
-      <name>Status</name>
-      <dataType>boolean</dataType>
-      <defaultValue>0</defaultValue>
-    </stateVariable>
-  </serviceStateTable>
-</scpd>
+import "github.com/anacrolix/dms/upnp"
 
-`
+var switchPowerServiceDef = upnp.ServiceDef{
+	Actions: []upnp.ActionDef{
+		{
+			Name: "SetTarget",
+			In:   []upnp.ArgDef{{Name: "newTargetValue", StateVar: "Target"}},
+		},
+		{
+			Name: "GetTarget",
+			Out:  []upnp.ArgDef{{Name: "RetTargetValue", StateVar: "Target"}},
+		},
+		{
+			Name: "GetStatus",
+			Out:  []upnp.ArgDef{{Name: "ResultStatus", StateVar: "Status"}},
+		},
+	},
+	StateVars: []upnp.StateVarDef{
+		{Name: "Target", DataType: "boolean", DefaultValue: "0"},
+		{Name: "Status", DataType: "boolean", DefaultValue: "0", SendEvents: true},
+	},
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/anacrolix/log"
 	"github.com/nfnt/resize"
 
+	"github.com/anacrolix/dms/access"
 	"github.com/anacrolix/dms/dlna/dms"
 	"github.com/anacrolix/dms/rrcache"
 )
@@ -46,12 +47,29 @@ type dmsConfig struct {
 	NoProbe             bool
 	StallEventSubscribe bool
 	NotifyInterval      time.Duration
+	SSDPIPv6            string
+	SSDPSiteLocal       bool
+	MDNS                bool
 	IgnoreHidden        bool
 	IgnoreUnreadable    bool
 	IgnorePaths         []string
-	AllowedIpNets       []*net.IPNet
+	// Comma separated access rules, as for access.ParseRules.
+	AllowedIps          string
 	AllowDynamicStreams bool
 	TranscodeLogPattern string
+	BootIDPath          string
+	PreferredLanguages  []string
+	BurnSubtitles       bool
+	MediaReceiversPath  string
+	// Media receivers that are authorized, by IP or DeviceID. All are if it's empty.
+	ApprovedMediaReceivers []string
+	Writable               bool
+	// Comma separated access rules for changing the tree, as for access.ParseRules.
+	WriteAllowedIps string
+	TrashPath       string
+	CastAPI         bool
+	// Comma separated access rules for the cast API, as for access.ParseRules.
+	CastAllowedIps string
 }
 
 func (config *dmsConfig) load(configPath string) {
@@ -74,6 +92,7 @@ var config = &dmsConfig{
 	Path:             "",
 	IfName:           "",
 	Http:             ":1338",
+	SSDPIPv6:         "all",
 	FriendlyName:     "",
 	DeviceIcon:       "",
 	DeviceIconSizes:  []string{"48,128"},
@@ -126,6 +145,15 @@ func main() {
 }
 
 func mainErr() error {
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		return discoverMain(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "renderer" {
+		return rendererMain(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cast" {
+		return castMain(os.Args[2:])
+	}
 	path := flag.String("path", config.Path, "browse root path")
 	ifName := flag.String("ifname", config.IfName, "specific SSDP network interface")
 	http := flag.String("http", config.Http, "http server port")
@@ -135,17 +163,28 @@ func mainErr() error {
 	logHeaders := flag.Bool("logHeaders", config.LogHeaders, "log HTTP headers")
 	fFprobeCachePath := flag.String("fFprobeCachePath", config.FFprobeCachePath, "path to FFprobe cache file")
 	configFilePath := flag.String("config", "", "json configuration file")
-	allowedIps := flag.String("allowedIps", "", "allowed ip of clients, separated by comma")
+	allowedIps := flag.String("allowedIps", "", "comma separated clients to allow: IPs, CIDR networks or MAC addresses. Those prefixed with ! are denied")
 	forceTranscodeTo := flag.String("forceTranscodeTo", config.ForceTranscodeTo, "force transcoding to certain format, supported: 'chromecast', 'vp8', 'web'")
 	transcodeLogPattern := flag.String("transcodeLogPattern", "", "pattern where to write transcode logs to. The [tsname] placeholder is replaced with the name of the item currently being played. The default is $HOME/.dms/log/[tsname]")
 	flag.BoolVar(&config.NoTranscode, "noTranscode", false, "disable transcoding")
 	flag.BoolVar(&config.NoProbe, "noProbe", false, "disable media probing with ffprobe")
 	flag.BoolVar(&config.StallEventSubscribe, "stallEventSubscribe", false, "workaround for some bad event subscribers")
 	flag.DurationVar(&config.NotifyInterval, "notifyInterval", 30*time.Second, "interval between SSPD announces")
+	flag.StringVar(&config.SSDPIPv6, "ssdpIPv6", config.SSDPIPv6, "interfaces to do SSDP over IPv6 on: 'all', 'none', or a comma separated list of interface names")
+	flag.BoolVar(&config.SSDPSiteLocal, "ssdpSiteLocal", false, "also use the IPv6 site-local SSDP group ff05::c")
+	flag.BoolVar(&config.MDNS, "mdns", false, "also advertise over mDNS/DNS-SD as _http._tcp")
 	flag.BoolVar(&config.IgnoreHidden, "ignoreHidden", false, "ignore hidden files and directories")
 	flag.BoolVar(&config.IgnoreUnreadable, "ignoreUnreadable", false, "ignore unreadable files and directories")
 	ignorePaths := flag.String("ignore", "", "comma separated list of directories to ignore (i.e. thumbnails,thumbs)")
 	flag.BoolVar(&config.AllowDynamicStreams, "allowDynamicStreams", false, "activate support for dynamic streams described via .dms.json metadata files")
+	preferredLanguages := flag.String("preferredLanguages", "", "comma separated list of languages in order of preference, used to pick transcode audio and subtitle tracks (i.e. eng,jpn)")
+	flag.BoolVar(&config.BurnSubtitles, "burnSubtitles", false, "render the selected subtitle track into transcoded video")
+	approvedMediaReceivers := flag.String("approvedMediaReceivers", "", "comma separated IPs or device IDs of the media receivers, such as Xboxes, to authorize. All are authorized if empty")
+	flag.BoolVar(&config.Writable, "writable", false, "let clients create folders, upload media, rename and delete through the ContentDirectory")
+	flag.StringVar(&config.WriteAllowedIps, "writeAllowedIps", "127.0.0.1,::1", "comma separated clients allowed to write when -writable, as for -allowedIps")
+	flag.StringVar(&config.TrashPath, "trash", "", "directory to move deleted objects to, instead of deleting them")
+	flag.BoolVar(&config.CastAPI, "castAPI", false, "serve the HTTP API dms cast uses to play items on renderers")
+	flag.StringVar(&config.CastAllowedIps, "castAllowedIps", "127.0.0.1,::1", "comma separated clients allowed to use the cast API, as for -allowedIps")
 
 	flag.Parse()
 	if flag.NArg() != 0 {
@@ -164,10 +203,16 @@ func mainErr() error {
 
 	config.LogHeaders = *logHeaders
 	config.FFprobeCachePath = *fFprobeCachePath
-	config.AllowedIpNets = makeIpNets(*allowedIps)
+	config.AllowedIps = *allowedIps
 	config.ForceTranscodeTo = *forceTranscodeTo
 	config.IgnorePaths = strings.Split(*ignorePaths, ",")
 	config.TranscodeLogPattern = *transcodeLogPattern
+	if *preferredLanguages != "" {
+		config.PreferredLanguages = strings.Split(*preferredLanguages, ",")
+	}
+	if *approvedMediaReceivers != "" {
+		config.ApprovedMediaReceivers = strings.Split(*approvedMediaReceivers, ",")
+	}
 
 	if config.TranscodeLogPattern == "" {
 		u, err := user.Current()
@@ -176,13 +221,41 @@ func mainErr() error {
 		}
 		config.TranscodeLogPattern = filepath.Join(u.HomeDir, ".dms", "log", "[tsname]")
 	}
+	if config.BootIDPath == "" {
+		if u, err := user.Current(); err == nil {
+			config.BootIDPath = filepath.Join(u.HomeDir, ".dms", "bootid")
+		}
+	}
+	if config.MediaReceiversPath == "" {
+		if u, err := user.Current(); err == nil {
+			config.MediaReceiversPath = filepath.Join(u.HomeDir, ".dms", "mediareceivers.json")
+		}
+	}
 
 	if len(*configFilePath) > 0 {
 		config.load(*configFilePath)
 	}
 
 	logger.Printf("device icon sizes are %q", config.DeviceIconSizes)
-	logger.Printf("allowed ip nets are %q", config.AllowedIpNets)
+	accessRules, err := access.ParseRules(config.AllowedIps)
+	if err != nil {
+		return err
+	}
+	logger.Printf("access rules are %q", accessRules)
+	writeAccessRules, err := access.ParseRules(config.WriteAllowedIps)
+	if err != nil {
+		return err
+	}
+	if config.Writable {
+		logger.Printf("writable, with access rules %q", writeAccessRules)
+	}
+	castAccessRules, err := access.ParseRules(config.CastAllowedIps)
+	if err != nil {
+		return err
+	}
+	if config.CastAPI {
+		logger.Printf("serving the cast API, with access rules %q", castAccessRules)
+	}
 	logger.Printf("serving folder %q", config.Path)
 	if config.AllowDynamicStreams {
 		logger.Printf("Dynamic streams ARE allowed")
@@ -197,29 +270,15 @@ func mainErr() error {
 
 	dmsServer := &dms.Server{
 		Logger: logger.WithNames("dms", "server"),
-		Interfaces: func(ifName string) (ifs []net.Interface) {
-			var err error
+		// Interfaces are watched rather than listed here, so that the named interface is used
+		// whenever it's present.
+		InterfaceFilter: func(ifName string) func(net.Interface) bool {
 			if ifName == "" {
-				ifs, err = net.Interfaces()
-			} else {
-				var if_ *net.Interface
-				if_, err = net.InterfaceByName(ifName)
-				if if_ != nil {
-					ifs = append(ifs, *if_)
-				}
+				return nil
 			}
-			if err != nil {
-				log.Fatal(err)
-			}
-			var tmp []net.Interface
-			for _, if_ := range ifs {
-				if if_.Flags&net.FlagUp == 0 || if_.MTU <= 0 {
-					continue
-				}
-				tmp = append(tmp, if_)
+			return func(if_ net.Interface) bool {
+				return if_.Name == ifName
 			}
-			ifs = tmp
-			return
 		}(config.IfName),
 		HTTPConn: func() net.Listener {
 			conn, err := net.Listen("tcp", config.Http)
@@ -236,7 +295,13 @@ func mainErr() error {
 		AllowDynamicStreams: config.AllowDynamicStreams,
 		ForceTranscodeTo:    config.ForceTranscodeTo,
 		TranscodeLogPattern: config.TranscodeLogPattern,
+		BootIDPath:          config.BootIDPath,
 		NoProbe:             config.NoProbe,
+		PreferredLanguages:  config.PreferredLanguages,
+		SSDPIPv6:            ssdpIPv6Filter(config.SSDPIPv6),
+		SSDPSiteLocal:       config.SSDPSiteLocal,
+		MDNS:                config.MDNS,
+		BurnSubtitles:       config.BurnSubtitles,
 		Icons: func() []dms.Icon {
 			var icons []dms.Icon
 			for _, size := range config.DeviceIconSizes {
@@ -271,7 +336,23 @@ func mainErr() error {
 		IgnoreHidden:        config.IgnoreHidden,
 		IgnoreUnreadable:    config.IgnoreUnreadable,
 		IgnorePaths:         config.IgnorePaths,
-		AllowedIpNets:       config.AllowedIpNets,
+		Access: &access.Policy{
+			Rules:  accessRules,
+			Logger: logger.WithNames("access"),
+		},
+		MediaReceiversPath:     config.MediaReceiversPath,
+		ApprovedMediaReceivers: config.ApprovedMediaReceivers,
+		Writable:               config.Writable,
+		WriteAccess: &access.Policy{
+			Rules:  writeAccessRules,
+			Logger: logger.WithNames("access", "write"),
+		},
+		TrashPath: config.TrashPath,
+		CastAPI:   config.CastAPI,
+		CastAccess: &access.Policy{
+			Rules:  castAccessRules,
+			Logger: logger.WithNames("access", "cast"),
+		},
 	}
 	if err := dmsServer.Init(); err != nil {
 		log.Fatalf("error initing dms server: %v", err)
@@ -284,7 +365,7 @@ func mainErr() error {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	<-sigs
-	err := dmsServer.Close()
+	err = dmsServer.Close()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -372,34 +453,20 @@ func resizeImage(imageData image.Image, size uint) []byte {
 	return buff.Bytes()
 }
 
-func makeIpNets(s string) []*net.IPNet {
-	var nets []*net.IPNet
-	if len(s) < 1 {
-		_, ipnet, _ := net.ParseCIDR("0.0.0.0/0")
-		nets = append(nets, ipnet)
-		_, ipnet, _ = net.ParseCIDR("::/0")
-		nets = append(nets, ipnet)
-	} else {
-		for _, el := range strings.Split(s, ",") {
-			ip := net.ParseIP(el)
-
-			if ip == nil {
-				_, ipnet, err := net.ParseCIDR(el)
-				if err == nil {
-					nets = append(nets, ipnet)
-				} else {
-					log.Printf("unable to parse expression %q", el)
-				}
-
-			} else {
-				_, ipnet, err := net.ParseCIDR(el + "/32")
-				if err == nil {
-					nets = append(nets, ipnet)
-				} else {
-					log.Printf("unable to parse ip %q", el)
-				}
-			}
-		}
+// Returns the filter for dms.Server.SSDPIPv6 given "all", "none", or a comma
+// separated list of interface names.
+func ssdpIPv6Filter(s string) func(net.Interface) bool {
+	switch s {
+	case "", "all":
+		return nil
+	case "none":
+		return func(net.Interface) bool { return false }
+	}
+	names := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		names[strings.TrimSpace(name)] = true
+	}
+	return func(if_ net.Interface) bool {
+		return names[if_.Name]
 	}
-	return nets
 }
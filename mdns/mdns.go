@@ -0,0 +1,522 @@
+// Package mdns advertises a service with Multicast DNS and DNS-SD (RFC 6762
+// and RFC 6763), in the manner of the ssdp package's Server.
+package mdns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/anacrolix/dms/ssdp"
+)
+
+const (
+	AddrString  = "224.0.0.251:5353"
+	AddrString6 = "[ff02::fb]:5353"
+	port        = 5353
+	// The record TTLs recommended by RFC 6762: host records for 2 minutes,
+	// and others for 75 minutes.
+	hostTTL    = 120
+	serviceTTL = 4500
+	// The TTL limit for answers to legacy unicast queries.
+	legacyTTL = 10
+	// The cache-flush bit of record classes, marking records as unique.
+	cacheFlush = 1 << 15
+	// The bit of question classes asking for a unicast response.
+	unicastResponse = 1 << 15
+	// The name queried to enumerate service types.
+	servicesName = "_services._dns-sd._udp.local."
+)
+
+var (
+	NetAddr  *net.UDPAddr
+	NetAddr6 *net.UDPAddr
+)
+
+func init() {
+	var err error
+	if NetAddr, err = net.ResolveUDPAddr("udp4", AddrString); err != nil {
+		log.Printf("Could not resolve %s: %s", AddrString, err)
+	}
+	if NetAddr6, err = net.ResolveUDPAddr("udp6", AddrString6); err != nil {
+		log.Printf("Could not resolve %s: %s", AddrString6, err)
+	}
+}
+
+// Server answers mDNS queries for a service on an interface, for one address
+// family, and announces it when it starts and its addresses change. Names
+// aren't probed for conflicts, so they should be unique to the host.
+type Server struct {
+	Interface net.Interface
+	// Use IPv6 on the interface instead of IPv4. AAAA records are served over
+	// IPv6, and A records over IPv4.
+	IPv6 bool
+	// The service instance name, such as a device's friendly name. Dots are
+	// replaced with spaces, and it's truncated to fit in a DNS label.
+	Instance string
+	// The service type, such as "_http._tcp".
+	Service string
+	// The host name the service is on, without the ".local" domain.
+	Host string
+	Port int
+	// The TXT record strings, such as "path=/rootDesc.xml".
+	TXT      []string
+	IPFilter func(net.IP) bool
+	// The network the server runs on. The real network is used if nil.
+	Transport ssdp.Transport
+	Logger    log.Logger
+
+	serviceName  dnsmessage.Name
+	instanceName dnsmessage.Name
+	hostName     dnsmessage.Name
+	services     dnsmessage.Name
+
+	conn    ssdp.PacketConn
+	closed  chan struct{}
+	refresh chan struct{}
+}
+
+// Returns an instance name that can be used as a DNS label.
+func instanceLabel(s string) string {
+	s = strings.ReplaceAll(s, ".", " ")
+	for len(s) > 63 {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+func (me *Server) group() *net.UDPAddr {
+	if me.IPv6 {
+		return &net.UDPAddr{IP: NetAddr6.IP, Port: NetAddr6.Port, Zone: me.Interface.Name}
+	}
+	return NetAddr
+}
+
+func (me *Server) Init() (err error) {
+	if err = me.initNames(); err != nil {
+		return
+	}
+	if me.IPFilter == nil {
+		me.IPFilter = func(net.IP) bool { return true }
+	}
+	me.closed = make(chan struct{})
+	me.refresh = make(chan struct{}, 1)
+	groups := []*net.UDPAddr{NetAddr}
+	if me.IPv6 {
+		groups = []*net.UDPAddr{NetAddr6}
+	}
+	me.conn, err = me.transport().ListenMulticast(me.Interface, groups)
+	return
+}
+
+func (me *Server) initNames() (err error) {
+	for _, n := range []struct {
+		name *dnsmessage.Name
+		s    string
+	}{
+		{&me.serviceName, me.Service + ".local."},
+		{&me.instanceName, instanceLabel(me.Instance) + "." + me.Service + ".local."},
+		{&me.hostName, me.Host + ".local."},
+		{&me.services, servicesName},
+	} {
+		*n.name, err = dnsmessage.NewName(n.s)
+		if err != nil {
+			return fmt.Errorf("bad name %q: %w", n.s, err)
+		}
+	}
+	return
+}
+
+// The real network, with the multicast TTL of 255 that RFC 6762 requires.
+type netTransport struct {
+	ssdp.NetTransport
+}
+
+func (netTransport) ListenMulticast(ifi net.Interface, groups []*net.UDPAddr) (ssdp.PacketConn, error) {
+	network := "udp4"
+	if groups[0].IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenMulticastUDP(network, &ifi, groups[0])
+	if err != nil {
+		return nil, err
+	}
+	if network == "udp6" {
+		err = ipv6.NewPacketConn(conn).SetMulticastHopLimit(255)
+	} else {
+		err = ipv4.NewPacketConn(conn).SetMulticastTTL(255)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (me *Server) transport() ssdp.Transport {
+	if me.Transport == nil {
+		return netTransport{}
+	}
+	return me.Transport
+}
+
+// Returns the addresses of the interface to serve.
+func (me *Server) ips() (ret []net.IP, err error) {
+	addrs, err := me.transport().InterfaceAddrs(me.Interface)
+	if err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		var ip net.IP
+		switch val := addr.(type) {
+		case *net.IPNet:
+			ip = val.IP
+		case *net.IPAddr:
+			ip = val.IP
+		default:
+			continue
+		}
+		if (ip.To4() == nil) != me.IPv6 || !me.IPFilter(ip) {
+			continue
+		}
+		if ip.IsLinkLocalUnicast() && !me.IPv6 {
+			continue
+		}
+		ret = append(ret, ip)
+	}
+	return
+}
+
+// Refresh has the server check the interface's addresses now, and announce
+// them if they've changed.
+func (me *Server) Refresh() {
+	select {
+	case me.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Serve answers queries and announces the service until the server is
+// closed.
+func (me *Server) Serve() error {
+	readDone := make(chan error, 1)
+	go func() {
+		readDone <- me.serve()
+	}()
+	var announced string
+	for {
+		ips, err := me.ips()
+		if err != nil {
+			me.Logger.Levelf(log.Warning, "getting interface addresses: %v", err)
+		} else if key := fmt.Sprint(ips); key != announced {
+			announced = key
+			// RFC 6762 requires at least two announcements a second apart.
+			for i := 0; i < 2; i++ {
+				if i != 0 {
+					select {
+					case <-me.closed:
+						return nil
+					case <-time.After(time.Second):
+					}
+				}
+				me.send(me.response(me.allRecords(ips, 1), nil, nil), me.group())
+			}
+		}
+		select {
+		case <-me.closed:
+			return nil
+		case err := <-readDone:
+			return err
+		case <-me.refresh:
+		}
+	}
+}
+
+func (me *Server) serve() error {
+	b := make([]byte, 9000)
+	for {
+		n, from, err := me.conn.ReadFromUDP(b)
+		select {
+		case <-me.closed:
+			return nil
+		default:
+		}
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("reading from UDP socket: %w", err)
+			}
+			me.Logger.Levelf(log.Warning, "error reading from UDP socket: %v", err)
+			select {
+			case <-me.closed:
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		me.handle(b[:n], from)
+	}
+}
+
+// Close sends goodbyes for the service's records, and stops the server.
+func (me *Server) Close() {
+	close(me.closed)
+	if ips, err := me.ips(); err == nil {
+		me.send(me.response(me.allRecords(ips, 0), nil, nil), me.group())
+	}
+	me.conn.Close()
+}
+
+func (me *Server) send(b []byte, addr *net.UDPAddr) {
+	if b == nil {
+		return
+	}
+	if _, err := me.conn.WriteToUDP(b, addr); err != nil {
+		me.Logger.Levelf(log.Warning, "error writing to UDP socket: %v", err)
+	}
+}
+
+// The service's records follow. ttlScale is 0 for goodbyes, and 1 otherwise.
+
+func (me *Server) servicesPTR(ttlScale uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: me.services, Class: dnsmessage.ClassINET, TTL: serviceTTL * ttlScale},
+		Body:   &dnsmessage.PTRResource{PTR: me.serviceName},
+	}
+}
+
+func (me *Server) instancePTR(ttlScale uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: me.serviceName, Class: dnsmessage.ClassINET, TTL: serviceTTL * ttlScale},
+		Body:   &dnsmessage.PTRResource{PTR: me.instanceName},
+	}
+}
+
+func (me *Server) srv(ttlScale uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: me.instanceName, Class: dnsmessage.ClassINET | cacheFlush, TTL: hostTTL * ttlScale},
+		Body:   &dnsmessage.SRVResource{Port: uint16(me.Port), Target: me.hostName},
+	}
+}
+
+func (me *Server) txt(ttlScale uint32) dnsmessage.Resource {
+	txt := me.TXT
+	if len(txt) == 0 {
+		// A TXT record must have at least one string.
+		txt = []string{""}
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: me.instanceName, Class: dnsmessage.ClassINET | cacheFlush, TTL: serviceTTL * ttlScale},
+		Body:   &dnsmessage.TXTResource{TXT: txt},
+	}
+}
+
+func (me *Server) addrRecords(ips []net.IP, ttlScale uint32) (ret []dnsmessage.Resource) {
+	for _, ip := range ips {
+		h := dnsmessage.ResourceHeader{Name: me.hostName, Class: dnsmessage.ClassINET | cacheFlush, TTL: hostTTL * ttlScale}
+		if ip4 := ip.To4(); ip4 != nil {
+			var a dnsmessage.AResource
+			copy(a.A[:], ip4)
+			ret = append(ret, dnsmessage.Resource{Header: h, Body: &a})
+		} else {
+			var a dnsmessage.AAAAResource
+			copy(a.AAAA[:], ip.To16())
+			ret = append(ret, dnsmessage.Resource{Header: h, Body: &a})
+		}
+	}
+	return
+}
+
+func (me *Server) allRecords(ips []net.IP, ttlScale uint32) []dnsmessage.Resource {
+	return append([]dnsmessage.Resource{
+		me.servicesPTR(ttlScale),
+		me.instancePTR(ttlScale),
+		me.srv(ttlScale),
+		me.txt(ttlScale),
+	}, me.addrRecords(ips, ttlScale)...)
+}
+
+// Returns whether the query's known answers include r with at least half its
+// TTL remaining, so it needn't be sent (RFC 6762 section 7.1).
+func knownAnswer(query *dnsmessage.Message, r dnsmessage.Resource) bool {
+	ptr, ok := r.Body.(*dnsmessage.PTRResource)
+	if !ok {
+		return false
+	}
+	for _, a := range query.Answers {
+		known, ok := a.Body.(*dnsmessage.PTRResource)
+		if ok && nameEqual(a.Header.Name, r.Header.Name) && nameEqual(known.PTR, ptr.PTR) && a.Header.TTL >= r.Header.TTL/2 {
+			return true
+		}
+	}
+	return false
+}
+
+func nameEqual(a, b dnsmessage.Name) bool {
+	return strings.EqualFold(a.String(), b.String())
+}
+
+// Returns the answers and additional records for a query, and whether a
+// unicast response was asked for.
+func (me *Server) answer(query *dnsmessage.Message, ips []net.IP) (answers, additionals []dnsmessage.Resource, unicast bool) {
+	var extra []dnsmessage.Resource
+	for _, q := range query.Questions {
+		if q.Class&unicastResponse != 0 {
+			unicast = true
+		}
+		if q.Class&^unicastResponse != dnsmessage.ClassINET && q.Class&^unicastResponse != dnsmessage.ClassANY {
+			continue
+		}
+		is := func(t dnsmessage.Type) bool {
+			return q.Type == t || q.Type == dnsmessage.TypeALL
+		}
+		switch {
+		case nameEqual(q.Name, me.services):
+			if is(dnsmessage.TypePTR) {
+				answers = append(answers, me.servicesPTR(1))
+			}
+		case nameEqual(q.Name, me.serviceName):
+			if is(dnsmessage.TypePTR) {
+				answers = append(answers, me.instancePTR(1))
+				extra = append(extra, me.srv(1), me.txt(1))
+				extra = append(extra, me.addrRecords(ips, 1)...)
+			}
+		case nameEqual(q.Name, me.instanceName):
+			if is(dnsmessage.TypeSRV) {
+				answers = append(answers, me.srv(1))
+				extra = append(extra, me.addrRecords(ips, 1)...)
+			}
+			if is(dnsmessage.TypeTXT) {
+				answers = append(answers, me.txt(1))
+			}
+		case nameEqual(q.Name, me.hostName):
+			if is(dnsmessage.TypeA) && !me.IPv6 || is(dnsmessage.TypeAAAA) && me.IPv6 {
+				answers = append(answers, me.addrRecords(ips, 1)...)
+			}
+		}
+	}
+	answers = filterResources(answers, func(r dnsmessage.Resource) bool {
+		return !knownAnswer(query, r)
+	})
+	additionals = filterResources(extra, func(r dnsmessage.Resource) bool {
+		for _, a := range answers {
+			if sameResource(a, r) {
+				return false
+			}
+		}
+		return true
+	})
+	return
+}
+
+func filterResources(rs []dnsmessage.Resource, keep func(dnsmessage.Resource) bool) (ret []dnsmessage.Resource) {
+	for _, r := range rs {
+		if keep(r) {
+			ret = append(ret, r)
+		}
+	}
+	return
+}
+
+func sameResource(a, b dnsmessage.Resource) bool {
+	return nameEqual(a.Header.Name, b.Header.Name) && a.Body.GoString() == b.Body.GoString()
+}
+
+// Packs a response. Responses to legacy unicast queries echo the query's ID
+// and questions, and have no cache-flush bits and limited TTLs.
+func (me *Server) response(answers []dnsmessage.Resource, additionals []dnsmessage.Resource, legacy *dnsmessage.Message) []byte {
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     answers,
+		Additionals: additionals,
+	}
+	if legacy != nil {
+		msg.Header.ID = legacy.Header.ID
+		msg.Questions = legacy.Questions
+		for _, rs := range [][]dnsmessage.Resource{msg.Answers, msg.Additionals} {
+			for i := range rs {
+				rs[i].Header.Class &^= cacheFlush
+				if rs[i].Header.TTL > legacyTTL {
+					rs[i].Header.TTL = legacyTTL
+				}
+			}
+		}
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		me.Logger.Levelf(log.Warning, "error packing response: %v", err)
+		return nil
+	}
+	return b
+}
+
+func (me *Server) handle(b []byte, from *net.UDPAddr) {
+	var query dnsmessage.Message
+	if err := query.Unpack(b); err != nil {
+		me.Logger.Levelf(log.Debug, "error parsing message from %v: %v", from, err)
+		return
+	}
+	if query.Header.Response || query.Header.OpCode != 0 || len(query.Questions) == 0 {
+		return
+	}
+	ips, err := me.ips()
+	if err != nil {
+		me.Logger.Levelf(log.Warning, "getting interface addresses: %v", err)
+		return
+	}
+	answers, additionals, unicast := me.answer(&query, ips)
+	if len(answers) == 0 {
+		return
+	}
+	if from.Port != port {
+		// A legacy resolver, that only listens for a unicast response.
+		me.send(me.response(answers, additionals, &query), from)
+		return
+	}
+	resp := me.response(answers, additionals, nil)
+	if unicast {
+		me.send(resp, from)
+		return
+	}
+	// Shared records are answered after a random delay, to avoid collisions
+	// with other responders.
+	delay := 20*time.Millisecond + time.Duration(rand.Int63n(int64(100*time.Millisecond)))
+	go func() {
+		select {
+		case <-time.After(delay):
+			me.send(resp, me.group())
+		case <-me.closed:
+		}
+	}()
+}
+
+// HostLabel returns a host name usable in a .local domain, derived from s.
+func HostLabel(s string) string {
+	var buf bytes.Buffer
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			buf.WriteRune(r)
+		case buf.Len() != 0 && !bytes.HasSuffix(buf.Bytes(), []byte("-")):
+			buf.WriteByte('-')
+		}
+	}
+	ret := strings.TrimSuffix(buf.String(), "-")
+	if len(ret) > 63 {
+		ret = ret[:63]
+	}
+	if ret == "" {
+		ret = "dms"
+	}
+	return ret
+}
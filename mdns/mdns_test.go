@@ -0,0 +1,185 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/anacrolix/dms/ssdp"
+)
+
+func newTestServer(t *testing.T, n *ssdp.MemoryNetwork) *Server {
+	host := n.NewHost()
+	ifi, err := host.AddInterface("eth0", "lan", "192.168.1.2/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Interface: ifi,
+		Instance:  "Media on example.com",
+		Service:   "_http._tcp",
+		Host:      "example-dms",
+		Port:      1338,
+		TXT:       []string{"path=/rootDesc.xml", "udn=uuid:1234"},
+		Transport: host,
+		Logger:    log.Default,
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func query(t *testing.T, name string, typ dnsmessage.Type) []byte {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 42},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  typ,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Reads messages from conn until one arrives with answers or the timeout
+// passes.
+func readResponse(t *testing.T, conn ssdp.PacketConn, timeout time.Duration) (msg dnsmessage.Message) {
+	got := make(chan dnsmessage.Message, 1)
+	go func() {
+		b := make([]byte, 9000)
+		for {
+			n, _, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			var m dnsmessage.Message
+			if m.Unpack(b[:n]) == nil && m.Header.Response && len(m.Answers) != 0 {
+				got <- m
+				return
+			}
+		}
+	}()
+	select {
+	case msg = <-got:
+	case <-time.After(timeout):
+		t.Fatal("no response")
+	}
+	return
+}
+
+func TestLegacyUnicastQuery(t *testing.T) {
+	n := ssdp.NewMemoryNetwork()
+	s := newTestServer(t, n)
+	go s.Serve()
+	defer s.Close()
+	client := n.NewHost()
+	ifi, _ := client.AddInterface("eth0", "lan", "192.168.1.5/24")
+	conn, err := client.ListenUnicast(ifi, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(query(t, "_HTTP._tcp.local.", dnsmessage.TypePTR), NetAddr); err != nil {
+		t.Fatal(err)
+	}
+	msg := readResponse(t, conn, time.Second)
+	if msg.Header.ID != 42 || len(msg.Questions) != 1 {
+		t.Fatalf("legacy response doesn't echo query: %+v", msg.Header)
+	}
+	ptr, ok := msg.Answers[0].Body.(*dnsmessage.PTRResource)
+	if !ok || ptr.PTR.String() != "Media on example com._http._tcp.local." {
+		t.Fatalf("unexpected answer: %v", msg.Answers[0].GoString())
+	}
+	var srv *dnsmessage.SRVResource
+	var txt *dnsmessage.TXTResource
+	var a *dnsmessage.AResource
+	for _, r := range msg.Additionals {
+		if r.Header.TTL > legacyTTL || r.Header.Class != dnsmessage.ClassINET {
+			t.Errorf("unexpected legacy header %v", r.Header.GoString())
+		}
+		switch b := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			srv = b
+		case *dnsmessage.TXTResource:
+			txt = b
+		case *dnsmessage.AResource:
+			a = b
+		}
+	}
+	if srv == nil || srv.Port != 1338 || srv.Target.String() != "example-dms.local." {
+		t.Fatalf("unexpected SRV: %+v", srv)
+	}
+	if txt == nil || len(txt.TXT) != 2 || txt.TXT[1] != "udn=uuid:1234" {
+		t.Fatalf("unexpected TXT: %+v", txt)
+	}
+	if a == nil || net.IP(a.A[:]).String() != "192.168.1.2" {
+		t.Fatalf("unexpected A: %+v", a)
+	}
+}
+
+func TestAnnounceAndGoodbye(t *testing.T) {
+	n := ssdp.NewMemoryNetwork()
+	client := n.NewHost()
+	ifi, _ := client.AddInterface("eth0", "lan", "192.168.1.5/24")
+	conn, err := client.ListenMulticast(ifi, []*net.UDPAddr{NetAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := newTestServer(t, n)
+	go s.Serve()
+	msg := readResponse(t, conn, time.Second)
+	if len(msg.Answers) != 5 || msg.Answers[0].Header.TTL != serviceTTL {
+		t.Fatalf("unexpected announcement: %v", msg.GoString())
+	}
+	// Wait for the second announcement before closing.
+	readResponse(t, conn, 2*time.Second)
+	s.Close()
+	msg = readResponse(t, conn, time.Second)
+	for _, r := range msg.Answers {
+		if r.Header.TTL != 0 {
+			t.Fatalf("goodbye with TTL: %v", r.GoString())
+		}
+	}
+}
+
+func TestKnownAnswerSuppression(t *testing.T) {
+	s := &Server{Instance: "dms", Service: "_http._tcp", Host: "dms"}
+	if err := s.initNames(); err != nil {
+		t.Fatal(err)
+	}
+	var q dnsmessage.Message
+	if err := q.Unpack(query(t, "_http._tcp.local.", dnsmessage.TypePTR)); err != nil {
+		t.Fatal(err)
+	}
+	answers, _, _ := s.answer(&q, nil)
+	if len(answers) != 1 {
+		t.Fatalf("got %d answers", len(answers))
+	}
+	known := s.instancePTR(1)
+	known.Header.TTL = serviceTTL / 2
+	q.Answers = append(q.Answers, known)
+	if answers, _, _ := s.answer(&q, nil); len(answers) != 0 {
+		t.Fatalf("known answer wasn't suppressed: %v", answers)
+	}
+}
+
+func TestHostLabel(t *testing.T) {
+	for in, want := range map[string]string{
+		"My Host.example": "my-host-example",
+		"--":              "dms",
+		"nas-01":          "nas-01",
+	} {
+		if got := HostLabel(in); got != want {
+			t.Errorf("HostLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dmr"
+	"github.com/anacrolix/dms/server"
+	"github.com/anacrolix/dms/server/cli"
+)
+
+// Implements "dms renderer", which runs a MediaRenderer that plays with a command, or records
+// what it's asked to play.
+func rendererMain(args []string) error {
+	fs := flag.NewFlagSet("renderer", flag.ExitOnError)
+	friendlyName := fs.String("friendlyName", "", "renderer friendly name")
+	player := fs.String("player", "", "command to play media with, such as \"mpv --start={offset} {uri}\". Nothing is played if empty")
+	record := fs.String("record", "", "file to record what the renderer is asked to do to, when there's no player")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var recordFile *os.File
+	defer func() {
+		if recordFile != nil {
+			recordFile.Close()
+		}
+	}()
+	return cli.RunFlags(ctx, fs, args, func() (*server.UpnpDevice, error) {
+		r := &dmr.Renderer{
+			FriendlyName: *friendlyName,
+			Logger:       log.Default.WithNames("renderer"),
+		}
+		if r.FriendlyName == "" {
+			hostname, _ := os.Hostname()
+			r.FriendlyName = fmt.Sprintf("dms renderer on %s", hostname)
+		}
+		if *player != "" {
+			r.Sink = dmr.ExecSink{
+				Command: strings.Fields(*player),
+				Stdout:  os.Stdout,
+				Stderr:  os.Stderr,
+			}
+		} else {
+			var w io.Writer
+			if *record != "" {
+				var err error
+				recordFile, err = os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+				if err != nil {
+					return nil, err
+				}
+				w = recordFile
+			}
+			r.Sink = dmr.NullSink{Record: w}
+		}
+		return r.Device(), nil
+	})
+}
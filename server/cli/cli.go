@@ -0,0 +1,87 @@
+// Package cli runs a UPnP server for a device from the command line, as the example programs
+// do.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/access"
+	"github.com/anacrolix/dms/server"
+)
+
+// Main runs a server for the device with the flags of the program, until it's interrupted.
+func Main(d *server.UpnpDevice) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return Run(ctx, d, os.Args[0], os.Args[1:])
+}
+
+// Run parses the flags in args, and runs a server for the device until the context is done.
+func Run(ctx context.Context, d *server.UpnpDevice, name string, args []string) error {
+	return RunFlags(ctx, flag.NewFlagSet(name, flag.ContinueOnError), args, func() (*server.UpnpDevice, error) {
+		return d, nil
+	})
+}
+
+// RunFlags is Run for programs with flags of their own, defined in fs. The device is made by
+// newDevice once the flags are parsed.
+func RunFlags(ctx context.Context, fs *flag.FlagSet, args []string, newDevice func() (*server.UpnpDevice, error)) error {
+	ifName := fs.String("ifname", "", "specific SSDP network interface")
+	httpAddr := fs.String("http", ":1338", "http server port")
+	logHeaders := fs.Bool("logHeaders", false, "log HTTP headers")
+	allowedIps := fs.String("allowedIps", "", "comma separated clients to allow: IPs, CIDR networks or MAC addresses. Those prefixed with ! are denied")
+	stallEventSubscribe := fs.Bool("stallEventSubscribe", false, "workaround for some bad event subscribers")
+	notifyInterval := fs.Duration("notifyInterval", 180*time.Second, "interval between SSPD announces")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected positional arguments: %q", fs.Args())
+	}
+
+	logger := log.Default.WithNames("main")
+	rules, err := access.ParseRules(*allowedIps)
+	if err != nil {
+		return err
+	}
+	logger.Printf("access rules are %q", rules)
+	opts := []server.Option{
+		server.WithLogger(logger.WithNames("upnp", "server")),
+		server.WithLogHeaders(*logHeaders),
+		server.WithAccess(&access.Policy{Rules: rules, Logger: logger.WithNames("access")}),
+		server.WithStallEventSubscribe(*stallEventSubscribe),
+		server.WithNotifyInterval(*notifyInterval),
+	}
+	if *ifName != "" {
+		ifi, err := net.InterfaceByName(*ifName)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, server.WithInterfaces(*ifi))
+	}
+	d, err := newDevice()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, server.WithListener(l))
+	s, err := server.New(d, opts...)
+	if err != nil {
+		l.Close()
+		return fmt.Errorf("error initing server: %w", err)
+	}
+	return s.Run(ctx)
+}
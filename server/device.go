@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/anacrolix/dms/ssdp"
+	"github.com/anacrolix/dms/upnp"
+)
+
+// Calls f with the device, and then those embedded in it, depth first.
+func (d *UpnpDevice) walk(f func(*UpnpDevice)) {
+	f(d)
+	for _, e := range d.EmbeddedDevices {
+		e.walk(f)
+	}
+}
+
+// Gives the embedded devices without one a UUID, and the services their URLs and SCPDs. uuids
+// holds those of the devices done already, which must be unique.
+func (d *UpnpDevice) init(uuids map[string]bool) error {
+	if uuids[d.RootDeviceUUID] {
+		return fmt.Errorf("more than one device with UUID %q", d.RootDeviceUUID)
+	}
+	uuids[d.RootDeviceUUID] = true
+	ids := make(map[string]bool, len(d.ServiceList))
+	for _, s := range d.ServiceList {
+		if ids[s.ServiceId] {
+			return fmt.Errorf("device %q has more than one service %q", d.RootDeviceUUID, s.ServiceId)
+		}
+		ids[s.ServiceId] = true
+		p := path.Join(d.urlPath(), serviceIDPath(s.ServiceId))
+		s.SCPDURL = p + "/scpd.xml"
+		s.ControlURL = p + "/control"
+		s.EventSubURL = p + "/event"
+		if s.Def == nil {
+			continue
+		}
+		if err := s.Def.Check(); err != nil {
+			return fmt.Errorf("bad definition of %s: %w", s.ServiceType, err)
+		}
+		scpd, err := s.Def.SCPDXML()
+		if err != nil {
+			return err
+		}
+		s.SCPD = string(scpd)
+	}
+	for i, e := range d.EmbeddedDevices {
+		if e.RootDeviceUUID == "" {
+			e.RootDeviceUUID = MakeDeviceUuid(fmt.Sprintf("%s/%d", d.RootDeviceUUID, i))
+		}
+		if err := e.init(uuids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the path the device's services are served under.
+func (d *UpnpDevice) urlPath() string {
+	return "/upnp/" + url.PathEscape(strings.TrimPrefix(d.RootDeviceUUID, "uuid:"))
+}
+
+// Returns the ID part of a service ID such as urn:upnp-org:serviceId:SwitchPower, escaped for
+// use in a URL path.
+func serviceIDPath(id string) string {
+	const sep = ":serviceId:"
+	if i := strings.Index(id, sep); i != -1 {
+		id = id[i+len(sep):]
+	}
+	return url.PathEscape(id)
+}
+
+// Returns the handler of one of the device's services.
+func (d *UpnpDevice) serviceHandler(s *ServiceWithSCPD) (upnp.UPnPService, bool) {
+	if h, ok := d.UpnpServices[s.ServiceId]; ok {
+		return h, true
+	}
+	urn, err := upnp.ParseServiceType(s.ServiceType)
+	if err != nil {
+		return nil, false
+	}
+	h, ok := d.UpnpServices[urn.Type]
+	return h, ok
+}
+
+// Returns the description of the device, including those embedded in it.
+func (d *UpnpDevice) desc() upnp.Device {
+	ret := upnp.Device{
+		DeviceType:   d.RootDeviceType,
+		FriendlyName: d.FriendlyName,
+		Manufacturer: d.Manufacturer,
+		ModelName:    d.RootDeviceModelName,
+		UDN:          d.RootDeviceUUID,
+	}
+	for _, s := range d.ServiceList {
+		ret.ServiceList = append(ret.ServiceList, s.Service)
+	}
+	for i, di := range d.DeviceIcons {
+		ret.IconList = append(ret.IconList, upnp.Icon{
+			Height:   di.Height,
+			Width:    di.Width,
+			Depth:    di.Depth,
+			Mimetype: di.Mimetype,
+			URL:      fmt.Sprintf("%s%s/%d", d.urlPath(), deviceIconPath, i),
+		})
+	}
+	if len(d.EmbeddedDevices) != 0 {
+		ret.DeviceList = &upnp.DeviceList{}
+		for _, e := range d.EmbeddedDevices {
+			ret.DeviceList.Devices = append(ret.DeviceList.Devices, e.desc())
+		}
+	}
+	return ret
+}
+
+// Returns the device types announced for the device.
+func (d *UpnpDevice) deviceTypes() []string {
+	if len(d.Devices) != 0 {
+		return d.Devices
+	}
+	return []string{d.RootDeviceType}
+}
+
+// Returns the service types announced for the device.
+func (d *UpnpDevice) serviceTypes() (ret []string) {
+	if len(d.Services) != 0 {
+		return d.Services
+	}
+	seen := make(map[string]bool)
+	for _, s := range d.ServiceList {
+		if !seen[s.ServiceType] {
+			seen[s.ServiceType] = true
+			ret = append(ret, s.ServiceType)
+		}
+	}
+	return
+}
+
+// Returns the devices embedded in the device, at any depth, for announcing over SSDP.
+func (d *UpnpDevice) ssdpEmbedded() (ret []ssdp.EmbeddedDevice) {
+	for _, e := range d.EmbeddedDevices {
+		e.walk(func(e *UpnpDevice) {
+			ret = append(ret, ssdp.EmbeddedDevice{
+				UUID:     e.RootDeviceUUID,
+				Devices:  e.deviceTypes(),
+				Services: e.serviceTypes(),
+			})
+		})
+	}
+	return
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+var testSwitchPowerDef = upnp.ServiceDef{
+	Actions: []upnp.ActionDef{{
+		Name: "GetStatus",
+		Out:  []upnp.ArgDef{{Name: "ResultStatus", StateVar: "Status"}},
+	}},
+	StateVars: []upnp.StateVarDef{{Name: "Status", DataType: "boolean"}},
+}
+
+// A switch that answers with its status, without eventing.
+type testSwitch struct {
+	*upnp.Actions
+}
+
+func (testSwitch) Subscribe(w http.ResponseWriter, r *http.Request) error {
+	http.Error(w, "no events", http.StatusNotImplemented)
+	return nil
+}
+
+func (testSwitch) Unsubscribe(w http.ResponseWriter, r *http.Request) error {
+	http.Error(w, "no events", http.StatusNotImplemented)
+	return nil
+}
+
+func newTestSwitch(status bool) upnp.UPnPService {
+	s := testSwitch{upnp.NewActions(&testSwitchPowerDef)}
+	upnp.Bind(s.Actions, "GetStatus", func(*http.Request, struct{}) (ret struct{ ResultStatus bool }, err error) {
+		ret.ResultStatus = status
+		return
+	})
+	return s
+}
+
+func newTestLight(name string, status bool) *UpnpDevice {
+	return &UpnpDevice{
+		FriendlyName:   name,
+		RootDeviceType: "urn:schemas-upnp-org:device:BinaryLight:1",
+		ServiceList: []*ServiceWithSCPD{{
+			Service: upnp.Service{
+				ServiceType: "urn:schemas-upnp-org:service:SwitchPower:1",
+				ServiceId:   "urn:upnp-org:serviceId:SwitchPower",
+			},
+			Def: &testSwitchPowerDef,
+		}},
+		UpnpServices: map[string]upnp.UPnPService{
+			"urn:upnp-org:serviceId:SwitchPower": newTestSwitch(status),
+		},
+	}
+}
+
+func TestEmbeddedDevices(t *testing.T) {
+	root := &UpnpDevice{
+		FriendlyName:   "root",
+		RootDeviceType: "urn:schemas-upnp-org:device:Basic:1",
+		RootDeviceUUID: "uuid:root",
+		EmbeddedDevices: []*UpnpDevice{
+			newTestLight("off", false),
+			newTestLight("on", true),
+		},
+	}
+	srv := &UpnpServer{UpnpDevice: root, Logger: log.Default}
+	if err := srv.InitDevice(); err != nil {
+		t.Fatal(err)
+	}
+	off, on := root.EmbeddedDevices[0], root.EmbeddedDevices[1]
+	if off.RootDeviceUUID == "" || off.RootDeviceUUID == on.RootDeviceUUID {
+		t.Fatalf("bad embedded UUIDs %q and %q", off.RootDeviceUUID, on.RootDeviceUUID)
+	}
+
+	b, err := xml.Marshal(upnp.DeviceDesc{Device: root.desc()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desc upnp.DeviceDesc
+	if err := xml.Unmarshal(b, &desc); err != nil {
+		t.Fatal(err)
+	}
+	if l := desc.Device.DeviceList; l == nil || len(l.Devices) != 2 || l.Devices[1].UDN != on.RootDeviceUUID {
+		t.Fatalf("unexpected description: %s", b)
+	}
+
+	embedded := root.ssdpEmbedded()
+	if len(embedded) != 2 || embedded[0].UUID != off.RootDeviceUUID ||
+		len(embedded[0].Services) != 1 || embedded[0].Services[0] != "urn:schemas-upnp-org:service:SwitchPower:1" {
+		t.Fatalf("unexpected SSDP devices: %+v", embedded)
+	}
+
+	mux := http.NewServeMux()
+	srv.handleServices(mux)
+	for _, d := range []*UpnpDevice{off, on} {
+		r := httptest.NewRequest("POST", d.ServiceList[0].ControlURL, strings.NewReader(
+			`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+				`<u:GetStatus xmlns:u="urn:schemas-upnp-org:service:SwitchPower:1"/></s:Body></s:Envelope>`))
+		r.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:SwitchPower:1#GetStatus"`)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		want := "<ResultStatus>0</ResultStatus>"
+		if d == on {
+			want = "<ResultStatus>1</ResultStatus>"
+		}
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: unexpected response %d: %s", d.FriendlyName, w.Code, w.Body)
+		}
+	}
+}
+
+func TestDuplicateDeviceUUID(t *testing.T) {
+	light := newTestLight("light", false)
+	light.RootDeviceUUID = "uuid:root"
+	srv := &UpnpServer{UpnpDevice: &UpnpDevice{
+		RootDeviceUUID:  "uuid:root",
+		EmbeddedDevices: []*UpnpDevice{light},
+	}}
+	if err := srv.InitDevice(); err == nil {
+		t.Fatal("duplicate UUID not detected")
+	}
+}
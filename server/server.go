@@ -4,38 +4,30 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	_ "embed"
 	"encoding/xml"
-	"flag"
+	"errors"
 	"fmt"
-	"image"
-	"image/png"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/signal"
-	"path"
-	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
+	"github.com/anacrolix/dms/access"
 	"github.com/anacrolix/dms/dlna/dms"
 	"github.com/anacrolix/dms/soap"
 	"github.com/anacrolix/dms/ssdp"
 	"github.com/anacrolix/dms/upnp"
 	"github.com/anacrolix/log"
-	"github.com/nfnt/resize"
 )
 
-const (
-	serverVersion     = "1"
-	serviceControlURL = "/ctl"
-)
+const serverVersion = "1"
 
 // An interface with these flags should be valid for SSDP.
 const ssdpInterfaceFlags = net.FlagUp | net.FlagMulticast
@@ -50,19 +42,11 @@ var (
 // Groups the ServiceWithSCPD definition with its XML description.
 type ServiceWithSCPD struct {
 	upnp.Service
+	// If not nil, SCPD is built from it, and actions received are checked against it.
+	Def  *upnp.ServiceDef
 	SCPD string
 }
 
-// default config
-var config = &upnpConfig{
-	Path:   "",
-	IfName: "",
-	Http:   ":1338",
-
-	DeviceIcon: "",
-	LogHeaders: false,
-}
-
 // The input device
 type UpnpDevice struct {
 	ServiceList         []*ServiceWithSCPD
@@ -73,22 +57,34 @@ type UpnpDevice struct {
 	FriendlyName        string
 	Manufacturer        string
 
+	// The device and service types announced over SSDP. If empty, they're taken from
+	// RootDeviceType and ServiceList.
 	Devices  []string
 	Services []string
 
+	// The handlers of the services, keyed by service ID, or by service type, such as
+	// SwitchPower, if that's unique within the device.
 	UpnpServices map[string]upnp.UPnPService
+
+	// Devices embedded in this one, such as a BinaryLight in a generic root device. Their Root
+	// fields describe them rather than the root device. Those without a UUID are given one
+	// derived from this device's.
+	EmbeddedDevices []*UpnpDevice
 }
 
 type UpnpServer struct {
-	HTTPConn       net.Listener
-	Interfaces     []net.Interface
-	httpServeMux   *http.ServeMux
-	RootObjectPath string
-	rootDescXML    []byte
-	closed         chan struct{}
-	ssdpStopped    chan struct{}
-	// The service SOAP handler keyed by service URN.
-	services map[string]upnp.UPnPService
+	// The listener HTTP is served on. One on a random port is opened if nil.
+	HTTPConn net.Listener
+	// The interfaces SSDP is done on. Those that are up are used if nil.
+	Interfaces   []net.Interface
+	httpServeMux *http.ServeMux
+	httpServer   *http.Server
+	rootDescXML  []byte
+	closeOnce    sync.Once
+	closed       chan struct{}
+	mu           sync.Mutex
+	// Closed when SSDP has stopped, once Run has started it.
+	ssdpStopped chan struct{}
 
 	LogHeaders bool
 	// Stall event subscription requests until they drop. A workaround for
@@ -96,8 +92,8 @@ type UpnpServer struct {
 	StallEventSubscribe bool
 	// Time interval between SSPD announces
 	NotifyInterval time.Duration
-	// White list of clients
-	AllowedIpNets  []*net.IPNet
+	// Which clients may use the server, over HTTP and SSDP. Everyone may if nil.
+	Access         *access.Policy
 	Logger         log.Logger
 	eventingLogger log.Logger
 
@@ -108,163 +104,87 @@ type UpnpServer struct {
 	UpnpDevice *UpnpDevice
 }
 
-type upnpConfig struct {
-	Path                string
-	IfName              string
-	Http                string
-	DeviceIcon          string
-	LogHeaders          bool
-	StallEventSubscribe bool
-	NotifyInterval      time.Duration
-	AllowedIpNets       []*net.IPNet
-	DeviceIcons         []dms.Icon
-}
+// Option configures a server made by New.
+type Option func(*UpnpServer)
 
-func Start(d *UpnpDevice) error {
-	path := flag.String("path", config.Path, "browse root path")
-	ifName := flag.String("ifname", config.IfName, "specific SSDP network interface")
-	http := flag.String("http", config.Http, "http server port")
-	logHeaders := flag.Bool("logHeaders", config.LogHeaders, "log HTTP headers")
-	allowedIps := flag.String("allowedIps", "", "allowed ip of clients, separated by comma")
-	flag.BoolVar(&config.StallEventSubscribe, "stallEventSubscribe", false, "workaround for some bad event subscribers")
-	flag.DurationVar(&config.NotifyInterval, "notifyInterval", 180*time.Second, "interval between SSPD announces")
+// WithListener has the server serve HTTP on l, rather than a listener on a random port.
+func WithListener(l net.Listener) Option {
+	return func(s *UpnpServer) { s.HTTPConn = l }
+}
 
-	flag.Parse()
-	if flag.NArg() != 0 {
-		flag.Usage()
-		return fmt.Errorf("%s: %s\n", "unexpected positional arguments", flag.Args())
-	}
+// WithInterfaces has the server do SSDP on the interfaces, rather than every one that's up. SSDP
+// isn't done if there are none.
+func WithInterfaces(ifs ...net.Interface) Option {
+	return func(s *UpnpServer) { s.Interfaces = append([]net.Interface{}, ifs...) }
+}
 
-	logger := log.Default.WithNames("main")
-
-	config.Path, _ = filepath.Abs(*path)
-	config.IfName = *ifName
-	config.Http = *http
-	config.LogHeaders = *logHeaders
-	config.AllowedIpNets = makeIpNets(*allowedIps)
-
-	logger.Printf("allowed ip nets are %q", config.AllowedIpNets)
-
-	upnpServer := &UpnpServer{
-		UpnpDevice: d,
-		Logger:     logger.WithNames("dms", "server"),
-		Interfaces: func(ifName string) (ifs []net.Interface) {
-			var err error
-			if ifName == "" {
-				ifs, err = net.Interfaces()
-			} else {
-				var if_ *net.Interface
-				if_, err = net.InterfaceByName(ifName)
-				if if_ != nil {
-					ifs = append(ifs, *if_)
-				}
-			}
-			if err != nil {
-				log.Fatal(err)
-			}
-			var tmp []net.Interface
-			for _, if_ := range ifs {
-				if if_.Flags&net.FlagUp == 0 || if_.MTU <= 0 {
-					continue
-				}
-				tmp = append(tmp, if_)
-			}
-			ifs = tmp
-			return
-		}(config.IfName),
-		HTTPConn: func() net.Listener {
-			conn, err := net.Listen("tcp", config.Http)
-			if err != nil {
-				log.Fatal(err)
-			}
-			return conn
-		}(),
-		RootObjectPath:      filepath.Clean(config.Path),
-		LogHeaders:          config.LogHeaders,
-		StallEventSubscribe: config.StallEventSubscribe,
-		NotifyInterval:      config.NotifyInterval,
-		AllowedIpNets:       config.AllowedIpNets,
-		rootDescPath:        "/rootDesc.xml",
-	}
-	if err := upnpServer.Init(); err != nil {
-		log.Fatalf("error initing server: %v", err)
-	}
+// WithLogger sets the logger the server logs to.
+func WithLogger(l log.Logger) Option {
+	return func(s *UpnpServer) { s.Logger = l }
+}
 
-	// Use goroutine run server
-	go func() {
-		if err := upnpServer.Run(); err != nil {
-			log.Fatal(err)
-		}
-	}()
+// WithNotifyInterval sets the interval between SSDP announcements.
+func WithNotifyInterval(d time.Duration) Option {
+	return func(s *UpnpServer) { s.NotifyInterval = d }
+}
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+// WithAccess restricts the clients to those the policy allows.
+func WithAccess(p *access.Policy) Option {
+	return func(s *UpnpServer) { s.Access = p }
+}
 
-	// Hang and wait until the sigs channel receive a signal
-	<-sigs
-	err := upnpServer.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-	return nil
+// WithLogHeaders has the server log the headers of HTTP requests.
+func WithLogHeaders(b bool) Option {
+	return func(s *UpnpServer) { s.LogHeaders = b }
 }
 
-func resizeImage(imageData image.Image, size uint) []byte {
-	img := resize.Resize(size, size, imageData, resize.Lanczos3)
-	var buff bytes.Buffer
-	png.Encode(&buff, img)
-	return buff.Bytes()
+// WithStallEventSubscribe stalls event subscriptions, a workaround for some bad subscribers.
+func WithStallEventSubscribe(b bool) Option {
+	return func(s *UpnpServer) { s.StallEventSubscribe = b }
 }
 
-func readIcon(path string, size uint) []byte {
-	r, err := getIconReader(path)
-	if err != nil {
-		panic(err)
+// New returns an initialized server for the device, ready to Run.
+func New(d *UpnpDevice, opts ...Option) (*UpnpServer, error) {
+	s := &UpnpServer{
+		UpnpDevice:     d,
+		Logger:         log.Default.WithNames("upnp", "server"),
+		NotifyInterval: 30 * time.Second,
 	}
-	defer r.Close()
-	imageData, _, err := image.Decode(r)
-	if err != nil {
-		panic(err)
+	for _, opt := range opts {
+		opt(s)
 	}
-	return resizeImage(imageData, size)
-}
-
-func getIconReader(path string) (io.ReadCloser, error) {
-	if path == "" {
-		fmt.Println("Icon path is empty")
-		// return ioutil.NopCloser(bytes.NewReader(defaultIcon)), nil
+	if err := s.Init(); err != nil {
+		return nil, err
 	}
-	return os.Open(path)
+	return s, nil
 }
 
-func (s *UpnpServer) InitDevice() {
-	// Init SCPD
-	for _, s := range s.UpnpDevice.ServiceList {
-		lastInd := strings.LastIndex(s.ServiceId, ":")
-		p := path.Join("/scpd", s.ServiceId[lastInd+1:])
-		s.SCPDURL = p + ".xml"
+// InitDevice gives the devices' services their URLs, and builds SCPDs from their definitions.
+func (s *UpnpServer) InitDevice() error {
+	if s.UpnpDevice.RootDeviceUUID == "" {
+		return errors.New("root device has no UUID")
 	}
-
+	uuids := make(map[string]bool)
+	return s.UpnpDevice.init(uuids)
 }
 
 func (srv *UpnpServer) Init() (err error) {
-	srv.InitDevice()
+	if err = srv.InitDevice(); err != nil {
+		return
+	}
 
 	srv.eventingLogger = srv.Logger.WithNames("eventing")
 	srv.eventingLogger.Levelf(log.Debug, "hello %v", "world")
 
 	srv.closed = make(chan struct{})
-	if srv.HTTPConn == nil {
-		srv.HTTPConn, err = net.Listen("tcp", "")
-		if err != nil {
-			log.Print(err)
-			return
-		}
+	if srv.rootDescPath == "" {
+		srv.rootDescPath = "/rootDesc.xml"
 	}
 	if srv.Interfaces == nil {
-		ifs, err := net.Interfaces()
+		var ifs []net.Interface
+		ifs, err = net.Interfaces()
 		if err != nil {
-			log.Print(err)
+			return fmt.Errorf("getting interfaces: %w", err)
 		}
 		var tmp []net.Interface
 		for _, if_ := range ifs {
@@ -279,54 +199,52 @@ func (srv *UpnpServer) Init() (err error) {
 	srv.httpServeMux = http.NewServeMux()
 	// srv.rootDeviceUUID = MakeDeviceUuid(srv.FriendlyName)
 
+	rootDevice := srv.UpnpDevice.desc()
+	rootDevice.PresentationURL = "/"
 	srv.rootDescXML, err = xml.MarshalIndent(
 		upnp.DeviceDesc{
 			SpecVersion: upnp.SpecVersion{Major: 1, Minor: 0},
-			Device: upnp.Device{
-				DeviceType: srv.UpnpDevice.RootDeviceType,
-				// FriendlyName: srv.FriendlyName,
-				FriendlyName: srv.UpnpDevice.FriendlyName,
-				Manufacturer: srv.UpnpDevice.Manufacturer,
-				ModelName:    srv.UpnpDevice.RootDeviceModelName,
-				UDN:          srv.UpnpDevice.RootDeviceUUID,
-				VendorXML:    ``,
-				ServiceList: func() (ss []upnp.Service) {
-					for _, s := range srv.UpnpDevice.ServiceList {
-						ss = append(ss, s.Service)
-					}
-					return
-				}(),
-				IconList: func() (ret []upnp.Icon) {
-					for i, di := range srv.UpnpDevice.DeviceIcons {
-						ret = append(ret, upnp.Icon{
-							Height:   di.Height,
-							Width:    di.Width,
-							Depth:    di.Depth,
-							Mimetype: di.Mimetype,
-							URL:      fmt.Sprintf("%s/%d", deviceIconPath, i),
-						})
-					}
-					return
-				}(),
-				PresentationURL: "/",
-			},
+			Device:      rootDevice,
 		},
 		" ", "  ")
 	if err != nil {
 		return
 	}
 	srv.rootDescXML = append([]byte(`<?xml version="1.0"?>`), srv.rootDescXML...)
-	srv.Logger.Println("HTTP srv on", srv.HTTPConn.Addr())
 	srv.initMux(srv.httpServeMux)
-	srv.ssdpStopped = make(chan struct{})
+	srv.initHTTPServer()
+	if srv.HTTPConn == nil {
+		srv.HTTPConn, err = net.Listen("tcp", "")
+		if err != nil {
+			return
+		}
+	}
+	srv.Logger.Println("HTTP srv on", srv.HTTPConn.Addr())
 	return nil
 }
 
-func (srv *UpnpServer) Run() (err error) {
+// Run does SSDP and serves HTTP until the context is done, or the server is shut down.
+func (srv *UpnpServer) Run(ctx context.Context) error {
+	srv.mu.Lock()
+	select {
+	case <-srv.closed:
+		srv.mu.Unlock()
+		return http.ErrServerClosed
+	default:
+	}
+	srv.ssdpStopped = make(chan struct{})
+	srv.mu.Unlock()
 	go func() {
 		srv.doSSDP()
 		close(srv.ssdpStopped)
 	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			srv.Close()
+		case <-srv.closed:
+		}
+	}()
 	return srv.serveHTTP()
 }
 
@@ -347,10 +265,43 @@ func (me *UpnpServer) httpPort() int {
 	return me.HTTPConn.Addr().(*net.TCPAddr).Port
 }
 
+// Marks the server closed, and returns the channel closed when SSDP has stopped, which is nil if
+// it wasn't started.
+func (srv *UpnpServer) stop() chan struct{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.closeOnce.Do(func() { close(srv.closed) })
+	return srv.ssdpStopped
+}
+
+// Shutdown stops SSDP, sending byebyes, and stops serving HTTP once active requests finish, or
+// the context is done.
+func (srv *UpnpServer) Shutdown(ctx context.Context) (err error) {
+	ssdpStopped := srv.stop()
+	err = srv.httpServer.Shutdown(ctx)
+	// The listener isn't closed by Shutdown if it was never served.
+	srv.HTTPConn.Close()
+	if ssdpStopped == nil {
+		return
+	}
+	select {
+	case <-ssdpStopped:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return
+}
+
+// Close stops the server without waiting for active requests.
 func (srv *UpnpServer) Close() (err error) {
-	close(srv.closed)
-	err = srv.HTTPConn.Close()
-	<-srv.ssdpStopped
+	ssdpStopped := srv.stop()
+	err = srv.httpServer.Close()
+	srv.HTTPConn.Close()
+	if ssdpStopped != nil {
+		<-ssdpStopped
+	}
 	return
 }
 
@@ -361,9 +312,9 @@ type mitmRespWriter struct {
 	logHeader    bool
 }
 
-func (me *UpnpServer) serveHTTP() error {
-	srv := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func (me *UpnpServer) initHTTPServer() {
+	me.httpServer = &http.Server{
+		Handler: me.Access.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if me.LogHeaders {
 				fmt.Fprintf(os.Stderr, "%s %s\r\n", r.Method, r.RequestURI)
 				r.Header.Write(os.Stderr)
@@ -375,9 +326,12 @@ func (me *UpnpServer) serveHTTP() error {
 				ResponseWriter: w,
 				logHeader:      me.LogHeaders,
 			}, r)
-		}),
+		})),
 	}
-	err := srv.Serve(me.HTTPConn)
+}
+
+func (me *UpnpServer) serveHTTP() error {
+	err := me.httpServer.Serve(me.HTTPConn)
 	select {
 	case <-me.closed:
 		return nil
@@ -404,15 +358,19 @@ func (me *UpnpServer) ssdpInterface(if_ net.Interface) {
 	s := ssdp.Server{
 		Interface: if_,
 		// Devices:   devices(),
-		Devices:  me.UpnpDevice.Devices,
-		Services: me.UpnpDevice.Services,
+		Devices:  me.UpnpDevice.deviceTypes(),
+		Services: me.UpnpDevice.serviceTypes(),
+		Embedded: me.UpnpDevice.ssdpEmbedded(),
 		Location: func(ip net.IP) string {
 			return me.location(ip)
 		},
 		Server:         serverField,
 		UUID:           me.UpnpDevice.RootDeviceUUID,
 		NotifyInterval: me.NotifyInterval,
-		Logger:         logger,
+		AllowSender: func(ip net.IP) bool {
+			return me.Access.CheckLevel(ip, "M-SEARCH", log.Debug)
+		},
+		Logger: logger,
 	}
 	if err := s.Init(); err != nil {
 		if if_.Flags&ssdpInterfaceFlags != ssdpInterfaceFlags {
@@ -454,41 +412,10 @@ func (server *UpnpServer) initMux(mux *http.ServeMux) {
 		w.Header().Set("server", serverField)
 		w.Write(server.rootDescXML)
 	})
-	server.handleSCPDs(mux)
-
-	// Control
-	mux.HandleFunc(serviceControlURL, server.serviceControlHandler)
-
-	// Eventing
-	for _, s := range server.UpnpDevice.ServiceList {
-		serviceKey := getServiceKey(s.Service.ServiceType)
-
-		mux.HandleFunc(s.Service.EventSubURL, func(service upnp.UPnPService) http.HandlerFunc {
-			fmt.Println("service", service)
-			return func(w http.ResponseWriter, r *http.Request) {
-				if r.Method == "SUBSCRIBE" {
-					service.Subscribe(w, r)
-				} else if r.Method == "UNSUBSCRIBE" {
-					service.Unsubscribe(w, r)
-				} else {
-					http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-				}
-			}
-		}(server.UpnpDevice.UpnpServices[serviceKey]))
-	}
+	server.handleServices(mux)
 	// mux.HandleFunc("/debug/pprof/", pprof.Index)
 }
 
-// Input: urn:schemas-upnp-org:service:SwitchPower:1
-// Output: SwitchPower
-func getServiceKey(serviceUrn string) string {
-	parts := strings.Split(serviceUrn, ":")
-	if len(parts) < 4 {
-		panic("Invalid service URN")
-	}
-	return strings.Split(serviceUrn, ":")[3]
-}
-
 func xmlMarshalOrPanic(value interface{}) []byte {
 	ret, err := xml.MarshalIndent(value, "", "  ")
 	if err != nil {
@@ -510,8 +437,14 @@ func marshalSOAPResponse(sa upnp.SoapAction, args [][2]string) []byte {
 	return []byte(fmt.Sprintf(`<u:%[1]sResponse xmlns:u="%[2]s">%[3]s</u:%[1]sResponse>`, sa.Action, sa.ServiceURN.String(), xmlMarshalOrPanic(soapArgs)))
 }
 
-func (server *UpnpServer) serviceControlHandler(w http.ResponseWriter, r *http.Request) {
-	// Handle a service control HTTP request.
+// Handle control HTTP requests of the device's service.
+func (server *UpnpServer) serviceControlHandler(d *UpnpDevice, s *ServiceWithSCPD) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server.serviceControl(d, s, w, r)
+	}
+}
+
+func (server *UpnpServer) serviceControl(d *UpnpDevice, s *ServiceWithSCPD, w http.ResponseWriter, r *http.Request) {
 	soapActionString := r.Header.Get("SOAPACTION")
 	soapAction, err := upnp.ParseActionHTTPHeader(soapActionString)
 	if err != nil {
@@ -527,7 +460,7 @@ func (server *UpnpServer) serviceControlHandler(w http.ResponseWriter, r *http.R
 	w.Header().Set("Ext", "")
 	w.Header().Set("Server", serverField)
 	soapRespXML, code := func() ([]byte, int) {
-		respArgs, err := server.soapActionResponse(soapAction, env.Body.Action, r)
+		respArgs, err := d.soapActionResponse(s, soapAction, env.Body.Action, r)
 		if err != nil {
 			upnpErr := upnp.ConvertError(err)
 			return xmlMarshalOrPanic(soap.NewFault("UPnPError", upnpErr)), 500
@@ -543,15 +476,20 @@ func (server *UpnpServer) serviceControlHandler(w http.ResponseWriter, r *http.R
 	}
 }
 
-func (server *UpnpServer) soapActionResponse(sa upnp.SoapAction, actionRequestXML []byte, r *http.Request) ([][2]string, error) {
-	service, ok := server.UpnpDevice.UpnpServices[sa.Type]
+// Handle a SOAP request to the device's service and return the response arguments or UPnP error.
+func (d *UpnpDevice) soapActionResponse(s *ServiceWithSCPD, sa upnp.SoapAction, actionRequestXML []byte, r *http.Request) ([][2]string, error) {
+	if urn, err := upnp.ParseServiceType(s.ServiceType); err != nil || urn.Type != sa.Type {
+		return nil, upnp.Errorf(upnp.InvalidActionErrorCode, "Invalid service: %s", sa.Type)
+	}
+	service, ok := d.serviceHandler(s)
 	if !ok {
 		return nil, upnp.Errorf(upnp.InvalidActionErrorCode, "Invalid service: %s", sa.Type)
 	}
-
-	// if service.needToNotify {
-	// defer me.notify(service)
-	// }
+	if s.Def != nil {
+		if err := s.Def.CheckAction(sa.Action, actionRequestXML); err != nil {
+			return nil, err
+		}
+	}
 	return service.Handle(sa.Action, actionRequestXML, r)
 }
 
@@ -564,50 +502,38 @@ func MakeDeviceUuid(unique string) string {
 	return upnp.FormatUUID(buf)
 }
 
-// Install handlers to serve SCPD for each UPnP service.
-func (s *UpnpServer) handleSCPDs(mux *http.ServeMux) {
-
-	// TODO?? what is this used for
+// Install handlers for the SCPD, control and eventing of every device's services.
+func (s *UpnpServer) handleServices(mux *http.ServeMux) {
 	startTime := time.Now()
-
-	for _, s := range s.UpnpDevice.ServiceList {
-		mux.HandleFunc(s.SCPDURL, func(serviceDesc string) http.HandlerFunc {
-			return func(w http.ResponseWriter, r *http.Request) {
-				w.Header().Set("content-type", `text/xml; charset="utf-8"`)
-				http.ServeContent(w, r, "", startTime, bytes.NewReader([]byte(serviceDesc)))
-			}
-		}(s.SCPD))
-	}
-}
-
-func makeIpNets(s string) []*net.IPNet {
-	var nets []*net.IPNet
-	if len(s) < 1 {
-		_, ipnet, _ := net.ParseCIDR("0.0.0.0/0")
-		nets = append(nets, ipnet)
-		_, ipnet, _ = net.ParseCIDR("::/0")
-		nets = append(nets, ipnet)
-	} else {
-		for _, el := range strings.Split(s, ",") {
-			ip := net.ParseIP(el)
-
-			if ip == nil {
-				_, ipnet, err := net.ParseCIDR(el)
-				if err == nil {
-					nets = append(nets, ipnet)
-				} else {
-					log.Printf("unable to parse expression %q", el)
+	s.UpnpDevice.walk(func(d *UpnpDevice) {
+		for _, svc := range d.ServiceList {
+			mux.HandleFunc(svc.SCPDURL, func(serviceDesc string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("content-type", `text/xml; charset="utf-8"`)
+					http.ServeContent(w, r, "", startTime, bytes.NewReader([]byte(serviceDesc)))
 				}
+			}(svc.SCPD))
+			mux.HandleFunc(svc.ControlURL, s.serviceControlHandler(d, svc))
+			mux.HandleFunc(svc.EventSubURL, s.serviceEventHandler(d, svc))
+		}
+	})
+}
 
-			} else {
-				_, ipnet, err := net.ParseCIDR(el + "/32")
-				if err == nil {
-					nets = append(nets, ipnet)
-				} else {
-					log.Printf("unable to parse ip %q", el)
-				}
-			}
+// Handle subscriptions to the events of the device's service.
+func (s *UpnpServer) serviceEventHandler(d *UpnpDevice, svc *ServiceWithSCPD) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service, ok := d.serviceHandler(svc)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case "SUBSCRIBE":
+			service.Subscribe(w, r)
+		case "UNSUBSCRIBE":
+			service.Unsubscribe(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	}
-	return nets
 }
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func newTestServer(t *testing.T) *UpnpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newTestLight("light", true)
+	root.RootDeviceUUID = "uuid:light"
+	s, err := New(root, WithListener(l), WithInterfaces(), WithLogger(log.Default))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRunUntilContextDone(t *testing.T) {
+	s := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() { ran <- s.Run(ctx) }()
+	resp, err := http.Get("http://" + s.HTTPConn.Addr().String() + "/rootDesc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(b), "<UDN>uuid:light</UDN>") {
+		t.Fatalf("unexpected description: %s", b)
+	}
+	cancel()
+	select {
+	case err := <-ran:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return")
+	}
+	if err := s.Run(context.Background()); !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("Run after shutdown returned %v", err)
+	}
+}
+
+func TestShutdownWithoutRun(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", s.HTTPConn.Addr().String()); err == nil {
+		t.Fatal("listener still open")
+	}
+}
@@ -0,0 +1,50 @@
+package ssdp
+
+import "sync"
+
+// The largest value of BOOTID.UPNP.ORG and CONFIGID.UPNP.ORG before they wrap.
+const (
+	maxBootID   = 1<<31 - 1
+	maxConfigID = 1<<24 - 1
+)
+
+// BootID is the UPnP 1.1 BOOTID.UPNP.ORG of a device. It's shared by all the
+// servers advertising the device, so that they agree on it as it changes.
+type BootID struct {
+	mu    sync.Mutex
+	value uint32
+	save  func(uint32)
+}
+
+// NewBootID returns a BootID starting at value. save is called with each new
+// value so that it can be persisted, if it's not nil.
+func NewBootID(value uint32, save func(uint32)) *BootID {
+	return &BootID{value: value & maxBootID, save: save}
+}
+
+// Get returns the current boot ID.
+func (b *BootID) Get() uint32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.value
+}
+
+// Advance returns the boot ID that follows from, which a server last
+// announced. It's incremented unless another server has already done so.
+func (b *BootID) Advance(from uint32) uint32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.value == from {
+		b.value = (b.value + 1) & maxBootID
+		if b.save != nil {
+			b.save(b.value)
+		}
+	}
+	return b.value
+}
+
+// ConfigID returns a CONFIGID.UPNP.ORG value derived from a hash of a
+// device's descriptions.
+func ConfigID(hash uint32) uint32 {
+	return hash & maxConfigID
+}
@@ -0,0 +1,284 @@
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+const (
+	// The search target matching all devices and services.
+	SearchAll = "ssdp:all"
+	// The default MX of searches, in seconds.
+	DefaultMX = 2
+)
+
+// Response is an advertisement from a device, either in answer to a search or
+// a NOTIFY sent to the multicast group.
+type Response struct {
+	// The target advertised: the ST of search responses, or the NT of
+	// notifications.
+	Target   string
+	USN      string
+	Location string
+	Server   string
+	// The NTS of notifications, such as "ssdp:alive" or "ssdp:byebye". Empty
+	// for search responses.
+	NTS    string
+	MaxAge time.Duration
+	From   *net.UDPAddr
+	Header http.Header
+}
+
+// Returns the max-age from a CACHE-CONTROL header.
+func parseMaxAge(cc string) time.Duration {
+	for _, d := range strings.Split(cc, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(d), "=")
+		if !ok || !strings.EqualFold(k, "max-age") {
+			continue
+		}
+		if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return time.Duration(i) * time.Second
+		}
+	}
+	return 0
+}
+
+func responseFromHeader(h http.Header, target string, from *net.UDPAddr) Response {
+	return Response{
+		Target:   target,
+		USN:      h.Get("USN"),
+		Location: h.Get("LOCATION"),
+		Server:   h.Get("SERVER"),
+		NTS:      h.Get("NTS"),
+		MaxAge:   parseMaxAge(h.Get("CACHE-CONTROL")),
+		From:     from,
+		Header:   h,
+	}
+}
+
+// ParseResponse parses a search response datagram.
+func ParseResponse(b []byte, from *net.UDPAddr) (ret Response, err error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status: %s", resp.Status)
+		return
+	}
+	ret = responseFromHeader(resp.Header, resp.Header.Get("ST"), from)
+	return
+}
+
+// ParseNotify parses a NOTIFY datagram.
+func ParseNotify(b []byte, from *net.UDPAddr) (ret Response, err error) {
+	req, err := ReadRequest(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		return
+	}
+	if req.Method != "NOTIFY" {
+		err = fmt.Errorf("unexpected method: %q", req.Method)
+		return
+	}
+	ret = responseFromHeader(req.Header, req.Header.Get("NT"), from)
+	return
+}
+
+// SearchOptions configures Search.
+type SearchOptions struct {
+	// The search target. Defaults to SearchAll.
+	ST string
+	// The number of seconds devices may wait before responding, which is
+	// also how long responses are collected for. Defaults to DefaultMX.
+	MX int
+	// The interfaces to search on. All multicast interfaces that are up are
+	// used if this is nil.
+	Interfaces []net.Interface
+	// Search over IPv6 instead of IPv4.
+	IPv6 bool
+	// The network to search. The real network is used if nil.
+	Transport Transport
+}
+
+// MulticastInterfaces returns the interfaces that are up and capable of
+// multicast.
+func MulticastInterfaces() (ret []net.Interface, err error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+	for _, ifi := range ifs {
+		if ifi.Flags&(net.FlagUp|net.FlagMulticast) == net.FlagUp|net.FlagMulticast {
+			ret = append(ret, ifi)
+		}
+	}
+	return
+}
+
+// Returns an M-SEARCH request for st sent to the group host.
+func makeSearchMessage(host, st string, mx int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, "M-SEARCH * HTTP/1.1\r\n")
+	fmt.Fprintf(&buf, "HOST: %s\r\n", host)
+	fmt.Fprint(&buf, "MAN: \"ssdp:discover\"\r\n")
+	fmt.Fprintf(&buf, "MX: %d\r\n", mx)
+	fmt.Fprintf(&buf, "ST: %s\r\n", st)
+	fmt.Fprint(&buf, "\r\n")
+	return buf.Bytes()
+}
+
+// Search sends an M-SEARCH on each interface and returns the responses
+// collected until MX seconds have passed, or ctx is done. Responses are
+// de-duplicated by USN, keeping the first received.
+func Search(ctx context.Context, opts SearchOptions) (ret []Response, err error) {
+	if opts.ST == "" {
+		opts.ST = SearchAll
+	}
+	if opts.MX <= 0 {
+		opts.MX = DefaultMX
+	}
+	ifs := opts.Interfaces
+	if ifs == nil {
+		ifs, err = MulticastInterfaces()
+		if err != nil {
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(opts.MX)*time.Second+time.Second)
+	defer cancel()
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]bool)
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, ifi := range ifs {
+		ifi := ifi
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := searchInterface(ctx, ifi, opts, func(r Response) {
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[r.USN] {
+					return
+				}
+				seen[r.USN] = true
+				ret = append(ret, r)
+			})
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", ifi.Name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) == len(ifs) && len(errs) != 0 {
+		err = errs[0]
+	}
+	return
+}
+
+func searchInterface(ctx context.Context, ifi net.Interface, opts SearchOptions, f func(Response)) error {
+	group, host := NetAddr, AddrString
+	if opts.IPv6 {
+		host = AddrString6LinkLocal
+		group = &net.UDPAddr{IP: NetAddr6LinkLocal.IP, Port: NetAddr6LinkLocal.Port, Zone: ifi.Name}
+	}
+	conn, err := transportOrDefault(opts.Transport).ListenUnicast(ifi, opts.IPv6)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	msg := makeSearchMessage(host, opts.ST, opts.MX)
+	// Searches are sent twice, as UDP is unreliable.
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP(msg, group); err != nil {
+			return err
+		}
+	}
+	b := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFromUDP(b)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		r, err := ParseResponse(b[:n], from)
+		if err != nil {
+			continue
+		}
+		f(r)
+	}
+}
+
+// Listen calls f with each NOTIFY received on the interface's SSDP
+// multicast groups, until ctx is done. The real network is used if t is nil.
+func Listen(ctx context.Context, t Transport, ifi net.Interface, ipv6 bool, f func(Response)) error {
+	s := Server{Interface: ifi, IPv6: ipv6}
+	conn, err := transportOrDefault(t).ListenMulticast(ifi, s.groups())
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	b := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFromUDP(b)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		r, err := ParseNotify(b[:n], from)
+		if err != nil {
+			continue
+		}
+		f(r)
+	}
+}
+
+// FetchDeviceDesc gets and parses the device description at location, as
+// given in a response's LOCATION.
+func FetchDeviceDesc(ctx context.Context, location string) (*upnp.DeviceDesc, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", location, resp.Status)
+	}
+	var desc upnp.DeviceDesc
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", location, err)
+	}
+	return &desc, nil
+}
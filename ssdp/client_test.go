@@ -0,0 +1,108 @@
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseResponse(t *testing.T) {
+	b := []byte("HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=1800\r\n" +
+		"EXT:\r\n" +
+		"LOCATION: http://192.168.1.2:1338/rootDesc.xml\r\n" +
+		"SERVER: Linux/3.4 DLNADOC/1.50 UPnP/1.0 dms/1\r\n" +
+		"ST: upnp:rootdevice\r\n" +
+		"USN: uuid:1234::upnp:rootdevice\r\n" +
+		"\r\n")
+	from := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 1900}
+	r, err := ParseResponse(b, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Target != "upnp:rootdevice" || r.USN != "uuid:1234::upnp:rootdevice" ||
+		r.Location != "http://192.168.1.2:1338/rootDesc.xml" || r.MaxAge != 30*time.Minute || r.From != from {
+		t.Fatalf("unexpected response: %+v", r)
+	}
+}
+
+func TestParseNotify(t *testing.T) {
+	s := Server{UUID: "uuid:1234", Server: "dms"}
+	b := s.makeNotifyMessage(AddrString, s.adverts()[0], byebyeNTS, nil)
+	r, err := ParseNotify(b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.NTS != byebyeNTS || r.Target != rootDevice || r.USN != "uuid:1234::upnp:rootdevice" {
+		t.Fatalf("unexpected notify: %+v", r)
+	}
+	if _, err := ParseNotify(makeSearchMessage(AddrString, SearchAll, 1), nil); err == nil {
+		t.Fatal("expected error parsing M-SEARCH as NOTIFY")
+	}
+}
+
+func TestSearchMessage(t *testing.T) {
+	req, err := ReadRequest(bufio.NewReader(bytes.NewReader(makeSearchMessage(AddrString6LinkLocal, SearchAll, 3))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Server{IPv6: true}
+	if req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` || req.Header.Get("MX") != "3" ||
+		!s.isGroupHost(req.Header.Get("HOST")) {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+}
+
+func TestBootIDAdvance(t *testing.T) {
+	var saved []uint32
+	b := NewBootID(5, func(v uint32) { saved = append(saved, v) })
+	if next := b.Advance(5); next != 6 {
+		t.Fatalf("got %d, want 6", next)
+	}
+	// A second server that announced 5 shouldn't advance it again.
+	if next := b.Advance(5); next != 6 {
+		t.Fatalf("got %d, want 6", next)
+	}
+	if len(saved) != 1 || saved[0] != 6 {
+		t.Fatalf("unexpected saves: %v", saved)
+	}
+	if next := NewBootID(maxBootID, nil).Advance(maxBootID); next != 0 {
+		t.Fatalf("got %d, want wrap to 0", next)
+	}
+}
+
+func TestUPnP11Headers(t *testing.T) {
+	s := Server{UUID: "uuid:1234", Location: func(net.IP) string { return "http://192.168.1.2/" }}
+	if h := s.currentUPnP11Headers(true); h != nil {
+		t.Fatalf("got UPnP 1.1 headers without a boot ID: %v", h)
+	}
+	s.BootID = NewBootID(7, nil)
+	s.ConfigID = 42
+	s.SearchPort = 49152
+	b, err := s.makeResponse(net.IPv4(192, 168, 1, 2), s.adverts()[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ParseResponse(b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{
+		"BOOTID.UPNP.ORG":     "7",
+		"CONFIGID.UPNP.ORG":   "42",
+		"SEARCHPORT.UPNP.ORG": "49152",
+	} {
+		if got := resp.Header.Get(k); got != want {
+			t.Errorf("%s: got %q, want %q", k, got, want)
+		}
+	}
+	notify, err := ParseNotify(s.makeNotifyMessage(AddrString, s.adverts()[0], byebyeNTS, s.currentUPnP11Headers(false)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notify.Header.Get("BOOTID.UPNP.ORG") != "7" || notify.Header.Get("SEARCHPORT.UPNP.ORG") != "" {
+		t.Fatalf("unexpected byebye headers: %v", notify.Header)
+	}
+}
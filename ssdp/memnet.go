@@ -0,0 +1,272 @@
+package ssdp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// MemoryNetwork is an in-memory network of hosts connected by links, for
+// running servers and clients against each other in tests. Packets sent to a
+// multicast group are delivered to every socket that joined the group on an
+// interface attached to the sender's link, including the sender's own, as
+// with multicast loopback. Unicast packets are delivered to the socket on
+// the sender's link bound to the destination address and port.
+type MemoryNetwork struct {
+	mu       sync.Mutex
+	conns    map[*memConn]struct{}
+	nextPort int
+	nextIf   int
+}
+
+// NewMemoryNetwork returns an empty network.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		conns:    make(map[*memConn]struct{}),
+		nextPort: 40000,
+	}
+}
+
+// MemoryHost is a host on a MemoryNetwork, and the Transport for servers and
+// clients running on it.
+type MemoryHost struct {
+	net    *MemoryNetwork
+	ifaces map[string]*memIface
+}
+
+type memIface struct {
+	ifi   net.Interface
+	link  string
+	addrs []net.Addr
+}
+
+var _ Transport = (*MemoryHost)(nil)
+
+// NewHost adds a host without interfaces to the network.
+func (n *MemoryNetwork) NewHost() *MemoryHost {
+	return &MemoryHost{net: n, ifaces: make(map[string]*memIface)}
+}
+
+func parseAddrs(cidrs []string) (ret []net.Addr, err error) {
+	for _, s := range cidrs {
+		var (
+			ip    net.IP
+			ipNet *net.IPNet
+		)
+		ip, ipNet, err = net.ParseCIDR(s)
+		if err != nil {
+			return
+		}
+		ipNet.IP = ip
+		ret = append(ret, ipNet)
+	}
+	return
+}
+
+// AddInterface attaches an interface named name to link, with addresses in
+// CIDR notation, such as "192.168.1.2/24".
+func (h *MemoryHost) AddInterface(name, link string, addrs ...string) (net.Interface, error) {
+	parsed, err := parseAddrs(addrs)
+	if err != nil {
+		return net.Interface{}, err
+	}
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	h.net.nextIf++
+	ifi := net.Interface{
+		Index: h.net.nextIf,
+		MTU:   1500,
+		Name:  name,
+		Flags: net.FlagUp | net.FlagMulticast,
+	}
+	h.ifaces[name] = &memIface{ifi: ifi, link: link, addrs: parsed}
+	return ifi, nil
+}
+
+// SetAddrs replaces the addresses of the named interface.
+func (h *MemoryHost) SetAddrs(name string, addrs ...string) error {
+	parsed, err := parseAddrs(addrs)
+	if err != nil {
+		return err
+	}
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	i, ok := h.ifaces[name]
+	if !ok {
+		return fmt.Errorf("no interface %q", name)
+	}
+	i.addrs = parsed
+	return nil
+}
+
+// RemoveInterface removes the named interface from the host. Sockets on it
+// stay open but are detached from its link, and getting its addresses fails.
+func (h *MemoryHost) RemoveInterface(name string) {
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	i, ok := h.ifaces[name]
+	if !ok {
+		return
+	}
+	delete(h.ifaces, name)
+	i.link = ""
+}
+
+func (h *MemoryHost) iface(ifi net.Interface) (*memIface, error) {
+	i, ok := h.ifaces[ifi.Name]
+	if !ok {
+		return nil, fmt.Errorf("no interface %q", ifi.Name)
+	}
+	return i, nil
+}
+
+func (h *MemoryHost) InterfaceAddrs(ifi net.Interface) ([]net.Addr, error) {
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	i, err := h.iface(ifi)
+	if err != nil {
+		return nil, err
+	}
+	return append([]net.Addr(nil), i.addrs...), nil
+}
+
+// Returns the first address of the interface in the family, for use as a
+// source address.
+func (i *memIface) sourceIP(ipv6 bool) net.IP {
+	for _, a := range i.addrs {
+		ip := a.(*net.IPNet).IP
+		if (ip.To4() == nil) == ipv6 {
+			return ip
+		}
+	}
+	if ipv6 {
+		return net.IPv6unspecified
+	}
+	return net.IPv4zero
+}
+
+func (h *MemoryHost) listen(ifi net.Interface, ipv6 bool, port int, groups []*net.UDPAddr) (PacketConn, error) {
+	h.net.mu.Lock()
+	defer h.net.mu.Unlock()
+	i, err := h.iface(ifi)
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		h.net.nextPort++
+		port = h.net.nextPort
+	}
+	c := &memConn{
+		net:    h.net,
+		iface:  i,
+		ipv6:   ipv6,
+		port:   port,
+		groups: groups,
+		recv:   make(chan memPacket, 64),
+		closed: make(chan struct{}),
+	}
+	h.net.conns[c] = struct{}{}
+	return c, nil
+}
+
+func (h *MemoryHost) ListenMulticast(ifi net.Interface, groups []*net.UDPAddr) (PacketConn, error) {
+	return h.listen(ifi, groups[0].IP.To4() == nil, groups[0].Port, groups)
+}
+
+func (h *MemoryHost) ListenUnicast(ifi net.Interface, ipv6 bool) (PacketConn, error) {
+	return h.listen(ifi, ipv6, 0, nil)
+}
+
+type memPacket struct {
+	b    []byte
+	from *net.UDPAddr
+}
+
+type memConn struct {
+	net    *MemoryNetwork
+	iface  *memIface
+	ipv6   bool
+	port   int
+	groups []*net.UDPAddr
+
+	recv      chan memPacket
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+	return &net.UDPAddr{IP: c.iface.sourceIP(c.ipv6), Port: c.port}
+}
+
+func (c *memConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case p := <-c.recv:
+		return copy(b, p.b), p.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// Returns whether the socket receives packets sent to addr on its link. The
+// network lock must be held.
+func (c *memConn) accepts(addr *net.UDPAddr) bool {
+	if addr.Port != c.port {
+		return false
+	}
+	if addr.IP.IsMulticast() {
+		for _, g := range c.groups {
+			if g.IP.Equal(addr.IP) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, a := range c.iface.addrs {
+		if a.(*net.IPNet).IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *memConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+	if c.iface.link == "" {
+		// The interface was removed.
+		return len(b), nil
+	}
+	from := &net.UDPAddr{IP: c.iface.sourceIP(c.ipv6), Port: c.port}
+	if c.ipv6 {
+		from.Zone = c.iface.ifi.Name
+	}
+	for other := range c.net.conns {
+		if other.iface.link != c.iface.link || other.ipv6 != c.ipv6 || !other.accepts(addr) {
+			continue
+		}
+		p := memPacket{b: append([]byte(nil), b...), from: from}
+		select {
+		case other.recv <- p:
+		default:
+			// Dropped, as a full socket buffer would.
+		}
+	}
+	return len(b), nil
+}
+
+func (c *memConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.net.mu.Lock()
+		delete(c.net.conns, c)
+		c.net.mu.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
@@ -3,6 +3,7 @@ package ssdp
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -15,23 +16,53 @@ import (
 
 	"github.com/anacrolix/log"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 const (
 	AddrString = "239.255.255.250:1900"
-	rootDevice = "upnp:rootdevice"
-	aliveNTS   = "ssdp:alive"
-	byebyeNTS  = "ssdp:byebye"
-	mxMax      = 10
+	// The IPv6 link-local and site-local SSDP multicast groups.
+	AddrString6LinkLocal = "[ff02::c]:1900"
+	AddrString6SiteLocal = "[ff05::c]:1900"
+	rootDevice           = "upnp:rootdevice"
+	aliveNTS             = "ssdp:alive"
+	byebyeNTS            = "ssdp:byebye"
+	updateNTS            = "ssdp:update"
+	mxMax                = 10
 )
 
-var NetAddr *net.UDPAddr
+const (
+	// Failed reads are retried after delays doubling from the first to the
+	// second, and given up on after maxReadErrors in a row.
+	minReadRetryDelay = 10 * time.Millisecond
+	maxReadRetryDelay = 5 * time.Second
+	maxReadErrors     = 10
+	// The first delay before getting the interface's addresses is retried.
+	// It doubles up to the NotifyInterval.
+	minAddrsRetryDelay = time.Second
+)
+
+var (
+	NetAddr           *net.UDPAddr
+	NetAddr6LinkLocal *net.UDPAddr
+	NetAddr6SiteLocal *net.UDPAddr
+)
 
 func init() {
-	var err error
-	NetAddr, err = net.ResolveUDPAddr("udp4", AddrString)
-	if err != nil {
-		log.Printf("Could not resolve %s: %s", AddrString, err)
+	for _, a := range []struct {
+		addr    **net.UDPAddr
+		network string
+		s       string
+	}{
+		{&NetAddr, "udp4", AddrString},
+		{&NetAddr6LinkLocal, "udp6", AddrString6LinkLocal},
+		{&NetAddr6SiteLocal, "udp6", AddrString6SiteLocal},
+	} {
+		var err error
+		*a.addr, err = net.ResolveUDPAddr(a.network, a.s)
+		if err != nil {
+			log.Printf("Could not resolve %s: %s", a.s, err)
+		}
 	}
 }
 
@@ -79,24 +110,137 @@ func ReadRequest(b *bufio.Reader) (req *http.Request, err error) {
 }
 
 type Server struct {
-	conn           *net.UDPConn
-	Interface      net.Interface
-	Server         string
-	Services       []string
-	Devices        []string
-	IPFilter       func(net.IP) bool
-	Location       func(net.IP) string
-	UUID           string
+	conn      PacketConn
+	Interface net.Interface
+	// The network the server runs on. The real network is used if nil.
+	Transport Transport
+	// Use IPv6 on the interface instead of IPv4.
+	IPv6 bool
+	// Also use the IPv6 site-local group, in addition to link-local.
+	SiteLocal bool
+	Server    string
+	Services  []string
+	Devices   []string
+	IPFilter  func(net.IP) bool
+	// If not nil, searches are only answered if it returns true for the sender's IP.
+	AllowSender func(net.IP) bool
+	Location    func(net.IP) string
+	UUID        string
+	// Devices embedded in the root device, announced with their own UUIDs.
+	Embedded       []EmbeddedDevice
 	NotifyInterval time.Duration
-	closed         chan struct{}
-	Logger         log.Logger
+	// The UPnP 1.1 boot ID of the device. The UPnP 1.1 headers are only sent
+	// if this is set.
+	BootID *BootID
+	// The UPnP 1.1 CONFIGID.UPNP.ORG of the device's descriptions.
+	ConfigID uint32
+	// The port unicast searches are answered on, if not the SSDP port. Such
+	// searches are passed to HandleSearch.
+	SearchPort int
+	// Called with errors the server recovers from, such as failed reads. They're logged if this
+	// is nil. Errors the server can't recover from are returned by Serve.
+	OnError func(error)
+	closed  chan struct{}
+	refresh chan struct{}
+	// Closed when reading stops, after readErr is set.
+	readDone chan struct{}
+	readErr  error
+	Logger   log.Logger
+}
+
+// Reports an error the server has recovered from.
+func (me *Server) error(err error) {
+	if me.OnError != nil {
+		me.OnError(err)
+		return
+	}
+	me.Logger.Levelf(log.Warning, "%v", err)
+}
+
+// NextRetryDelay returns the delay to wait after d, which doubles from min up to max. It's for
+// backing off retries, starting from a zero d.
+func NextRetryDelay(d, min, max time.Duration) time.Duration {
+	d *= 2
+	if d < min {
+		d = min
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// Returns the IP of an interface address, if it's of a known type.
+func addrIP(addr net.Addr) (net.IP, bool) {
+	switch val := addr.(type) {
+	case *net.IPNet:
+		return val.IP, true
+	case *net.IPAddr:
+		return val.IP, true
+	}
+	return nil, false
+}
+
+// Returns the multicast groups the server sends to and listens on.
+func (me *Server) groups() []*net.UDPAddr {
+	if !me.IPv6 {
+		return []*net.UDPAddr{NetAddr}
+	}
+	if me.SiteLocal {
+		return []*net.UDPAddr{NetAddr6LinkLocal, NetAddr6SiteLocal}
+	}
+	return []*net.UDPAddr{NetAddr6LinkLocal}
+}
+
+// Returns whether ip is of the address family the server uses.
+func (me *Server) family(ip net.IP) bool {
+	return (ip.To4() == nil) == me.IPv6
+}
+
+// Returns whether host is one of the server's multicast groups, as given in
+// a HOST header.
+func (me *Server) isGroupHost(host string) bool {
+	for _, g := range me.groups() {
+		if strings.EqualFold(host, hostString(g)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the HOST header value for a multicast group.
+func hostString(addr *net.UDPAddr) string {
+	switch addr {
+	case NetAddr6LinkLocal:
+		return AddrString6LinkLocal
+	case NetAddr6SiteLocal:
+		return AddrString6SiteLocal
+	}
+	return AddrString
 }
 
-func makeConn(ifi net.Interface) (ret *net.UDPConn, err error) {
-	ret, err = net.ListenMulticastUDP("udp", &ifi, NetAddr)
+func makeConn(ifi net.Interface, groups []*net.UDPAddr) (ret *net.UDPConn, err error) {
+	network := "udp4"
+	if groups[0].IP.To4() == nil {
+		network = "udp6"
+	}
+	ret, err = net.ListenMulticastUDP(network, &ifi, groups[0])
 	if err != nil {
 		return
 	}
+	if network == "udp6" {
+		p := ipv6.NewPacketConn(ret)
+		for _, g := range groups[1:] {
+			if err = p.JoinGroup(&ifi, &net.UDPAddr{IP: g.IP}); err != nil {
+				ret.Close()
+				return
+			}
+		}
+		if err := p.SetMulticastHopLimit(2); err != nil {
+			log.Print(err)
+		}
+		return
+	}
 	p := ipv4.NewPacketConn(ret)
 	if err := p.SetMulticastTTL(2); err != nil {
 		log.Print(err)
@@ -107,7 +251,15 @@ func makeConn(ifi net.Interface) (ret *net.UDPConn, err error) {
 	return
 }
 
+// Reads and handles packets until the server is closed. Read errors are
+// retried with backoff, until there are too many in a row or the socket is
+// closed under us, when readErr is set.
 func (me *Server) serve() {
+	defer close(me.readDone)
+	var (
+		errs  int
+		delay time.Duration
+	)
 	for {
 		size := me.Interface.MTU
 		if size > 65536 {
@@ -123,16 +275,31 @@ func (me *Server) serve() {
 		default:
 		}
 		if err != nil {
-			me.Logger.Printf("error reading from UDP socket: %s", err)
-			break
+			err = fmt.Errorf("reading from UDP socket: %w", err)
+			errs++
+			if errs >= maxReadErrors || errors.Is(err, net.ErrClosed) {
+				me.readErr = err
+				return
+			}
+			me.error(err)
+			delay = NextRetryDelay(delay, minReadRetryDelay, maxReadRetryDelay)
+			select {
+			case <-time.After(delay):
+			case <-me.closed:
+				return
+			}
+			continue
 		}
-		go me.handle(b[:n], addr)
+		errs, delay = 0, 0
+		go me.handle(b[:n], addr, false)
 	}
 }
 
 func (me *Server) Init() (err error) {
 	me.closed = make(chan struct{})
-	me.conn, err = makeConn(me.Interface)
+	me.refresh = make(chan struct{}, 1)
+	me.readDone = make(chan struct{})
+	me.conn, err = transportOrDefault(me.Transport).ListenMulticast(me.Interface, me.groups())
 	if me.IPFilter == nil {
 		me.IPFilter = func(net.IP) bool { return true }
 	}
@@ -145,61 +312,184 @@ func (me *Server) Close() {
 	me.conn.Close()
 }
 
-func (me *Server) Serve() (err error) {
+// Returns the addresses of the interface to announce.
+func (me *Server) announceIPs() (ret []net.IP, err error) {
+	addrs, err := transportOrDefault(me.Transport).InterfaceAddrs(me.Interface)
+	if err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		ip, ok := addrIP(addr)
+		if !ok {
+			me.Logger.Levelf(log.Debug, "ignoring address %v of unexpected type %T", addr, addr)
+			continue
+		}
+		if !me.family(ip) || !me.IPFilter(ip) {
+			continue
+		}
+		if ip.IsLinkLocalUnicast() && !me.IPv6 {
+			// These addresses seem to confuse VLC. Possibly there's supposed to be a zone
+			// included in the address, but I don't see one. IPv6 SSDP is link scoped, so
+			// link-local addresses are expected there.
+			continue
+		}
+		ret = append(ret, ip)
+	}
+	return
+}
+
+// Refresh has the server check the interface's addresses now instead of at
+// the next notify interval. Changed addresses are announced immediately, with
+// a byebye first if any were removed.
+func (me *Server) Refresh() {
+	select {
+	case me.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Serve announces the device until the server is closed, or it can no longer
+// read from its socket. Failing to get the interface's addresses is reported
+// to OnError and retried.
+func (me *Server) Serve() error {
 	go me.serve()
+	var (
+		announced map[string]bool
+		next      time.Time
+		// The boot ID last announced.
+		bootID uint32
+		// The delay before retrying getting the interface's addresses.
+		addrsRetry time.Duration
+	)
 	for {
 		select {
 		case <-me.closed:
-			return
+			return nil
+		case <-me.readDone:
+			return me.readErr
 		default:
 		}
 
-		addrs, err := me.Interface.Addrs()
+		ips, err := me.announceIPs()
 		if err != nil {
-			return err
+			me.error(fmt.Errorf("getting interface addresses: %w", err))
+			addrsRetry = NextRetryDelay(addrsRetry, minAddrsRetryDelay, me.NotifyInterval)
+			next = time.Now().Add(addrsRetry)
+			ips = nil
+		} else {
+			addrsRetry = 0
 		}
-		for _, addr := range addrs {
-			ip := func() net.IP {
-				switch val := addr.(type) {
-				case *net.IPNet:
-					return val.IP
-				case *net.IPAddr:
-					return val.IP
-				}
-				panic(fmt.Sprint("unexpected addr type:", addr))
-			}()
-			if !me.IPFilter(ip) {
-				continue
+		current := make(map[string]bool, len(ips))
+		changed := len(ips) != len(announced)
+		for _, ip := range ips {
+			current[ip.String()] = true
+			if !announced[ip.String()] {
+				changed = true
 			}
-			if ip.IsLinkLocalUnicast() {
-				// These addresses seem to confuse VLC. Possibly there's supposed to be a zone
-				// included in the address, but I don't see one.
-				continue
+		}
+		if me.BootID != nil && announced != nil && me.BootID.Get() != bootID {
+			// Another server advertising the device has moved it to a new boot ID.
+			changed = true
+		}
+		if err == nil && (changed || !time.Now().Before(next)) {
+			if changed && announced != nil {
+				me.Logger.Levelf(log.Debug, "addresses changed to %v", ips)
+				me.announceChange(announced, current, ips, bootID)
+			}
+			if me.BootID != nil {
+				bootID = me.BootID.Get()
+			}
+			for _, ip := range ips {
+				extraHdrs := [][2]string{
+					{"CACHE-CONTROL", fmt.Sprintf("max-age=%d", 5*me.NotifyInterval/2/time.Second)},
+					{"LOCATION", me.Location(ip)},
+				}
+				extraHdrs = append(extraHdrs, me.upnp11Headers(bootID, true)...)
+				me.notifyAll(aliveNTS, extraHdrs)
 			}
+			announced = current
+			next = time.Now().Add(me.NotifyInterval)
+		}
+		select {
+		case <-me.closed:
+			return nil
+		case <-me.readDone:
+			return me.readErr
+		case <-me.refresh:
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// Announces that the interface's addresses have changed from announced to
+// current, before the new addresses are announced as alive. UPnP 1.1 control
+// points are told the boot ID that follows bootID with ssdp:update. Otherwise
+// byebye is sent if addresses were removed, so control points forget them.
+func (me *Server) announceChange(announced, current map[string]bool, ips []net.IP, bootID uint32) {
+	if me.BootID != nil {
+		next := me.BootID.Advance(bootID)
+		for _, ip := range ips {
 			extraHdrs := [][2]string{
-				{"CACHE-CONTROL", fmt.Sprintf("max-age=%d", 5*me.NotifyInterval/2/time.Second)},
 				{"LOCATION", me.Location(ip)},
 			}
-			me.notifyAll(aliveNTS, extraHdrs)
+			extraHdrs = append(extraHdrs, me.upnp11Headers(bootID, true)...)
+			extraHdrs = append(extraHdrs, [2]string{"NEXTBOOTID.UPNP.ORG", strconv.FormatUint(uint64(next), 10)})
+			me.notifyAll(updateNTS, extraHdrs)
+		}
+		return
+	}
+	for ip := range announced {
+		if !current[ip] {
+			me.sendByeBye()
+			return
 		}
-		time.Sleep(me.NotifyInterval)
 	}
 }
 
-func (me *Server) usnFromTarget(target string) string {
-	if target == me.UUID {
+// Returns the UPnP 1.1 headers for a message sent with the given boot ID, or
+// nil if the server isn't doing UPnP 1.1. SEARCHPORT.UPNP.ORG is only included
+// if withSearchPort.
+func (me *Server) upnp11Headers(bootID uint32, withSearchPort bool) (ret [][2]string) {
+	if me.BootID == nil {
+		return
+	}
+	ret = [][2]string{
+		{"BOOTID.UPNP.ORG", strconv.FormatUint(uint64(bootID), 10)},
+		{"CONFIGID.UPNP.ORG", strconv.FormatUint(uint64(me.ConfigID), 10)},
+	}
+	if withSearchPort && me.SearchPort != 0 && me.SearchPort != NetAddr.Port {
+		ret = append(ret, [2]string{"SEARCHPORT.UPNP.ORG", strconv.Itoa(me.SearchPort)})
+	}
+	return
+}
+
+// EmbeddedDevice is a device within the root device, with its own UUID.
+type EmbeddedDevice struct {
+	UUID     string
+	Devices  []string
+	Services []string
+}
+
+// A target announced and searched for, and its USN.
+type advert struct {
+	target string
+	usn    string
+}
+
+func usnFromTarget(uuid, target string) string {
+	if target == uuid {
 		return target
 	}
-	return me.UUID + "::" + target
+	return uuid + "::" + target
 }
 
-func (me *Server) makeNotifyMessage(target, nts string, extraHdrs [][2]string) []byte {
+func (me *Server) makeNotifyMessage(host string, adv advert, nts string, extraHdrs [][2]string) []byte {
 	lines := [...][2]string{
-		{"HOST", AddrString},
-		{"NT", target},
+		{"HOST", host},
+		{"NT", adv.target},
 		{"NTS", nts},
 		{"SERVER", me.Server},
-		{"USN", me.usnFromTarget(target)},
+		{"USN", adv.usn},
 	}
 	buf := &bytes.Buffer{}
 	fmt.Fprint(buf, "NOTIFY * HTTP/1.1\r\n")
@@ -240,32 +530,73 @@ func (me *Server) log(args ...interface{}) {
 }
 
 func (me *Server) sendByeBye() {
-	for _, type_ := range me.allTypes() {
-		buf := me.makeNotifyMessage(type_, byebyeNTS, nil)
-		me.send(buf, NetAddr)
+	for _, g := range me.groups() {
+		for _, adv := range me.adverts() {
+			buf := me.makeNotifyMessage(hostString(g), adv, byebyeNTS, me.currentUPnP11Headers(false))
+			me.send(buf, me.groupAddr(g))
+		}
+	}
+}
+
+// Returns the UPnP 1.1 headers with the current boot ID.
+func (me *Server) currentUPnP11Headers(withSearchPort bool) [][2]string {
+	if me.BootID == nil {
+		return nil
 	}
+	return me.upnp11Headers(me.BootID.Get(), withSearchPort)
 }
 
 func (me *Server) notifyAll(nts string, extraHdrs [][2]string) {
-	for _, type_ := range me.allTypes() {
-		buf := me.makeNotifyMessage(type_, nts, extraHdrs)
-		delay := time.Duration(rand.Int63n(int64(100 * time.Millisecond)))
-		me.delayedSend(delay, buf, NetAddr)
+	for _, g := range me.groups() {
+		for _, adv := range me.adverts() {
+			buf := me.makeNotifyMessage(hostString(g), adv, nts, extraHdrs)
+			delay := time.Duration(rand.Int63n(int64(100 * time.Millisecond)))
+			me.delayedSend(delay, buf, me.groupAddr(g))
+		}
+	}
+}
+
+// Returns the destination for sending to a multicast group. Link-local IPv6
+// groups need the zone of the interface.
+func (me *Server) groupAddr(g *net.UDPAddr) *net.UDPAddr {
+	if g == NetAddr6LinkLocal {
+		return &net.UDPAddr{IP: g.IP, Port: g.Port, Zone: me.Interface.Name}
 	}
+	return g
 }
 
-func (me *Server) allTypes() (ret []string) {
+// Returns what's announced for a device, and its services.
+func deviceAdverts(uuid string, devices, services []string) (ret []advert) {
 	for _, a := range [][]string{
-		{rootDevice, me.UUID},
-		me.Devices,
-		me.Services,
+		{uuid},
+		devices,
+		services,
 	} {
-		ret = append(ret, a...)
+		for _, target := range a {
+			ret = append(ret, advert{target, usnFromTarget(uuid, target)})
+		}
 	}
 	return
 }
 
-func (me *Server) handle(buf []byte, sender *net.UDPAddr) {
+// Returns everything announced, for the root device and those embedded in it.
+func (me *Server) adverts() (ret []advert) {
+	ret = append(ret, advert{rootDevice, usnFromTarget(me.UUID, rootDevice)})
+	ret = append(ret, deviceAdverts(me.UUID, me.Devices, me.Services)...)
+	for _, e := range me.Embedded {
+		ret = append(ret, deviceAdverts(e.UUID, e.Devices, e.Services)...)
+	}
+	return
+}
+
+// HandleSearch answers an M-SEARCH received by unicast on the SearchPort.
+// Unicast searches are answered immediately, and only if the sender is on one
+// of the interface's networks.
+func (me *Server) HandleSearch(buf []byte, sender *net.UDPAddr) {
+	me.handle(buf, sender, true)
+}
+
+func (me *Server) handle(buf []byte, sender *net.UDPAddr, unicast bool) {
 	req, err := ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
 	if err != nil {
 		me.Logger.Println(err)
@@ -274,8 +605,11 @@ func (me *Server) handle(buf []byte, sender *net.UDPAddr) {
 	if req.Method != "M-SEARCH" || req.Header.Get("man") != `"ssdp:discover"` {
 		return
 	}
+	if me.AllowSender != nil && !me.AllowSender(sender.IP) {
+		return
+	}
 	var mx int64
-	if req.Header.Get("Host") == AddrString {
+	if me.isGroupHost(req.Header.Get("Host")) {
 		mxHeader := req.Header.Get("mx")
 		i, err := strconv.ParseUint(mxHeader, 0, 0)
 		if err != nil {
@@ -291,49 +625,63 @@ func (me *Server) handle(buf []byte, sender *net.UDPAddr) {
 	if mx > mxMax {
 		mx = mxMax
 	}
-	types := func(st string) []string {
+	// Every device with a matching type answers, so there can be more than one.
+	adverts := func(st string) (ret []advert) {
 		if st == "ssdp:all" {
-			return me.allTypes()
+			return me.adverts()
 		}
-		for _, t := range me.allTypes() {
-			if t == st {
-				return []string{t}
+		for _, adv := range me.adverts() {
+			if adv.target == st {
+				ret = append(ret, adv)
 			}
 		}
-		return nil
+		return
 	}(req.Header.Get("st"))
+	addrs, err := transportOrDefault(me.Transport).InterfaceAddrs(me.Interface)
+	if err != nil {
+		me.error(fmt.Errorf("getting interface addresses to answer search: %w", err))
+		return
+	}
 	for _, ip := range func() (ret []net.IP) {
-		addrs, err := me.Interface.Addrs()
-		if err != nil {
-			panic(err)
-		}
 		for _, addr := range addrs {
 			if ip, ok := func() (net.IP, bool) {
 				switch data := addr.(type) {
 				case *net.IPNet:
+					if !me.family(data.IP) {
+						return nil, false
+					}
 					if data.Contains(sender.IP) {
 						return data.IP, true
 					}
 					return nil, false
 				case *net.IPAddr:
-					return data.IP, true
+					return data.IP, me.family(data.IP)
 				}
-				panic(addr)
+				me.Logger.Levelf(log.Debug, "ignoring address %v of unexpected type %T", addr, addr)
+				return nil, false
 			}(); ok {
 				ret = append(ret, ip)
 			}
 		}
 		return
 	}() {
-		for _, type_ := range types {
-			resp := me.makeResponse(ip, type_, req)
+		for _, adv := range adverts {
+			resp, err := me.makeResponse(ip, adv, req)
+			if err != nil {
+				me.error(fmt.Errorf("making search response: %w", err))
+				return
+			}
+			if unicast {
+				me.send(resp, sender)
+				continue
+			}
 			delay := time.Duration(rand.Int63n(int64(time.Second) * mx))
 			me.delayedSend(delay, resp, sender)
 		}
 	}
 }
 
-func (me *Server) makeResponse(ip net.IP, targ string, req *http.Request) (ret []byte) {
+func (me *Server) makeResponse(ip net.IP, adv advert, req *http.Request) ([]byte, error) {
 	resp := &http.Response{
 		StatusCode: 200,
 		ProtoMajor: 1,
@@ -346,14 +694,17 @@ func (me *Server) makeResponse(ip net.IP, targ string, req *http.Request) (ret [
 		{"EXT", ""},
 		{"LOCATION", me.Location(ip)},
 		{"SERVER", me.Server},
-		{"ST", targ},
-		{"USN", me.usnFromTarget(targ)},
+		{"ST", adv.target},
+		{"USN", adv.usn},
 	} {
 		resp.Header.Set(pair[0], pair[1])
 	}
+	for _, pair := range me.currentUPnP11Headers(true) {
+		resp.Header.Set(pair[0], pair[1])
+	}
 	buf := &bytes.Buffer{}
 	if err := resp.Write(buf); err != nil {
-		panic(err)
+		return nil, err
 	}
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
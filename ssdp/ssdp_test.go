@@ -0,0 +1,387 @@
+package ssdp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+const (
+	testDevice  = "urn:schemas-upnp-org:device:MediaServer:1"
+	testService = "urn:schemas-upnp-org:service:ContentDirectory:1"
+)
+
+func addInterface(t *testing.T, h *MemoryHost, name, link string, addrs ...string) net.Interface {
+	ifi, err := h.AddInterface(name, link, addrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ifi
+}
+
+// Returns an initialized server that isn't serving yet.
+func initTestServer(t *testing.T, host *MemoryHost, ifi net.Interface, ipv6 bool) *Server {
+	s := &Server{
+		Interface:      ifi,
+		IPv6:           ipv6,
+		Transport:      host,
+		Server:         "test/1 UPnP/1.0",
+		Devices:        []string{testDevice},
+		Services:       []string{testService},
+		UUID:           "uuid:1234",
+		Location:       func(ip net.IP) string { return "http://" + net.JoinHostPort(ip.String(), "1338") + "/" },
+		NotifyInterval: time.Minute,
+		Logger:         log.Default,
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestServer(t *testing.T, host *MemoryHost, ifi net.Interface, ipv6 bool) *Server {
+	s := initTestServer(t, host, ifi, ipv6)
+	go s.Serve()
+	return s
+}
+
+func TestSearchTargets(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	s := newTestServer(t, serverHost, addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24"), false)
+	// Cleanup rather than defer, as the parallel subtests run after this returns.
+	t.Cleanup(s.Close)
+	client := n.NewHost()
+	ifi := addInterface(t, client, "eth0", "lan", "192.168.1.5/24")
+	for _, tc := range []struct {
+		st   string
+		want []string
+	}{
+		{testService, []string{testService}},
+		{SearchAll, []string{rootDevice, testDevice, testService, "uuid:1234"}},
+		{"urn:schemas-upnp-org:service:Unknown:1", nil},
+	} {
+		tc := tc
+		t.Run(tc.st, func(t *testing.T) {
+			t.Parallel()
+			resps, err := Search(context.Background(), SearchOptions{
+				ST:         tc.st,
+				MX:         1,
+				Interfaces: []net.Interface{ifi},
+				Transport:  client,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range resps {
+				got = append(got, r.Target)
+				if r.Location != "http://192.168.1.2:1338/" {
+					t.Errorf("unexpected location %q", r.Location)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Fatalf("got targets %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Reads packets from conn until it's closed, passing them to f with the time
+// they arrived.
+func readAll(conn PacketConn, f func([]byte, time.Time)) {
+	b := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		f(append([]byte(nil), b[:n]...), time.Now())
+	}
+}
+
+func TestSearchMXDelay(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	s := newTestServer(t, serverHost, addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24"), false)
+	defer s.Close()
+	client := n.NewHost()
+	conn, err := client.ListenUnicast(addInterface(t, client, "eth0", "lan", "192.168.1.5/24"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu       sync.Mutex
+		arrivals []time.Duration
+	)
+	sent := time.Now()
+	if _, err := conn.WriteToUDP(makeSearchMessage(AddrString, SearchAll, 1), NetAddr); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(1500*time.Millisecond, func() { conn.Close() })
+	readAll(conn, func(_ []byte, at time.Time) {
+		mu.Lock()
+		arrivals = append(arrivals, at.Sub(sent))
+		mu.Unlock()
+	})
+	if len(arrivals) != 4 {
+		t.Fatalf("got %d responses, want 4", len(arrivals))
+	}
+	for _, d := range arrivals {
+		if d > 1100*time.Millisecond {
+			t.Errorf("response after %v exceeds MX", d)
+		}
+	}
+}
+
+func TestHandleSearchUnicast(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	s := newTestServer(t, serverHost, addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24"), false)
+	defer s.Close()
+	client := n.NewHost()
+	conn, err := client.ListenUnicast(addInterface(t, client, "eth0", "lan", "192.168.1.5/24"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// MX is ignored for unicast searches.
+	s.HandleSearch(makeSearchMessage("192.168.1.2:49152", rootDevice, 5), conn.LocalAddr().(*net.UDPAddr))
+	got := make(chan Response, 1)
+	go readAll(conn, func(b []byte, _ time.Time) {
+		if r, err := ParseResponse(b, nil); err == nil {
+			got <- r
+		}
+	})
+	select {
+	case r := <-got:
+		if r.Target != rootDevice {
+			t.Fatalf("unexpected response: %+v", r)
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("unicast search not answered immediately")
+	}
+}
+
+func TestByeByeOnClose(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	serverIf := addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24")
+	client := n.NewHost()
+	clientIf := addInterface(t, client, "eth0", "lan", "192.168.1.5/24")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifies := make(chan Response, 100)
+	listening := make(chan error, 1)
+	go func() {
+		listening <- Listen(ctx, client, clientIf, false, func(r Response) { notifies <- r })
+	}()
+	// Let the listener join the group before the server announces.
+	time.Sleep(50 * time.Millisecond)
+	s := newTestServer(t, serverHost, serverIf, false)
+	counts := make(map[string]int)
+	collect := func(want int) {
+		timeout := time.After(time.Second)
+		for total := 0; total < want; total++ {
+			select {
+			case r := <-notifies:
+				counts[r.NTS]++
+			case <-timeout:
+				t.Fatalf("got %v, want %d notifies", counts, want)
+			}
+		}
+	}
+	collect(4)
+	if counts[aliveNTS] != 4 {
+		t.Fatalf("unexpected notifies: %v", counts)
+	}
+	s.Close()
+	collect(4)
+	if counts[byebyeNTS] != 4 {
+		t.Fatalf("unexpected notifies: %v", counts)
+	}
+	cancel()
+	if err := <-listening; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocationSelection(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	for _, s := range []*Server{
+		newTestServer(t, serverHost, addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24", "10.0.0.2/24"), false),
+		newTestServer(t, serverHost, addInterface(t, serverHost, "eth1", "other", "172.16.0.2/16"), false),
+	} {
+		defer s.Close()
+	}
+	client := n.NewHost()
+	resps, err := Search(context.Background(), SearchOptions{
+		ST:         rootDevice,
+		MX:         1,
+		Interfaces: []net.Interface{addInterface(t, client, "eth0", "lan", "10.0.0.5/24")},
+		Transport:  client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != 1 || resps[0].Location != "http://10.0.0.2:1338/" {
+		t.Fatalf("unexpected responses: %+v", resps)
+	}
+}
+
+func TestSearchIPv6(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	s := newTestServer(t, serverHost, addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24", "fe80::2/64"), true)
+	defer s.Close()
+	client := n.NewHost()
+	resps, err := Search(context.Background(), SearchOptions{
+		ST:         rootDevice,
+		MX:         1,
+		Interfaces: []net.Interface{addInterface(t, client, "eth0", "lan", "192.168.1.5/24", "fe80::5/64")},
+		IPv6:       true,
+		Transport:  client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != 1 || resps[0].Location != "http://[fe80::2]:1338/" {
+		t.Fatalf("unexpected responses: %+v", resps)
+	}
+}
+
+func TestServeAddrsErrorRecovered(t *testing.T) {
+	n := NewMemoryNetwork()
+	host := n.NewHost()
+	ifi := addInterface(t, host, "eth0", "lan", "192.168.1.2/24")
+	errs := make(chan error, 10)
+	s := &Server{
+		Interface:      ifi,
+		Transport:      host,
+		UUID:           "uuid:1234",
+		Location:       func(net.IP) string { return "" },
+		NotifyInterval: time.Minute,
+		OnError:        func(err error) { errs <- err },
+		Logger:         log.Default,
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	host.RemoveInterface("eth0")
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("addresses error not reported")
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned %v after addresses error", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Searches fail to get the addresses too, and shouldn't panic.
+	s.handle(makeSearchMessage(AddrString, SearchAll, 1), &net.UDPAddr{IP: net.IPv4(192, 168, 1, 5), Port: 1900}, false)
+	s.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeReturnsWhenSocketClosed(t *testing.T) {
+	n := NewMemoryNetwork()
+	host := n.NewHost()
+	s := initTestServer(t, host, addInterface(t, host, "eth0", "lan", "192.168.1.2/24"), false)
+	defer s.Close()
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	s.conn.Close()
+	select {
+	case err := <-served:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return")
+	}
+}
+
+func TestNextRetryDelay(t *testing.T) {
+	var got []time.Duration
+	var d time.Duration
+	for i := 0; i < 5; i++ {
+		d = NextRetryDelay(d, time.Second, 5*time.Second)
+		got = append(got, d)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSearchEmbedded(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	s := initTestServer(t, serverHost, addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24"), false)
+	s.Embedded = []EmbeddedDevice{{
+		UUID:     "uuid:5678",
+		Devices:  []string{"urn:schemas-upnp-org:device:MediaRenderer:1"},
+		Services: []string{testService},
+	}}
+	go s.Serve()
+	defer s.Close()
+	client := n.NewHost()
+	resps, err := Search(context.Background(), SearchOptions{
+		ST:         testService,
+		MX:         1,
+		Interfaces: []net.Interface{addInterface(t, client, "eth0", "lan", "192.168.1.5/24")},
+		Transport:  client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usns []string
+	for _, r := range resps {
+		usns = append(usns, r.USN)
+	}
+	sort.Strings(usns)
+	want := []string{"uuid:1234::" + testService, "uuid:5678::" + testService}
+	if strings.Join(usns, " ") != strings.Join(want, " ") {
+		t.Fatalf("got USNs %q, want %q", usns, want)
+	}
+}
+
+func TestSearchSenderDenied(t *testing.T) {
+	n := NewMemoryNetwork()
+	serverHost := n.NewHost()
+	s := initTestServer(t, serverHost, addInterface(t, serverHost, "eth0", "lan", "192.168.1.2/24"), false)
+	s.AllowSender = func(ip net.IP) bool { return !ip.Equal(net.IPv4(192, 168, 1, 5)) }
+	go s.Serve()
+	defer s.Close()
+	for addr, want := range map[string]int{"192.168.1.5/24": 0, "192.168.1.6/24": 1} {
+		client := n.NewHost()
+		resps, err := Search(context.Background(), SearchOptions{
+			ST:         rootDevice,
+			MX:         1,
+			Interfaces: []net.Interface{addInterface(t, client, "eth0", "lan", addr)},
+			Transport:  client,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resps) != want {
+			t.Errorf("%s: got %d responses, want %d", addr, len(resps), want)
+		}
+	}
+}
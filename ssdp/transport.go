@@ -0,0 +1,77 @@
+package ssdp
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// PacketConn is a UDP socket used for SSDP. *net.UDPConn implements it.
+type PacketConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// Transport provides the network to servers and clients, so that they can be
+// run against something other than the real one.
+type Transport interface {
+	// ListenMulticast returns a socket on the port of groups that has joined
+	// them on the interface. Packets sent on it to groups leave by the
+	// interface.
+	ListenMulticast(ifi net.Interface, groups []*net.UDPAddr) (PacketConn, error)
+	// ListenUnicast returns a socket on an arbitrary port, that sends to
+	// multicast groups by the interface.
+	ListenUnicast(ifi net.Interface, ipv6 bool) (PacketConn, error)
+	// InterfaceAddrs returns the addresses of the interface.
+	InterfaceAddrs(ifi net.Interface) ([]net.Addr, error)
+}
+
+// NetTransport is the Transport of the real network.
+type NetTransport struct{}
+
+var _ Transport = NetTransport{}
+
+func (NetTransport) ListenMulticast(ifi net.Interface, groups []*net.UDPAddr) (PacketConn, error) {
+	conn, err := makeConn(ifi, groups)
+	if err != nil {
+		// Avoid returning a non-nil interface holding a nil pointer.
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (NetTransport) ListenUnicast(ifi net.Interface, v6 bool) (PacketConn, error) {
+	network := "udp4"
+	if v6 {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v6 {
+		err = ipv6.NewPacketConn(conn).SetMulticastInterface(&ifi)
+	} else {
+		err = ipv4.NewPacketConn(conn).SetMulticastInterface(&ifi)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (NetTransport) InterfaceAddrs(ifi net.Interface) ([]net.Addr, error) {
+	return ifi.Addrs()
+}
+
+// Returns t, or NetTransport if it's nil.
+func transportOrDefault(t Transport) Transport {
+	if t == nil {
+		return NetTransport{}
+	}
+	return t
+}
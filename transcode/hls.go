@@ -0,0 +1,145 @@
+package transcode
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	. "github.com/anacrolix/dms/misc"
+)
+
+// HLSFormat is the container used for HLS media segments.
+type HLSFormat string
+
+const (
+	HLSFormatTS   HLSFormat = "ts"
+	HLSFormatFMP4 HLSFormat = "fmp4"
+
+	// The name of the fMP4 initialization segment within a segment directory.
+	HLSInitName = "init.mp4"
+)
+
+// Returns the file extension of media segments in this format.
+func (f HLSFormat) SegmentExt() string {
+	if f == HLSFormatFMP4 {
+		return "m4s"
+	}
+	return "ts"
+}
+
+// Returns the MIME-type of media segments in this format.
+func (f HLSFormat) SegmentMimeType() string {
+	if f == HLSFormatFMP4 {
+		return "video/mp4"
+	}
+	return "video/mp2t"
+}
+
+// Returns the file name ffmpeg writes segment n to.
+func (f HLSFormat) SegmentName(n int) string {
+	return fmt.Sprintf("%05d.%s", n, f.SegmentExt())
+}
+
+// Parses the segment number from a name returned by SegmentName.
+func (f HLSFormat) ParseSegmentName(name string) (n int, err error) {
+	s := strings.TrimSuffix(name, "."+f.SegmentExt())
+	if s == name {
+		err = fmt.Errorf("bad segment name: %q", name)
+		return
+	}
+	n, err = strconv.Atoi(s)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("bad segment number: %d", n)
+	}
+	return
+}
+
+// HLSSegmentCount returns the number of segments of length segLen needed to
+// cover duration.
+func HLSSegmentCount(duration, segLen time.Duration) int {
+	if duration <= 0 || segLen <= 0 {
+		return 0
+	}
+	return int((duration + segLen - 1) / segLen)
+}
+
+// HLSPlaylist returns a VOD media playlist covering duration in segments of
+// segLen. Segment URIs are the segment names prefixed with prefix, so that a
+// seek to any position maps directly onto a segment boundary.
+func HLSPlaylist(duration, segLen time.Duration, format HLSFormat, prefix string) string {
+	var b strings.Builder
+	version := 3
+	if format == HLSFormatFMP4 {
+		version = 7
+	}
+	fmt.Fprint(&b, "#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int((segLen+time.Second-1)/time.Second))
+	fmt.Fprint(&b, "#EXT-X-MEDIA-SEQUENCE:0\n")
+	fmt.Fprint(&b, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprint(&b, "#EXT-X-INDEPENDENT-SEGMENTS\n")
+	if format == HLSFormatFMP4 {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", prefix+HLSInitName)
+	}
+	n := HLSSegmentCount(duration, segLen)
+	for i := 0; i < n; i++ {
+		d := segLen
+		if rem := duration - time.Duration(i)*segLen; rem < d {
+			d = rem
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", d.Seconds())
+		fmt.Fprintf(&b, "%s%s\n", prefix, format.SegmentName(i))
+	}
+	fmt.Fprint(&b, "#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// HLSTranscode starts ffmpeg segmenting the file at path into dir, beginning
+// with segment start. Keyframes are forced on segment boundaries so that
+// every segment can be decoded independently, and segments only appear
+// under their final name once they're complete. The returned command has
+// been started, and the caller is responsible for waiting on it.
+func HLSTranscode(path, dir string, start int, segLen time.Duration, format HLSFormat, stderr io.Writer, opts Options) (cmd *exec.Cmd, err error) {
+	offset := time.Duration(start) * segLen
+	args := []string{
+		"ffmpeg",
+		"-ss", FormatDurationSexagesimal(offset),
+		"-i", path,
+	}
+	vargs, err := videoTrackArgs(path, nil, opts.Tracks, offset)
+	if err != nil {
+		return
+	}
+	args = append(args, vargs...)
+	args = append(args, audioTrackArgs(opts.Tracks)...)
+	args = append(args, []string{
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%g)", segLen.Seconds()),
+		"-sc_threshold", "0",
+		"-c:a", "aac", "-ac", "2", "-b:a", "160k",
+		"-output_ts_offset", FormatDurationSexagesimal(offset),
+		"-f", "hls",
+		"-hls_time", strconv.FormatFloat(segLen.Seconds(), 'f', -1, 64),
+		"-hls_list_size", "0",
+		"-hls_flags", "temp_file",
+		"-start_number", strconv.Itoa(start),
+		"-hls_segment_filename", filepath.Join(dir, "%05d."+format.SegmentExt()),
+	}...)
+	if format == HLSFormatFMP4 {
+		args = append(args, "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", HLSInitName)
+	} else {
+		args = append(args, "-hls_segment_type", "mpegts")
+	}
+	args = append(args, filepath.Join(dir, "ffmpeg.m3u8"))
+	log.Println("transcode command:", args)
+	cmd = exec.Command(args[0], args[1:]...)
+	cmd.Stderr = stderr
+	err = startCommand(cmd, opts)
+	return
+}
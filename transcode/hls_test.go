@@ -0,0 +1,41 @@
+package transcode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHLSPlaylist(t *testing.T) {
+	pl := HLSPlaylist(13*time.Second, 6*time.Second, HLSFormatTS, "abc/")
+	t.Log("\n" + pl)
+	for _, s := range []string{
+		"#EXT-X-TARGETDURATION:6\n",
+		"#EXTINF:6.000,\nabc/00000.ts\n",
+		"#EXTINF:6.000,\nabc/00001.ts\n",
+		"#EXTINF:1.000,\nabc/00002.ts\n#EXT-X-ENDLIST\n",
+	} {
+		if !strings.Contains(pl, s) {
+			t.Errorf("playlist missing %q", s)
+		}
+	}
+	if strings.Contains(pl, "EXT-X-MAP") {
+		t.Error("unexpected init segment in ts playlist")
+	}
+	pl = HLSPlaylist(13*time.Second, 6*time.Second, HLSFormatFMP4, "abc/")
+	if !strings.Contains(pl, `#EXT-X-MAP:URI="abc/init.mp4"`) {
+		t.Error("missing init segment in fmp4 playlist")
+	}
+}
+
+func TestHLSSegmentName(t *testing.T) {
+	for _, f := range []HLSFormat{HLSFormatTS, HLSFormatFMP4} {
+		n, err := f.ParseSegmentName(f.SegmentName(42))
+		if err != nil || n != 42 {
+			t.Errorf("%s: got %d, %v", f, n, err)
+		}
+	}
+	if _, err := HLSFormatTS.ParseSegmentName("00001.m4s"); err == nil {
+		t.Error("expected error for wrong extension")
+	}
+}
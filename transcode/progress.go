@@ -0,0 +1,115 @@
+package transcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is the state of a running ffmpeg transcode, as reported through
+// its machine readable -progress output.
+type Progress struct {
+	// Position in the output.
+	Position time.Duration
+	// Transcode rate relative to real time. Below 1 the transcode isn't
+	// keeping up with playback.
+	Speed float64
+	FPS   float64
+	// Output bitrate in kbit/s.
+	Bitrate         float64
+	Frames          int64
+	DroppedFrames   int64
+	DuplicateFrames int64
+	// Bytes output so far.
+	TotalSize int64
+	// Set on the last report, when ffmpeg has finished.
+	End bool
+}
+
+// Parses a float from a progress value, ignoring the unit suffix ffmpeg
+// includes for some keys, such as "1.02x". "N/A" gives 0.
+func parseProgressFloat(s, suffix string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, suffix)), 64)
+	return f
+}
+
+// ParseProgress reads ffmpeg -progress output from r, calling f with each
+// complete report, until r is exhausted.
+func ParseProgress(r io.Reader, f func(Progress)) error {
+	var p Progress
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "frame":
+			p.Frames, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			p.FPS = parseProgressFloat(value, "")
+		case "bitrate":
+			p.Bitrate = parseProgressFloat(value, "kbits/s")
+		case "total_size":
+			p.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us":
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				p.Position = time.Duration(us) * time.Microsecond
+			}
+		case "dup_frames":
+			p.DuplicateFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "drop_frames":
+			p.DroppedFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "speed":
+			p.Speed = parseProgressFloat(value, "x")
+		case "progress":
+			p.End = value == "end"
+			f(p)
+		}
+	}
+	return s.Err()
+}
+
+// Hooks the -progress output of cmd up to f, if cmd is ffmpeg and f isn't
+// nil. The returned function must be called once cmd has been started, with
+// whether that succeeded.
+func attachProgress(cmd *exec.Cmd, f func(Progress)) (started func(ok bool), err error) {
+	started = func(bool) {}
+	if f == nil || !progressSupported {
+		return
+	}
+	if strings.TrimSuffix(filepath.Base(cmd.Args[0]), ".exe") != "ffmpeg" {
+		return
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, pw)
+	// The child's extra files start after stdin, stdout and stderr.
+	fd := 2 + len(cmd.ExtraFiles)
+	cmd.Args = append([]string{
+		cmd.Args[0],
+		"-progress", fmt.Sprintf("pipe:%d", fd),
+		"-nostats",
+	}, cmd.Args[1:]...)
+	started = func(ok bool) {
+		pw.Close()
+		if !ok {
+			pr.Close()
+			return
+		}
+		go func() {
+			defer pr.Close()
+			ParseProgress(pr, f)
+		}()
+	}
+	return
+}
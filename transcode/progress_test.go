@@ -0,0 +1,56 @@
+package transcode
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProgressOutput = `frame=120
+fps=24.50
+stream_0_0_q=28.0
+bitrate=1024.3kbits/s
+total_size=655360
+out_time_us=5000000
+out_time_ms=5000000
+out_time=00:00:05.000000
+dup_frames=1
+drop_frames=2
+speed=1.5x
+progress=continue
+frame=240
+fps=N/A
+bitrate=N/A
+out_time_us=10000000
+speed=0.75x
+progress=end
+`
+
+func TestParseProgress(t *testing.T) {
+	var ps []Progress
+	err := ParseProgress(strings.NewReader(testProgressOutput), func(p Progress) {
+		ps = append(ps, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("got %d reports", len(ps))
+	}
+	want := Progress{
+		Position:        5 * time.Second,
+		Speed:           1.5,
+		FPS:             24.5,
+		Bitrate:         1024.3,
+		Frames:          120,
+		DroppedFrames:   2,
+		DuplicateFrames: 1,
+		TotalSize:       655360,
+	}
+	if ps[0] != want {
+		t.Errorf("got %+v, want %+v", ps[0], want)
+	}
+	if !ps[1].End || ps[1].Speed != 0.75 || ps[1].FPS != 0 || ps[1].Position != 10*time.Second {
+		t.Errorf("unexpected final report %+v", ps[1])
+	}
+}
@@ -0,0 +1,7 @@
+//go:build windows
+// +build windows
+
+package transcode
+
+// Passing extra file descriptors to child processes isn't supported.
+const progressSupported = false
@@ -0,0 +1,85 @@
+package transcode
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/anacrolix/log"
+)
+
+// SubtitleFormat is a text subtitle format that subtitles can be converted
+// to.
+type SubtitleFormat string
+
+const (
+	SubtitleFormatSRT SubtitleFormat = "srt"
+	SubtitleFormatVTT SubtitleFormat = "vtt"
+	SubtitleFormatASS SubtitleFormat = "ass"
+)
+
+// ParseSubtitleFormat returns the SubtitleFormat named by s.
+func ParseSubtitleFormat(s string) (SubtitleFormat, error) {
+	switch f := SubtitleFormat(s); f {
+	case SubtitleFormatSRT, SubtitleFormatVTT, SubtitleFormatASS:
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported subtitle format: %q", s)
+}
+
+// Returns the name of ffmpeg's muxer for the format.
+func (f SubtitleFormat) muxer() string {
+	if f == SubtitleFormatVTT {
+		return "webvtt"
+	}
+	return string(f)
+}
+
+// MimeType returns the MIME-type that renderers expect for the format.
+func (f SubtitleFormat) MimeType() string {
+	switch f {
+	case SubtitleFormatVTT:
+		return "text/vtt"
+	case SubtitleFormatASS:
+		return "text/ssa"
+	}
+	return "text/srt"
+}
+
+// TextSubtitleCodecs are the ffprobe codec names of subtitle streams that can
+// be converted to a text SubtitleFormat.
+var TextSubtitleCodecs = map[string]bool{
+	"subrip":    true,
+	"srt":       true,
+	"ass":       true,
+	"ssa":       true,
+	"webvtt":    true,
+	"mov_text":  true,
+	"text":      true,
+	"microdvd":  true,
+	"sami":      true,
+	"subviewer": true,
+}
+
+// ConvertSubtitle converts the subtitle stream with the given relative index
+// in the file at path to format. Subtitle files have a single stream 0.
+func ConvertSubtitle(path string, stream int, format SubtitleFormat) ([]byte, error) {
+	args := []string{
+		"ffmpeg",
+		"-nostdin",
+		"-i", path,
+		"-map", "0:s:" + strconv.Itoa(stream),
+		"-f", format.muxer(),
+		"pipe:",
+	}
+	log.Println("subtitle command:", args)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("converting subtitle: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
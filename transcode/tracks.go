@@ -0,0 +1,203 @@
+package transcode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// Tracks selects the input streams included in a transcode. Indexes are
+// relative to the streams of the same type, as in ffmpeg's 0:a:N stream
+// specifiers.
+type Tracks struct {
+	Audio int
+	// Subtitle is only used if HasSubtitle is set.
+	Subtitle    int
+	HasSubtitle bool
+	// Render the selected subtitle into the video, for renderers that can't
+	// display subtitles themselves.
+	BurnSubtitle bool
+}
+
+// StreamInt returns an integer value from an ffprobe stream. Values are
+// numbers when fresh from ffprobe, float64 when loaded from a JSON cache, and
+// sometimes strings, such as sample_rate.
+func StreamInt(s map[string]interface{}, key string) (int, bool) {
+	switch v := s[key].(type) {
+	case float64:
+		return int(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err == nil
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// StreamLanguage returns the lowercased language tag of an ffprobe stream, or
+// "" if it's not tagged.
+func StreamLanguage(s map[string]interface{}) string {
+	for key, val := range s {
+		if strings.EqualFold(key, "tag:language") {
+			lang, _ := val.(string)
+			return strings.ToLower(lang)
+		}
+	}
+	if tags, ok := s["tags"].(map[string]interface{}); ok {
+		lang, _ := tags["language"].(string)
+		return strings.ToLower(lang)
+	}
+	return ""
+}
+
+func streamIsDefault(s map[string]interface{}) bool {
+	d, ok := s["disposition"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	i, _ := StreamInt(d, "default")
+	return i != 0
+}
+
+// StreamsOfType returns the streams with the given codec_type in order, so
+// that positions in the result are the relative indexes used by Tracks.
+// Attached pictures such as cover art are not considered video.
+func StreamsOfType(info *ffprobe.Info, codecType string) (ret []map[string]interface{}) {
+	if info == nil {
+		return
+	}
+	for _, s := range info.Streams {
+		if s["codec_type"] != codecType {
+			continue
+		}
+		if d, ok := s["disposition"].(map[string]interface{}); ok {
+			if i, _ := StreamInt(d, "attached_pic"); i != 0 {
+				continue
+			}
+		}
+		ret = append(ret, s)
+	}
+	return
+}
+
+// Returns the index of the first stream tagged with the most preferred
+// language, or -1.
+func preferredStream(streams []map[string]interface{}, languages []string) int {
+	for _, lang := range languages {
+		for i, s := range streams {
+			if StreamLanguage(s) == strings.ToLower(lang) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// SelectTracks chooses the default tracks for a file given languages in order
+// of preference, as ISO 639-2 codes like those in ffprobe's tags.language.
+// The audio track is the most preferred language, falling back to the
+// stream marked default, and then the first. Subtitles are selected in the
+// most preferred language available when the audio isn't in the first
+// preference.
+func SelectTracks(info *ffprobe.Info, languages []string) (ret Tracks) {
+	audio := StreamsOfType(info, "audio")
+	if i := preferredStream(audio, languages); i >= 0 {
+		ret.Audio = i
+	} else {
+		for i, s := range audio {
+			if streamIsDefault(s) {
+				ret.Audio = i
+				break
+			}
+		}
+	}
+	if len(languages) == 0 {
+		return
+	}
+	if ret.Audio < len(audio) && StreamLanguage(audio[ret.Audio]) == strings.ToLower(languages[0]) {
+		return
+	}
+	if i := preferredStream(StreamsOfType(info, "subtitle"), languages); i >= 0 {
+		ret.Subtitle = i
+		ret.HasSubtitle = true
+	}
+	return
+}
+
+// ParseTrack resolves a track given as a relative index or a language code to
+// the relative index among streams.
+func ParseTrack(s string, streams []map[string]interface{}) (int, error) {
+	if i, err := strconv.Atoi(s); err == nil {
+		if i < 0 || i >= len(streams) {
+			return 0, fmt.Errorf("no track %d", i)
+		}
+		return i, nil
+	}
+	if i := preferredStream(streams, []string{s}); i >= 0 {
+		return i, nil
+	}
+	return 0, fmt.Errorf("no track with language %q", s)
+}
+
+// Subtitle codecs that are images, and so have to be overlaid rather than
+// rendered with the subtitles filter.
+var bitmapSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// Escapes a path for use as an option value in an ffmpeg filtergraph.
+func escapeFilterPath(path string) string {
+	r := strings.NewReplacer(`\`, `\\\\`, `:`, `\\:`, `'`, `\\\'`, `,`, `\,`, `[`, `\[`, `]`, `\]`, `;`, `\;`)
+	return r.Replace(path)
+}
+
+// Returns the arguments that select the video stream of path, with the
+// selected subtitle burnt in if requested. info is probed from path if it's
+// needed and not given. start is the input seek position, which the
+// subtitles filter needs to know to keep in sync.
+func videoTrackArgs(path string, info *ffprobe.Info, tracks Tracks, start time.Duration) ([]string, error) {
+	if !tracks.BurnSubtitle || !tracks.HasSubtitle {
+		return []string{"-map", "0:V:0"}, nil
+	}
+	if info == nil {
+		var err error
+		info, err = ffprobe.Run(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	subs := StreamsOfType(info, "subtitle")
+	if tracks.Subtitle >= len(subs) {
+		return nil, fmt.Errorf("no subtitle track %d", tracks.Subtitle)
+	}
+	if codec, _ := subs[tracks.Subtitle]["codec_name"].(string); bitmapSubtitleCodecs[codec] {
+		return []string{
+			"-filter_complex", fmt.Sprintf("[0:V:0][0:s:%d]overlay[v]", tracks.Subtitle),
+			"-map", "[v]",
+		}, nil
+	}
+	filter := fmt.Sprintf("subtitles=filename=%s:si=%d", escapeFilterPath(path), tracks.Subtitle)
+	if start > 0 {
+		// The subtitles filter reads from the start of the file, so shift
+		// the seeked video to the original timestamps and back.
+		filter = fmt.Sprintf("setpts=PTS+%g/TB,%s,setpts=PTS-STARTPTS", start.Seconds(), filter)
+	}
+	return []string{
+		"-map", "0:V:0",
+		"-vf", filter,
+	}, nil
+}
+
+// Returns the arguments that select the audio stream.
+func audioTrackArgs(tracks Tracks) []string {
+	return []string{"-map", fmt.Sprintf("0:a:%d?", tracks.Audio)}
+}
@@ -0,0 +1,63 @@
+package transcode
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func testInfo() *ffprobe.Info {
+	stream := func(codecType, lang string, def bool) map[string]interface{} {
+		s := map[string]interface{}{
+			"codec_type":  codecType,
+			"tags":        map[string]interface{}{"language": lang},
+			"disposition": map[string]interface{}{"default": json.Number("0")},
+		}
+		if def {
+			s["disposition"] = map[string]interface{}{"default": json.Number("1")}
+		}
+		return s
+	}
+	return &ffprobe.Info{Streams: []map[string]interface{}{
+		stream("video", "und", true),
+		stream("audio", "jpn", false),
+		stream("audio", "eng", true),
+		stream("subtitle", "fre", false),
+		stream("subtitle", "eng", false),
+	}}
+}
+
+func TestSelectTracks(t *testing.T) {
+	info := testInfo()
+	for _, c := range []struct {
+		langs []string
+		want  Tracks
+	}{
+		{nil, Tracks{Audio: 1}},
+		{[]string{"jpn"}, Tracks{Audio: 0}},
+		{[]string{"eng", "jpn"}, Tracks{Audio: 1}},
+		{[]string{"fre", "eng"}, Tracks{Audio: 1, Subtitle: 0, HasSubtitle: true}},
+		{[]string{"ger", "eng"}, Tracks{Audio: 1, Subtitle: 1, HasSubtitle: true}},
+	} {
+		if got := SelectTracks(info, c.langs); got != c.want {
+			t.Errorf("%q: got %+v, want %+v", c.langs, got, c.want)
+		}
+	}
+}
+
+func TestParseTrack(t *testing.T) {
+	subs := StreamsOfType(testInfo(), "subtitle")
+	if i, err := ParseTrack("eng", subs); err != nil || i != 1 {
+		t.Errorf("got %d, %v", i, err)
+	}
+	if i, err := ParseTrack("0", subs); err != nil || i != 0 {
+		t.Errorf("got %d, %v", i, err)
+	}
+	if _, err := ParseTrack("2", subs); err == nil {
+		t.Error("expected error for out of range track")
+	}
+	if _, err := ParseTrack("kor", subs); err == nil {
+		t.Error("expected error for missing language")
+	}
+}
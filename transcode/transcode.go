@@ -16,9 +16,28 @@ import (
 	. "github.com/anacrolix/dms/misc"
 )
 
+// Options apply to all the transcode functions, although not every transcode
+// is able to honour them.
+type Options struct {
+	Tracks Tracks
+	// Called with each progress report from ffmpeg, if it's not nil.
+	Progress func(Progress)
+}
+
+// Starts an external command, hooking up progress reporting if requested.
+func startCommand(cmd *exec.Cmd, opts Options) (err error) {
+	started, err := attachProgress(cmd, opts.Progress)
+	if err != nil {
+		return
+	}
+	err = cmd.Start()
+	started(err == nil)
+	return
+}
+
 // Invokes an external command and returns a reader from its stdout. The
 // command is waited on asynchronously.
-func transcodePipe(args []string, stderr io.Writer) (r io.ReadCloser, err error) {
+func transcodePipe(args []string, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
 	log.Println("transcode command:", args)
 	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Stderr = stderr
@@ -26,7 +45,7 @@ func transcodePipe(args []string, stderr io.Writer) (r io.ReadCloser, err error)
 	if err != nil {
 		return
 	}
-	err = cmd.Start()
+	err = startCommand(cmd, opts)
 	if err != nil {
 		return
 	}
@@ -44,8 +63,9 @@ func transcodePipe(args []string, stderr io.Writer) (r io.ReadCloser, err error)
 func streamArgs(s map[string]interface{}) (ret []string) {
 	defer func() {
 		if len(ret) != 0 {
+			index, _ := StreamInt(s, "index")
 			ret = append(ret, []string{
-				"-map", "0:" + strconv.Itoa(int(s["index"].(float64))),
+				"-map", "0:" + strconv.Itoa(index),
 			}...)
 		}
 	}()
@@ -72,8 +92,21 @@ func streamArgs(s map[string]interface{}) (ret []string) {
 }
 
 // Streams the desired file in the MPEG_PS_PAL DLNA profile.
-func Transcode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
-	args := []string{
+func Transcode(path string, start, length time.Duration, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
+	info, err := ffprobe.Run(path)
+	if err != nil {
+		return
+	}
+	args, err := transcodeArgs(path, info, start, length, opts.Tracks)
+	if err != nil {
+		return
+	}
+	return transcodePipe(args, stderr, opts)
+}
+
+// Returns the ffmpeg command for Transcode, given the file's probed info.
+func transcodeArgs(path string, info *ffprobe.Info, start, length time.Duration, tracks Tracks) (args []string, err error) {
+	args = []string{
 		"ffmpeg",
 		"-threads", strconv.FormatInt(int64(runtime.NumCPU()), 10),
 		"-async", "1",
@@ -87,19 +120,32 @@ func Transcode(path string, start, length time.Duration, stderr io.Writer) (r io
 	args = append(args, []string{
 		"-i", path,
 	}...)
-	info, err := ffprobe.Run(path)
-	if err != nil {
-		return
+	if video := StreamsOfType(info, "video"); len(video) != 0 {
+		if tracks.BurnSubtitle && tracks.HasSubtitle {
+			// The video is mapped with the subtitles burnt in, so it mustn't be mapped again.
+			var vargs []string
+			vargs, err = videoTrackArgs(path, info, tracks, start)
+			if err != nil {
+				return
+			}
+			args = append(args, vargs...)
+			args = append(args, "-target", "pal-dvd")
+		} else {
+			args = append(args, streamArgs(video[0])...)
+		}
+	}
+	if audio := StreamsOfType(info, "audio"); tracks.Audio < len(audio) {
+		args = append(args, streamArgs(audio[tracks.Audio])...)
 	}
-	for _, s := range info.Streams {
-		args = append(args, streamArgs(s)...)
+	if subs := StreamsOfType(info, "subtitle"); tracks.HasSubtitle && !tracks.BurnSubtitle && tracks.Subtitle < len(subs) {
+		args = append(args, streamArgs(subs[tracks.Subtitle])...)
 	}
 	args = append(args, []string{"-f", "mpegts", "pipe:"}...)
-	return transcodePipe(args, stderr)
+	return
 }
 
 // Returns a stream of Chromecast supported VP8.
-func VP8Transcode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func VP8Transcode(path string, start, length time.Duration, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
 	args := []string{
 		"avconv",
 		"-threads", strconv.FormatInt(int64(runtime.NumCPU()), 10),
@@ -111,25 +157,39 @@ func VP8Transcode(path string, start, length time.Duration, stderr io.Writer) (r
 			"-t", FormatDurationSexagesimal(length),
 		}...)
 	}
+	args = append(args, "-i", path)
+	vargs, err := videoTrackArgs(path, nil, opts.Tracks, start)
+	if err != nil {
+		return
+	}
+	args = append(args, vargs...)
+	args = append(args, audioTrackArgs(opts.Tracks)...)
 	args = append(args, []string{
-		"-i", path,
 		// "-deadline", "good",
 		// "-c:v", "libvpx", "-crf", "10",
 		"-f", "webm",
 		"pipe:",
 	}...)
-	return transcodePipe(args, stderr)
+	return transcodePipe(args, stderr, opts)
 }
 
 // Returns a stream of Chromecast supported matroska.
-func ChromecastTranscode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func ChromecastTranscode(path string, start, length time.Duration, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
 	args := []string{
 		"ffmpeg",
 		"-ss", FormatDurationSexagesimal(start),
 		"-i", path,
+	}
+	vargs, err := videoTrackArgs(path, nil, opts.Tracks, start)
+	if err != nil {
+		return
+	}
+	args = append(args, vargs...)
+	args = append(args, audioTrackArgs(opts.Tracks)...)
+	args = append(args, []string{
 		"-c:v", "libx264", "-preset", "ultrafast", "-profile:v", "high", "-level", "5.0",
 		"-movflags", "+faststart+frag_keyframe+empty_moov",
-	}
+	}...)
 	if length > 0 {
 		args = append(args, []string{
 			"-t", FormatDurationSexagesimal(length),
@@ -139,21 +199,29 @@ func ChromecastTranscode(path string, start, length time.Duration, stderr io.Wri
 		"-f", "mp4",
 		"pipe:",
 	}...)
-	return transcodePipe(args, stderr)
+	return transcodePipe(args, stderr, opts)
 }
 
 // Returns a stream of h264 video and mp3 audio
-func WebTranscode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func WebTranscode(path string, start, length time.Duration, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
 	args := []string{
 		"ffmpeg",
 		"-ss", FormatDurationSexagesimal(start),
 		"-i", path,
+	}
+	vargs, err := videoTrackArgs(path, nil, opts.Tracks, start)
+	if err != nil {
+		return
+	}
+	args = append(args, vargs...)
+	args = append(args, audioTrackArgs(opts.Tracks)...)
+	args = append(args, []string{
 		"-pix_fmt", "yuv420p",
 		"-c:v", "libx264", "-crf", "25",
 		"-c:a", "mp3", "-ab", "128k", "-ar", "44100",
 		"-preset", "ultrafast",
 		"-movflags", "+faststart+frag_keyframe+empty_moov",
-	}
+	}...)
 	if length > 0 {
 		args = append(args, []string{
 			"-t", FormatDurationSexagesimal(length),
@@ -163,7 +231,7 @@ func WebTranscode(path string, start, length time.Duration, stderr io.Writer) (r
 		"-f", "mp4",
 		"pipe:",
 	}...)
-	return transcodePipe(args, stderr)
+	return transcodePipe(args, stderr, opts)
 }
 
 // credit laurent @ https://stackoverflow.com/questions/34118732/parse-a-command-line-string-into-flags-and-arguments-in-golang
@@ -233,11 +301,68 @@ func parseCommandLine(command string) ([]string, error) {
 }
 
 // Exec runs the cmd to generate the video to stream. It does not support seeking. Used by the dynamic stream feature.
-func Exec(cmds string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func Exec(cmds string, start, length time.Duration, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
 	cmda, aerr := parseCommandLine(cmds)
 	if aerr != nil {
 		err = aerr
 		return
 	}
-	return transcodePipe(cmda, stderr)
+	return transcodePipe(cmda, stderr, opts)
+}
+
+// Returns the leading ffmpeg arguments for transcoding only the selected
+// audio stream of path.
+func audioArgs(path string, start, length time.Duration, tracks Tracks) []string {
+	args := []string{
+		"ffmpeg",
+		"-ss", FormatDurationSexagesimal(start),
+	}
+	if length > 0 {
+		args = append(args, []string{
+			"-t", FormatDurationSexagesimal(length),
+		}...)
+	}
+	return append(args, []string{
+		"-i", path,
+		"-vn", "-sn",
+		"-map", fmt.Sprintf("0:a:%d", tracks.Audio),
+	}...)
+}
+
+// LPCMTranscoder returns a transcode function that streams the selected audio
+// stream as raw big-endian 16-bit PCM at the given sample rate and channel
+// count, as required by the DLNA LPCM profile.
+func LPCMTranscoder(rate, channels int) func(path string, start, length time.Duration, stderr io.Writer, opts Options) (io.ReadCloser, error) {
+	return func(path string, start, length time.Duration, stderr io.Writer, opts Options) (io.ReadCloser, error) {
+		args := append(audioArgs(path, start, length, opts.Tracks), []string{
+			"-c:a", "pcm_s16be",
+			"-ar", strconv.Itoa(rate),
+			"-ac", strconv.Itoa(channels),
+			"-f", "s16be",
+			"pipe:",
+		}...)
+		return transcodePipe(args, stderr, opts)
+	}
+}
+
+// Streams the selected audio stream in the DLNA MP3 profile.
+func MP3Transcode(path string, start, length time.Duration, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
+	args := append(audioArgs(path, start, length, opts.Tracks), []string{
+		"-c:a", "libmp3lame", "-b:a", "320k", "-ar", "44100", "-ac", "2",
+		"-f", "mp3",
+		"pipe:",
+	}...)
+	return transcodePipe(args, stderr, opts)
+}
+
+// Streams the selected audio stream in the DLNA AAC_ISO profile, as fragmented
+// MP4 so that it can be written to a pipe.
+func AACTranscode(path string, start, length time.Duration, stderr io.Writer, opts Options) (r io.ReadCloser, err error) {
+	args := append(audioArgs(path, start, length, opts.Tracks), []string{
+		"-c:a", "aac", "-b:a", "256k", "-ar", "44100", "-ac", "2",
+		"-movflags", "+frag_keyframe+empty_moov",
+		"-f", "mp4",
+		"pipe:",
+	}...)
+	return transcodePipe(args, stderr, opts)
 }
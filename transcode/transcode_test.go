@@ -0,0 +1,44 @@
+package transcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestTranscodeArgsBurnSubtitle(t *testing.T) {
+	info := func(subCodec string) *ffprobe.Info {
+		stream := func(index int, codecType, codec string) map[string]interface{} {
+			return map[string]interface{}{
+				"index":      float64(index),
+				"codec_type": codecType,
+				"codec_name": codec,
+			}
+		}
+		return &ffprobe.Info{Streams: []map[string]interface{}{
+			stream(0, "video", "h264"),
+			stream(1, "audio", "aac"),
+			stream(2, "subtitle", subCodec),
+		}}
+	}
+	for _, tc := range []struct {
+		subCodec string
+		video    string
+	}{
+		{"subrip", "-map 0:V:0 -vf subtitles=filename=movie.mkv:si=0 -target pal-dvd"},
+		{"hdmv_pgs_subtitle", "-filter_complex [0:V:0][0:s:0]overlay[v] -map [v] -target pal-dvd"},
+	} {
+		args, err := transcodeArgs("movie.mkv", info(tc.subCodec), 0, -1, Tracks{HasSubtitle: true, BurnSubtitle: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Join(args, " ")
+		if !strings.Contains(got, " -i movie.mkv "+tc.video+" -acodec copy -map 0:1 -f mpegts pipe:") {
+			t.Errorf("%s: %s", tc.subCodec, got)
+		}
+		if strings.Contains(got, "-map 0:0") {
+			t.Errorf("%s: video mapped again: %s", tc.subCodec, got)
+		}
+	}
+}
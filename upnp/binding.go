@@ -0,0 +1,302 @@
+package upnp
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/log"
+)
+
+// Actions handles a service's actions with typed functions bound to them, and implements the
+// Handle method of UPnPService. Arguments are checked against the definition, and decoded into
+// the fields of the bound function's request struct. The fields of the response struct are
+// encoded as the out arguments.
+//
+// Fields map to the arguments of the same name, or as given by a `upnp:"Name"` tag. Fields can
+// be strings, bools and integers. Unexported fields and those tagged `upnp:"-"` are ignored.
+type Actions struct {
+	Def      *ServiceDef
+	handlers map[string]actionHandler
+}
+
+type actionHandler func(argsXML []byte, r *http.Request) ([][2]string, error)
+
+// NewActions returns Actions for the service definition, without any bound.
+func NewActions(def *ServiceDef) *Actions {
+	return &Actions{
+		Def:      def,
+		handlers: make(map[string]actionHandler),
+	}
+}
+
+// Bind has f handle the action. It panics if the action isn't defined, or In or Out don't suit
+// its arguments. In can have fields for only some of the in arguments, but Out must have a field
+// for every out argument.
+func Bind[In, Out any](a *Actions, action string, f func(r *http.Request, in In) (Out, error)) {
+	def, ok := a.Def.Action(action)
+	if !ok {
+		log.Panicf("binding undefined action %q", action)
+	}
+	inFields, err := a.Def.argFields(reflect.TypeOf((*In)(nil)).Elem(), def.In, false)
+	if err != nil {
+		log.Panicf("binding %q request: %s", action, err)
+	}
+	outFields, err := a.Def.argFields(reflect.TypeOf((*Out)(nil)).Elem(), def.Out, true)
+	if err != nil {
+		log.Panicf("binding %q response: %s", action, err)
+	}
+	a.handlers[action] = func(argsXML []byte, r *http.Request) ([][2]string, error) {
+		var in In
+		if err := a.Def.decodeArgs(def, argsXML, inFields, reflect.ValueOf(&in).Elem()); err != nil {
+			return nil, err
+		}
+		out, err := f(r, in)
+		if err != nil {
+			return nil, err
+		}
+		return encodeArgs(outFields, reflect.ValueOf(out)), nil
+	}
+}
+
+// Handle decodes the arguments of the action and calls the function bound to it. It returns
+// InvalidActionError if none is.
+func (a *Actions) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
+	h, ok := a.handlers[action]
+	if !ok {
+		return nil, InvalidActionError
+	}
+	return h(argsXML, r)
+}
+
+// A struct field bound to an argument.
+type argField struct {
+	arg   ArgDef
+	index int
+}
+
+// Returns the fields of t bound to args, in the order of args. If all, every argument must have
+// a field.
+func (d *ServiceDef) argFields(t reflect.Type, args []ArgDef, all bool) (ret []argField, err error) {
+	named, err := structArgFields(t)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]int, len(named))
+	for _, f := range named {
+		byName[f.name] = f.index
+	}
+	for _, arg := range args {
+		i, ok := byName[arg.Name]
+		if !ok {
+			if all {
+				return nil, fmt.Errorf("no field for argument %q", arg.Name)
+			}
+			continue
+		}
+		delete(byName, arg.Name)
+		sv, _ := d.StateVar(arg.StateVar)
+		if err := checkFieldType(t.Field(i).Type, sv.DataType); err != nil {
+			return nil, fmt.Errorf("field %s: %w", t.Field(i).Name, err)
+		}
+		ret = append(ret, argField{arg, i})
+	}
+	for name := range byName {
+		return nil, fmt.Errorf("field for undefined argument %q", name)
+	}
+	return
+}
+
+// A struct field and the name of the argument it's for.
+type namedField struct {
+	name  string
+	index int
+}
+
+// Returns the fields of the struct type t that are for arguments, in field order.
+func structArgFields(t reflect.Type) (ret []namedField, err error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", t)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("upnp"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("more than one field for argument %q", name)
+		}
+		seen[name] = true
+		ret = append(ret, namedField{name, i})
+	}
+	return
+}
+
+// MarshalArgs returns the fields of the struct v as arguments, in field order, encoded and
+// named as for Actions. It's for calling actions, where there's no definition to go by.
+func MarshalArgs(v interface{}) (ret [][2]string, err error) {
+	rv := reflect.ValueOf(v)
+	fields, err := structArgFields(rv.Type())
+	if err != nil {
+		return
+	}
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		if !isArgKind(fv.Kind()) {
+			return nil, fmt.Errorf("field %s: unsupported type %s", rv.Type().Field(f.index).Name, fv.Type())
+		}
+		ret = append(ret, [2]string{f.name, formatField(fv)})
+	}
+	return
+}
+
+// UnmarshalArgs sets the fields of the struct pointed to by v from the arguments. Arguments
+// without a field are ignored.
+func UnmarshalArgs(args [][2]string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer {
+		return fmt.Errorf("%s is not a pointer", rv.Type())
+	}
+	rv = rv.Elem()
+	fields, err := structArgFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		for _, f := range fields {
+			if f.name != arg[0] {
+				continue
+			}
+			fv := rv.Field(f.index)
+			if !isArgKind(fv.Kind()) {
+				return fmt.Errorf("field %s: unsupported type %s", rv.Type().Field(f.index).Name, fv.Type())
+			}
+			if err := setField(fv, strings.TrimSpace(arg[1])); err != nil {
+				return fmt.Errorf("argument %q: %w", arg[0], err)
+			}
+		}
+	}
+	return nil
+}
+
+func isArgKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isIntegerType(dataType string) bool {
+	switch dataType {
+	case "ui1", "ui2", "ui4", "i1", "i2", "i4", "int":
+		return true
+	}
+	return false
+}
+
+// Returns an error if fields of type t can't hold values of the UPnP data type.
+func checkFieldType(t reflect.Type, dataType string) error {
+	switch t.Kind() {
+	case reflect.String:
+		return nil
+	case reflect.Bool:
+		if dataType == "boolean" {
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if isIntegerType(dataType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s can't hold %s", t, dataType)
+}
+
+// Checks the arguments of the action and sets the fields bound to them in v. Missing arguments
+// leave their fields unset.
+func (d *ServiceDef) decodeArgs(a *ActionDef, argsXML []byte, fields []argField, v reflect.Value) error {
+	args, err := d.checkArgs(a, argsXML)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		for _, f := range fields {
+			if f.arg.Name != arg[0] {
+				continue
+			}
+			if err := setField(v.Field(f.index), strings.TrimSpace(arg[1])); err != nil {
+				return Errorf(ArgumentValueInvalidErrorCode, "argument %q: %s", arg[0], err)
+			}
+		}
+	}
+	return nil
+}
+
+func setField(v reflect.Value, s string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return nil
+	}
+	if s == "" {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "1", "true", "yes":
+			v.SetBool(true)
+		default:
+			v.SetBool(false)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	default:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	}
+	return nil
+}
+
+func encodeArgs(fields []argField, v reflect.Value) (ret [][2]string) {
+	ret = make([][2]string, 0, len(fields))
+	for _, f := range fields {
+		ret = append(ret, [2]string{f.arg.Name, formatField(v.Field(f.index))})
+	}
+	return
+}
+
+func formatField(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		if v.Bool() {
+			return "1"
+		}
+		return "0"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	default:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+}
@@ -0,0 +1,105 @@
+package upnp
+
+import (
+	"net/http"
+	"testing"
+)
+
+type setLevelReq struct {
+	Level uint32
+	Mode  string
+}
+
+type setLevelResp struct {
+	Level uint32 `upnp:"Result"`
+}
+
+func newTestActions() *Actions {
+	a := NewActions(&testServiceDef)
+	Bind(a, "SetLevel", func(_ *http.Request, req setLevelReq) (setLevelResp, error) {
+		if req.Mode == "Slow" {
+			return setLevelResp{}, Errorf(ActionFailedErrorCode, "too slow")
+		}
+		return setLevelResp{Level: req.Level * 2}, nil
+	})
+	return a
+}
+
+func TestActionsHandle(t *testing.T) {
+	a := newTestActions()
+	ret, err := a.Handle("SetLevel", []byte(`<u:SetLevel xmlns:u="x"><Level> 21 </Level><Mode>Fast</Mode><Bogus>1</Bogus></u:SetLevel>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ret) != 1 || ret[0] != [2]string{"Result", "42"} {
+		t.Fatalf("unexpected response: %q", ret)
+	}
+	for _, tc := range []struct {
+		action string
+		args   string
+		code   uint
+	}{
+		{"Unknown", `<u:Unknown xmlns:u="x"/>`, InvalidActionErrorCode},
+		{"SetLevel", `<u:SetLevel`, InvalidArgsErrorCode},
+		{"SetLevel", `<u:SetLevel xmlns:u="x"><Level>x</Level></u:SetLevel>`, ArgumentValueInvalidErrorCode},
+		{"SetLevel", `<u:SetLevel xmlns:u="x"><Mode>Slow</Mode></u:SetLevel>`, ActionFailedErrorCode},
+	} {
+		_, err := a.Handle(tc.action, []byte(tc.args), nil)
+		if err == nil || ConvertError(err).Code != tc.code {
+			t.Errorf("%s: got %v, want code %d", tc.args, err, tc.code)
+		}
+	}
+}
+
+func TestBindChecksTypes(t *testing.T) {
+	for name, bind := range map[string]func(*Actions){
+		"missing out field": func(a *Actions) {
+			Bind(a, "SetLevel", func(*http.Request, setLevelReq) (struct{}, error) { return struct{}{}, nil })
+		},
+		"undefined argument": func(a *Actions) {
+			Bind(a, "SetLevel", func(*http.Request, struct{ Speed string }) (setLevelResp, error) { return setLevelResp{}, nil })
+		},
+		"wrong type": func(a *Actions) {
+			Bind(a, "SetLevel", func(*http.Request, struct{ Level bool }) (setLevelResp, error) { return setLevelResp{}, nil })
+		},
+		"undefined action": func(a *Actions) {
+			Bind(a, "Unknown", func(*http.Request, struct{}) (struct{}, error) { return struct{}{}, nil })
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Bind didn't panic", name)
+				}
+			}()
+			bind(NewActions(&testServiceDef))
+		}()
+	}
+}
+
+func TestMarshalArgs(t *testing.T) {
+	args, err := MarshalArgs(struct {
+		Level  uint32
+		Fast   bool   `upnp:"Mode"`
+		Ignore string `upnp:"-"`
+	}{Level: 3, Fast: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 || args[0] != [2]string{"Level", "3"} || args[1] != [2]string{"Mode", "1"} {
+		t.Fatalf("unexpected args: %q", args)
+	}
+	var resp setLevelResp
+	if err := UnmarshalArgs([][2]string{{"Other", "x"}, {"Result", " 42 "}}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Level != 42 {
+		t.Fatalf("got %d", resp.Level)
+	}
+	if err := UnmarshalArgs([][2]string{{"Result", "x"}}, &resp); err == nil {
+		t.Fatal("expected error for bad integer")
+	}
+	if _, err := MarshalArgs(struct{ F float64 }{}); err == nil {
+		t.Fatal("expected error for unsupported field type")
+	}
+}
@@ -1,12 +1,15 @@
 package upnp
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"sync"
 	"time"
 
@@ -43,11 +46,29 @@ type subscriber struct {
 	nextSeq uint32 // 0 for initial event, wraps from Uint32Max to 1.
 	urls    []*url.URL
 	expiry  time.Time
+	// Event bodies waiting to be sent, in order, and whether they're being sent.
+	queue   [][]byte
+	sending bool
+	// Events in a row that couldn't be delivered.
+	failures int
 }
 
-// Intended to eventually be an embeddable implementation for managing
-// eventing for a service. Not complete.
+// The timeout given to subscriptions that don't ask for one, or ask for infinite.
+const DefaultSubscriptionTimeout = 1800
+
+// Subscribers are dropped after this many events in a row couldn't be delivered to them.
+const maxEventFailures = 3
+
+// Sends events when Eventing has no Client. Subscribers that stop responding would otherwise hold
+// up their events forever.
+var defaultEventClient = &http.Client{Timeout: 30 * time.Second}
+
+// Eventing is an embeddable implementation of the eventing of a service: it keeps its
+// subscriptions, and sends events to them.
 type Eventing struct {
+	// The client events are sent with. One with a 30 second timeout is used if nil.
+	Client *http.Client
+
 	mutex       sync.Mutex
 	subscribers map[string]*subscriber
 }
@@ -72,14 +93,190 @@ func (me *Eventing) Subscribe(callback []*url.URL, timeoutSeconds int) (sid stri
 		me.subscribers = make(map[string]*subscriber)
 	}
 	me.subscribers[sid] = ssr
-	actualTimeout = int(ssr.expiry.Sub(time.Now()) / time.Second)
+	actualTimeout = timeoutSeconds
+	return
+}
+
+// Renew extends the subscription by the timeout.
+func (me *Eventing) Renew(sid string, timeoutSeconds int) (actualTimeout int, err error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	ssr, ok := me.subscribers[sid]
+	if !ok || time.Now().After(ssr.expiry) {
+		err = fmt.Errorf("no such subscription: %s", sid)
+		return
+	}
+	ssr.expiry = time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	actualTimeout = timeoutSeconds
 	return
 }
 
 func (me *Eventing) Unsubscribe(sid string) error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if _, ok := me.subscribers[sid]; !ok {
+		return fmt.Errorf("no such subscription: %s", sid)
+	}
+	delete(me.subscribers, sid)
 	return nil
 }
 
+// ServeSubscription handles SUBSCRIBE and UNSUBSCRIBE requests to the service's event URL. New
+// subscribers are sent the variables returned by initial as their first event. See UPnP Device
+// Architecture 4.1.
+func (me *Eventing) ServeSubscription(w http.ResponseWriter, r *http.Request, initial func() []Variable) {
+	sid := r.Header.Get("SID")
+	switch r.Method {
+	case "SUBSCRIBE":
+		timeout := DefaultSubscriptionTimeout
+		fmt.Sscanf(r.Header.Get("TIMEOUT"), "Second-%d", &timeout)
+		if timeout <= 0 {
+			timeout = DefaultSubscriptionTimeout
+		}
+		if sid != "" {
+			if r.Header.Get("CALLBACK") != "" || r.Header.Get("NT") != "" {
+				http.Error(w, "SID with CALLBACK or NT", http.StatusBadRequest)
+				return
+			}
+			timeout, err := me.Renew(sid, timeout)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				return
+			}
+			w.Header().Set("SID", sid)
+			w.Header().Set("TIMEOUT", fmt.Sprintf("Second-%d", timeout))
+			return
+		}
+		urls := ParseCallbackURLs(r.Header.Get("CALLBACK"))
+		if r.Header.Get("NT") != "upnp:event" || len(urls) == 0 {
+			http.Error(w, "bad NT or CALLBACK", http.StatusPreconditionFailed)
+			return
+		}
+		sid, timeout, err := me.Subscribe(urls, timeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("SID", sid)
+		w.Header().Set("TIMEOUT", fmt.Sprintf("Second-%d", timeout))
+		// The initial event is queued before responding, so it's first, but it's sent after.
+		me.mutex.Lock()
+		if ssr, ok := me.subscribers[sid]; ok && initial != nil {
+			me.enqueue(ssr, initial())
+		}
+		me.mutex.Unlock()
+	case "UNSUBSCRIBE":
+		if err := me.Unsubscribe(sid); err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Notify sends an event of the variables to every subscriber. Subscriptions that have expired
+// are dropped.
+func (me *Eventing) Notify(vars ...Variable) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	now := time.Now()
+	for sid, ssr := range me.subscribers {
+		if now.After(ssr.expiry) {
+			delete(me.subscribers, sid)
+			continue
+		}
+		me.enqueue(ssr, vars)
+	}
+}
+
+// Queues an event for the subscriber, and starts sending if it isn't already. The mutex must be
+// held.
+func (me *Eventing) enqueue(ssr *subscriber, vars []Variable) {
+	ps := PropertySet{Space: "urn:schemas-upnp-org:event-1-0"}
+	for _, v := range vars {
+		ps.Properties = append(ps.Properties, Property{Variable: v})
+	}
+	body, err := xml.Marshal(ps)
+	if err != nil {
+		log.Panicf("marshalling property set: %s", err)
+	}
+	ssr.queue = append(ssr.queue, append([]byte(`<?xml version="1.0"?>`), body...))
+	if !ssr.sending {
+		ssr.sending = true
+		go me.send(ssr)
+	}
+}
+
+// Sends the subscriber's queued events in order, until there are none. The subscriber is dropped
+// if too many events in a row fail.
+func (me *Eventing) send(ssr *subscriber) {
+	for {
+		me.mutex.Lock()
+		if len(ssr.queue) == 0 {
+			ssr.sending = false
+			me.mutex.Unlock()
+			return
+		}
+		body := ssr.queue[0]
+		ssr.queue = ssr.queue[1:]
+		seq := ssr.nextSeq
+		ssr.nextSeq++
+		if ssr.nextSeq == 0 {
+			ssr.nextSeq = 1
+		}
+		me.mutex.Unlock()
+		ok := me.notify(ssr, seq, body)
+		me.mutex.Lock()
+		if ok {
+			ssr.failures = 0
+		} else {
+			ssr.failures++
+		}
+		if ssr.failures >= maxEventFailures {
+			log.Levelf(log.Debug, "dropping subscriber %s after %d failed events", ssr.sid, ssr.failures)
+			if me.subscribers[ssr.sid] == ssr {
+				delete(me.subscribers, ssr.sid)
+			}
+			ssr.queue = nil
+			ssr.sending = false
+			me.mutex.Unlock()
+			return
+		}
+		me.mutex.Unlock()
+	}
+}
+
+// Sends the event to the first of the subscriber's callback URLs that accepts it, and returns
+// whether one did.
+func (me *Eventing) notify(ssr *subscriber, seq uint32, body []byte) bool {
+	client := me.Client
+	if client == nil {
+		client = defaultEventClient
+	}
+	for _, u := range ssr.urls {
+		req, err := http.NewRequest("NOTIFY", u.String(), bytes.NewReader(body))
+		if err != nil {
+			continue
+		}
+		req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+		req.Header.Set("NT", "upnp:event")
+		req.Header.Set("NTS", "upnp:propchange")
+		req.Header.Set("SID", ssr.sid)
+		req.Header.Set("SEQ", strconv.FormatUint(uint64(seq), 10))
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Levelf(log.Debug, "notifying %s: %v", u, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return true
+		}
+		log.Levelf(log.Debug, "notifying %s: %s", u, resp.Status)
+	}
+	return false
+}
+
 var callbackURLRegexp = regexp.MustCompile("<(.*?)>")
 
 // Parse the CALLBACK HTTP header in an event subscription request. See UPnP
@@ -2,7 +2,12 @@ package upnp
 
 import (
 	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Visually verify that property sets are marshalled correctly.
@@ -59,3 +64,76 @@ func TestSubscribeRace(t *testing.T) {
 	<-done
 	<-done
 }
+
+func TestServeSubscription(t *testing.T) {
+	type notify struct {
+		sid, seq, body string
+	}
+	notifies := make(chan notify, 2)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		notifies <- notify{r.Header.Get("SID"), r.Header.Get("SEQ"), string(b)}
+	}))
+	defer callback.Close()
+
+	var e Eventing
+	serve := func(method string, h http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/event", nil)
+		r.Header = h
+		w := httptest.NewRecorder()
+		e.ServeSubscription(w, r, func() []Variable {
+			return []Variable{{XMLName: xml.Name{Local: "Status"}, Value: "0"}}
+		})
+		return w
+	}
+	w := serve("SUBSCRIBE", http.Header{
+		"Callback": {"<" + callback.URL + ">"},
+		"Nt":       {"upnp:event"},
+		"Timeout":  {"Second-60"},
+	})
+	sid := w.Header().Get("SID")
+	if w.Code != http.StatusOK || sid == "" || w.Header().Get("TIMEOUT") != "Second-60" {
+		t.Fatalf("unexpected response: %d %v", w.Code, w.Header())
+	}
+	e.Notify(Variable{XMLName: xml.Name{Local: "Status"}, Value: "1"})
+	for i, want := range []string{"<Status>0</Status>", "<Status>1</Status>"} {
+		n := <-notifies
+		if n.sid != sid || n.seq != string(rune('0'+i)) || !strings.Contains(n.body, want) {
+			t.Fatalf("unexpected event %d: %+v", i, n)
+		}
+	}
+	if w := serve("SUBSCRIBE", http.Header{"Sid": {sid}}); w.Code != http.StatusOK || w.Header().Get("TIMEOUT") != "Second-1800" {
+		t.Fatalf("renewing: %d %v", w.Code, w.Header())
+	}
+	if w := serve("UNSUBSCRIBE", http.Header{"Sid": {sid}}); w.Code != http.StatusOK {
+		t.Fatalf("unsubscribing: %d", w.Code)
+	}
+	if w := serve("SUBSCRIBE", http.Header{"Sid": {sid}}); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("renewing after unsubscribing: %d", w.Code)
+	}
+}
+
+func TestDropFailingSubscriber(t *testing.T) {
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusInternalServerError)
+	}))
+	defer callback.Close()
+
+	var e Eventing
+	sid, _, err := e.Subscribe(ParseCallbackURLs("<"+callback.URL+">"), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxEventFailures; i++ {
+		e.Notify(Variable{XMLName: xml.Name{Local: "Status"}, Value: "1"})
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := e.Renew(sid, 60); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber wasn't dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
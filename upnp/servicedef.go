@@ -0,0 +1,226 @@
+package upnp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/log"
+)
+
+// ServiceDef defines a service's actions and state variables. The service's SCPD is built from
+// it, and actions received are checked against it, so that what's advertised is what's handled.
+type ServiceDef struct {
+	Actions   []ActionDef
+	StateVars []StateVarDef
+}
+
+type ActionDef struct {
+	Name string
+	In   []ArgDef
+	Out  []ArgDef
+}
+
+// ArgDef is an action argument, typed by its related state variable.
+type ArgDef struct {
+	Name     string
+	StateVar string
+}
+
+type StateVarDef struct {
+	Name     string
+	DataType string
+	// If not empty, the only values the variable can have.
+	AllowedValues []string
+	DefaultValue  string
+	// Whether changes to the variable are evented.
+	SendEvents bool
+}
+
+// Check returns an error if the definition is inconsistent, such as an argument related to an
+// undefined state variable.
+func (d *ServiceDef) Check() error {
+	vars := make(map[string]bool, len(d.StateVars))
+	for _, sv := range d.StateVars {
+		if vars[sv.Name] {
+			return fmt.Errorf("duplicate state variable %q", sv.Name)
+		}
+		vars[sv.Name] = true
+	}
+	actions := make(map[string]bool, len(d.Actions))
+	for _, a := range d.Actions {
+		if actions[a.Name] {
+			return fmt.Errorf("duplicate action %q", a.Name)
+		}
+		actions[a.Name] = true
+		for _, arg := range append(append([]ArgDef(nil), a.In...), a.Out...) {
+			if !vars[arg.StateVar] {
+				return fmt.Errorf("action %q argument %q: undefined state variable %q", a.Name, arg.Name, arg.StateVar)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *ServiceDef) Action(name string) (*ActionDef, bool) {
+	for i := range d.Actions {
+		if d.Actions[i].Name == name {
+			return &d.Actions[i], true
+		}
+	}
+	return nil, false
+}
+
+func (d *ServiceDef) StateVar(name string) (*StateVarDef, bool) {
+	for i := range d.StateVars {
+		if d.StateVars[i].Name == name {
+			return &d.StateVars[i], true
+		}
+	}
+	return nil, false
+}
+
+// SCPD returns the service description.
+func (d *ServiceDef) SCPD() SCPD {
+	scpd := SCPD{SpecVersion: SpecVersion{Major: 1, Minor: 0}}
+	for _, a := range d.Actions {
+		action := Action{Name: a.Name}
+		for _, arg := range a.In {
+			action.Arguments = append(action.Arguments, Argument{Name: arg.Name, Direction: "in", RelatedStateVar: arg.StateVar})
+		}
+		for _, arg := range a.Out {
+			action.Arguments = append(action.Arguments, Argument{Name: arg.Name, Direction: "out", RelatedStateVar: arg.StateVar})
+		}
+		scpd.ActionList = append(scpd.ActionList, action)
+	}
+	for _, sv := range d.StateVars {
+		v := StateVariable{
+			SendEvents:   "no",
+			Name:         sv.Name,
+			DataType:     sv.DataType,
+			DefaultValue: sv.DefaultValue,
+		}
+		if sv.SendEvents {
+			v.SendEvents = "yes"
+		}
+		if len(sv.AllowedValues) != 0 {
+			allowed := sv.AllowedValues
+			v.AllowedValues = &allowed
+		}
+		scpd.ServiceStateTable = append(scpd.ServiceStateTable, v)
+	}
+	return scpd
+}
+
+// SCPDXML returns the XML document of the service description.
+func (d *ServiceDef) SCPDXML() ([]byte, error) {
+	b, err := xml.MarshalIndent(d.SCPD(), "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// CheckAction returns InvalidActionError if the service doesn't define the action, and an
+// InvalidArgsErrorCode error if the action element can't be parsed, or an
+// ArgumentValueInvalidErrorCode error if its arguments don't suit their state variables. Missing
+// arguments are allowed, as many control points leave out those they don't care about, and
+// undefined ones are ignored.
+func (d *ServiceDef) CheckAction(action string, argsXML []byte) error {
+	a, ok := d.Action(action)
+	if !ok {
+		return InvalidActionError
+	}
+	_, err := d.checkArgs(a, argsXML)
+	return err
+}
+
+// Parses the arguments of the action, as CheckAction does, and returns the defined ones if they're
+// valid.
+func (d *ServiceDef) checkArgs(a *ActionDef, argsXML []byte) (ret [][2]string, err error) {
+	args, err := ParseActionArgs(argsXML)
+	if err != nil {
+		return nil, Errorf(InvalidArgsErrorCode, "error parsing arguments: %s", err)
+	}
+	for _, arg := range args {
+		def, ok := a.inArg(arg[0])
+		if !ok {
+			log.Levelf(log.Debug, "ignoring unexpected %s argument %q", a.Name, arg[0])
+			continue
+		}
+		sv, _ := d.StateVar(def.StateVar)
+		if err := sv.checkValue(arg[1]); err != nil {
+			return nil, Errorf(ArgumentValueInvalidErrorCode, "argument %q: %s", arg[0], err)
+		}
+		ret = append(ret, arg)
+	}
+	return ret, nil
+}
+
+func (a *ActionDef) inArg(name string) (ArgDef, bool) {
+	for _, arg := range a.In {
+		if arg.Name == name {
+			return arg, true
+		}
+	}
+	return ArgDef{}, false
+}
+
+// Returns the name and value of each child element of the action element.
+func ParseActionArgs(argsXML []byte) (ret [][2]string, err error) {
+	var action struct {
+		Args []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	}
+	if err = xml.Unmarshal(argsXML, &action); err != nil {
+		return
+	}
+	for _, arg := range action.Args {
+		ret = append(ret, [2]string{arg.XMLName.Local, arg.Value})
+	}
+	return
+}
+
+// Returns an error if s isn't a valid value of the variable. Empty values are allowed, as for
+// missing arguments.
+func (sv *StateVarDef) checkValue(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	if len(sv.AllowedValues) != 0 {
+		for _, v := range sv.AllowedValues {
+			if s == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not an allowed value", s)
+	}
+	var err error
+	switch sv.DataType {
+	case "ui1":
+		_, err = strconv.ParseUint(s, 10, 8)
+	case "ui2":
+		_, err = strconv.ParseUint(s, 10, 16)
+	case "ui4":
+		_, err = strconv.ParseUint(s, 10, 32)
+	case "i1":
+		_, err = strconv.ParseInt(s, 10, 8)
+	case "i2":
+		_, err = strconv.ParseInt(s, 10, 16)
+	case "i4", "int":
+		_, err = strconv.ParseInt(s, 10, 32)
+	case "r4", "r8", "number", "float":
+		_, err = strconv.ParseFloat(s, 64)
+	case "boolean":
+		switch strings.ToLower(s) {
+		case "0", "1", "true", "false", "yes", "no":
+		default:
+			err = fmt.Errorf("%q is not a boolean", s)
+		}
+	}
+	return err
+}
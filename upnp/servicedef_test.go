@@ -0,0 +1,78 @@
+package upnp
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+var testServiceDef = ServiceDef{
+	Actions: []ActionDef{{
+		Name: "SetLevel",
+		In: []ArgDef{
+			{Name: "Level", StateVar: "A_ARG_TYPE_Level"},
+			{Name: "Mode", StateVar: "A_ARG_TYPE_Mode"},
+		},
+		Out: []ArgDef{{Name: "Result", StateVar: "A_ARG_TYPE_Level"}},
+	}},
+	StateVars: []StateVarDef{
+		{Name: "A_ARG_TYPE_Level", DataType: "ui4"},
+		{Name: "A_ARG_TYPE_Mode", DataType: "string", AllowedValues: []string{"Fast", "Slow"}},
+	},
+}
+
+func TestServiceDefSCPDXML(t *testing.T) {
+	b, err := testServiceDef.SCPDXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scpd SCPD
+	if err := xml.Unmarshal(b, &scpd); err != nil {
+		t.Fatal(err)
+	}
+	if len(scpd.ActionList) != 1 || len(scpd.ActionList[0].Arguments) != 3 {
+		t.Fatalf("unexpected actions: %+v", scpd.ActionList)
+	}
+	arg := scpd.ActionList[0].Arguments[1]
+	if arg.Name != "Mode" || arg.Direction != "in" || arg.RelatedStateVar != "A_ARG_TYPE_Mode" {
+		t.Fatalf("unexpected argument: %+v", arg)
+	}
+	if len(scpd.ServiceStateTable) != 2 || scpd.ServiceStateTable[1].AllowedValues == nil || len(*scpd.ServiceStateTable[1].AllowedValues) != 2 {
+		t.Fatalf("unexpected state variables: %+v", scpd.ServiceStateTable)
+	}
+}
+
+func TestServiceDefCheck(t *testing.T) {
+	if err := testServiceDef.Check(); err != nil {
+		t.Fatal(err)
+	}
+	bad := ServiceDef{Actions: []ActionDef{{Name: "A", In: []ArgDef{{Name: "X", StateVar: "Missing"}}}}}
+	if bad.Check() == nil {
+		t.Fatal("undefined state variable not detected")
+	}
+}
+
+func TestCheckAction(t *testing.T) {
+	for _, tc := range []struct {
+		action string
+		args   string
+		code   uint
+	}{
+		{"SetLevel", `<u:SetLevel xmlns:u="x"><Level>3</Level><Mode>Fast</Mode></u:SetLevel>`, 0},
+		{"SetLevel", `<u:SetLevel xmlns:u="x"></u:SetLevel>`, 0},
+		{"Unknown", `<u:Unknown xmlns:u="x"></u:Unknown>`, InvalidActionErrorCode},
+		{"SetLevel", `<u:SetLevel xmlns:u="x"><Bogus>1</Bogus></u:SetLevel>`, 0},
+		{"SetLevel", `<u:SetLevel xmlns:u="x"><Level>-1</Level></u:SetLevel>`, ArgumentValueInvalidErrorCode},
+		{"SetLevel", `<u:SetLevel xmlns:u="x"><Mode>Medium</Mode></u:SetLevel>`, ArgumentValueInvalidErrorCode},
+	} {
+		err := testServiceDef.CheckAction(tc.action, []byte(tc.args))
+		if tc.code == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tc.args, err)
+			}
+			continue
+		}
+		if err == nil || ConvertError(err).Code != tc.code {
+			t.Errorf("%s: got %v, want code %d", tc.args, err, tc.code)
+		}
+	}
+}
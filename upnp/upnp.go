@@ -91,23 +91,41 @@ type Service struct {
 }
 
 type Device struct {
-	DeviceType      string `xml:"deviceType"`
-	FriendlyName    string `xml:"friendlyName"`
-	Manufacturer    string `xml:"manufacturer"`
-	ModelName       string `xml:"modelName"`
-	UDN             string
-	VendorXML       string    `xml:",innerxml"`
-	IconList        []Icon    `xml:"iconList>icon"`
-	ServiceList     []Service `xml:"serviceList>service"`
-	PresentationURL string    `xml:"presentationURL,omitempty"`
+	DeviceType       string `xml:"deviceType"`
+	FriendlyName     string `xml:"friendlyName"`
+	Manufacturer     string `xml:"manufacturer"`
+	ManufacturerURL  string `xml:"manufacturerURL,omitempty"`
+	ModelDescription string `xml:"modelDescription,omitempty"`
+	ModelName        string `xml:"modelName"`
+	// Windows Media Player and Xbox need a model number.
+	ModelNumber  string `xml:"modelNumber,omitempty"`
+	ModelURL     string `xml:"modelURL,omitempty"`
+	SerialNumber string `xml:"serialNumber,omitempty"`
+	UDN          string
+	VendorXML    string    `xml:",innerxml"`
+	IconList     []Icon    `xml:"iconList>icon"`
+	ServiceList  []Service `xml:"serviceList>service"`
+	// Devices embedded in this one, if any. It's a pointer, as the list can't be empty.
+	DeviceList      *DeviceList `xml:"deviceList,omitempty"`
+	PresentationURL string      `xml:"presentationURL,omitempty"`
+}
+
+type DeviceList struct {
+	Devices []Device `xml:"device"`
 }
 
 type DeviceDesc struct {
 	XMLName xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	// The UPnP 1.1 configuration ID, which matches CONFIGID.UPNP.ORG.
+	ConfigID uint32 `xml:"configId,attr,omitempty"`
 	// NSDLNA      string      `xml:"xmlns:dlna,attr"`
 	// NSSEC       string      `xml:"xmlns:sec,attr"`
 	SpecVersion SpecVersion `xml:"specVersion"`
-	Device      Device      `xml:"device"`
+	// The base for relative URLs in the description, if it's not the
+	// description's own URL. Deprecated in UPnP 1.1, but still sent by some
+	// devices.
+	URLBase string `xml:"URLBase,omitempty"`
+	Device  Device `xml:"device"`
 }
 
 type Error struct {
@@ -122,6 +140,7 @@ func (e *Error) Error() string {
 
 const (
 	InvalidActionErrorCode        = 401
+	InvalidArgsErrorCode          = 402
 	ActionFailedErrorCode         = 501
 	ArgumentValueInvalidErrorCode = 600
 )
@@ -148,14 +167,14 @@ func ConvertError(err error) *Error {
 }
 
 type Action struct {
-	Name      string
-	Arguments []Argument
+	Name      string     `xml:"name"`
+	Arguments []Argument `xml:"argumentList>argument,omitempty"`
 }
 
 type Argument struct {
-	Name            string
-	Direction       string
-	RelatedStateVar string
+	Name            string `xml:"name"`
+	Direction       string `xml:"direction"`
+	RelatedStateVar string `xml:"relatedStateVariable"`
 }
 
 type SCPD struct {
@@ -169,6 +188,7 @@ type StateVariable struct {
 	SendEvents    string    `xml:"sendEvents,attr"`
 	Name          string    `xml:"name"`
 	DataType      string    `xml:"dataType"`
+	DefaultValue  string    `xml:"defaultValue,omitempty"`
 	AllowedValues *[]string `xml:"allowedValueList>allowedValue,omitempty"`
 }
 
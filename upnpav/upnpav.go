@@ -8,6 +8,56 @@ import (
 const (
 	// NoSuchObjectErrorCode : The specified ObjectID is invalid.
 	NoSuchObjectErrorCode = 701
+	// InvalidCurrentTagValueErrorCode : The CurrentTagValue of UpdateObject doesn't match the object.
+	InvalidCurrentTagValueErrorCode = 702
+	// InvalidNewTagValueErrorCode : The NewTagValue of UpdateObject is invalid.
+	InvalidNewTagValueErrorCode = 703
+	// ReadOnlyTagErrorCode : UpdateObject tried to change a tag that can't be changed.
+	ReadOnlyTagErrorCode = 705
+	// ParameterMismatchErrorCode : The tag value lists of UpdateObject don't pair up.
+	ParameterMismatchErrorCode = 706
+	// InvalidSearchCriteriaErrorCode : The search criteria is unsupported or invalid.
+	InvalidSearchCriteriaErrorCode = 708
+	// NoSuchContainerErrorCode : The specified ContainerID is invalid.
+	NoSuchContainerErrorCode = 710
+	// RestrictedObjectErrorCode : The object can't be changed.
+	RestrictedObjectErrorCode = 711
+	// BadMetadataErrorCode : The metadata given for a new object is invalid.
+	BadMetadataErrorCode = 712
+	// RestrictedParentObjectErrorCode : Objects can't be created in the container.
+	RestrictedParentObjectErrorCode = 713
+	// NoSuchSourceResourceErrorCode : The SourceURI of ImportResource couldn't be fetched.
+	NoSuchSourceResourceErrorCode = 714
+	// NoSuchFileTransferErrorCode : The TransferID is invalid.
+	NoSuchFileTransferErrorCode = 717
+	// NoSuchDestinationResourceErrorCode : The DestinationURI isn't an importUri of the server.
+	NoSuchDestinationResourceErrorCode = 718
+	// CannotProcessRequestErrorCode : The request failed for some other reason.
+	CannotProcessRequestErrorCode = 720
+)
+
+const (
+	MediaRendererDeviceType = "urn:schemas-upnp-org:device:MediaRenderer:1"
+	AVTransportServiceType  = "urn:schemas-upnp-org:service:AVTransport:1"
+)
+
+// AVTransport errors.
+const (
+	TransitionNotAvailableErrorCode = 701
+	NoContentsErrorCode             = 702
+	SeekModeNotSupportedErrorCode   = 710
+	IllegalSeekTargetErrorCode      = 711
+	IllegalMIMETypeErrorCode        = 714
+	ResourceNotFoundErrorCode       = 716
+	InvalidInstanceIDErrorCode      = 718
+)
+
+// ConnectionManager errors.
+const (
+	IncompatibleProtocolInfoErrorCode   = 701
+	IncompatibleDirectionsErrorCode     = 702
+	LocalRestrictionsErrorCode          = 704
+	InvalidConnectionReferenceErrorCode = 706
 )
 
 // Resource description
@@ -19,6 +69,19 @@ type Resource struct {
 	Bitrate      uint     `xml:"bitrate,attr,omitempty"`
 	Duration     string   `xml:"duration,attr,omitempty"`
 	Resolution   string   `xml:"resolution,attr,omitempty"`
+	// Sample rate in Hz, for audio resources.
+	SampleFrequency uint `xml:"sampleFrequency,attr,omitempty"`
+	NrAudioChannels uint `xml:"nrAudioChannels,attr,omitempty"`
+	// Where the resource can be uploaded to, for objects that don't have their content yet.
+	ImportURI string `xml:"importUri,attr,omitempty"`
+}
+
+// CaptionInfoEx is Samsung's extension linking an item to its subtitles.
+type CaptionInfoEx struct {
+	XMLName xml.Name `xml:"sec:CaptionInfoEx"`
+	// The subtitle format, such as "srt".
+	Type string `xml:"sec:type,attr"`
+	URL  string `xml:",chardata"`
 }
 
 // Container description
@@ -31,8 +94,11 @@ type Container struct {
 // Item description
 type Item struct {
 	Object
-	XMLName  xml.Name `xml:"item"`
-	Res      []Resource
+	XMLName       xml.Name `xml:"item"`
+	Res           []Resource
+	CaptionInfoEx []CaptionInfoEx
+	// Samsung's details of the item, such as where to resume it from.
+	DcmInfo  string `xml:"sec:dcmInfo,omitempty"`
 	InnerXML string `xml:",innerxml"`
 }
 